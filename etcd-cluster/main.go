@@ -1,9 +1,88 @@
 package main
 
-// 内部模块，仅仅支持内部grpc调用
-func init() {
+import (
+	"context"
+	"flag"
+	k "kongApi"
+	"log/slog"
+	"logging"
+	"os"
+	"os/signal"
+	ss "service"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
 
-}
+// Kong target 控制器：监听etcd中的服务注册信息，持续同步Kong的upstream target
+// 可以部署多个副本，通过etcd选主保证同一时间只有一个副本修改Kong
 func main() {
+	endpoints := flag.String("etcd", "127.0.0.1:12379,127.0.0.1:22379,127.0.0.1:32379", "etcd endpoints, comma separated")
+	kongURL := flag.String("kong", k.KongAdminURL, "kong admin api url")
+	kongToken := flag.String("kong-token", os.Getenv("KONG_ADMIN_TOKEN"), "kong admin token")
+	kongCA := flag.String("kong-ca", "", "ca file to verify the kong admin api certificate")
+	kongCert := flag.String("kong-cert", "", "client certificate for the kong admin api (mTLS)")
+	kongKey := flag.String("kong-key", "", "client key for the kong admin api")
+	etcdCA := flag.String("etcd-ca", "", "ca file to verify the etcd server certificate")
+	etcdCert := flag.String("etcd-cert", "", "client certificate for etcd")
+	etcdKey := flag.String("etcd-key", "", "client key for etcd")
+	interval := flag.Duration("interval", 30*time.Second, "full reconcile interval")
+	dryRun := flag.Bool("dry-run", false, "only report drift, do not modify kong")
+	maxDelete := flag.Float64("max-delete-ratio", 0.5, "max fraction of an upstream's targets deleted in one reconcile")
+	logLevel := flag.String("log-level", "info", "log level")
+	flag.Parse()
+
+	hostname, _ := os.Hostname()
+	id := hostname + "-" + strconv.Itoa(os.Getpid())
+	if err := logging.Setup(logging.Options{Service: "kong-target-controller", Instance: id, Level: *logLevel}); err != nil {
+		slog.Error("failed to setup logging", "error", err)
+		os.Exit(1)
+	}
+
+	etcdEndpoints := strings.Split(*endpoints, ",")
+	etcdOpts, err := ss.EtcdTLSOptions(ss.TLSConfig{CAFile: *etcdCA, CertFile: *etcdCert, KeyFile: *etcdKey})
+	if err != nil {
+		slog.Error("failed to load etcd tls config", "error", err)
+		os.Exit(1)
+	}
+	cli, err := ss.NewEtcdClient(etcdEndpoints, etcdOpts...)
+	if err != nil {
+		slog.Error("failed to connect to etcd", "error", err)
+		os.Exit(1)
+	}
+	defer cli.Close()
+
+	discovery, err := ss.NewServiceDiscovery(etcdEndpoints, etcdOpts...)
+	if err != nil {
+		slog.Error("failed to create service discovery", "error", err)
+		os.Exit(1)
+	}
+	defer discovery.Close()
+	if err := discovery.WatchService(ss.ServicePrefix); err != nil {
+		slog.Error("failed to watch services", "error", err)
+		os.Exit(1)
+	}
+
+	var opts []k.Option
+	if *kongToken != "" {
+		opts = append(opts, k.WithAdminToken(*kongToken))
+	}
+	if *kongCA != "" || *kongCert != "" {
+		tlsConfig, err := k.NewTLSConfig(*kongCA, *kongCert, *kongKey)
+		if err != nil {
+			slog.Error("failed to load kong tls config", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, k.WithTLSConfig(tlsConfig))
+	}
+	controller := ss.NewTargetController(discovery, k.NewClient(*kongURL, opts...))
+	controller.Interval = *interval
+	controller.DryRun = *dryRun
+	controller.MaxDeleteRatio = *maxDelete
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	err = ss.RunAsLeader(ctx, cli, ss.ElectionPrefix+"kong-target-controller", id, controller.Run)
+	slog.Info("kong target controller stopped", "error", err)
 }
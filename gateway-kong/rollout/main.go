@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	k "kongApi"
+	"log/slog"
+	"logging"
+	"os"
+	"os/signal"
+	ss "service"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 灰度发布工具：按步骤把Kong中旧版本实例的流量转移到新版本，检查失败时自动回滚
+//
+// 新版本实例以 SERVICE_VERSION=v2 且权重为0启动后执行：
+//
+//	rollout -service product -to v2 -steps 10,50,100 -interval 2m
+//
+// 查看Kong认为不健康的target及原因：
+//
+//	rollout -service product -status
+func main() {
+	endpoints := flag.String("etcd", "127.0.0.1:12379,127.0.0.1:22379,127.0.0.1:32379", "etcd endpoints, comma separated")
+	kongURL := flag.String("kong", k.KongAdminURL, "kong admin api url")
+	kongToken := flag.String("kong-token", os.Getenv("KONG_ADMIN_TOKEN"), "kong admin token")
+	kongCA := flag.String("kong-ca", "", "ca file to verify the kong admin api certificate")
+	kongCert := flag.String("kong-cert", "", "client certificate for the kong admin api (mTLS)")
+	kongKey := flag.String("kong-key", "", "client key for the kong admin api")
+	etcdCA := flag.String("etcd-ca", "", "ca file to verify the etcd server certificate")
+	etcdCert := flag.String("etcd-cert", "", "client certificate for etcd")
+	etcdKey := flag.String("etcd-key", "", "client key for etcd")
+	service := flag.String("service", "", "service name")
+	from := flag.String("from", "", "old version, empty means every version except -to")
+	to := flag.String("to", "", "new version")
+	steps := flag.String("steps", "10,25,50,100", "percentage of traffic for the new version at each step; 100 for blue/green")
+	interval := flag.Duration("interval", time.Minute, "observation time after each step")
+	weight := flag.Int("weight", 100, "weight of each new instance after the rollout")
+	maxUnhealthy := flag.Float64("max-unhealthy", 0, "max ratio of new instances marked unhealthy by kong healthchecks")
+	prometheus := flag.String("prometheus", "", "prometheus url, empty disables the error rate check")
+	query := flag.String("query", "", "error rate query, $version is replaced with the new version")
+	maxErrorRate := flag.Float64("max-error-rate", 0.01, "max error rate of the new version")
+	status := flag.Bool("status", false, "print the kong health of the service's targets and exit")
+	flag.Parse()
+
+	if err := logging.Setup(logging.Options{Service: "rollout"}); err != nil {
+		slog.Error("failed to setup logging", "error", err)
+		os.Exit(1)
+	}
+	var opts []k.Option
+	if *kongToken != "" {
+		opts = append(opts, k.WithAdminToken(*kongToken))
+	}
+	if *kongCA != "" || *kongCert != "" {
+		tlsConfig, err := k.NewTLSConfig(*kongCA, *kongCert, *kongKey)
+		if err != nil {
+			slog.Error("failed to load kong tls config", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, k.WithTLSConfig(tlsConfig))
+	}
+	kong := k.NewClient(*kongURL, opts...)
+	if *service != "" && *status {
+		report, err := kong.HealthReport(context.Background(), *service)
+		if err != nil {
+			slog.Error("failed to get health report", "upstream", *service, "error", err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		if len(report.Unhealthy()) > 0 {
+			os.Exit(3)
+		}
+		return
+	}
+	if *service == "" || *to == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var percents []int
+	for _, s := range strings.Split(*steps, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			slog.Error("invalid steps", "steps", *steps)
+			os.Exit(2)
+		}
+		percents = append(percents, p)
+	}
+
+	etcdEndpoints := strings.Split(*endpoints, ",")
+	etcdOpts, err := ss.EtcdTLSOptions(ss.TLSConfig{CAFile: *etcdCA, CertFile: *etcdCert, KeyFile: *etcdKey})
+	if err != nil {
+		slog.Error("failed to load etcd tls config", "error", err)
+		os.Exit(1)
+	}
+	cli, err := ss.NewEtcdClient(etcdEndpoints, etcdOpts...)
+	if err != nil {
+		slog.Error("failed to connect to etcd", "error", err)
+		os.Exit(1)
+	}
+	defer cli.Close()
+	discovery, err := ss.NewServiceDiscovery(etcdEndpoints, etcdOpts...)
+	if err != nil {
+		slog.Error("failed to create service discovery", "error", err)
+		os.Exit(1)
+	}
+	defer discovery.Close()
+	if err := discovery.WatchService(ss.ServicePrefix + *service + "/"); err != nil {
+		slog.Error("failed to watch services", "error", err)
+		os.Exit(1)
+	}
+
+	rollout := ss.NewRollout(discovery, kong, *service, *to)
+	rollout.From, rollout.Steps, rollout.Interval, rollout.Weight = *from, percents, *interval, *weight
+	rollout.Etcd = cli
+	rollout.Checkers = append(rollout.Checkers, &ss.KongHealthChecker{Kong: kong, MaxUnhealthy: *maxUnhealthy})
+	if *prometheus != "" && *query != "" {
+		rollout.Checkers = append(rollout.Checkers, &ss.PrometheusChecker{URL: *prometheus, Query: *query, MaxErrorRate: *maxErrorRate})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := rollout.Run(ctx); err != nil {
+		slog.Error("rollout failed", "upstream", *service, "version", *to, "error", err)
+		os.Exit(1)
+	}
+}
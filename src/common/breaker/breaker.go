@@ -0,0 +1,331 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOpen 熔断器处于打开状态，请求被直接拒绝
+	ErrOpen = errors.New("circuit breaker is open")
+	// ErrTooManyRequests 半开状态下探测请求数已达上限
+	ErrTooManyRequests = errors.New("circuit breaker: too many requests in half-open state")
+)
+
+// State 熔断器状态
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// Counts 当前统计周期内的请求计数
+type Counts struct {
+	Requests             uint32
+	Successes            uint32
+	Failures             uint32
+	ConsecutiveSuccesses uint32
+	ConsecutiveFailures  uint32
+}
+
+func (c *Counts) onSuccess() {
+	c.Successes++
+	c.ConsecutiveSuccesses++
+	c.ConsecutiveFailures = 0
+}
+
+func (c *Counts) onFailure() {
+	c.Failures++
+	c.ConsecutiveFailures++
+	c.ConsecutiveSuccesses = 0
+}
+
+// Settings 熔断器配置，零值字段使用默认值
+type Settings struct {
+	// Interval 关闭状态下统计周期的长度，每个周期开始时清空计数，默认10s
+	Interval time.Duration
+	// OpenTimeout 打开状态持续的时间，超时后进入半开状态，默认5s
+	OpenTimeout time.Duration
+	// HalfOpenRequests 半开状态下允许通过的探测请求数，全部成功后关闭熔断器，默认1
+	HalfOpenRequests uint32
+	// MinRequests 统计周期内请求数达到该值后才按失败率判断，默认20
+	MinRequests uint32
+	// FailureRatio 失败率达到该值时打开熔断器，默认0.5
+	FailureRatio float64
+	// ConsecutiveFailures 连续失败达到该值时打开熔断器，为0时不按连续失败判断
+	ConsecutiveFailures uint32
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.Interval <= 0 {
+		s.Interval = 10 * time.Second
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = 5 * time.Second
+	}
+	if s.HalfOpenRequests == 0 {
+		s.HalfOpenRequests = 1
+	}
+	if s.MinRequests == 0 {
+		s.MinRequests = 20
+	}
+	if s.FailureRatio <= 0 {
+		s.FailureRatio = 0.5
+	}
+	return s
+}
+
+// Event 熔断器状态变化事件
+type Event struct {
+	Name   string
+	From   State
+	To     State
+	Counts Counts // 状态变化前的计数
+	Time   time.Time
+}
+
+// Breaker 单个目标的熔断器（closed -> open -> half-open -> closed）
+type Breaker struct {
+	name     string
+	settings Settings
+	onChange func(Event)
+
+	lock       sync.Mutex
+	state      State
+	generation uint64 // 每次状态切换或计数清空时递增，用于丢弃过期的结果
+	counts     Counts
+	expiry     time.Time // 关闭状态下为统计周期结束时间，打开状态下为进入半开的时间
+}
+
+// NewBreaker 创建熔断器，onChange 可为nil
+func NewBreaker(name string, settings Settings, onChange func(Event)) *Breaker {
+	b := &Breaker{
+		name:     name,
+		settings: settings.withDefaults(),
+		onChange: onChange,
+	}
+	b.expiry = time.Now().Add(b.settings.Interval)
+	return b
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State 返回当前状态
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	state, _ := b.current(time.Now())
+	return state
+}
+
+// Counts 返回当前统计周期内的计数
+func (b *Breaker) Counts() Counts {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.current(time.Now())
+	return b.counts
+}
+
+// Allow 申请执行一次请求，获得许可后必须调用返回的done上报结果（success为false表示失败）
+func (b *Breaker) Allow() (done func(success bool), err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	state, generation := b.current(now)
+	switch {
+	case state == StateOpen:
+		return nil, ErrOpen
+	case state == StateHalfOpen && b.counts.Requests >= b.settings.HalfOpenRequests:
+		return nil, ErrTooManyRequests
+	}
+	b.counts.Requests++
+	return func(success bool) {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		b.report(generation, success, time.Now())
+	}, nil
+}
+
+// Execute 在熔断器保护下执行fn，fn返回的错误均计为失败
+func (b *Breaker) Execute(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err == nil)
+	return err
+}
+
+func (b *Breaker) report(generation uint64, success bool, now time.Time) {
+	state, current := b.current(now)
+	if generation != current {
+		return
+	}
+	if success {
+		b.counts.onSuccess()
+		if state == StateHalfOpen && b.counts.ConsecutiveSuccesses >= b.settings.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+		return
+	}
+	b.counts.onFailure()
+	switch state {
+	case StateClosed:
+		if b.shouldTrip() {
+			b.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		// 探测失败，重新打开
+		b.setState(StateOpen, now)
+	}
+}
+
+func (b *Breaker) shouldTrip() bool {
+	c := b.counts
+	if b.settings.ConsecutiveFailures > 0 && c.ConsecutiveFailures >= b.settings.ConsecutiveFailures {
+		return true
+	}
+	return c.Requests >= b.settings.MinRequests &&
+		float64(c.Failures)/float64(c.Requests) >= b.settings.FailureRatio
+}
+
+// current 根据时间推进状态（统计周期到期清空计数、打开超时进入半开）
+func (b *Breaker) current(now time.Time) (State, uint64) {
+	switch b.state {
+	case StateClosed:
+		if now.After(b.expiry) {
+			b.newGeneration(now)
+		}
+	case StateOpen:
+		if now.After(b.expiry) {
+			b.setState(StateHalfOpen, now)
+		}
+	}
+	return b.state, b.generation
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	if b.state == state {
+		return
+	}
+	event := Event{Name: b.name, From: b.state, To: state, Counts: b.counts, Time: now}
+	b.state = state
+	b.newGeneration(now)
+	if b.onChange != nil {
+		b.onChange(event)
+	}
+}
+
+func (b *Breaker) newGeneration(now time.Time) {
+	b.generation++
+	b.counts = Counts{}
+	switch b.state {
+	case StateClosed:
+		b.expiry = now.Add(b.settings.Interval)
+	case StateOpen:
+		b.expiry = now.Add(b.settings.OpenTimeout)
+	default:
+		b.expiry = time.Time{}
+	}
+}
+
+// Group 按名称（如 目标服务+方法）管理一组熔断器，并向订阅者广播状态变化
+type Group struct {
+	settings Settings
+
+	lock        sync.RWMutex
+	breakers    map[string]*Breaker
+	subscribers map[int]chan Event
+	nextSubId   int
+}
+
+func NewGroup(settings Settings) *Group {
+	return &Group{
+		settings:    settings,
+		breakers:    make(map[string]*Breaker),
+		subscribers: make(map[int]chan Event),
+	}
+}
+
+// Get 获取名称对应的熔断器，不存在时创建
+func (g *Group) Get(name string) *Breaker {
+	g.lock.RLock()
+	b, ok := g.breakers[name]
+	g.lock.RUnlock()
+	if ok {
+		return b
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if b, ok = g.breakers[name]; ok {
+		return b
+	}
+	b = NewBreaker(name, g.settings, g.publish)
+	g.breakers[name] = b
+	return b
+}
+
+// States 返回所有熔断器的当前状态
+func (g *Group) States() map[string]State {
+	g.lock.RLock()
+	breakers := make([]*Breaker, 0, len(g.breakers))
+	for _, b := range g.breakers {
+		breakers = append(breakers, b)
+	}
+	g.lock.RUnlock()
+	states := make(map[string]State, len(breakers))
+	for _, b := range breakers {
+		states[b.name] = b.State()
+	}
+	return states
+}
+
+// Subscribe 订阅状态变化事件，返回事件通道和取消订阅函数；订阅者消费过慢时事件会被丢弃
+func (g *Group) Subscribe() (<-chan Event, func()) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	id := g.nextSubId
+	g.nextSubId++
+	ch := make(chan Event, 64)
+	g.subscribers[id] = ch
+	return ch, func() {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		if _, ok := g.subscribers[id]; ok {
+			delete(g.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+// publish 在熔断器的锁内被调用，不能阻塞
+func (g *Group) publish(e Event) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	for _, ch := range g.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,5 @@
+module breaker
+
+go 1.22
+
+require google.golang.org/grpc v1.70.0
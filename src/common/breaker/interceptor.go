@@ -0,0 +1,114 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"time"
+)
+
+// rejectedError 熔断器拒绝请求时返回的错误，对调用方表现为 codes.Unavailable
+type rejectedError struct {
+	name string
+	err  error
+}
+
+func (e *rejectedError) Error() string {
+	return e.name + ": " + e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+func (e *rejectedError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+// IsRejected 判断错误是否由熔断器拒绝请求产生
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrOpen) || errors.Is(err, ErrTooManyRequests)
+}
+
+// IsFailure 默认的失败判定：只有体现下游不可用的错误码计为失败，业务错误（NotFound、InvalidArgument等）不影响熔断
+func IsFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// KeyFunc 生成熔断器名称，默认按 目标地址+方法 区分
+type KeyFunc func(target, fullMethod string) string
+
+func ByTargetMethod(target, fullMethod string) string {
+	return target + fullMethod
+}
+
+// UnaryClientInterceptor 一元调用熔断拦截器，keyFn 为nil时使用 ByTargetMethod
+func UnaryClientInterceptor(g *Group, keyFn KeyFunc) grpc.UnaryClientInterceptor {
+	if keyFn == nil {
+		keyFn = ByTargetMethod
+	}
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		b := g.Get(keyFn(cc.Target(), method))
+		done, err := b.Allow()
+		if err != nil {
+			return &rejectedError{name: b.Name(), err: err}
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		// 调用方主动取消不计入统计
+		done(!IsFailure(err) || ctx.Err() == context.Canceled)
+		return err
+	}
+}
+
+// StreamClientInterceptor 流式调用熔断拦截器，仅统计建立流的结果
+func StreamClientInterceptor(g *Group, keyFn KeyFunc) grpc.StreamClientInterceptor {
+	if keyFn == nil {
+		keyFn = ByTargetMethod
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		b := g.Get(keyFn(cc.Target(), method))
+		done, err := b.Allow()
+		if err != nil {
+			return nil, &rejectedError{name: b.Name(), err: err}
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		done(!IsFailure(err) || ctx.Err() == context.Canceled)
+		return stream, err
+	}
+}
+
+// RetryUnaryClientInterceptor 一元调用重试拦截器，只重试幂等方法；
+// 与熔断拦截器组合时应放在其外层，每次重试都会经过熔断器，熔断器拒绝后不再重试
+func RetryUnaryClientInterceptor(p RetryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if p.MaxAttempts < 2 || !p.idempotent(ctx, method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		var err error
+		for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+			if attempt > 0 {
+				timer := time.NewTimer(p.Backoff.Next(attempt - 1))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return err
+				case <-timer.C:
+				}
+				slog.WarnContext(ctx, "retrying request", "method", method, "attempt", attempt+1, "error", err)
+			}
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || IsRejected(err) || !p.retryable(status.Code(err)) {
+				return err
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,111 @@
+package breaker
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Backoff 指数退避，第n次重试前等待 Base*Multiplier^n（不超过Max），并按Jitter比例随机缩短
+type Backoff struct {
+	Base       time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64 // 0~1，0表示不加抖动
+}
+
+// DefaultBackoff 50ms起步，每次翻倍，最长1s，抖动20%
+var DefaultBackoff = Backoff{Base: 50 * time.Millisecond, Max: time.Second, Multiplier: 2, Jitter: 0.2}
+
+// Next 返回第attempt次重试（从0开始）前的等待时间
+func (b Backoff) Next(attempt int) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(b.Base) * math.Pow(multiplier, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		jitter := math.Min(b.Jitter, 1)
+		d -= d * jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// RetryPolicy 客户端重试策略，只有幂等方法才会被重试
+type RetryPolicy struct {
+	// MaxAttempts 最多尝试的次数（包括第一次），小于2时不重试
+	MaxAttempts int
+	Backoff     Backoff
+	// RetryableCodes 可以重试的错误码，为空时使用 Unavailable
+	RetryableCodes []codes.Code
+	// Idempotent 判断方法是否幂等，为nil时只重试通过 WithIdempotent 标记的调用
+	Idempotent func(fullMethod string) bool
+}
+
+// DefaultRetryPolicy 最多3次，按方法名前缀识别查询类方法
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	Backoff:        DefaultBackoff,
+	RetryableCodes: []codes.Code{codes.Unavailable},
+	Idempotent:     MethodPrefixes("Get", "List", "Query", "Find", "Check"),
+}
+
+// MethodPrefixes 方法名（/pkg.Service/Method 中的Method）以任一前缀开头时视为幂等
+func MethodPrefixes(prefixes ...string) func(fullMethod string) bool {
+	return func(fullMethod string) bool {
+		method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+		for _, p := range prefixes {
+			if strings.HasPrefix(method, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Methods 只有列出的完整方法名视为幂等
+func Methods(fullMethods ...string) func(fullMethod string) bool {
+	set := make(map[string]struct{}, len(fullMethods))
+	for _, m := range fullMethods {
+		set[m] = struct{}{}
+	}
+	return func(fullMethod string) bool {
+		_, ok := set[fullMethod]
+		return ok
+	}
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent 显式标记本次调用是否幂等，优先于 RetryPolicy.Idempotent
+func WithIdempotent(ctx context.Context, idempotent bool) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, idempotent)
+}
+
+func (p RetryPolicy) idempotent(ctx context.Context, fullMethod string) bool {
+	if v, ok := ctx.Value(idempotentKey{}).(bool); ok {
+		return v
+	}
+	return p.Idempotent != nil && p.Idempotent(fullMethod)
+}
+
+func (p RetryPolicy) retryable(code codes.Code) bool {
+	if len(p.RetryableCodes) == 0 {
+		return code == codes.Unavailable
+	}
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
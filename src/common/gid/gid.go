@@ -19,3 +19,8 @@ func (s *SnowFlakeGID) GetInt64() (int64, error) {
 func (s *SnowFlakeGID) GetBase64() (string, error) {
 	return s.ID.Base64(), nil
 }
+
+// FromInt64 由int64形式的ID还原GID
+func FromInt64(id int64) GID {
+	return &SnowFlakeGID{ID: snowflak.ID(id)}
+}
@@ -0,0 +1,289 @@
+package kongApi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// AdminTokenHeader Kong Admin API 鉴权使用的请求头（RBAC）
+const AdminTokenHeader = "Kong-Admin-Token"
+
+// DefaultTimeout 单次请求的默认超时时间
+const DefaultTimeout = 10 * time.Second
+
+var (
+	ErrNotFound     = errors.New("kong: not found")
+	ErrConflict     = errors.New("kong: conflict")
+	ErrValidation   = errors.New("kong: validation failed")
+	ErrUnauthorized = errors.New("kong: unauthorized")
+)
+
+// APIError Kong Admin API 返回的错误，可以通过 errors.Is 与 ErrNotFound 等比较
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Name       string         // Kong返回的错误名称，如 unique constraint violation
+	Message    string         // Kong返回的错误信息
+	Fields     map[string]any // 校验失败的字段
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("kong: %s %s: %d %s", e.Method, e.Path, e.StatusCode, msg)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrValidation:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// IsNotFound 判断错误是否为实体不存在
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// Client Kong Admin API 客户端
+type Client struct {
+	baseURL    string
+	token      string
+	timeout    time.Duration
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+	customHTTP bool // httpClient由WithHTTPClient传入
+}
+
+type Option func(*Client)
+
+// WithAdminToken 设置 Kong-Admin-Token
+func WithAdminToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithTLSConfig 设置访问Admin API使用的TLS配置，配置客户端证书即为mTLS
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithTimeout 设置单次请求的超时时间
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithHTTPClient 使用自定义的http客户端，此时忽略 WithTLSConfig 和 WithTimeout
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+		c.customHTTP = hc != nil
+	}
+}
+
+// NewClient 新建客户端，baseURL为空时使用 KongAdminURL
+func NewClient(baseURL string, opts ...Option) *Client {
+	if baseURL == "" {
+		baseURL = KongAdminURL
+	}
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.httpClient == nil {
+		c.httpClient = c.newHTTPClient()
+	}
+	return c
+}
+
+// WithTLS 返回只替换了TLS配置的客户端副本，保留token、超时和自定义的http客户端
+func (c *Client) WithTLS(cfg *tls.Config) *Client {
+	clone := *c
+	clone.tlsConfig = cfg
+	if !clone.customHTTP {
+		clone.httpClient = clone.newHTTPClient()
+	}
+	return &clone
+}
+
+func (c *Client) newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig
+	}
+	return &http.Client{Transport: transport, Timeout: c.timeout}
+}
+
+// NewTLSConfig 加载CA和客户端证书，certFile为空时只校验服务端证书
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// BaseURL 返回Admin API地址
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// do 发送请求，in不为空时作为JSON请求体，out不为空时解析响应
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("kong: marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("kong: create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set(AdminTokenHeader, c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("kong: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("kong: read response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		var kongErr struct {
+			Name    string         `json:"name"`
+			Message string         `json:"message"`
+			Fields  map[string]any `json:"fields"`
+		}
+		if json.Unmarshal(data, &kongErr) == nil {
+			apiErr.Name, apiErr.Message, apiErr.Fields = kongErr.Name, kongErr.Message, kongErr.Fields
+		} else {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("kong: unmarshal response of %s %s: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// page Kong分页列表的响应
+type page[T any] struct {
+	Data []T    `json:"data"`
+	Next string `json:"next"`
+}
+
+// get 获取单个实体
+func get[T any](ctx context.Context, c *Client, path string) (*T, error) {
+	var res T
+	if err := c.do(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// send 以指定方法提交实体并返回Kong保存后的结果
+func send[T any](ctx context.Context, c *Client, method, path string, in *T) (*T, error) {
+	var res T
+	if err := c.do(ctx, method, path, in, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// list 沿着next链接读取所有分页
+func list[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	var res []T
+	for path != "" {
+		var p page[T]
+		if err := c.do(ctx, http.MethodGet, path, nil, &p); err != nil {
+			return nil, err
+		}
+		res = append(res, p.Data...)
+		path = nextPath(p.Next)
+	}
+	return res, nil
+}
+
+// nextPath Kong返回的next可能是完整URL也可能是路径
+func nextPath(next string) string {
+	if next == "" {
+		return ""
+	}
+	u, err := url.Parse(next)
+	if err != nil {
+		return next
+	}
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	return u.Path + "?" + u.RawQuery
+}
+
+// join 拼接路径，每一段都会进行转义
+func join(segments ...string) string {
+	var b strings.Builder
+	for _, s := range segments {
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(s))
+	}
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package kongApi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultHealthChecks 通过path进行主动HTTP检查，同时根据代理的请求结果进行被动检查
+func DefaultHealthChecks(path string) HealthChecks {
+	return HealthChecks{
+		Active: ActiveHealthCheck{
+			Type:        "http",
+			HTTPPath:    path,
+			Timeout:     1,
+			Concurrency: 10,
+			Healthy: HealthyStatus{
+				Interval:     5,
+				HTTPStatuses: []int{200, 201},
+				Successes:    2,
+			},
+			Unhealthy: UnhealthyStatus{
+				Interval:     3,
+				HTTPStatuses: []int{500, 503},
+				HTTPFailures: 3,
+				TCPFailures:  3,
+				Timeouts:     3,
+			},
+		},
+		Passive: PassiveHealthCheck{
+			Type: "http",
+			Healthy: HealthyStatus{
+				HTTPStatuses: []int{200, 201},
+				Successes:    5,
+			},
+			Unhealthy: UnhealthyStatus{
+				HTTPStatuses: []int{500, 503},
+				HTTPFailures: 5,
+				TCPFailures:  2,
+				Timeouts:     3,
+			},
+		},
+	}
+}
+
+// Validate 在提交到Kong之前检查配置
+func (h *HealthChecks) Validate() error {
+	if h.Threshold < 0 || h.Threshold > 100 {
+		return fmt.Errorf("kong: healthchecks threshold %v out of range 0-100", h.Threshold)
+	}
+	for _, t := range []string{h.Active.Type, h.Passive.Type} {
+		switch t {
+		case "", "http", "https", "tcp", "grpc", "grpcs":
+		default:
+			return fmt.Errorf("kong: unknown healthcheck type %q", t)
+		}
+	}
+	if h.Active.Timeout < 0 || h.Active.Concurrency < 0 {
+		return fmt.Errorf("kong: invalid active healthcheck timeout or concurrency")
+	}
+	for _, s := range []HealthyStatus{h.Active.Healthy, h.Passive.Healthy} {
+		if s.Interval < 0 || s.Successes < 0 {
+			return fmt.Errorf("kong: negative healthy interval or successes")
+		}
+	}
+	for _, u := range []UnhealthyStatus{h.Active.Unhealthy, h.Passive.Unhealthy} {
+		if u.Interval < 0 || u.HTTPFailures < 0 || u.TCPFailures < 0 || u.Timeouts < 0 {
+			return fmt.Errorf("kong: negative unhealthy interval or failure threshold")
+		}
+	}
+	if h.Passive.Healthy.Interval != 0 || h.Passive.Unhealthy.Interval != 0 {
+		return fmt.Errorf("kong: passive healthchecks have no interval")
+	}
+	return nil
+}
+
+// TargetStatus target的健康状态及原因
+type TargetStatus struct {
+	TargetHealth
+	Reason string // 不健康的原因，健康时为空
+}
+
+// HealthReport 一个Upstream中所有target的健康状态
+type HealthReport struct {
+	Upstream     string
+	HealthChecks *HealthChecks
+	Targets      []TargetStatus
+}
+
+// Unhealthy 返回Kong不会转发流量的target（不健康或域名解析失败）
+func (r *HealthReport) Unhealthy() []TargetStatus {
+	var res []TargetStatus
+	for _, t := range r.Targets {
+		if t.Health == HealthUnhealthy || t.Health == HealthDNSError {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func (r *HealthReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "upstream %s: %d target(s), %d unhealthy\n", r.Upstream, len(r.Targets), len(r.Unhealthy()))
+	for _, t := range r.Targets {
+		fmt.Fprintf(&b, "  %-21s weight=%-5d %s", t.Target, t.Weight, t.Health)
+		if t.Reason != "" {
+			b.WriteString("  " + t.Reason)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// HealthReport 读取Upstream的健康检查配置和 /upstreams/{name}/health，给出不健康target的原因
+func (c *Client) HealthReport(ctx context.Context, upstream string) (*HealthReport, error) {
+	u, err := c.GetUpstream(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	health, err := c.UpstreamHealth(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	report := &HealthReport{Upstream: u.Name, HealthChecks: u.HealthChecks}
+	for _, t := range health {
+		report.Targets = append(report.Targets, TargetStatus{TargetHealth: t, Reason: healthReason(t, u.HealthChecks)})
+	}
+	return report, nil
+}
+
+// healthReason Kong不返回具体的失败原因，这里根据状态、地址和检查配置推断
+func healthReason(t TargetHealth, checks *HealthChecks) string {
+	switch t.Health {
+	case HealthDNSError:
+		return "target hostname could not be resolved"
+	case HealthChecksOff:
+		if t.Weight == 0 {
+			return "target weight is 0"
+		}
+		return ""
+	}
+	var bad []string
+	for _, addr := range t.Data.Addresses {
+		if addr.Health == HealthUnhealthy {
+			bad = append(bad, fmt.Sprintf("%s:%d", addr.IP, addr.Port))
+		}
+	}
+	if t.Health != HealthUnhealthy && len(bad) == 0 {
+		return ""
+	}
+	var parts []string
+	if len(bad) > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d address(es) unhealthy: %s", len(bad), len(t.Data.Addresses), strings.Join(bad, ",")))
+	}
+	if checks == nil {
+		return strings.Join(append(parts, "no healthchecks configured, marked unhealthy manually"), "; ")
+	}
+	if a := checks.Active; a.Unhealthy.Interval > 0 {
+		target := a.Type
+		if a.HTTPPath != "" && (a.Type == "" || strings.HasPrefix(a.Type, "http")) {
+			target += " " + a.HTTPPath
+		}
+		parts = append(parts, "active "+strings.TrimSpace(target)+": "+thresholds(a.Unhealthy))
+	}
+	if p := checks.Passive; p.Unhealthy.HTTPFailures > 0 || p.Unhealthy.TCPFailures > 0 || p.Unhealthy.Timeouts > 0 {
+		parts = append(parts, "passive: "+thresholds(p.Unhealthy))
+	}
+	if t.Health == HealthUnhealthy && len(parts) == 0 {
+		parts = append(parts, "failure thresholds are 0, marked unhealthy manually")
+	}
+	return strings.Join(parts, "; ")
+}
+
+// thresholds 描述标记为不健康的条件
+func thresholds(u UnhealthyStatus) string {
+	var conds []string
+	if u.HTTPFailures > 0 {
+		conds = append(conds, fmt.Sprintf("%d http failures %v", u.HTTPFailures, u.HTTPStatuses))
+	}
+	if u.TCPFailures > 0 {
+		conds = append(conds, fmt.Sprintf("%d tcp failures", u.TCPFailures))
+	}
+	if u.Timeouts > 0 {
+		conds = append(conds, fmt.Sprintf("%d timeouts", u.Timeouts))
+	}
+	if len(conds) == 0 {
+		return "no failure thresholds"
+	}
+	return "unhealthy after " + strings.Join(conds, " or ")
+}
@@ -1,329 +1,160 @@
 package kongApi
 
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-)
-
+// KongAdminURL Kong Admin API 的默认地址
 const KongAdminURL = "http://localhost:8001"
 
-// 为UpStream结构添加健康检查
+// HealthChecks Upstream的健康检查配置，次数类的阈值为0时Kong不会据此改变target的状态
 type HealthChecks struct {
-	Active  ActiveHealthCheck  `json:"active"`
-	Passive PassiveHealthCheck `json:"passive"`
+	Active    ActiveHealthCheck  `json:"active"`
+	Passive   PassiveHealthCheck `json:"passive"`
+	Threshold float64            `json:"threshold,omitempty"` // 健康target的权重占比低于该百分比时Upstream视为不健康
 }
 
-// 主动健康检查
+// 主动健康检查，Kong定期请求target
 type ActiveHealthCheck struct {
-	HTTPPath  string        `json:"http_path"`
-	Type      string        `json:"type"`
-	Healthy   HealthyStatus `json:"healthy"`
-	Unhealthy HealthyStatus `json:"unhealthy"`
-}
-
-// 被动健康检查
+	Type                   string              `json:"type,omitempty"` // http、https、tcp、grpc、grpcs
+	HTTPPath               string              `json:"http_path,omitempty"`
+	Timeout                float64             `json:"timeout,omitempty"`     // 请求超时（秒）
+	Concurrency            int                 `json:"concurrency,omitempty"` // 同时检查的target数量
+	HTTPSVerifyCertificate *bool               `json:"https_verify_certificate,omitempty"`
+	HTTPSSni               string              `json:"https_sni,omitempty"`
+	Headers                map[string][]string `json:"headers,omitempty"`
+	Healthy                HealthyStatus       `json:"healthy"`
+	Unhealthy              UnhealthyStatus     `json:"unhealthy"`
+}
+
+// 被动健康检查，根据代理的请求结果判断target状态（断路器）
 type PassiveHealthCheck struct {
-	Healthy   HealthyStatus `json:"healthy"`
-	Unhealthy HealthyStatus `json:"unhealthy"`
+	Type      string          `json:"type,omitempty"`
+	Healthy   HealthyStatus   `json:"healthy"`
+	Unhealthy UnhealthyStatus `json:"unhealthy"`
 }
 
+// HealthyStatus 连续成功Successes次后target恢复为健康
 type HealthyStatus struct {
-	HTTPStatuses []int `json:"http_statuses"`
-	Interval     int   `json:"interval,omitempty"`
-}
-
-// Upstream 结构
-type Upstream struct {
-	Name         string        `json:"name"`
-	HealthChecks *HealthChecks `json:"health_checks,omitempty"`
-}
-
-// Target 结构
-type Target struct {
-	Target string `json:"target"`
-	Weight int    `json:"weight"`
-}
-
-// Service 结构
-type Service struct {
-	Name           string `json:"name"`
-	Host           string `json:"host"`
-	Port           int    `json:"port,omitempty"`
-	Protocol       string `json:"protocol,omitempty"`
-	Path           string `json:"path,omitempty"`
-	Retries        int    `json:"retries,omitempty"`
-	ConnectTimeout int    `json:"connect_timeout,omitempty"`
-}
-
-// Route 结构
-type Route struct {
-	Name    string   `json:"name"`
-	Paths   []string `json:"paths"`
-	Service struct {
-		ID string `json:"id"`
-	} `json:"service"`
-}
-
-// 创建 Upstream
-func CreateUpstream(name string) error {
-	upstream := Upstream{
-		Name: name,
-	}
-	data, _ := json.Marshal(upstream)
-
-	resp, err := http.Post(KongAdminURL+"/upstreams", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return fmt.Errorf("failed to create upstream: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to create upstream: %s", body)
-	}
-	fmt.Println("Upstream created successfully!")
-	return nil
-}
-
-func UpdateHealthChecks(upstreamName string, healthChecks HealthChecks) error {
-	url := fmt.Sprintf("%s/upstreams/%s", KongAdminURL, upstreamName)
-	data, err := json.Marshal(map[string]interface{}{
-		"healthchecks": healthChecks,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal health checks: %v", err)
-	}
-
-	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewBuffer(data))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to update health checks: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to update health checks: %s", body)
-	}
-
-	fmt.Println("Health checks updated successfully!")
-	return nil
-}
-
-// 添加 Target 到 Upstream
-func AddTargetToUpstream(upstreamName, targetEndPoint string, weight int) error {
-	targetData := Target{
-		Target: targetEndPoint,
-		Weight: weight,
-	}
-	data, _ := json.Marshal(targetData)
-
-	resp, err := http.Post(KongAdminURL+"/upstreams/"+upstreamName+"/targets", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return fmt.Errorf("failed to add target to upstream: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to add target to upstream: %s", body)
-	}
-	fmt.Println("Target added successfully!")
-	return nil
-}
-
-// 更新 Target 参数
-func UpdateTargetInUpstream(upstreamName, targetEndPoint string, weight int) error {
-	// 创建更新后的 Target 数据
-	targetData := Target{
-		Target: targetEndPoint,
-		Weight: weight,
-	}
-
-	// 将 Target 数据转换为 JSON 格式
-	data, err := json.Marshal(targetData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal target data: %v", err)
-	}
-
-	// 使用 PUT 请求更新目标参数
-	req, err := http.NewRequest(http.MethodPut, KongAdminURL+"/upstreams/"+upstreamName+"/targets/"+targetEndPoint, bytes.NewBuffer(data))
-	if err != nil {
-		return fmt.Errorf("failed to create PUT request: %v", err)
-	}
-
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-
-	// 发送请求并获取响应
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to update target in upstream: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to update target in upstream: %s", body)
-	}
-
-	fmt.Println("Target updated successfully!")
-	return nil
+	Interval     int   `json:"interval,omitempty"` // 主动检查健康target的间隔（秒），被动检查不设置
+	HTTPStatuses []int `json:"http_statuses,omitempty"`
+	Successes    int   `json:"successes,omitempty"`
 }
 
-// 创建 Service 并返回服务 ID
-func CreateService(name, hostName, protocol, path string) (string, error) {
-	service := Service{
-		Name:     name,
-		Host:     hostName,
-		Protocol: protocol,
-		Path:     path,
-	}
-	data, _ := json.Marshal(service)
-
-	resp, err := http.Post(KongAdminURL+"/services", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return "", fmt.Errorf("failed to create service: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to create service: %s", body)
-	}
-
-	var serviceData map[string]interface{}
-	body, _ := ioutil.ReadAll(resp.Body)
-	if err := json.Unmarshal(body, &serviceData); err != nil {
-		return "", fmt.Errorf("failed to unmarshal service data: %v", err)
-	}
-
-	if serviceID, ok := serviceData["id"].(string); ok {
-		fmt.Println("Service created successfully with ID:", serviceID)
-		return serviceID, nil
-	}
-	return "", fmt.Errorf("service ID not found")
+// UnhealthyStatus 失败次数达到任一阈值后target被标记为不健康
+type UnhealthyStatus struct {
+	Interval     int   `json:"interval,omitempty"` // 主动检查不健康target的间隔（秒），被动检查不设置
+	HTTPStatuses []int `json:"http_statuses,omitempty"`
+	HTTPFailures int   `json:"http_failures,omitempty"`
+	TCPFailures  int   `json:"tcp_failures,omitempty"`
+	Timeouts     int   `json:"timeouts,omitempty"`
 }
 
-// 创建 Route
-func CreateRoute(name, serviceID string, paths []string) error {
-	route := Route{
-		Name:  name,
-		Paths: paths,
-	}
-	route.Service.ID = serviceID // 绑定服务的 ID
-
-	data, _ := json.Marshal(route)
-
-	resp, err := http.Post(KongAdminURL+"/routes", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return fmt.Errorf("failed to create route: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to create route: %s", body)
-	}
-	fmt.Println("Route created successfully!")
-	return nil
+// Ref 关联的实体，按ID或名称引用
+type Ref struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
 }
 
-// 检查 Target 是否存在
-func TargetExists(upstreamName, target string) (bool, error) {
-	resp, err := http.Get(KongAdminURL + "/upstreams/" + upstreamName + "/targets")
-	if err != nil {
-		return false, fmt.Errorf("failed to check target: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return false, fmt.Errorf("failed to check target: %s", body)
-	}
-
-	var data map[string]interface{}
-	body, _ := ioutil.ReadAll(resp.Body)
-	if err := json.Unmarshal(body, &data); err != nil {
-		return false, fmt.Errorf("failed to unmarshal target data: %v", err)
-	}
-
-	if targets, ok := data["data"].([]interface{}); ok {
-		for _, t := range targets {
-			if targetInfo, ok := t.(map[string]interface{}); ok {
-				if targetInfo["target"] == target {
-					return true, nil
-				}
-			}
-		}
-	}
-	return false, nil
+// Upstream 结构
+type Upstream struct {
+	ID           string        `json:"id,omitempty"`
+	Name         string        `json:"name,omitempty"`
+	Algorithm    string        `json:"algorithm,omitempty"`
+	Slots        int           `json:"slots,omitempty"`
+	HealthChecks *HealthChecks `json:"healthchecks,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	CreatedAt    int64         `json:"created_at,omitempty"`
 }
 
-// 检查 Upstream 是否存在
-func UpstreamExists(name string) (bool, error) {
-	return ResourceExists("/upstreams/" + name)
-}
+// Target 结构，权重为0时Kong不再向该节点转发流量
+type Target struct {
+	ID        string   `json:"id,omitempty"`
+	Target    string   `json:"target"`
+	Weight    int      `json:"weight"`
+	Upstream  *Ref     `json:"upstream,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt float64  `json:"created_at,omitempty"`
+}
+
+// 健康检查得出的target状态
+const (
+	HealthHealthy   = "HEALTHY"
+	HealthUnhealthy = "UNHEALTHY"
+	HealthDNSError  = "DNS_ERROR"
+	HealthChecksOff = "HEALTHCHECKS_OFF" // 未开启健康检查或权重为0
+)
 
-// 检查 Route 是否存在
-func RouteExists(name string) (bool, error) {
-	return ResourceExists("/routes/" + name)
+// TargetHealth Kong主动和被动健康检查得出的target状态
+type TargetHealth struct {
+	Target   string           `json:"target"`
+	Weight   int              `json:"weight"`
+	Health   string           `json:"health"`
+	Upstream *Ref             `json:"upstream,omitempty"`
+	Data     TargetHealthData `json:"data"`
 }
 
-// 示例：调用通用方法检查 Service 是否存在
-func ServiceExists(name string) (bool, error) {
-	return ResourceExists("/services/" + name)
+// TargetHealthData target解析出的地址，target为域名时可能有多个
+type TargetHealthData struct {
+	Addresses []AddressHealth `json:"addresses,omitempty"`
 }
-func ResourceExists(resourcePath string) (bool, error) {
-	resp, err := http.Get(KongAdminURL + resourcePath)
-	if err != nil {
-		return false, fmt.Errorf("failed to check resource: %v", err)
-	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return false, nil
-	} else if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return false, fmt.Errorf("error checking resource: %s", body)
-	}
-	return true, nil
+// AddressHealth 单个地址的健康状态
+type AddressHealth struct {
+	IP     string `json:"ip"`
+	Port   int    `json:"port"`
+	Weight int    `json:"weight"`
+	Health string `json:"health"`
 }
 
-func GetServiceID(serviceName string) (string, error) {
-	// 构造请求 URL
-	url := fmt.Sprintf(KongAdminURL+"/services/%s", serviceName)
-
-	// 发送 HTTP GET 请求
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 检查 HTTP 状态码
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	// 读取响应体
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
-	}
-	res := map[string]interface{}{}
-	json.Unmarshal(body, &res)
-
-	return res["id"].(string), nil
+// Service 结构
+type Service struct {
+	ID             string   `json:"id,omitempty"`
+	Name           string   `json:"name,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	Port           int      `json:"port,omitempty"`
+	Protocol       string   `json:"protocol,omitempty"`
+	Path           string   `json:"path,omitempty"`
+	Retries        int      `json:"retries,omitempty"`
+	ConnectTimeout int      `json:"connect_timeout,omitempty"`
+	WriteTimeout   int      `json:"write_timeout,omitempty"`
+	ReadTimeout    int      `json:"read_timeout,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	CreatedAt      int64    `json:"created_at,omitempty"`
+}
+
+// Route 结构，多个路由都匹配时优先选择RegexPriority高的正则路径
+type Route struct {
+	ID            string              `json:"id,omitempty"`
+	Name          string              `json:"name,omitempty"`
+	Paths         []string            `json:"paths,omitempty"`
+	Hosts         []string            `json:"hosts,omitempty"`
+	Methods       []string            `json:"methods,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Protocols     []string            `json:"protocols,omitempty"`
+	RegexPriority *int                `json:"regex_priority,omitempty"`
+	StripPath     *bool               `json:"strip_path,omitempty"`
+	PreserveHost  *bool               `json:"preserve_host,omitempty"`
+	Service       *Ref                `json:"service,omitempty"`
+	Tags          []string            `json:"tags,omitempty"`
+	CreatedAt     int64               `json:"created_at,omitempty"`
+}
+
+// Plugin 结构，Service、Route、Consumer都为空时为全局插件
+type Plugin struct {
+	ID        string         `json:"id,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Config    map[string]any `json:"config,omitempty"`
+	Enabled   *bool          `json:"enabled,omitempty"`
+	Service   *Ref           `json:"service,omitempty"`
+	Route     *Ref           `json:"route,omitempty"`
+	Consumer  *Ref           `json:"consumer,omitempty"`
+	Protocols []string       `json:"protocols,omitempty"`
+	Tags      []string       `json:"tags,omitempty"`
+	CreatedAt int64          `json:"created_at,omitempty"`
+}
+
+// Consumer 结构
+type Consumer struct {
+	ID        string   `json:"id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	CustomID  string   `json:"custom_id,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int64    `json:"created_at,omitempty"`
 }
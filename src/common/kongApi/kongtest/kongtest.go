@@ -0,0 +1,548 @@
+// Package kongtest 提供内存中的 Kong Admin API，用于在没有Kong的环境下测试
+package kongtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"kongApi"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schema 实体的约束
+type schema struct {
+	unique   string   // 唯一字段，可以代替ID访问实体
+	required []string // 必填字段
+	parent   string   // 嵌套在父实体下，如target属于upstream
+	refs     []string // 引用的其他实体
+}
+
+var schemas = map[string]schema{
+	"upstreams": {unique: "name", required: []string{"name"}},
+	"targets":   {unique: "target", required: []string{"target"}, parent: "upstream"},
+	"services":  {unique: "name", required: []string{"host"}},
+	"routes":    {unique: "name", refs: []string{"service"}},
+	"plugins":   {required: []string{"name"}, refs: []string{"service", "route", "consumer"}},
+	"consumers": {unique: "username"},
+	"jwt":       {unique: "key", parent: "consumer"},
+	"key-auth":  {unique: "key", parent: "consumer"},
+	"acls":      {unique: "group", required: []string{"group"}, parent: "consumer"},
+}
+
+// 引用字段对应的实体类型
+var refKinds = map[string]string{
+	"upstream": "upstreams",
+	"service":  "services",
+	"route":    "routes",
+	"consumer": "consumers",
+}
+
+type collection struct {
+	order []string
+	items map[string]map[string]any
+}
+
+// Server 模拟的Kong Admin API
+type Server struct {
+	*httptest.Server
+	Token    string // 不为空时校验 Kong-Admin-Token
+	PageSize int    // 列表接口默认的分页大小
+
+	lock        sync.Mutex
+	seq         int
+	collections map[string]*collection
+	requests    []string
+	health      map[string]string
+}
+
+// NewServer 启动模拟的Kong，使用完毕后需要调用Close
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer 启动使用TLS的模拟Kong，客户端可以使用 s.Client() 的证书配置
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer() *Server {
+	s := &Server{PageSize: 100, collections: make(map[string]*collection)}
+	for kind := range schemas {
+		s.collections[kind] = &collection{items: make(map[string]map[string]any)}
+	}
+	return s
+}
+
+// Requests 返回收到的请求，格式为 "METHOD /path"
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// List 返回指定类型的所有实体
+func (s *Server) List(kind string) []map[string]any {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := s.collections[kind]
+	if !ok {
+		return nil
+	}
+	res := make([]map[string]any, 0, len(c.order))
+	for _, id := range c.order {
+		res = append(res, clone(c.items[id]))
+	}
+	return res
+}
+
+// Get 按ID或唯一字段返回实体，target需要使用 List 查找
+func (s *Server) Get(kind, key string) (map[string]any, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := s.find(kind, key, "")
+	if e == nil {
+		return nil, false
+	}
+	return clone(e), true
+}
+
+// SetHealth 设置target的健康状态，未设置的target为HEALTHY，权重为0的target为HEALTHCHECKS_OFF
+func (s *Server) SetHealth(target, health string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.health == nil {
+		s.health = make(map[string]string)
+	}
+	s.health[target] = health
+}
+
+func (s *Server) upstreamHealth(upstreamID string) (int, any, *httpError) {
+	data := []map[string]any{}
+	c := s.collections["targets"]
+	for _, id := range c.order {
+		e := c.items[id]
+		if refID(e["upstream"]) != upstreamID {
+			continue
+		}
+		target, _ := e["target"].(string)
+		health := kongApi.HealthHealthy
+		if h, ok := s.health[target]; ok {
+			health = h
+		} else if fmt.Sprint(e["weight"]) == "0" {
+			health = kongApi.HealthChecksOff
+		}
+		host, port, _ := strings.Cut(target, ":")
+		p, _ := strconv.Atoi(port)
+		address := map[string]any{"ip": host, "port": p, "weight": e["weight"], "health": health}
+		data = append(data, map[string]any{
+			"target":   target,
+			"weight":   e["weight"],
+			"health":   health,
+			"upstream": map[string]any{"id": upstreamID},
+			"data":     map[string]any{"addresses": []any{address}},
+		})
+	}
+	return http.StatusOK, map[string]any{"data": data, "next": nil}, nil
+}
+
+// httpError 与Kong一致的错误响应
+type httpError struct {
+	status int
+	body   map[string]any
+}
+
+func notFound() *httpError {
+	return &httpError{http.StatusNotFound, map[string]any{"message": "Not found"}}
+}
+
+func schemaViolation(field, msg string) *httpError {
+	return &httpError{http.StatusBadRequest, map[string]any{
+		"code":    2,
+		"name":    "schema violation",
+		"message": fmt.Sprintf("schema violation (%s: %s)", field, msg),
+		"fields":  map[string]any{field: msg},
+	}}
+}
+
+func foreignKeyViolation(field string) *httpError {
+	return &httpError{http.StatusBadRequest, map[string]any{
+		"code":    4,
+		"name":    "foreign key violation",
+		"message": fmt.Sprintf("the foreign key '%s' does not reference an existing entity", field),
+		"fields":  map[string]any{field: "does not reference an existing entity"},
+	}}
+}
+
+func uniqueViolation(field string, value any) *httpError {
+	return &httpError{http.StatusConflict, map[string]any{
+		"code":    5,
+		"name":    "unique constraint violation",
+		"message": fmt.Sprintf("UNIQUE violation detected on '{%s=%q}'", field, fmt.Sprint(value)),
+	}}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if s.Token != "" && r.Header.Get(kongApi.AdminTokenHeader) != s.Token {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"message": "Invalid credentials. Token or User credentials required"})
+		return
+	}
+	var body map[string]any
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "Cannot parse JSON body"})
+			return
+		}
+		if body == nil {
+			body = make(map[string]any)
+		}
+	}
+	status, res, herr := s.route(r, body)
+	if herr != nil {
+		writeJSON(w, herr.status, herr.body)
+		return
+	}
+	if res == nil {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, res)
+}
+
+// route 按路径分发：/kind、/kind/key、/parent/key/kind、/parent/key/kind/key
+func (s *Server) route(r *http.Request, body map[string]any) (int, any, *httpError) {
+	segs := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var parentField, parentID string
+	if len(segs) >= 3 {
+		parentField = singular(segs[0])
+		if _, ok := refKinds[parentField]; !ok {
+			return 0, nil, notFound()
+		}
+		parent := s.find(segs[0], segs[1], "")
+		if parent == nil {
+			return 0, nil, notFound()
+		}
+		parentID = parent["id"].(string)
+		segs = segs[2:]
+	}
+	kind := segs[0]
+	if parentField == "upstream" && kind == "health" && len(segs) == 1 && r.Method == http.MethodGet {
+		return s.upstreamHealth(parentID)
+	}
+	if parentField == "upstream" && kind == "targets" && len(segs) == 3 && r.Method == http.MethodPost {
+		target := s.find("targets", segs[1], parentID)
+		if target == nil || (segs[2] != "healthy" && segs[2] != "unhealthy") {
+			return 0, nil, notFound()
+		}
+		if s.health == nil {
+			s.health = make(map[string]string)
+		}
+		s.health[target["target"].(string)] = strings.ToUpper(segs[2])
+		return http.StatusNoContent, nil, nil
+	}
+	if _, ok := schemas[kind]; !ok || len(segs) > 2 {
+		return 0, nil, notFound()
+	}
+	if len(segs) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			return s.list(r, kind, parentField, parentID)
+		case http.MethodPost:
+			if parentField != "" {
+				body[parentField] = map[string]any{"id": parentID}
+			}
+			e, err := s.create(kind, body)
+			return http.StatusCreated, e, err
+		}
+		return 0, nil, &httpError{http.StatusMethodNotAllowed, map[string]any{"message": "Method not allowed"}}
+	}
+
+	key := segs[1]
+	scope := ""
+	if parentField != "" {
+		scope = parentID
+	}
+	existing := s.find(kind, key, scope)
+	switch r.Method {
+	case http.MethodGet:
+		if existing == nil {
+			return 0, nil, notFound()
+		}
+		return http.StatusOK, clone(existing), nil
+	case http.MethodDelete:
+		if existing != nil {
+			s.delete(kind, existing["id"].(string))
+		}
+		return http.StatusNoContent, nil, nil
+	case http.MethodPatch:
+		if existing == nil {
+			return 0, nil, notFound()
+		}
+		merged := clone(existing)
+		for k, v := range body {
+			merged[k] = v
+		}
+		e, err := s.save(kind, merged)
+		return http.StatusOK, e, err
+	case http.MethodPut:
+		if parentField != "" {
+			body[parentField] = map[string]any{"id": parentID}
+		}
+		u := schemas[kind].unique
+		if _, ok := body[u]; u != "" && !ok && !isID(key) {
+			body[u] = key
+		}
+		if existing != nil {
+			// PUT替换整个实体，未设置的字段恢复为默认值
+			body["id"] = existing["id"]
+			body["created_at"] = existing["created_at"]
+			s.setDefaults(kind, body)
+			e, err := s.save(kind, body)
+			return http.StatusOK, e, err
+		}
+		if isID(key) {
+			body["id"] = key
+		}
+		e, err := s.create(kind, body)
+		return http.StatusOK, e, err
+	}
+	return 0, nil, &httpError{http.StatusMethodNotAllowed, map[string]any{"message": "Method not allowed"}}
+}
+
+func (s *Server) list(r *http.Request, kind, parentField, parentID string) (int, any, *httpError) {
+	c := s.collections[kind]
+	var matched []map[string]any
+	for _, id := range c.order {
+		e := c.items[id]
+		if parentField != "" && refID(e[parentField]) != parentID {
+			continue
+		}
+		matched = append(matched, clone(e))
+	}
+	size := s.PageSize
+	if v, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && v > 0 {
+		size = v
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := offset + size
+	if end > len(matched) {
+		end = len(matched)
+	}
+	res := map[string]any{"data": append([]map[string]any{}, matched[offset:end]...), "next": nil}
+	if end < len(matched) {
+		res["offset"] = strconv.Itoa(end)
+		res["next"] = fmt.Sprintf("%s?offset=%d&size=%d", r.URL.Path, end, size)
+	}
+	return http.StatusOK, res, nil
+}
+
+// create 校验并保存新实体，填充ID和默认值
+func (s *Server) create(kind string, e map[string]any) (map[string]any, *httpError) {
+	if _, ok := e["id"]; !ok {
+		s.seq++
+		e["id"] = fmt.Sprintf("00000000-0000-4000-8000-%012d", s.seq)
+	}
+	if _, ok := e["created_at"]; !ok {
+		e["created_at"] = time.Now().Unix()
+	}
+	s.setDefaults(kind, e)
+	return s.save(kind, e)
+}
+
+// setDefaults 填充Kong为未设置的字段提供的默认值
+func (s *Server) setDefaults(kind string, e map[string]any) {
+	switch kind {
+	case "upstreams":
+		setDefault(e, "algorithm", "round-robin")
+		setDefault(e, "slots", 10000)
+	case "targets":
+		setDefault(e, "weight", 100)
+	case "services":
+		setDefault(e, "protocol", "http")
+		setDefault(e, "port", 80)
+	case "routes":
+		setDefault(e, "protocols", []any{"http", "https"})
+		setDefault(e, "regex_priority", 0)
+		setDefault(e, "strip_path", true)
+		setDefault(e, "preserve_host", false)
+	case "plugins":
+		setDefault(e, "enabled", true)
+	case "jwt":
+		setDefault(e, "key", s.randomKey())
+		setDefault(e, "secret", s.randomKey())
+		setDefault(e, "algorithm", "HS256")
+	case "key-auth":
+		setDefault(e, "key", s.randomKey())
+	}
+}
+
+// save 校验必填字段、引用和唯一约束后写入
+func (s *Server) save(kind string, e map[string]any) (map[string]any, *httpError) {
+	sc := schemas[kind]
+	for _, field := range sc.required {
+		if isEmpty(e[field]) {
+			return nil, schemaViolation(field, "required field missing")
+		}
+	}
+	if kind == "routes" && isEmpty(e["paths"]) && isEmpty(e["hosts"]) && isEmpty(e["methods"]) && isEmpty(e["headers"]) {
+		return nil, schemaViolation("@entity", "must set one of 'methods', 'hosts', 'headers', 'paths', 'snis' when 'protocols' is 'https'")
+	}
+	if kind == "consumers" && isEmpty(e["username"]) && isEmpty(e["custom_id"]) {
+		return nil, schemaViolation("@entity", "at least one of these fields must be non-empty: 'custom_id', 'username'")
+	}
+	for _, field := range append(sc.refs, sc.parent) {
+		if field == "" || isEmpty(e[field]) {
+			continue
+		}
+		ref, _ := e[field].(map[string]any)
+		key, _ := ref["id"].(string)
+		if key == "" {
+			key, _ = ref["name"].(string)
+		}
+		target := s.find(refKinds[field], key, "")
+		if target == nil {
+			return nil, foreignKeyViolation(field)
+		}
+		e[field] = map[string]any{"id": target["id"]}
+	}
+	id := e["id"].(string)
+	c := s.collections[kind]
+	for _, otherID := range c.order {
+		other := c.items[otherID]
+		if otherID == id {
+			continue
+		}
+		if sc.unique != "" && !isEmpty(e[sc.unique]) && other[sc.unique] == e[sc.unique] &&
+			(sc.parent == "" || refID(other[sc.parent]) == refID(e[sc.parent])) {
+			return nil, uniqueViolation(sc.unique, e[sc.unique])
+		}
+		if kind == "plugins" && other["name"] == e["name"] && samePluginScope(other, e) {
+			return nil, uniqueViolation("name", e["name"])
+		}
+	}
+	if _, ok := c.items[id]; !ok {
+		c.order = append(c.order, id)
+	}
+	c.items[id] = e
+	return clone(e), nil
+}
+
+// delete 删除实体，upstream会级联删除target，service和route会级联删除插件
+func (s *Server) delete(kind, id string) {
+	c := s.collections[kind]
+	delete(c.items, id)
+	for i, v := range c.order {
+		if v == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	field := singular(kind)
+	for childKind, sc := range schemas {
+		if sc.parent != field && !(childKind == "plugins" && (field == "service" || field == "route" || field == "consumer")) {
+			continue
+		}
+		for _, childID := range append([]string(nil), s.collections[childKind].order...) {
+			if refID(s.collections[childKind].items[childID][field]) == id {
+				s.delete(childKind, childID)
+			}
+		}
+	}
+}
+
+// find 按ID或唯一字段查找，scope不为空时只在该父实体下查找
+func (s *Server) find(kind, key, scope string) map[string]any {
+	c, ok := s.collections[kind]
+	if !ok {
+		return nil
+	}
+	sc := schemas[kind]
+	for _, id := range c.order {
+		e := c.items[id]
+		if scope != "" && refID(e[sc.parent]) != scope {
+			continue
+		}
+		if id == key || (sc.unique != "" && e[sc.unique] == key) {
+			return e
+		}
+	}
+	return nil
+}
+
+// randomKey 生成凭证的key和secret，测试中保持确定
+func (s *Server) randomKey() string {
+	s.seq++
+	return fmt.Sprintf("%032x", s.seq)
+}
+
+func samePluginScope(a, b map[string]any) bool {
+	for _, field := range schemas["plugins"].refs {
+		if refID(a[field]) != refID(b[field]) {
+			return false
+		}
+	}
+	return true
+}
+
+func singular(kind string) string {
+	return strings.TrimSuffix(kind, "s")
+}
+
+func refID(v any) string {
+	ref, _ := v.(map[string]any)
+	id, _ := ref["id"].(string)
+	return id
+}
+
+func isID(key string) bool {
+	return len(key) == 36 && strings.Count(key, "-") == 4
+}
+
+func isEmpty(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+	return false
+}
+
+func setDefault(e map[string]any, field string, value any) {
+	if _, ok := e[field]; !ok {
+		e[field] = value
+	}
+}
+
+func clone(e map[string]any) map[string]any {
+	res := make(map[string]any, len(e))
+	for k, v := range e {
+		res[k] = v
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
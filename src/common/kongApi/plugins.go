@@ -0,0 +1,335 @@
+package kongApi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 常用插件名称
+const (
+	PluginJWT              = "jwt"
+	PluginKeyAuth          = "key-auth"
+	PluginRateLimiting     = "rate-limiting"
+	PluginCORS             = "cors"
+	PluginRequestSizeLimit = "request-size-limiting"
+	PluginACL              = "acl"
+)
+
+// PluginScope 插件的作用范围，按名称或ID引用，全部为空时为全局插件
+type PluginScope struct {
+	Service  string
+	Route    string
+	Consumer string
+}
+
+func (s PluginScope) String() string {
+	var parts []string
+	for _, p := range [][2]string{{"service", s.Service}, {"route", s.Route}, {"consumer", s.Consumer}} {
+		if p[1] != "" {
+			parts = append(parts, p[0]+"="+p[1])
+		}
+	}
+	if len(parts) == 0 {
+		return "global"
+	}
+	return strings.Join(parts, ",")
+}
+
+// JWTConfig jwt插件配置，校验请求中由Consumer的jwt凭证签发的token
+type JWTConfig struct {
+	URIParamNames     []string `json:"uri_param_names,omitempty"`
+	CookieNames       []string `json:"cookie_names,omitempty"`
+	HeaderNames       []string `json:"header_names,omitempty"`
+	KeyClaimName      string   `json:"key_claim_name,omitempty"`
+	ClaimsToVerify    []string `json:"claims_to_verify,omitempty"` // exp、nbf
+	SecretIsBase64    bool     `json:"secret_is_base64,omitempty"`
+	MaximumExpiration int      `json:"maximum_expiration,omitempty"` // 秒，需要同时校验exp
+	Anonymous         string   `json:"anonymous,omitempty"`
+	RunOnPreflight    *bool    `json:"run_on_preflight,omitempty"`
+}
+
+func (c JWTConfig) Plugin() Plugin {
+	return Plugin{Name: PluginJWT, Config: toMap(c)}
+}
+
+// KeyAuthConfig key-auth插件配置
+type KeyAuthConfig struct {
+	KeyNames        []string `json:"key_names,omitempty"`
+	HideCredentials bool     `json:"hide_credentials,omitempty"`
+	Anonymous       string   `json:"anonymous,omitempty"`
+}
+
+func (c KeyAuthConfig) Plugin() Plugin {
+	return Plugin{Name: PluginKeyAuth, Config: toMap(c)}
+}
+
+// RateLimitingConfig rate-limiting插件配置，各时间窗口的请求上限至少设置一个
+type RateLimitingConfig struct {
+	Second        int    `json:"second,omitempty"`
+	Minute        int    `json:"minute,omitempty"`
+	Hour          int    `json:"hour,omitempty"`
+	Day           int    `json:"day,omitempty"`
+	LimitBy       string `json:"limit_by,omitempty"` // consumer、credential、ip、service、header、path
+	HeaderName    string `json:"header_name,omitempty"`
+	Policy        string `json:"policy,omitempty"` // local、cluster、redis
+	FaultTolerant *bool  `json:"fault_tolerant,omitempty"`
+	RedisHost     string `json:"redis_host,omitempty"`
+	RedisPort     int    `json:"redis_port,omitempty"`
+	RedisPassword string `json:"redis_password,omitempty"`
+	RedisDatabase int    `json:"redis_database,omitempty"`
+}
+
+func (c RateLimitingConfig) Plugin() Plugin {
+	return Plugin{Name: PluginRateLimiting, Config: toMap(c)}
+}
+
+// CORSConfig cors插件配置
+type CORSConfig struct {
+	Origins           []string `json:"origins,omitempty"`
+	Methods           []string `json:"methods,omitempty"`
+	Headers           []string `json:"headers,omitempty"`
+	ExposedHeaders    []string `json:"exposed_headers,omitempty"`
+	Credentials       bool     `json:"credentials,omitempty"`
+	MaxAge            int      `json:"max_age,omitempty"`
+	PreflightContinue bool     `json:"preflight_continue,omitempty"`
+}
+
+func (c CORSConfig) Plugin() Plugin {
+	return Plugin{Name: PluginCORS, Config: toMap(c)}
+}
+
+// RequestSizeLimitingConfig request-size-limiting插件配置
+type RequestSizeLimitingConfig struct {
+	AllowedPayloadSize int    `json:"allowed_payload_size,omitempty"`
+	SizeUnit           string `json:"size_unit,omitempty"` // bytes、kilobytes、megabytes
+	RequireContentLen  bool   `json:"require_content_length,omitempty"`
+}
+
+func (c RequestSizeLimitingConfig) Plugin() Plugin {
+	return Plugin{Name: PluginRequestSizeLimit, Config: toMap(c)}
+}
+
+// ACLConfig acl插件配置，按Consumer所属的分组放行或拒绝，需要配合认证插件使用
+type ACLConfig struct {
+	Allow            []string `json:"allow,omitempty"`
+	Deny             []string `json:"deny,omitempty"`
+	HideGroupsHeader bool     `json:"hide_groups_header,omitempty"`
+}
+
+func (c ACLConfig) Plugin() Plugin {
+	return Plugin{Name: PluginACL, Config: toMap(c)}
+}
+
+// ListScopedPlugins 列出作用范围与scope完全一致的插件
+func (c *Client) ListScopedPlugins(ctx context.Context, scope PluginScope) ([]Plugin, error) {
+	service, route, consumer, err := c.resolveScope(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	path := "/plugins"
+	switch {
+	case route != nil:
+		path = join("routes", route.ID, "plugins")
+	case service != nil:
+		path = join("services", service.ID, "plugins")
+	case consumer != nil:
+		path = join("consumers", consumer.ID, "plugins")
+	}
+	plugins, err := list[Plugin](ctx, c, path)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Plugin, 0, len(plugins))
+	for _, p := range plugins {
+		if refID(p.Service) == refID(service) && refID(p.Route) == refID(route) && refID(p.Consumer) == refID(consumer) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// FindPlugin 查找scope下指定名称的插件，不存在时返回ErrNotFound
+func (c *Client) FindPlugin(ctx context.Context, name string, scope PluginScope) (*Plugin, error) {
+	plugins, err := c.ListScopedPlugins(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plugins {
+		if p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, fmt.Errorf("kong: plugin %s (%s): %w", name, scope, ErrNotFound)
+}
+
+// EnablePlugin 在scope下启用插件，已存在时更新配置并启用
+func (c *Client) EnablePlugin(ctx context.Context, scope PluginScope, plugin Plugin) (*Plugin, error) {
+	enabled := true
+	plugin.Enabled = &enabled
+	existing, err := c.FindPlugin(ctx, plugin.Name, scope)
+	if err == nil {
+		return c.UpdatePlugin(ctx, existing.ID, &Plugin{Config: plugin.Config, Enabled: &enabled, Protocols: plugin.Protocols, Tags: plugin.Tags})
+	}
+	if !IsNotFound(err) {
+		return nil, err
+	}
+	plugin.ID = ""
+	if plugin.Service, plugin.Route, plugin.Consumer, err = c.resolveScope(ctx, scope); err != nil {
+		return nil, err
+	}
+	return c.CreatePlugin(ctx, &plugin)
+}
+
+// ConfigurePlugin 更新scope下已存在插件的配置，不改变启用状态
+func (c *Client) ConfigurePlugin(ctx context.Context, name string, scope PluginScope, config map[string]any) (*Plugin, error) {
+	existing, err := c.FindPlugin(ctx, name, scope)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdatePlugin(ctx, existing.ID, &Plugin{Config: config})
+}
+
+// DisablePlugin 停用scope下的插件并保留配置，不存在时不报错
+func (c *Client) DisablePlugin(ctx context.Context, name string, scope PluginScope) error {
+	existing, err := c.FindPlugin(ctx, name, scope)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	enabled := false
+	_, err = c.UpdatePlugin(ctx, existing.ID, &Plugin{Enabled: &enabled})
+	return err
+}
+
+// RemovePlugin 删除scope下的插件，不存在时不报错
+func (c *Client) RemovePlugin(ctx context.Context, name string, scope PluginScope) error {
+	existing, err := c.FindPlugin(ctx, name, scope)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.DeletePlugin(ctx, existing.ID)
+}
+
+// resolveScope 将scope中的名称解析为ID
+func (c *Client) resolveScope(ctx context.Context, scope PluginScope) (service, route, consumer *Ref, err error) {
+	if scope.Service != "" {
+		s, err := c.GetService(ctx, scope.Service)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("kong: resolve service %s: %w", scope.Service, err)
+		}
+		service = &Ref{ID: s.ID}
+	}
+	if scope.Route != "" {
+		r, err := c.GetRoute(ctx, scope.Route)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("kong: resolve route %s: %w", scope.Route, err)
+		}
+		route = &Ref{ID: r.ID}
+	}
+	if scope.Consumer != "" {
+		u, err := c.GetConsumer(ctx, scope.Consumer)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("kong: resolve consumer %s: %w", scope.Consumer, err)
+		}
+		consumer = &Ref{ID: u.ID}
+	}
+	return service, route, consumer, nil
+}
+
+func refID(ref *Ref) string {
+	if ref == nil {
+		return ""
+	}
+	return ref.ID
+}
+
+// ---------------- Credential ----------------
+
+// JWTCredential Consumer的jwt凭证，Key对应token中的iss（key_claim_name）
+type JWTCredential struct {
+	ID           string   `json:"id,omitempty"`
+	Key          string   `json:"key,omitempty"`
+	Secret       string   `json:"secret,omitempty"`
+	Algorithm    string   `json:"algorithm,omitempty"` // HS256、RS256等，默认HS256
+	RSAPublicKey string   `json:"rsa_public_key,omitempty"`
+	Consumer     *Ref     `json:"consumer,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	CreatedAt    int64    `json:"created_at,omitempty"`
+}
+
+// KeyAuthCredential Consumer的API Key
+type KeyAuthCredential struct {
+	ID        string   `json:"id,omitempty"`
+	Key       string   `json:"key,omitempty"` // 为空时由Kong生成
+	TTL       int      `json:"ttl,omitempty"`
+	Consumer  *Ref     `json:"consumer,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int64    `json:"created_at,omitempty"`
+}
+
+// ACLGroup Consumer所属的分组，供acl插件使用
+type ACLGroup struct {
+	ID        string   `json:"id,omitempty"`
+	Group     string   `json:"group"`
+	Consumer  *Ref     `json:"consumer,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int64    `json:"created_at,omitempty"`
+}
+
+func (c *Client) ListJWTCredentials(ctx context.Context, consumer string) ([]JWTCredential, error) {
+	return list[JWTCredential](ctx, c, join("consumers", consumer, "jwt"))
+}
+
+func (c *Client) GetJWTCredential(ctx context.Context, consumer, keyOrID string) (*JWTCredential, error) {
+	return get[JWTCredential](ctx, c, join("consumers", consumer, "jwt", keyOrID))
+}
+
+// CreateJWTCredential 为Consumer创建jwt凭证，Key和Secret为空时由Kong生成
+func (c *Client) CreateJWTCredential(ctx context.Context, consumer string, credential *JWTCredential) (*JWTCredential, error) {
+	return send(ctx, c, http.MethodPost, join("consumers", consumer, "jwt"), credential)
+}
+
+func (c *Client) UpdateJWTCredential(ctx context.Context, consumer, keyOrID string, credential *JWTCredential) (*JWTCredential, error) {
+	return send(ctx, c, http.MethodPatch, join("consumers", consumer, "jwt", keyOrID), credential)
+}
+
+func (c *Client) DeleteJWTCredential(ctx context.Context, consumer, keyOrID string) error {
+	return c.do(ctx, http.MethodDelete, join("consumers", consumer, "jwt", keyOrID), nil, nil)
+}
+
+func (c *Client) ListKeyAuthCredentials(ctx context.Context, consumer string) ([]KeyAuthCredential, error) {
+	return list[KeyAuthCredential](ctx, c, join("consumers", consumer, "key-auth"))
+}
+
+func (c *Client) GetKeyAuthCredential(ctx context.Context, consumer, keyOrID string) (*KeyAuthCredential, error) {
+	return get[KeyAuthCredential](ctx, c, join("consumers", consumer, "key-auth", keyOrID))
+}
+
+// CreateKeyAuthCredential 为Consumer创建API Key，Key为空时由Kong生成
+func (c *Client) CreateKeyAuthCredential(ctx context.Context, consumer string, credential *KeyAuthCredential) (*KeyAuthCredential, error) {
+	return send(ctx, c, http.MethodPost, join("consumers", consumer, "key-auth"), credential)
+}
+
+func (c *Client) DeleteKeyAuthCredential(ctx context.Context, consumer, keyOrID string) error {
+	return c.do(ctx, http.MethodDelete, join("consumers", consumer, "key-auth", keyOrID), nil, nil)
+}
+
+func (c *Client) ListACLGroups(ctx context.Context, consumer string) ([]ACLGroup, error) {
+	return list[ACLGroup](ctx, c, join("consumers", consumer, "acls"))
+}
+
+// AddACLGroup 将Consumer加入分组
+func (c *Client) AddACLGroup(ctx context.Context, consumer, group string) (*ACLGroup, error) {
+	return send(ctx, c, http.MethodPost, join("consumers", consumer, "acls"), &ACLGroup{Group: group})
+}
+
+func (c *Client) DeleteACLGroup(ctx context.Context, consumer, groupOrID string) error {
+	return c.do(ctx, http.MethodDelete, join("consumers", consumer, "acls", groupOrID), nil, nil)
+}
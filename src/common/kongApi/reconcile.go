@@ -0,0 +1,427 @@
+package kongApi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DesiredState 一个服务在Kong中的期望状态
+type DesiredState struct {
+	Upstream Upstream // 包含健康检查配置
+	Targets  []Target // 只保证存在且权重一致，不会删除其他实例的target
+	Service  Service  // Host为空时指向同名的Upstream
+	Routes   []Route  // 绑定在Service上的全部路由，多余的路由会被删除
+	Plugins  []Plugin // 作用在Service上的插件，为nil时不管理插件
+}
+
+// Action 对实体的操作
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Operation 计划中的一个操作
+type Operation struct {
+	Action Action
+	Kind   string   // upstream、target、service、route、plugin
+	Name   string   // 实体名称
+	Fields []string // 更新时发生变化的字段
+	apply  func(ctx context.Context, st *applyState) error
+}
+
+func (o Operation) String() string {
+	sign := map[Action]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}[o.Action]
+	s := fmt.Sprintf("%s %s %s %s", sign, o.Action, o.Kind, o.Name)
+	if len(o.Fields) > 0 {
+		s += " (" + strings.Join(o.Fields, ", ") + ")"
+	}
+	return s
+}
+
+// Plan Kong当前状态到期望状态需要执行的操作
+type Plan struct {
+	Service    string
+	Operations []Operation
+}
+
+// Empty 期望状态和Kong一致时返回true
+func (p *Plan) Empty() bool {
+	return len(p.Operations) == 0
+}
+
+func (p *Plan) String() string {
+	if p.Empty() {
+		return fmt.Sprintf("kong plan for %s: no changes\n", p.Service)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "kong plan for %s: %d operation(s)\n", p.Service, len(p.Operations))
+	for _, op := range p.Operations {
+		b.WriteString("  " + op.String() + "\n")
+	}
+	return b.String()
+}
+
+// applyState 执行计划时在操作之间传递的信息
+type applyState struct {
+	serviceID string
+}
+
+// Reconciler 比较期望状态和Kong的实际状态，以幂等的方式创建、更新和删除实体
+type Reconciler struct {
+	client     *Client
+	DryRun     bool      // 只输出计划不执行
+	CreateOnly bool      // 只创建缺少的实体，不更新和删除已存在的实体（target的权重始终同步）
+	Output     io.Writer // DryRun时输出计划，为空时不输出
+}
+
+func NewReconciler(client *Client) *Reconciler {
+	return &Reconciler{client: client}
+}
+
+// Reconcile 生成计划并执行，DryRun时只输出计划
+func (r *Reconciler) Reconcile(ctx context.Context, desired *DesiredState) (*Plan, error) {
+	plan, err := r.Plan(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	if r.DryRun {
+		if r.Output != nil {
+			io.WriteString(r.Output, plan.String())
+		}
+		return plan, nil
+	}
+	return plan, r.Apply(ctx, plan)
+}
+
+// Apply 按顺序执行计划中的操作，遇到错误时停止，再次执行Reconcile可以继续
+func (r *Reconciler) Apply(ctx context.Context, plan *Plan) error {
+	st := &applyState{}
+	for _, op := range plan.Operations {
+		if err := op.apply(ctx, st); err != nil {
+			return fmt.Errorf("%s %s %s: %w", op.Action, op.Kind, op.Name, err)
+		}
+	}
+	return nil
+}
+
+// Plan 读取Kong的实际状态并生成计划，操作顺序为 upstream、target、service、route、plugin
+func (r *Reconciler) Plan(ctx context.Context, desired *DesiredState) (*Plan, error) {
+	if desired.Upstream.Name == "" || desired.Service.Name == "" {
+		return nil, fmt.Errorf("kong: upstream and service name are required")
+	}
+	c := r.client
+	plan := &Plan{Service: desired.Service.Name}
+	add := func(op Operation) {
+		if r.CreateOnly && op.Action != ActionCreate && op.Kind != "target" {
+			return
+		}
+		plan.Operations = append(plan.Operations, op)
+	}
+
+	// Upstream
+	upstream := desired.Upstream
+	actualUpstream, err := c.GetUpstream(ctx, upstream.Name)
+	switch {
+	case IsNotFound(err):
+		add(Operation{Action: ActionCreate, Kind: "upstream", Name: upstream.Name, apply: func(ctx context.Context, st *applyState) error {
+			_, err := c.UpsertUpstream(ctx, &upstream)
+			return err
+		}})
+	case err != nil:
+		return nil, err
+	default:
+		if fields := changedFields("upstream", upstream, actualUpstream); len(fields) > 0 {
+			// PUT替换整个实体，期望状态中清空的字段也会恢复为默认值
+			add(Operation{Action: ActionUpdate, Kind: "upstream", Name: upstream.Name, Fields: fields, apply: func(ctx context.Context, st *applyState) error {
+				_, err := c.UpsertUpstream(ctx, &upstream)
+				return err
+			}})
+		}
+	}
+
+	// Target
+	for _, target := range desired.Targets {
+		target := target
+		var actual *Target
+		if actualUpstream != nil {
+			if actual, err = c.GetTarget(ctx, upstream.Name, target.Target); err != nil && !IsNotFound(err) {
+				return nil, err
+			}
+		}
+		switch {
+		case actual == nil:
+			add(Operation{Action: ActionCreate, Kind: "target", Name: target.Target, apply: func(ctx context.Context, st *applyState) error {
+				_, err := c.UpsertTarget(ctx, upstream.Name, &target)
+				return err
+			}})
+		case actual.Weight != target.Weight:
+			add(Operation{Action: ActionUpdate, Kind: "target", Name: target.Target, Fields: []string{"weight"}, apply: func(ctx context.Context, st *applyState) error {
+				_, err := c.UpsertTarget(ctx, upstream.Name, &target)
+				return err
+			}})
+		}
+	}
+
+	// Service
+	service := desired.Service
+	if service.Host == "" {
+		service.Host = upstream.Name
+	}
+	actualService, err := c.GetService(ctx, service.Name)
+	switch {
+	case IsNotFound(err):
+		actualService = nil
+		add(Operation{Action: ActionCreate, Kind: "service", Name: service.Name, apply: func(ctx context.Context, st *applyState) error {
+			res, err := c.UpsertService(ctx, &service)
+			if err == nil {
+				st.serviceID = res.ID
+			}
+			return err
+		}})
+	case err != nil:
+		return nil, err
+	default:
+		if fields := changedFields("service", service, actualService); len(fields) > 0 {
+			add(Operation{Action: ActionUpdate, Kind: "service", Name: service.Name, Fields: fields, apply: func(ctx context.Context, st *applyState) error {
+				_, err := c.UpsertService(ctx, &service)
+				return err
+			}})
+		}
+	}
+	// 后续的操作需要Service的ID
+	serviceID := func(ctx context.Context, st *applyState) (string, error) {
+		if st.serviceID == "" {
+			res, err := c.GetService(ctx, service.Name)
+			if err != nil {
+				return "", err
+			}
+			st.serviceID = res.ID
+		}
+		return st.serviceID, nil
+	}
+
+	// Route
+	var actualRoutes []Route
+	if actualService != nil {
+		if actualRoutes, err = c.ListServiceRoutes(ctx, actualService.ID); err != nil {
+			return nil, err
+		}
+	}
+	routes := make(map[string]Route, len(actualRoutes))
+	for _, route := range actualRoutes {
+		routes[route.Name] = route
+	}
+	wanted := make(map[string]bool, len(desired.Routes))
+	for _, route := range desired.Routes {
+		route := route
+		route.Service = nil
+		wanted[route.Name] = true
+		actual, ok := routes[route.Name]
+		if !ok {
+			add(Operation{Action: ActionCreate, Kind: "route", Name: route.Name, apply: func(ctx context.Context, st *applyState) error {
+				id, err := serviceID(ctx, st)
+				if err != nil {
+					return err
+				}
+				route.Service = &Ref{ID: id}
+				// 同名路由可能绑定在其他Service上，PUT会覆盖它
+				_, err = c.UpsertRoute(ctx, &route)
+				return err
+			}})
+			continue
+		}
+		actual.Service = nil
+		if fields := changedFields("route", route, actual); len(fields) > 0 {
+			add(Operation{Action: ActionUpdate, Kind: "route", Name: route.Name, Fields: fields, apply: func(ctx context.Context, st *applyState) error {
+				id, err := serviceID(ctx, st)
+				if err != nil {
+					return err
+				}
+				route.Service = &Ref{ID: id}
+				_, err = c.UpsertRoute(ctx, &route)
+				return err
+			}})
+		}
+	}
+	// 新路由创建之后再删除旧路由，避免路径变更时出现短暂的404
+	for _, route := range actualRoutes {
+		if wanted[route.Name] {
+			continue
+		}
+		id, name := route.ID, route.Name
+		if name == "" {
+			name = id
+		}
+		add(Operation{Action: ActionDelete, Kind: "route", Name: name, apply: func(ctx context.Context, st *applyState) error {
+			return c.DeleteRoute(ctx, id)
+		}})
+	}
+
+	// Plugin
+	if desired.Plugins == nil {
+		return plan, nil
+	}
+	var actualPlugins []Plugin
+	if actualService != nil {
+		if actualPlugins, err = c.ListServicePlugins(ctx, actualService.ID); err != nil {
+			return nil, err
+		}
+	}
+	plugins := make(map[string]Plugin, len(actualPlugins))
+	for _, plugin := range actualPlugins {
+		// 只管理作用在整个Service上的插件
+		if plugin.Route == nil && plugin.Consumer == nil {
+			plugins[plugin.Name] = plugin
+		}
+	}
+	wanted = make(map[string]bool, len(desired.Plugins))
+	for _, plugin := range desired.Plugins {
+		plugin := plugin
+		plugin.ID, plugin.Service, plugin.Route, plugin.Consumer = "", nil, nil, nil
+		wanted[plugin.Name] = true
+		actual, ok := plugins[plugin.Name]
+		if !ok {
+			add(Operation{Action: ActionCreate, Kind: "plugin", Name: plugin.Name, apply: func(ctx context.Context, st *applyState) error {
+				id, err := serviceID(ctx, st)
+				if err != nil {
+					return err
+				}
+				plugin.Service = &Ref{ID: id}
+				_, err = c.CreatePlugin(ctx, &plugin)
+				return err
+			}})
+			continue
+		}
+		if fields := changedFields("plugin", plugin, actual); len(fields) > 0 {
+			add(Operation{Action: ActionUpdate, Kind: "plugin", Name: plugin.Name, Fields: fields, apply: func(ctx context.Context, st *applyState) error {
+				id, err := serviceID(ctx, st)
+				if err != nil {
+					return err
+				}
+				plugin.ID, plugin.Service = actual.ID, &Ref{ID: id}
+				_, err = c.UpsertPlugin(ctx, &plugin)
+				return err
+			}})
+		}
+	}
+	for _, plugin := range actualPlugins {
+		if _, managed := plugins[plugin.Name]; !managed || wanted[plugin.Name] {
+			continue
+		}
+		id := plugin.ID
+		add(Operation{Action: ActionDelete, Kind: "plugin", Name: plugin.Name, apply: func(ctx context.Context, st *applyState) error {
+			return c.DeletePlugin(ctx, id)
+		}})
+	}
+	return plan, nil
+}
+
+// kongDefaults Kong为未设置的字段填充的默认值，期望状态和实际状态中未设置的字段都按默认值比较
+var kongDefaults = map[string]map[string]any{
+	"upstream": {"algorithm": "round-robin", "slots": 10000},
+	"service":  {"protocol": "http", "port": 80, "retries": 5, "connect_timeout": 60000, "write_timeout": 60000, "read_timeout": 60000},
+	"route":    {"protocols": []string{"http", "https"}, "regex_priority": 0, "strip_path": true, "preserve_host": false},
+	"plugin":   {"enabled": true, "protocols": []string{"grpc", "grpcs", "http", "https"}},
+}
+
+// nestedFields Kong会为其内部字段填充默认值的对象，按包含关系比较，期望状态中未设置时不比较
+var nestedFields = map[string]bool{"healthchecks": true, "config": true}
+
+// changedFields 逐个比较desired类型声明的字段，返回与actual不一致的字段；
+// 期望状态中清空的字段（如paths、strip_path、tags）与Kong中的值不同也视为变化
+func changedFields(kind string, desired, actual any) []string {
+	d, a := toMap(desired), toMap(actual)
+	defaults := toMap(kongDefaults[kind])
+	var fields []string
+	for _, k := range jsonFields(desired) {
+		switch k {
+		case "id", "name", "created_at", "service", "route", "consumer", "upstream":
+			continue
+		}
+		dv, ok := d[k]
+		if !ok {
+			dv = defaults[k]
+		}
+		av, ok := a[k]
+		if !ok || av == nil {
+			av = defaults[k]
+		}
+		if nestedFields[k] {
+			if dv != nil && !contains(av, dv) {
+				fields = append(fields, k)
+			}
+			continue
+		}
+		if !equalValue(av, dv) {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// jsonFields 返回结构体声明的JSON字段名
+func jsonFields(v any) []string {
+	t := reflect.Indirect(reflect.ValueOf(v)).Type()
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// equalValue 比较两个JSON值，nil与空的数组、对象视为相等
+func equalValue(actual, desired any) bool {
+	if isEmptyValue(actual) && isEmptyValue(desired) {
+		return true
+	}
+	return reflect.DeepEqual(actual, desired)
+}
+
+func isEmptyValue(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+	return false
+}
+
+// contains 判断actual是否包含desired，对象按字段递归比较，其余类型要求相等
+func contains(actual, desired any) bool {
+	d, ok := desired.(map[string]any)
+	if !ok {
+		return reflect.DeepEqual(actual, desired)
+	}
+	a, ok := actual.(map[string]any)
+	if !ok {
+		return false
+	}
+	for k, v := range d {
+		if !contains(a[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+func toMap(v any) map[string]any {
+	data, _ := json.Marshal(v)
+	res := map[string]any{}
+	json.Unmarshal(data, &res)
+	return res
+}
@@ -0,0 +1,203 @@
+package kongApi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ---------------- Upstream ----------------
+
+func (c *Client) ListUpstreams(ctx context.Context) ([]Upstream, error) {
+	return list[Upstream](ctx, c, "/upstreams")
+}
+
+func (c *Client) GetUpstream(ctx context.Context, nameOrID string) (*Upstream, error) {
+	return get[Upstream](ctx, c, join("upstreams", nameOrID))
+}
+
+func (c *Client) CreateUpstream(ctx context.Context, upstream *Upstream) (*Upstream, error) {
+	return send(ctx, c, http.MethodPost, "/upstreams", upstream)
+}
+
+// UpsertUpstream 按名称创建或覆盖Upstream
+func (c *Client) UpsertUpstream(ctx context.Context, upstream *Upstream) (*Upstream, error) {
+	return send(ctx, c, http.MethodPut, join("upstreams", upstream.Name), upstream)
+}
+
+// UpdateUpstream 只更新upstream中非空的字段
+func (c *Client) UpdateUpstream(ctx context.Context, nameOrID string, upstream *Upstream) (*Upstream, error) {
+	return send(ctx, c, http.MethodPatch, join("upstreams", nameOrID), upstream)
+}
+
+// UpdateHealthChecks 更新Upstream的健康检查配置
+func (c *Client) UpdateHealthChecks(ctx context.Context, nameOrID string, healthChecks HealthChecks) error {
+	return c.do(ctx, http.MethodPatch, join("upstreams", nameOrID), map[string]any{"healthchecks": healthChecks}, nil)
+}
+
+// UpstreamHealth 返回Upstream中所有target的健康状态
+func (c *Client) UpstreamHealth(ctx context.Context, nameOrID string) ([]TargetHealth, error) {
+	return list[TargetHealth](ctx, c, join("upstreams", nameOrID, "health"))
+}
+
+func (c *Client) DeleteUpstream(ctx context.Context, nameOrID string) error {
+	return c.do(ctx, http.MethodDelete, join("upstreams", nameOrID), nil, nil)
+}
+
+// ---------------- Target ----------------
+
+func (c *Client) ListTargets(ctx context.Context, upstream string) ([]Target, error) {
+	return list[Target](ctx, c, join("upstreams", upstream, "targets"))
+}
+
+func (c *Client) GetTarget(ctx context.Context, upstream, target string) (*Target, error) {
+	return get[Target](ctx, c, join("upstreams", upstream, "targets", target))
+}
+
+func (c *Client) AddTarget(ctx context.Context, upstream string, target *Target) (*Target, error) {
+	return send(ctx, c, http.MethodPost, join("upstreams", upstream, "targets"), target)
+}
+
+// UpsertTarget 创建或更新Target的权重
+func (c *Client) UpsertTarget(ctx context.Context, upstream string, target *Target) (*Target, error) {
+	return send(ctx, c, http.MethodPut, join("upstreams", upstream, "targets", target.Target), target)
+}
+
+// SetTargetHealth 手动标记target健康或不健康，直到健康检查改变它的状态
+func (c *Client) SetTargetHealth(ctx context.Context, upstream, target string, healthy bool) error {
+	action := "unhealthy"
+	if healthy {
+		action = "healthy"
+	}
+	return c.do(ctx, http.MethodPost, join("upstreams", upstream, "targets", target, action), nil, nil)
+}
+
+func (c *Client) DeleteTarget(ctx context.Context, upstream, target string) error {
+	return c.do(ctx, http.MethodDelete, join("upstreams", upstream, "targets", target), nil, nil)
+}
+
+// ---------------- Service ----------------
+
+func (c *Client) ListServices(ctx context.Context) ([]Service, error) {
+	return list[Service](ctx, c, "/services")
+}
+
+func (c *Client) GetService(ctx context.Context, nameOrID string) (*Service, error) {
+	return get[Service](ctx, c, join("services", nameOrID))
+}
+
+func (c *Client) CreateService(ctx context.Context, service *Service) (*Service, error) {
+	return send(ctx, c, http.MethodPost, "/services", service)
+}
+
+// UpsertService 按名称创建或覆盖Service
+func (c *Client) UpsertService(ctx context.Context, service *Service) (*Service, error) {
+	return send(ctx, c, http.MethodPut, join("services", service.Name), service)
+}
+
+func (c *Client) UpdateService(ctx context.Context, nameOrID string, service *Service) (*Service, error) {
+	return send(ctx, c, http.MethodPatch, join("services", nameOrID), service)
+}
+
+func (c *Client) DeleteService(ctx context.Context, nameOrID string) error {
+	return c.do(ctx, http.MethodDelete, join("services", nameOrID), nil, nil)
+}
+
+// ---------------- Route ----------------
+
+func (c *Client) ListRoutes(ctx context.Context) ([]Route, error) {
+	return list[Route](ctx, c, "/routes")
+}
+
+// ListServiceRoutes 列出绑定在Service上的路由
+func (c *Client) ListServiceRoutes(ctx context.Context, service string) ([]Route, error) {
+	return list[Route](ctx, c, join("services", service, "routes"))
+}
+
+func (c *Client) GetRoute(ctx context.Context, nameOrID string) (*Route, error) {
+	return get[Route](ctx, c, join("routes", nameOrID))
+}
+
+func (c *Client) CreateRoute(ctx context.Context, route *Route) (*Route, error) {
+	return send(ctx, c, http.MethodPost, "/routes", route)
+}
+
+// UpsertRoute 按名称创建或覆盖Route
+func (c *Client) UpsertRoute(ctx context.Context, route *Route) (*Route, error) {
+	return send(ctx, c, http.MethodPut, join("routes", route.Name), route)
+}
+
+func (c *Client) UpdateRoute(ctx context.Context, nameOrID string, route *Route) (*Route, error) {
+	return send(ctx, c, http.MethodPatch, join("routes", nameOrID), route)
+}
+
+func (c *Client) DeleteRoute(ctx context.Context, nameOrID string) error {
+	return c.do(ctx, http.MethodDelete, join("routes", nameOrID), nil, nil)
+}
+
+// ---------------- Plugin ----------------
+
+func (c *Client) ListPlugins(ctx context.Context) ([]Plugin, error) {
+	return list[Plugin](ctx, c, "/plugins")
+}
+
+// ListServicePlugins 列出作用在Service上的插件
+func (c *Client) ListServicePlugins(ctx context.Context, service string) ([]Plugin, error) {
+	return list[Plugin](ctx, c, join("services", service, "plugins"))
+}
+
+// ListRoutePlugins 列出作用在Route上的插件
+func (c *Client) ListRoutePlugins(ctx context.Context, route string) ([]Plugin, error) {
+	return list[Plugin](ctx, c, join("routes", route, "plugins"))
+}
+
+func (c *Client) GetPlugin(ctx context.Context, id string) (*Plugin, error) {
+	return get[Plugin](ctx, c, join("plugins", id))
+}
+
+func (c *Client) CreatePlugin(ctx context.Context, plugin *Plugin) (*Plugin, error) {
+	return send(ctx, c, http.MethodPost, "/plugins", plugin)
+}
+
+// UpsertPlugin 按ID创建或覆盖插件
+func (c *Client) UpsertPlugin(ctx context.Context, plugin *Plugin) (*Plugin, error) {
+	if plugin.ID == "" {
+		return nil, errors.New("kong: plugin id is required for upsert")
+	}
+	return send(ctx, c, http.MethodPut, join("plugins", plugin.ID), plugin)
+}
+
+func (c *Client) UpdatePlugin(ctx context.Context, id string, plugin *Plugin) (*Plugin, error) {
+	return send(ctx, c, http.MethodPatch, join("plugins", id), plugin)
+}
+
+func (c *Client) DeletePlugin(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, join("plugins", id), nil, nil)
+}
+
+// ---------------- Consumer ----------------
+
+func (c *Client) ListConsumers(ctx context.Context) ([]Consumer, error) {
+	return list[Consumer](ctx, c, "/consumers")
+}
+
+func (c *Client) GetConsumer(ctx context.Context, usernameOrID string) (*Consumer, error) {
+	return get[Consumer](ctx, c, join("consumers", usernameOrID))
+}
+
+func (c *Client) CreateConsumer(ctx context.Context, consumer *Consumer) (*Consumer, error) {
+	return send(ctx, c, http.MethodPost, "/consumers", consumer)
+}
+
+// UpsertConsumer 按用户名创建或覆盖Consumer
+func (c *Client) UpsertConsumer(ctx context.Context, consumer *Consumer) (*Consumer, error) {
+	return send(ctx, c, http.MethodPut, join("consumers", consumer.Username), consumer)
+}
+
+func (c *Client) UpdateConsumer(ctx context.Context, usernameOrID string, consumer *Consumer) (*Consumer, error) {
+	return send(ctx, c, http.MethodPatch, join("consumers", usernameOrID), consumer)
+}
+
+func (c *Client) DeleteConsumer(ctx context.Context, usernameOrID string) error {
+	return c.do(ctx, http.MethodDelete, join("consumers", usernameOrID), nil, nil)
+}
@@ -0,0 +1,215 @@
+package kongApi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScheduledRoute 只在一段时间内生效的路由，如秒杀活动的高优先级路由
+type ScheduledRoute struct {
+	Route     Route // 需要设置Name和Service
+	StartTime time.Time
+	EndTime   time.Time
+	Lead      time.Duration // 提前创建路由的时间，为0时使用RouteScheduler.Lead
+	Downgrade *int          // 结束后降级到的regex_priority，为nil时删除路由
+}
+
+// RouteScheduler 在活动开始前创建路由，结束后删除或降级路由
+type RouteScheduler struct {
+	client *Client
+	Lead   time.Duration // 默认提前创建路由的时间
+	Resync time.Duration // 定期重新同步，修复被手动修改的路由
+
+	lock   sync.Mutex
+	routes map[string]*ScheduledRoute
+	wake   chan struct{}
+}
+
+func NewRouteScheduler(client *Client) *RouteScheduler {
+	return &RouteScheduler{
+		client: client,
+		Lead:   time.Minute,
+		Resync: time.Minute,
+		routes: make(map[string]*ScheduledRoute),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Schedule 添加或替换同名路由的时间计划
+func (s *RouteScheduler) Schedule(route ScheduledRoute) error {
+	if route.Route.Name == "" || route.Route.Service == nil {
+		return errors.New("kong: scheduled route requires name and service")
+	}
+	if !route.EndTime.After(route.StartTime) {
+		return fmt.Errorf("kong: scheduled route %s ends before it starts", route.Route.Name)
+	}
+	s.lock.Lock()
+	s.routes[route.Route.Name] = &route
+	s.lock.Unlock()
+	s.notify()
+	return nil
+}
+
+// Cancel 取消计划并删除已创建的路由
+func (s *RouteScheduler) Cancel(ctx context.Context, name string) error {
+	s.lock.Lock()
+	delete(s.routes, name)
+	s.lock.Unlock()
+	s.notify()
+	if err := s.client.DeleteRoute(ctx, name); err != nil && !IsNotFound(err) {
+		return fmt.Errorf("kong: delete route %s: %w", name, err)
+	}
+	return nil
+}
+
+// Scheduled 返回尚未结束的路由名称
+func (s *RouteScheduler) Scheduled() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make([]string, 0, len(s.routes))
+	for name := range s.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sync 按now将所有路由同步到应有的状态，已结束的路由处理后移出计划
+func (s *RouteScheduler) Sync(ctx context.Context, now time.Time) error {
+	s.lock.Lock()
+	routes := make([]*ScheduledRoute, 0, len(s.routes))
+	for _, route := range s.routes {
+		routes = append(routes, route)
+	}
+	s.lock.Unlock()
+
+	var errs []error
+	for _, route := range routes {
+		switch {
+		case now.Before(route.StartTime.Add(-s.lead(route))):
+			// 未到创建时间
+		case now.Before(route.EndTime):
+			if err := s.activate(ctx, route); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			if err := s.expire(ctx, route); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			s.lock.Lock()
+			if s.routes[route.Route.Name] == route {
+				delete(s.routes, route.Route.Name)
+			}
+			s.lock.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run 在每个开始和结束时间点执行Sync，直到ctx结束
+func (s *RouteScheduler) Run(ctx context.Context) error {
+	for {
+		now := time.Now()
+		if err := s.Sync(ctx, now); err != nil {
+			slog.Warn("kong route schedule sync failed", "error", err)
+		}
+		timer := time.NewTimer(s.next(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// next 距离下一个开始或结束时间点的间隔，不超过Resync
+func (s *RouteScheduler) next(now time.Time) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	wait := s.Resync
+	for _, route := range s.routes {
+		for _, at := range []time.Time{route.StartTime.Add(-s.lead(route)), route.EndTime} {
+			if d := at.Sub(now); d > 0 && d < wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// activate 创建路由，已存在但与计划不一致时覆盖
+func (s *RouteScheduler) activate(ctx context.Context, route *ScheduledRoute) error {
+	desired := route.Route
+	service, err := s.client.GetService(ctx, refKey(desired.Service))
+	if err != nil {
+		return fmt.Errorf("kong: get service of route %s: %w", desired.Name, err)
+	}
+	desired.Service = nil
+	actual, err := s.client.GetRoute(ctx, desired.Name)
+	if err != nil && !IsNotFound(err) {
+		return fmt.Errorf("kong: get route %s: %w", desired.Name, err)
+	}
+	if actual != nil && refID(actual.Service) == service.ID {
+		actual.Service = nil
+		if len(changedFields("route", desired, actual)) == 0 {
+			return nil
+		}
+	}
+	desired.Service = &Ref{ID: service.ID}
+	if _, err := s.client.UpsertRoute(ctx, &desired); err != nil {
+		return fmt.Errorf("kong: upsert route %s: %w", desired.Name, err)
+	}
+	slog.Info("scheduled kong route activated", "route", desired.Name, "start", route.StartTime, "end", route.EndTime)
+	return nil
+}
+
+// expire 删除路由或降低它的优先级
+func (s *RouteScheduler) expire(ctx context.Context, route *ScheduledRoute) error {
+	name := route.Route.Name
+	if route.Downgrade == nil {
+		if err := s.client.DeleteRoute(ctx, name); err != nil && !IsNotFound(err) {
+			return fmt.Errorf("kong: delete route %s: %w", name, err)
+		}
+		slog.Info("scheduled kong route removed", "route", name)
+		return nil
+	}
+	_, err := s.client.UpdateRoute(ctx, name, &Route{RegexPriority: route.Downgrade})
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("kong: downgrade route %s: %w", name, err)
+	}
+	slog.Info("scheduled kong route downgraded", "route", name, "regex_priority", *route.Downgrade)
+	return nil
+}
+
+func (s *RouteScheduler) lead(route *ScheduledRoute) time.Duration {
+	if route.Lead > 0 {
+		return route.Lead
+	}
+	return s.Lead
+}
+
+func (s *RouteScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func refKey(ref *Ref) string {
+	if ref.ID != "" {
+		return ref.ID
+	}
+	return ref.Name
+}
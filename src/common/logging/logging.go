@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"tracing"
+)
+
+// 日志中的公共字段名
+const (
+	KeyService   = "service"
+	KeyInstance  = "instance"
+	KeyRequestID = "request_id"
+	KeyTraceID   = "trace_id"
+	KeySpanID    = "span_id"
+)
+
+// level 全局日志级别，可在运行时通过配置中心修改
+var level = new(slog.LevelVar)
+
+// Options 日志初始化参数
+type Options struct {
+	Service  string    // 服务名称
+	Instance string    // 实例ID
+	Level    string    // debug/info/warn/error，默认info
+	Output   io.Writer // 默认标准输出
+	Text     bool      // 使用文本格式（本地调试），默认JSON
+}
+
+// Setup 初始化全局日志：设置slog默认logger，标准库log的输出也会转为结构化日志
+func Setup(opts Options) error {
+	if opts.Level != "" {
+		if err := SetLevel(opts.Level); err != nil {
+			return err
+		}
+	}
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if opts.Text {
+		h = slog.NewTextHandler(out, handlerOpts)
+	} else {
+		h = slog.NewJSONHandler(out, handlerOpts)
+	}
+	var attrs []slog.Attr
+	if opts.Service != "" {
+		attrs = append(attrs, slog.String(KeyService, opts.Service))
+	}
+	if opts.Instance != "" {
+		attrs = append(attrs, slog.String(KeyInstance, opts.Instance))
+	}
+	slog.SetDefault(slog.New(NewContextHandler(h.WithAttrs(attrs))))
+	return nil
+}
+
+// ParseLevel 解析日志级别
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return l, fmt.Errorf("invalid log level %q: %w", s, err)
+	}
+	return l, nil
+}
+
+// SetLevel 动态修改日志级别
+func SetLevel(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level 返回当前的日志级别
+func Level() slog.Level {
+	return level.Level()
+}
+
+type fieldsKey struct{}
+
+// WithFields 在context中附加日志字段，之后使用该context记录的日志都会带上这些字段
+func WithFields(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	record := slog.Record{}
+	record.Add(args...)
+	merged := make([]slog.Attr, 0, len(fields)+record.NumAttrs())
+	merged = append(merged, fields...)
+	record.Attrs(func(a slog.Attr) bool {
+		merged = append(merged, a)
+		return true
+	})
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// Fields 返回context中附加的日志字段
+func Fields(ctx context.Context) []slog.Attr {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	return fields
+}
+
+// ContextHandler 从context中读取请求ID、trace id等字段追加到每条日志
+type ContextHandler struct {
+	slog.Handler
+}
+
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		r.AddAttrs(Fields(ctx)...)
+		if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String(KeyTraceID, sc.TraceID.String()), slog.String(KeySpanID, sc.SpanID.String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
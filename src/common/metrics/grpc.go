@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// 与 go-grpc-prometheus 一致的gRPC指标
+var (
+	grpcServerStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_started_total",
+		Help: "Total number of RPCs started on the server.",
+	}, []string{"grpc_type", "grpc_service", "grpc_method"})
+
+	grpcServerHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Total number of RPCs completed on the server, regardless of success or failure.",
+	}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"})
+
+	grpcServerHandling = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Histogram of response latency of gRPC that had been application-level handled by the server.",
+		Buckets: latencyBuckets,
+	}, []string{"grpc_type", "grpc_service", "grpc_method"})
+
+	grpcClientStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_started_total",
+		Help: "Total number of RPCs started on the client.",
+	}, []string{"grpc_type", "grpc_service", "grpc_method"})
+
+	grpcClientHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_handled_total",
+		Help: "Total number of RPCs completed by the client, regardless of success or failure.",
+	}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"})
+
+	grpcClientHandling = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_client_handling_seconds",
+		Help:    "Histogram of response latency of the gRPC until it is finished by the application.",
+		Buckets: latencyBuckets,
+	}, []string{"grpc_type", "grpc_service", "grpc_method"})
+)
+
+func grpcCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		grpcServerStarted, grpcServerHandled, grpcServerHandling,
+		grpcClientStarted, grpcClientHandled, grpcClientHandling,
+	}
+}
+
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+func streamType(client, server bool) string {
+	switch {
+	case client && server:
+		return "bidi_stream"
+	case client:
+		return "client_stream"
+	case server:
+		return "server_stream"
+	default:
+		return "unary"
+	}
+}
+
+type rpcObserver struct {
+	started  *prometheus.CounterVec
+	handled  *prometheus.CounterVec
+	handling *prometheus.HistogramVec
+}
+
+var (
+	serverObserver = rpcObserver{grpcServerStarted, grpcServerHandled, grpcServerHandling}
+	clientObserver = rpcObserver{grpcClientStarted, grpcClientHandled, grpcClientHandling}
+)
+
+// start 记录开始并返回结束时调用的函数
+func (o rpcObserver) start(typ, fullMethod string) func(err error) {
+	service, method := splitMethod(fullMethod)
+	o.started.WithLabelValues(typ, service, method).Inc()
+	start := time.Now()
+	return func(err error) {
+		o.handled.WithLabelValues(typ, service, method, status.Code(err).String()).Inc()
+		o.handling.WithLabelValues(typ, service, method).Observe(Since(start))
+	}
+}
+
+// UnaryServerInterceptor 统计服务端一元调用
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		done := serverObserver.start("unary", info.FullMethod)
+		resp, err := handler(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor 统计服务端流式调用，耗时为整个流的持续时间
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done := serverObserver.start(streamType(info.IsClientStream, info.IsServerStream), info.FullMethod)
+		err := handler(srv, ss)
+		done(err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor 统计客户端一元调用
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done := clientObserver.start("unary", method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor 统计客户端流式调用，仅记录建立流的结果
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done := clientObserver.start(streamType(desc.ClientStreams, desc.ServerStreams), method)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		done(err)
+		return stream, err
+	}
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// Registry 所有公共模块和业务指标共用的注册中心
+var Registry = prometheus.NewRegistry()
+
+// 延迟直方图的桶（秒），覆盖 0.5ms ~ 10s
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	// CacheRequests 缓存查询次数，result 为 hit/miss
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Total number of cache lookups.",
+	}, []string{"cache", "result"})
+
+	// CacheEvictions 缓存淘汰次数，reason 为 capacity/expired/rejected/invalidated/gap
+	CacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_evictions_total",
+		Help: "Total number of cache entries evicted.",
+	}, []string{"cache", "reason"})
+
+	// RedisDuration redis命令耗时
+	RedisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "Latency of redis commands.",
+		Buckets: latencyBuckets,
+	}, []string{"command", "status"})
+
+	// DBDuration 数据库操作耗时
+	DBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Latency of database operations.",
+		Buckets: latencyBuckets,
+	}, []string{"operation", "table", "status"})
+
+	// MQPublished 消息发送次数
+	MQPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mq_messages_published_total",
+		Help: "Total number of messages published to the message queue.",
+	}, []string{"exchange", "routing_key", "status"})
+
+	// MQConsumed 消息消费次数
+	MQConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mq_messages_consumed_total",
+		Help: "Total number of messages consumed from the message queue.",
+	}, []string{"queue", "status"})
+
+	// WriteBehindOps 写回消费者处理的写操作数，result 为 ok/skipped/retried/dead/requeued
+	WriteBehindOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_write_behind_ops_total",
+		Help: "Total number of write-behind operations applied by storage consumers.",
+	}, []string{"queue", "result"})
+
+	// EtcdKeepAlives etcd租约续约次数，status 为 ok/lost
+	EtcdKeepAlives = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "etcd_lease_keepalive_total",
+		Help: "Total number of etcd lease keepalive responses.",
+	}, []string{"status"})
+
+	// EtcdLeaseAlive 服务注册的租约是否存活（1存活，0失效）
+	EtcdLeaseAlive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "etcd_lease_alive",
+		Help: "Whether the service registration lease is alive.",
+	})
+
+	// EtcdLeaseTTL 最近一次续约返回的TTL
+	EtcdLeaseTTL = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "etcd_lease_ttl_seconds",
+		Help: "TTL returned by the last etcd lease keepalive.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CacheRequests, CacheEvictions, RedisDuration, DBDuration,
+		MQPublished, MQConsumed, WriteBehindOps, EtcdKeepAlives, EtcdLeaseAlive, EtcdLeaseTTL,
+	)
+	Registry.MustRegister(grpcCollectors()...)
+}
+
+// Handler 返回 /metrics 的处理函数
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Register 注册指标，同名指标已注册时返回已有的指标，便于热更新时重复调用
+func Register[C prometheus.Collector](c C) (C, error) {
+	if err := Registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
+
+// Status 将错误转换为指标中的 status 标签
+func Status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Since 返回从start开始经过的秒数
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
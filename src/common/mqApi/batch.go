@@ -0,0 +1,145 @@
+package mqApi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log/slog"
+	"metrics"
+	"time"
+	"tracing"
+)
+
+// DeadLetterSuffix 死信队列名称的后缀，qname的死信队列为 qname + DeadLetterSuffix
+const DeadLetterSuffix = ".dead"
+
+// DeadLetterReasonHeader 死信消息头中记录失败原因的键
+const DeadLetterReasonHeader = "x-dead-letter-reason"
+
+// BatchHandler 批量处理消息，返回与msgs一一对应的错误
+// 为nil的消息被确认，不为nil的消息重新入队
+type BatchHandler func(msgs []MqMsg) []error
+
+// ConsumeBatch 持续消费qname中的消息，凑满size条或第一条消息等待wait之后交给handler处理
+// 预取数量为size，直到StopConsumers或通道关闭；无法解析的消息转入死信队列
+func (r *RabbitMQApi) ConsumeBatch(qname string, size int, wait time.Duration, handler BatchHandler) error {
+	if size <= 0 {
+		size = 1
+	}
+	tag, done, err := r.consumers.add(qname)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := r.channel.Qos(size, 0, false); err != nil {
+		return fmt.Errorf("failed to set qos: %w", err)
+	}
+	deliveries, err := r.subscribe(qname, tag)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]amqp.Delivery, 0, size)
+	var flush <-chan time.Time
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				// 取消订阅后处理完已投递的消息再返回
+				r.handleBatch(qname, batch, handler)
+				return nil
+			}
+			batch = append(batch, d)
+			if len(batch) == 1 {
+				flush = time.After(wait)
+			}
+			if len(batch) < size {
+				continue
+			}
+		case <-flush:
+		}
+		r.handleBatch(qname, batch, handler)
+		batch, flush = batch[:0], nil
+	}
+}
+
+func (r *RabbitMQApi) handleBatch(qname string, batch []amqp.Delivery, handler BatchHandler) {
+	if len(batch) == 0 {
+		return
+	}
+	msgs := make([]MqMsg, 0, len(batch))
+	spans := make([]*tracing.Span, 0, len(batch))
+	valid := make([]amqp.Delivery, 0, len(batch))
+	for _, d := range batch {
+		var msg MqMsg
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			slog.Warn("failed to unmarshal message", "queue", qname, "error", err)
+			metrics.MQConsumed.WithLabelValues(qname, "error").Inc()
+			if err := r.publishDead(qname, d.Body, d.Headers, err); err != nil {
+				slog.Error("failed to dead-letter message", "queue", qname, "error", err)
+				d.Nack(false, false)
+				continue
+			}
+			d.Ack(false)
+			continue
+		}
+		msg, span := traceReceived(d, msg, qname)
+		msgs = append(msgs, msg)
+		spans = append(spans, span)
+		valid = append(valid, d)
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	errs := handler(msgs)
+	for i, d := range valid {
+		if i < len(errs) {
+			spans[i].RecordError(errs[i])
+		}
+		spans[i].End()
+		if i < len(errs) && errs[i] != nil {
+			slog.Warn("failed to handle message, requeued", "queue", qname, "error", errs[i])
+			metrics.MQConsumed.WithLabelValues(qname, "error").Inc()
+			d.Nack(false, true)
+			continue
+		}
+		metrics.MQConsumed.WithLabelValues(qname, "ok").Inc()
+		d.Ack(false)
+	}
+}
+
+// DeadLetter 将处理失败的消息发送到qname的死信队列，消息头中记录失败原因
+func (r *RabbitMQApi) DeadLetter(qname string, msg MqMsg, reason error) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	return r.publishDead(qname, body, headers, reason)
+}
+
+func (r *RabbitMQApi) publishDead(qname string, body []byte, headers amqp.Table, reason error) error {
+	dead := qname + DeadLetterSuffix
+	if _, err := r.channel.QueueDeclare(dead, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead letter queue: %w", err)
+	}
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[DeadLetterReasonHeader] = reason.Error()
+	// 通过默认交换机直接投递到死信队列
+	err := r.channel.Publish("", dead, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		Headers:      headers,
+		Body:         body,
+		DeliveryMode: amqp.Persistent,
+	})
+	metrics.MQPublished.WithLabelValues("", dead, metrics.Status(err)).Inc()
+	if err != nil {
+		return fmt.Errorf("failed to publish dead letter: %w", err)
+	}
+	return nil
+}
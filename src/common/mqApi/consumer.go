@@ -0,0 +1,151 @@
+package mqApi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log/slog"
+	"metrics"
+	"sync"
+)
+
+// ErrConsumerStopped 已调用StopConsumers，不再接受新的消费者
+var ErrConsumerStopped = errors.New("rabbitmq: consumers stopped")
+
+// Handler 处理一条消息，返回nil时确认消息，返回错误时消息重新入队
+type Handler func(ctx context.Context, msg MqMsg) error
+
+// consumers 记录运行中的消费者，退出时取消订阅并等待处理中的消息
+type consumers struct {
+	lock     sync.Mutex
+	tags     map[string]struct{}
+	seq      int
+	stopped  bool
+	inflight sync.WaitGroup
+}
+
+// queueName 返回qname绑定时声明的队列名称
+func (r *RabbitMQApi) queueName(qname string) string {
+	if q, ok := r.routingQueues[qname]; ok {
+		return q.Name
+	} else if q, ok := r.publicQueues[qname]; ok {
+		return q.Name
+	}
+	return qname
+}
+
+// add 登记一个消费者，返回消费者名称和退出时的清理函数
+func (c *consumers) add(qname string) (string, func(), error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return "", nil, ErrConsumerStopped
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]struct{})
+	}
+	c.seq++
+	tag := fmt.Sprintf("%s-%d", qname, c.seq)
+	c.tags[tag] = struct{}{}
+	c.inflight.Add(1)
+	return tag, func() {
+		c.lock.Lock()
+		delete(c.tags, tag)
+		c.lock.Unlock()
+		c.inflight.Done()
+	}, nil
+}
+
+// subscribe 以手动确认的方式订阅队列，订阅期间调用了StopConsumers时立即取消订阅
+func (r *RabbitMQApi) subscribe(qname, tag string) (<-chan amqp.Delivery, error) {
+	deliveries, err := r.channel.Consume(
+		r.queueName(qname), // 队列名称
+		tag,                // 消费者名称，StopConsumers据此取消订阅
+		false,              // 手动确认，处理完成后再ack
+		false,              // 是否独占
+		false,              // noLocal
+		false,              // 是否不等待服务端确认
+		nil,                // 附加参数
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register a consumer: %w", err)
+	}
+	c := &r.consumers
+	c.lock.Lock()
+	stopped := c.stopped
+	c.lock.Unlock()
+	if stopped {
+		r.channel.Cancel(tag, false)
+	}
+	return deliveries, nil
+}
+
+// Consume 持续消费qname中的消息并手动确认，直到StopConsumers或通道关闭
+func (r *RabbitMQApi) Consume(qname string, handler Handler) error {
+	tag, done, err := r.consumers.add(qname)
+	if err != nil {
+		return err
+	}
+	defer done()
+	deliveries, err := r.subscribe(qname, tag)
+	if err != nil {
+		return err
+	}
+	// 取消订阅后deliveries关闭，已投递的消息处理完再返回
+	for d := range deliveries {
+		r.handle(qname, d, handler)
+	}
+	return nil
+}
+
+func (r *RabbitMQApi) handle(qname string, d amqp.Delivery, handler Handler) {
+	var msg MqMsg
+	if err := json.Unmarshal(d.Body, &msg); err != nil {
+		slog.Warn("failed to unmarshal message", "queue", qname, "error", err)
+		metrics.MQConsumed.WithLabelValues(qname, "error").Inc()
+		d.Nack(false, false) // 无法解析的消息不再重试
+		return
+	}
+	msg, span := traceReceived(d, msg, qname)
+	defer span.End()
+	if err := handler(msg.Context(context.Background()), msg); err != nil {
+		span.RecordError(err)
+		slog.Warn("failed to handle message, requeued", "queue", qname, "error", err)
+		metrics.MQConsumed.WithLabelValues(qname, "error").Inc()
+		d.Nack(false, true)
+		return
+	}
+	metrics.MQConsumed.WithLabelValues(qname, "ok").Inc()
+	d.Ack(false)
+}
+
+// StopConsumers 取消所有消费者的订阅，等待处理中的消息确认后返回
+// ctx结束时不再等待，未确认的消息在连接关闭后由RabbitMQ重新投递
+func (r *RabbitMQApi) StopConsumers(ctx context.Context) error {
+	c := &r.consumers
+	c.lock.Lock()
+	c.stopped = true
+	tags := make([]string, 0, len(c.tags))
+	for tag := range c.tags {
+		tags = append(tags, tag)
+	}
+	c.lock.Unlock()
+	for _, tag := range tags {
+		if err := r.channel.Cancel(tag, false); err != nil {
+			slog.Warn("failed to cancel consumer", "consumer", tag, "error", err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("rabbitmq: wait for in-flight messages: %w", ctx.Err())
+	}
+}
@@ -1,5 +1,10 @@
 package mqApi
 
+import (
+	"context"
+	"tracing"
+)
+
 type MsgType int
 
 const (
@@ -7,12 +12,34 @@ const (
 	StorageUpdate
 	StorageDelete
 	StorageCreate
+	CacheInvalidate
 )
 
 type MqMsg struct {
-	MsgType MsgType     `json:"type"`
-	Data    interface{} `json:"data"`
+	MsgType MsgType           `json:"type"`
+	Data    interface{}       `json:"data"`
+	Headers map[string]string `json:"headers,omitempty"` // 消息头（链路追踪的traceparent等）
+}
+
+// WithContext 将ctx中的链路信息写入消息头，消费者据此延续调用链
+func (m MqMsg) WithContext(ctx context.Context) MqMsg {
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, tracing.MapCarrier(headers))
+	m.Headers = headers
+	return m
 }
+
+// Context 从消息头中恢复链路信息，消费者应在返回的context下处理消息
+func (m MqMsg) Context(ctx context.Context) context.Context {
+	if len(m.Headers) == 0 {
+		return ctx
+	}
+	return tracing.Extract(ctx, tracing.MapCarrier(m.Headers))
+}
+
 type MqApi interface {
 	RecvMsg(qname string) (interface{}, error)
 	SendMsg(msg MqMsg, routingKey string) error
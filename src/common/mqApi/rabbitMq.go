@@ -1,10 +1,15 @@
 package mqApi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/streadway/amqp"
-	"log"
+	"log/slog"
+	"metrics"
+	"sync/atomic"
+	"tracing"
 )
 
 // RabbitMQApi 实现 MqApi 接口
@@ -15,6 +20,8 @@ type RabbitMQApi struct {
 	publicQueues  map[string]amqp.Queue
 	exchange      string // 交换机名称
 	exchangeType  string // 交换机类型
+	channelClosed atomic.Bool
+	consumers     consumers
 }
 
 // NewRabbitMQApi 创建新的 RabbitMQ API 实例
@@ -47,14 +54,23 @@ func NewRabbitMQApi(amqpURL, exchange, exchangeType string) (*RabbitMQApi, error
 	if err != nil {
 		return nil, fmt.Errorf("failed to declare an exchange: %v", err)
 	}
-	return &RabbitMQApi{
+	r := &RabbitMQApi{
 		conn:          conn,
 		channel:       channel,
 		exchange:      exchange,
 		exchangeType:  exchangeType,
 		publicQueues:  make(map[string]amqp.Queue),
 		routingQueues: make(map[string]amqp.Queue),
-	}, nil
+	}
+	// 通道因错误被服务端关闭后不能再使用
+	closed := channel.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if err, ok := <-closed; ok {
+			slog.Warn("rabbitmq channel closed", "exchange", exchange, "error", err)
+		}
+		r.channelClosed.Store(true)
+	}()
+	return r, nil
 }
 func (r *RabbitMQApi) bindPubQ(cname string) error {
 	q, ex := r.publicQueues[cname]
@@ -117,6 +133,28 @@ func (r *RabbitMQApi) BindQ(qname string, routingKey string) error {
 	}
 	return nil
 }
+
+// BindExclusiveQ 声明服务端命名的独占队列并按routingKey绑定到交换机，返回队列名称
+// 队列在连接断开后自动删除，用于每个实例各自接收一份广播消息
+func (r *RabbitMQApi) BindExclusiveQ(routingKey string) (string, error) {
+	queue, err := r.channel.QueueDeclare(
+		"",    // 由服务端生成队列名称
+		false, // 是否持久化
+		true,  // 是否自动删除
+		true,  // 是否独占
+		false, // 是否阻塞
+		nil,   // 额外属性
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to declare a queue: %w", err)
+	}
+	if err := r.channel.QueueBind(queue.Name, routingKey, r.exchange, false, nil); err != nil {
+		return "", fmt.Errorf("failed to bind a queue: %w", err)
+	}
+	r.routingQueues[queue.Name] = queue
+	return queue.Name, nil
+}
+
 func (r *RabbitMQApi) recvSimple(qname string) (interface{}, error) {
 
 	msgs, err := r.channel.Consume(
@@ -135,9 +173,14 @@ func (r *RabbitMQApi) recvSimple(qname string) (interface{}, error) {
 	for msg := range msgs {
 		var mqMsg MqMsg
 		if err := json.Unmarshal(msg.Body, &mqMsg); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
+			slog.Warn("failed to unmarshal message", "queue", qname, "error", err)
+			metrics.MQConsumed.WithLabelValues(qname, "error").Inc()
 			continue
 		}
+		metrics.MQConsumed.WithLabelValues(qname, "ok").Inc()
+		// 消息交给调用方处理，span只记录接收
+		mqMsg, span := traceReceived(msg, mqMsg, qname)
+		span.End()
 		return mqMsg, nil
 	}
 
@@ -163,9 +206,13 @@ func (r *RabbitMQApi) recvPublish(cname string) (interface{}, error) {
 	for msg := range msgs {
 		var mqMsg MqMsg
 		if err := json.Unmarshal(msg.Body, &mqMsg); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
+			slog.Warn("failed to unmarshal message", "queue", cname, "error", err)
+			metrics.MQConsumed.WithLabelValues(cname, "error").Inc()
 			continue
 		}
+		metrics.MQConsumed.WithLabelValues(cname, "ok").Inc()
+		mqMsg, span := traceReceived(msg, mqMsg, cname)
+		span.End()
 		return mqMsg, nil
 	}
 
@@ -189,8 +236,37 @@ func (r *RabbitMQApi) RecvMsg(qname string) (interface{}, error) {
 	}
 }
 
+// traceReceived 为收到的消息创建消费span，并将消息头中的链路信息更新为该span
+// 调用方在处理完消息后结束span
+func traceReceived(d amqp.Delivery, msg MqMsg, qname string) (MqMsg, *tracing.Span) {
+	if v, ok := d.Headers[tracing.TraceparentHeader].(string); ok && msg.Headers[tracing.TraceparentHeader] == "" {
+		msg.Headers = map[string]string{tracing.TraceparentHeader: v}
+	}
+	ctx, span := tracing.Start(msg.Context(context.Background()), "receive "+qname, tracing.KindConsumer)
+	span.SetAttribute("messaging.system", "rabbitmq")
+	span.SetAttribute("messaging.source.name", qname)
+	span.SetAttribute("messaging.rabbitmq.routing_key", d.RoutingKey)
+	return msg.WithContext(ctx), span
+}
+
 // SendMsg 实现 MqApi 接口的 SendMsg 方法
-func (r *RabbitMQApi) SendMsg(msg MqMsg, routingKey string) error {
+// 需要延续调用链时先调用 msg.WithContext(ctx) 写入链路信息
+func (r *RabbitMQApi) SendMsg(msg MqMsg, routingKey string) (err error) {
+	ctx, span := tracing.Start(msg.Context(context.Background()), "send "+r.exchange, tracing.KindProducer)
+	span.SetAttribute("messaging.system", "rabbitmq")
+	span.SetAttribute("messaging.destination.name", r.exchange)
+	span.SetAttribute("messaging.rabbitmq.routing_key", routingKey)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+		metrics.MQPublished.WithLabelValues(r.exchange, routingKey, metrics.Status(err)).Inc()
+	}()
+	msg = msg.WithContext(ctx)
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
 	// 将消息转换为 JSON 格式
 	body, err := json.Marshal(msg)
 	if err != nil {
@@ -205,6 +281,7 @@ func (r *RabbitMQApi) SendMsg(msg MqMsg, routingKey string) error {
 		false,      // 是否强制消息
 		amqp.Publishing{
 			ContentType:  "application/json", // 消息格式
+			Headers:      headers,            // 消息头
 			Body:         body,               // 消息体
 			DeliveryMode: amqp.Persistent,    // 设置消息持久化
 		},
@@ -215,6 +292,17 @@ func (r *RabbitMQApi) SendMsg(msg MqMsg, routingKey string) error {
 	return nil
 }
 
+// Ping 检查连接和通道是否可用，用于服务的就绪检查
+func (r *RabbitMQApi) Ping(ctx context.Context) error {
+	if r.conn.IsClosed() {
+		return errors.New("rabbitmq: connection closed")
+	}
+	if r.channelClosed.Load() {
+		return errors.New("rabbitmq: channel closed")
+	}
+	return nil
+}
+
 // Close 关闭 RabbitMQ 连接和通道
 func (r *RabbitMQApi) Close() {
 	r.channel.Close()
@@ -0,0 +1,8 @@
+module ratelimit
+
+go 1.22
+
+require (
+	github.com/redis/go-redis/v9 v9.7.0
+	google.golang.org/grpc v1.70.0
+)
@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// RetryAfterKey 被限流时返回给调用方的重试间隔（秒），网关将其转换为HTTP的Retry-After头
+const RetryAfterKey = "retry-after"
+
+// KeyFunc 从请求中提取限流key，返回空字符串时不限流
+type KeyFunc func(ctx context.Context, fullMethod string) string
+
+// ByMethod 按gRPC方法限流
+func ByMethod(ctx context.Context, fullMethod string) string {
+	return fullMethod
+}
+
+// ByClientIP 按连接的对端IP限流，不信任请求中的 x-forwarded-for / x-real-ip
+// 服务在网关或Kong之后时使用 ByClientIPBehind
+func ByClientIP(ctx context.Context, fullMethod string) string {
+	if ip := peerIP(ctx); ip.IsValid() {
+		return ip.String()
+	}
+	return ""
+}
+
+// ByClientIPBehind 按调用方IP限流，只有对端地址属于可信代理网段（如网关、Kong）时才使用它们传递的地址：
+// 从右向左跳过 x-forwarded-for 中的可信代理，取第一个不可信的地址，客户端伪造的最左侧地址不会被使用
+func ByClientIPBehind(trustedProxies ...string) (KeyFunc, error) {
+	prefixes := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, cidr := range trustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("ratelimit: invalid trusted proxy %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	trusted := func(ip netip.Addr) bool {
+		ip = ip.Unmap()
+		for _, p := range prefixes {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(ctx context.Context, fullMethod string) string {
+		ip := peerIP(ctx)
+		if !ip.IsValid() {
+			return ""
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		if !trusted(ip) || md == nil {
+			return ip.String()
+		}
+		var hops []string
+		for _, v := range md.Get("x-forwarded-for") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			ip = hop
+			if !trusted(hop) {
+				return hop.Unmap().String()
+			}
+		}
+		if len(hops) == 0 {
+			if v := md.Get("x-real-ip"); len(v) > 0 {
+				if real, err := netip.ParseAddr(strings.TrimSpace(v[0])); err == nil {
+					ip = real
+				}
+			}
+		}
+		return ip.Unmap().String()
+	}, nil
+}
+
+// peerIP 连接的对端地址
+func peerIP(ctx context.Context) netip.Addr {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return netip.Addr{}
+	}
+	if ap, err := netip.ParseAddrPort(p.Addr.String()); err == nil {
+		return ap.Addr().Unmap()
+	}
+	ip, _ := netip.ParseAddr(p.Addr.String())
+	return ip.Unmap()
+}
+
+// ByMetadata 按metadata中的某个字段（如 user-id）限流
+func ByMetadata(key string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(key); len(v) > 0 {
+				return v[0]
+			}
+		}
+		return ""
+	}
+}
+
+// PerMethod 将方法名与其他key组合，对每个方法分别限流
+func PerMethod(keyFn KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		key := keyFn(ctx, fullMethod)
+		if key == "" {
+			return ""
+		}
+		return fullMethod + "|" + key
+	}
+}
+
+// check 判断请求是否被限流，限流器出错时放行，避免redis故障导致服务整体不可用
+func check(ctx context.Context, l Limiter, keyFn KeyFunc, fullMethod string) error {
+	key := keyFn(ctx, fullMethod)
+	if key == "" {
+		return nil
+	}
+	res, err := l.Reserve(ctx, key, 0)
+	if err != nil {
+		slog.WarnContext(ctx, "ratelimit check failed, allowing request", "key", key, "error", err)
+		return nil
+	}
+	if res.OK {
+		return nil
+	}
+	retry := int(math.Ceil(res.Delay.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(RetryAfterKey, strconv.Itoa(retry)))
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s, retry after %ds", fullMethod, retry)
+}
+
+// UnaryServerInterceptor 一元调用限流拦截器，被限流时返回 codes.ResourceExhausted
+func UnaryServerInterceptor(l Limiter, keyFn KeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := check(ctx, l, keyFn, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 流式调用限流拦截器，仅在建立流时检查一次
+func StreamServerInterceptor(l Limiter, keyFn KeyFunc) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := check(ss.Context(), l, keyFn, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrLimitExceeded 在ctx截止前无法获得许可
+var ErrLimitExceeded = errors.New("rate limit exceeded")
+
+// Reservation 一次限流预约的结果
+type Reservation struct {
+	OK    bool          // 是否获得许可（获得许可后等待Delay即可执行）
+	Delay time.Duration // OK为true时表示需要等待的时间，为false时表示建议的重试间隔
+}
+
+// Limiter 统一的限流接口，key用于区分不同的限流对象（方法、IP、用户等）
+type Limiter interface {
+	// Allow 立即判断是否允许执行
+	Allow(ctx context.Context, key string) (bool, error)
+
+	// Reserve 预约一次执行许可，最多接受maxWait的等待
+	Reserve(ctx context.Context, key string, maxWait time.Duration) (Reservation, error)
+
+	// Wait 阻塞直到获得许可，ctx结束或截止时间内无法获得许可时返回错误
+	Wait(ctx context.Context, key string) error
+}
+
+// reserver 各限流算法只需实现reserve，Allow和Wait由公共逻辑提供
+type reserver interface {
+	Reserve(ctx context.Context, key string, maxWait time.Duration) (Reservation, error)
+}
+
+func allow(ctx context.Context, r reserver, key string) (bool, error) {
+	res, err := r.Reserve(ctx, key, 0)
+	if err != nil {
+		return false, err
+	}
+	return res.OK, nil
+}
+
+func wait(ctx context.Context, r reserver, key string) error {
+	for {
+		maxWait := time.Duration(math.MaxInt64)
+		if deadline, ok := ctx.Deadline(); ok {
+			maxWait = time.Until(deadline)
+		}
+		if maxWait < 0 {
+			return ErrLimitExceeded
+		}
+		res, err := r.Reserve(ctx, key, maxWait)
+		if err != nil {
+			return err
+		}
+		if !res.OK && res.Delay > maxWait {
+			return ErrLimitExceeded
+		}
+		if err := sleep(ctx, res.Delay); err != nil {
+			return err
+		}
+		if res.OK {
+			return nil
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
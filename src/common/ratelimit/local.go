@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// keyedState 按key保存各个限流对象的状态，并定期清理长时间未访问的key
+type keyedState[S any] struct {
+	lock     sync.Mutex
+	states   map[string]*S
+	lastSeen map[string]time.Time
+	idle     time.Duration
+	ops      int
+	newState func(now time.Time) *S
+}
+
+func newKeyedState[S any](idle time.Duration, newState func(now time.Time) *S) *keyedState[S] {
+	return &keyedState[S]{
+		states:   make(map[string]*S),
+		lastSeen: make(map[string]time.Time),
+		idle:     idle,
+		newState: newState,
+	}
+}
+
+// with 在锁内获取key对应的状态并执行fn
+func (k *keyedState[S]) with(key string, fn func(s *S, now time.Time) Reservation) Reservation {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	now := time.Now()
+	k.ops++
+	if k.ops%1024 == 0 {
+		for key, seen := range k.lastSeen {
+			if now.Sub(seen) > k.idle {
+				delete(k.states, key)
+				delete(k.lastSeen, key)
+			}
+		}
+	}
+	s, ok := k.states[key]
+	if !ok {
+		s = k.newState(now)
+		k.states[key] = s
+	}
+	k.lastSeen[key] = now
+	return fn(s, now)
+}
+
+// ---------------- 令牌桶 ----------------
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TokenBucket 进程内令牌桶，以rate个/秒的速度生成令牌，最多累积burst个
+type TokenBucket struct {
+	rate  float64
+	burst float64
+	state *keyedState[tokenBucket]
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	b := &TokenBucket{rate: rate, burst: float64(burst)}
+	idle := time.Duration(float64(burst)/rate*float64(time.Second)) + time.Minute
+	b.state = newKeyedState(idle, func(now time.Time) *tokenBucket {
+		return &tokenBucket{tokens: b.burst, last: now}
+	})
+	return b
+}
+
+func (b *TokenBucket) Reserve(ctx context.Context, key string, maxWait time.Duration) (Reservation, error) {
+	return b.state.with(key, func(s *tokenBucket, now time.Time) Reservation {
+		tokens := math.Min(b.burst, s.tokens+now.Sub(s.last).Seconds()*b.rate)
+		tokens--
+		var delay time.Duration
+		if tokens < 0 {
+			delay = time.Duration(-tokens / b.rate * float64(time.Second))
+		}
+		if delay > maxWait {
+			return Reservation{OK: false, Delay: delay}
+		}
+		s.tokens, s.last = tokens, now
+		return Reservation{OK: true, Delay: delay}
+	}), nil
+}
+
+func (b *TokenBucket) Allow(ctx context.Context, key string) (bool, error) {
+	return allow(ctx, b, key)
+}
+
+func (b *TokenBucket) Wait(ctx context.Context, key string) error {
+	return wait(ctx, b, key)
+}
+
+// ---------------- 漏桶 ----------------
+
+type leakyBucket struct {
+	next time.Time // 下一个请求可以流出的时间
+}
+
+// LeakyBucket 进程内漏桶，请求以恒定的rate个/秒流出，桶中最多排队capacity个请求
+type LeakyBucket struct {
+	interval time.Duration
+	capacity int
+	state    *keyedState[leakyBucket]
+}
+
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	b := &LeakyBucket{
+		interval: time.Duration(float64(time.Second) / rate),
+		capacity: capacity,
+	}
+	b.state = newKeyedState(time.Duration(capacity)*b.interval+time.Minute, func(now time.Time) *leakyBucket {
+		return &leakyBucket{next: now}
+	})
+	return b
+}
+
+func (b *LeakyBucket) Reserve(ctx context.Context, key string, maxWait time.Duration) (Reservation, error) {
+	return b.state.with(key, func(s *leakyBucket, now time.Time) Reservation {
+		next := s.next
+		if next.Before(now) {
+			next = now
+		}
+		delay := next.Sub(now)
+		if full := time.Duration(b.capacity-1) * b.interval; delay > full {
+			// 桶已满，等到有请求流出后再重试
+			return Reservation{OK: false, Delay: delay - full}
+		}
+		if delay > maxWait {
+			return Reservation{OK: false, Delay: delay}
+		}
+		s.next = next.Add(b.interval)
+		return Reservation{OK: true, Delay: delay}
+	}), nil
+}
+
+func (b *LeakyBucket) Allow(ctx context.Context, key string) (bool, error) {
+	return allow(ctx, b, key)
+}
+
+func (b *LeakyBucket) Wait(ctx context.Context, key string) error {
+	return wait(ctx, b, key)
+}
+
+// ---------------- 滑动窗口 ----------------
+
+type slidingWindow struct {
+	start time.Time // 当前窗口的开始时间
+	prev  int       // 上一个窗口的请求数
+	curr  int       // 当前窗口的请求数
+}
+
+// SlidingWindow 进程内滑动窗口计数器，任意window时间内最多允许limit个请求
+// 使用前一个窗口计数按时间加权的近似算法，内存占用固定
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+	state  *keyedState[slidingWindow]
+}
+
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	w := &SlidingWindow{limit: limit, window: window}
+	w.state = newKeyedState(2*window+time.Minute, func(now time.Time) *slidingWindow {
+		return &slidingWindow{start: now.Truncate(window)}
+	})
+	return w
+}
+
+func (w *SlidingWindow) Reserve(ctx context.Context, key string, maxWait time.Duration) (Reservation, error) {
+	return w.state.with(key, func(s *slidingWindow, now time.Time) Reservation {
+		start := now.Truncate(w.window)
+		switch elapsed := start.Sub(s.start); {
+		case elapsed >= 2*w.window:
+			s.prev, s.curr = 0, 0
+		case elapsed >= w.window:
+			s.prev, s.curr = s.curr, 0
+		}
+		s.start = start
+		remain := w.window - now.Sub(start)
+		count := float64(s.prev)*float64(remain)/float64(w.window) + float64(s.curr)
+		if count+1 > float64(w.limit) {
+			return Reservation{OK: false, Delay: retryAfter(s.prev, s.curr, w.limit, w.window, remain)}
+		}
+		s.curr++
+		return Reservation{OK: true}
+	}), nil
+}
+
+// retryAfter 估算滑动窗口中腾出一个名额所需的时间
+func retryAfter(prev, curr, limit int, window, remain time.Duration) time.Duration {
+	if curr+1 > limit || prev == 0 {
+		// 当前窗口已满，需要等到下一个窗口
+		return remain
+	}
+	// prev*(remain-t)/window + curr + 1 <= limit
+	need := float64(window) * float64(limit-curr-1) / float64(prev)
+	return remain - time.Duration(need)
+}
+
+func (w *SlidingWindow) Allow(ctx context.Context, key string) (bool, error) {
+	return allow(ctx, w, key)
+}
+
+func (w *SlidingWindow) Wait(ctx context.Context, key string) error {
+	return wait(ctx, w, key)
+}
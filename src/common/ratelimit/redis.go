@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"time"
+)
+
+// 所有脚本都使用redis服务器时间（微秒），避免各副本时钟不一致
+// 返回 {是否允许, 等待/重试时间(微秒)}
+
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local max_wait = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate / 1000000) - 1
+local delay = 0
+if tokens < 0 then
+	delay = math.ceil(-tokens * 1000000 / rate)
+end
+if delay > max_wait then
+	return {0, delay}
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000 + delay / 1000) + 1000)
+return {1, delay}
+`)
+
+var leakyBucketScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local max_wait = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local nxt = tonumber(redis.call('GET', KEYS[1])) or now
+if nxt < now then
+	nxt = now
+end
+local delay = nxt - now
+local full = (capacity - 1) * interval
+if delay > full then
+	return {0, delay - full}
+end
+if delay > max_wait then
+	return {0, delay}
+end
+redis.call('SET', KEYS[1], nxt + interval, 'PX', math.ceil((delay + interval) / 1000) + 1000)
+return {1, delay}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local start = now - now % window
+local curr_key = KEYS[1] .. ':' .. start
+local prev_key = KEYS[1] .. ':' .. (start - window)
+local curr = tonumber(redis.call('GET', curr_key)) or 0
+local prev = tonumber(redis.call('GET', prev_key)) or 0
+local remain = window - (now - start)
+if prev * remain / window + curr + 1 > limit then
+	if curr + 1 > limit or prev == 0 then
+		return {0, remain}
+	end
+	return {0, math.ceil(remain - window * (limit - curr - 1) / prev)}
+end
+redis.call('INCR', curr_key)
+redis.call('PEXPIRE', curr_key, math.ceil(window * 2 / 1000))
+return {1, 0}
+`)
+
+// RedisLimiter 基于redis的分布式限流器，限流在所有副本间共享
+type RedisLimiter struct {
+	client redis.UniversalClient
+	prefix string
+	run    func(ctx context.Context, key string, maxWait time.Duration) ([]int64, error)
+}
+
+func newRedisLimiter(client redis.UniversalClient, prefix string, script *redis.Script, args ...interface{}) *RedisLimiter {
+	l := &RedisLimiter{client: client, prefix: prefix}
+	l.run = func(ctx context.Context, key string, maxWait time.Duration) ([]int64, error) {
+		// 使用hash tag保证滑动窗口的多个key落在集群的同一个slot
+		k := "ratelimit:" + l.prefix + ":{" + key + "}"
+		wait := int64(math.MaxInt32)
+		if maxWait < time.Duration(wait)*time.Microsecond {
+			wait = maxWait.Microseconds()
+		}
+		argv := append(append(make([]interface{}, 0, len(args)+1), args...), wait)
+		return script.Run(ctx, l.client, []string{k}, argv...).Int64Slice()
+	}
+	return l
+}
+
+// NewRedisTokenBucket 分布式令牌桶
+func NewRedisTokenBucket(client redis.UniversalClient, name string, rate float64, burst int) *RedisLimiter {
+	return newRedisLimiter(client, "tb:"+name, tokenBucketScript, rate, burst)
+}
+
+// NewRedisLeakyBucket 分布式漏桶
+func NewRedisLeakyBucket(client redis.UniversalClient, name string, rate float64, capacity int) *RedisLimiter {
+	interval := int64(math.Ceil(1000000 / rate))
+	return newRedisLimiter(client, "lb:"+name, leakyBucketScript, interval, capacity)
+}
+
+// NewRedisSlidingWindow 分布式滑动窗口
+func NewRedisSlidingWindow(client redis.UniversalClient, name string, limit int, window time.Duration) *RedisLimiter {
+	return newRedisLimiter(client, "sw:"+name, slidingWindowScript, limit, window.Microseconds())
+}
+
+func (l *RedisLimiter) Reserve(ctx context.Context, key string, maxWait time.Duration) (Reservation, error) {
+	res, err := l.run(ctx, key, maxWait)
+	if err != nil {
+		return Reservation{}, fmt.Errorf("ratelimit: redis script failed: %w", err)
+	}
+	if len(res) != 2 {
+		return Reservation{}, fmt.Errorf("ratelimit: unexpected script result %v", res)
+	}
+	return Reservation{OK: res[0] == 1, Delay: time.Duration(res[1]) * time.Microsecond}, nil
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	return allow(ctx, l, key)
+}
+
+func (l *RedisLimiter) Wait(ctx context.Context, key string) error {
+	return wait(ctx, l, key)
+}
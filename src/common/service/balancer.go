@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	WeightedRoundRobin    = "weighted_round_robin" // 加权轮询
+	LeastRequest          = "least_request"        // 最少未完成请求
+	ConsistentHash        = "consistent_hash"      // 一致性哈希
+	DefaultHashKey        = "user-id"              // 一致性哈希默认使用的metadata key
+	consistentHashReplica = 160                    // 权重最大的实例的虚拟节点数，其他实例按权重比例减少
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(WeightedRoundRobin, &wrrPickerBuilder{}, base.Config{HealthCheck: true}))
+	balancer.Register(leastRequestBuilder{})
+	RegisterConsistentHashBalancer(ConsistentHash, DefaultHashKey)
+}
+
+// RegisterConsistentHashBalancer 注册按outgoing metadata中hashKey的值进行一致性哈希的负载均衡器
+// 必须在init中调用，请求中没有hashKey时随机选择实例
+func RegisterConsistentHashBalancer(name, hashKey string) {
+	balancer.Register(base.NewBalancerBuilder(name, &consistentHashPickerBuilder{hashKey: hashKey}, base.Config{HealthCheck: true}))
+}
+
+// WithHashKey 设置默认一致性哈希负载均衡器使用的key（如用户ID）
+func WithHashKey(ctx context.Context, value string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, DefaultHashKey, value)
+}
+
+// ---------------- 加权轮询 ----------------
+
+type wrrPickerBuilder struct{}
+
+func (b *wrrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	nodes := make([]*wrrNode, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		weight := getInstanceAttr(sci.Address).Weight
+		if weight <= 0 {
+			continue
+		}
+		nodes = append(nodes, &wrrNode{
+			sc:     sc,
+			addr:   sci.Address.Addr,
+			weight: weight,
+		})
+	}
+	if len(nodes) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	// 保证相同实例集合的选择顺序稳定
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].addr < nodes[j].addr })
+	return &wrrPicker{nodes: nodes}
+}
+
+type wrrNode struct {
+	sc            balancer.SubConn
+	addr          string
+	weight        int
+	currentWeight int
+}
+
+// wrrPicker 平滑加权轮询（与nginx相同的算法），避免高权重实例连续被选中
+type wrrPicker struct {
+	nodes []*wrrNode
+	lock  sync.Mutex
+}
+
+func (p *wrrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	total := 0
+	var best *wrrNode
+	for _, n := range p.nodes {
+		n.currentWeight += n.weight
+		total += n.weight
+		if best == nil || n.currentWeight > best.currentWeight {
+			best = n
+		}
+	}
+	best.currentWeight -= total
+	return balancer.PickResult{SubConn: best.sc}, nil
+}
+
+// ---------------- 最少未完成请求 ----------------
+
+// leastRequestBuilder 每个连接使用独立的pickerBuilder，未完成请求计数不在连接之间共享
+type leastRequestBuilder struct{}
+
+func (leastRequestBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(LeastRequest, &leastRequestPickerBuilder{}, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+func (leastRequestBuilder) Name() string {
+	return LeastRequest
+}
+
+type leastRequestPickerBuilder struct {
+	// 未完成请求计数按地址保存，实例列表变化重建picker时不丢失；同一个连接的Build不会并发调用
+	inflight map[string]*int64
+}
+
+func (b *leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	// 只保留当前就绪实例的计数，已移除实例上未完成的请求结束时更新的是picker持有的计数
+	inflight := make(map[string]*int64, len(info.ReadySCs))
+	nodes := make([]*lrNode, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		weight := getInstanceAttr(sci.Address).Weight
+		if weight <= 0 {
+			continue
+		}
+		counter, ok := b.inflight[sci.Address.Addr]
+		if !ok {
+			counter = new(int64)
+		}
+		inflight[sci.Address.Addr] = counter
+		nodes = append(nodes, &lrNode{
+			sc:       sc,
+			weight:   weight,
+			inflight: counter,
+		})
+	}
+	b.inflight = inflight
+	if len(nodes) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	return &leastRequestPicker{nodes: nodes}
+}
+
+type lrNode struct {
+	sc       balancer.SubConn
+	weight   int
+	inflight *int64
+}
+
+// load 按权重归一化后的负载
+func (n *lrNode) load() float64 {
+	return float64(atomic.LoadInt64(n.inflight)+1) / float64(n.weight)
+}
+
+// leastRequestPicker 随机选取两个实例，选择未完成请求较少的一个（power of two choices）
+type leastRequestPicker struct {
+	nodes []*lrNode
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	n := p.nodes[rand.Intn(len(p.nodes))]
+	if len(p.nodes) > 1 {
+		other := p.nodes[rand.Intn(len(p.nodes))]
+		if other.load() < n.load() {
+			n = other
+		}
+	}
+	atomic.AddInt64(n.inflight, 1)
+	return balancer.PickResult{
+		SubConn: n.sc,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(n.inflight, -1)
+		},
+	}, nil
+}
+
+// ---------------- 一致性哈希 ----------------
+
+type consistentHashPickerBuilder struct {
+	hashKey string
+}
+
+func (b *consistentHashPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	type node struct {
+		sc     balancer.SubConn
+		addr   string
+		weight int
+	}
+	var ready []node
+	maxWeight := 0
+	for sc, sci := range info.ReadySCs {
+		weight := getInstanceAttr(sci.Address).Weight
+		if weight <= 0 {
+			continue
+		}
+		ready = append(ready, node{sc: sc, addr: sci.Address.Addr, weight: weight})
+		maxWeight = max(maxWeight, weight)
+	}
+	if len(ready) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	// 按地址排序，哈希冲突时总是保留地址较小的实例，相同的实例集合得到相同的环
+	sort.Slice(ready, func(i, j int) bool { return ready[i].addr < ready[j].addr })
+	p := &consistentHashPicker{
+		hashKey: b.hashKey,
+		nodes:   make(map[uint32]balancer.SubConn, len(ready)*consistentHashReplica),
+	}
+	for _, n := range ready {
+		p.subConns = append(p.subConns, n.sc)
+		// 虚拟节点数与相对权重成正比，权重的绝对值（如Kong的100）不影响环的大小
+		replicas := max(1, consistentHashReplica*n.weight/maxWeight)
+		for i := 0; i < replicas; i++ {
+			h := ringHash(n.addr + "#" + strconv.Itoa(i))
+			if _, ok := p.nodes[h]; ok {
+				continue
+			}
+			p.nodes[h] = n.sc
+			p.ring = append(p.ring, h)
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i] < p.ring[j] })
+	return p
+}
+
+// ringHash CRC32后再做一次雪崩混合（murmur3的fmix32），只有末尾不同的虚拟节点名也能均匀分布在环上
+func ringHash(s string) uint32 {
+	h := crc32.ChecksumIEEE([]byte(s))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+type consistentHashPicker struct {
+	hashKey  string
+	ring     []uint32
+	nodes    map[uint32]balancer.SubConn
+	subConns []balancer.SubConn
+}
+
+func (p *consistentHashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	md, _ := metadata.FromOutgoingContext(info.Ctx)
+	values := md.Get(p.hashKey)
+	if len(values) == 0 || values[0] == "" {
+		return balancer.PickResult{SubConn: p.subConns[rand.Intn(len(p.subConns))]}, nil
+	}
+	h := ringHash(values[0])
+	idx := sort.Search(len(p.ring), func(i int) bool { return p.ring[i] >= h })
+	if idx == len(p.ring) {
+		idx = 0
+	}
+	return balancer.PickResult{SubConn: p.nodes[p.ring[idx]]}, nil
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"log/slog"
+	"logging"
+	"reflect"
+	"time"
+)
+
+var (
+	ErrConfigNotFound = errors.New("config not found")
+	ErrConfigConflict = errors.New("config has been modified by others")
+)
+
+// DatabaseConfig 数据库连接池配置
+type DatabaseConfig struct {
+	DSN             string `json:"dsn"`
+	MaxOpenConns    int    `json:"max_open_conns"`
+	MaxIdleConns    int    `json:"max_idle_conns"`
+	ConnMaxLifetime int    `json:"conn_max_lifetime"` // 连接最大存活时间（秒）
+}
+
+// 链路追踪的导出方式
+const (
+	TracingExporterFile = "file"
+	TracingExporterOTLP = "otlp"
+)
+
+// TracingConfig 链路追踪配置
+type TracingConfig struct {
+	Exporter    string  `json:"exporter"`     // 导出方式：file、otlp，为空时只传递链路信息不导出
+	Endpoint    string  `json:"endpoint"`     // file为文件路径，otlp为collector地址（如 http://127.0.0.1:4318）
+	SampleRatio float64 `json:"sample_ratio"` // 新trace的采样比例，为0时全部采样
+}
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level string `json:"level"` // debug、info、warn、error，为空时保持当前级别
+}
+
+// ServiceConfig 配置中心中保存的服务配置
+type ServiceConfig struct {
+	Info           ServiceInfo     `json:"info"`            // 服务运行信息（端口、Kong路由等）
+	Database       DatabaseConfig  `json:"database"`        // 数据库配置
+	Middlewares    map[string]bool `json:"middlewares"`     // gRPC中间件开关
+	Tracing        TracingConfig   `json:"tracing"`         // 链路追踪配置
+	Log            LogConfig       `json:"log"`             // 日志配置
+	Shutdown       ShutdownConfig  `json:"shutdown"`        // 退出时的等待时间
+	TLS            TLSSettings     `json:"tls"`             // gRPC、网关、etcd和Kong连接的TLS配置
+	RequestTimeout int             `json:"request_timeout"` // 服务端处理一元调用的最长时间（秒），为0时沿用当前值
+	Revision       int64           `json:"-"`               // 配置在etcd中的修订版本（ModRevision）
+	Version        int64           `json:"-"`               // 配置被写入的次数
+}
+
+// Validate 校验配置，避免错误的配置推送导致服务不可用
+func (c *ServiceConfig) Validate() error {
+	if c.Info.Name == "" {
+		return errors.New("config: service name is required")
+	}
+	if c.Info.Port < 0 || c.Info.Port > 65535 {
+		return fmt.Errorf("config: invalid grpc port %d", c.Info.Port)
+	}
+	if c.Info.HttpPort < 0 || c.Info.HttpPort > 65535 {
+		return fmt.Errorf("config: invalid http port %d", c.Info.HttpPort)
+	}
+	if c.Info.Port != 0 && c.Info.Port == c.Info.HttpPort {
+		return errors.New("config: grpc port and http port must be different")
+	}
+	if c.Info.Weight < 0 {
+		return fmt.Errorf("config: invalid weight %d", c.Info.Weight)
+	}
+	if c.Info.HealthChecks != nil {
+		if err := c.Info.HealthChecks.Validate(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnMaxLifetime < 0 {
+		return errors.New("config: invalid database pool settings")
+	}
+	switch c.Tracing.Exporter {
+	case "":
+	case TracingExporterFile, TracingExporterOTLP:
+		if c.Tracing.Endpoint == "" {
+			return fmt.Errorf("config: tracing endpoint is required for exporter %s", c.Tracing.Exporter)
+		}
+	default:
+		return fmt.Errorf("config: unknown tracing exporter %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("config: invalid tracing sample ratio %v", c.Tracing.SampleRatio)
+	}
+	if c.RequestTimeout < 0 {
+		return fmt.Errorf("config: invalid request timeout %d", c.RequestTimeout)
+	}
+	if s := c.Shutdown; s.PropagationDelay < 0 || s.GatewayTimeout < 0 || s.GrpcTimeout < 0 || s.HookTimeout < 0 {
+		return errors.New("config: invalid shutdown timeouts")
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if c.Log.Level != "" {
+		if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	return nil
+}
+
+// ConfigChange 两个配置版本之间的差异，按位标记需要重启的组件
+type ConfigChange uint8
+
+const (
+	ChangeGrpc       ConfigChange = 1 << iota // gRPC监听需要重启
+	ChangeGateway                             // 网关需要重启
+	ChangeKong                                // Kong注册信息需要更新
+	ChangeEtcd                                // etcd中的注册信息需要更新
+	ChangeDatabase                            // 数据库连接池需要重建
+	ChangeMiddleware                          // 中间件开关或请求超时变化（无需重启）
+	ChangeTracing                             // 链路追踪的导出配置变化
+	ChangeLog                                 // 日志级别变化（无需重启）
+)
+
+func (c ConfigChange) Has(flag ConfigChange) bool {
+	return c&flag != 0
+}
+
+func (c ConfigChange) String() string {
+	names := []string{"grpc", "gateway", "kong", "etcd", "database", "middleware", "tracing", "log"}
+	res := ""
+	for i, name := range names {
+		if c.Has(1 << i) {
+			if res != "" {
+				res += "|"
+			}
+			res += name
+		}
+	}
+	if res == "" {
+		return "none"
+	}
+	return res
+}
+
+// DiffConfig 比较运行中的配置和新配置，返回需要重启的组件
+func DiffConfig(old, new *ServiceConfig) ConfigChange {
+	var change ConfigChange
+	o, n := old.Info, new.Info
+	if o.Ip != n.Ip || o.Port != n.Port {
+		// 网关通过回环连接gRPC，gRPC地址变化时网关和Kong的target也需要更新
+		change |= ChangeGrpc | ChangeGateway | ChangeKong
+	}
+	if o.HttpPort != n.HttpPort {
+		change |= ChangeGateway | ChangeKong
+	}
+	if o.Weight != n.Weight || o.Protocol != n.Protocol || o.HealthPath != n.HealthPath ||
+		o.ServicePath != n.ServicePath || o.RoutesName != n.RoutesName || !reflect.DeepEqual(o.Paths, n.Paths) ||
+		!reflect.DeepEqual(o.HealthChecks, n.HealthChecks) {
+		change |= ChangeKong
+	}
+	if change != 0 || o.Name != n.Name || o.Version != n.Version || !reflect.DeepEqual(o.Metadata, n.Metadata) {
+		change |= ChangeEtcd
+	}
+	// 证书内容的变化会自动重新加载，这里只处理证书路径等配置的变化；etcd的配置在重启后生效
+	if old.TLS.Grpc != new.TLS.Grpc {
+		change |= ChangeGrpc | ChangeGateway
+	}
+	if old.TLS.Gateway != new.TLS.Gateway {
+		change |= ChangeGateway | ChangeKong
+	}
+	if old.TLS.Kong != new.TLS.Kong {
+		change |= ChangeKong
+	}
+	if old.Database != new.Database {
+		change |= ChangeDatabase
+	}
+	if (len(old.Middlewares) > 0 || len(new.Middlewares) > 0) && !reflect.DeepEqual(old.Middlewares, new.Middlewares) {
+		change |= ChangeMiddleware
+	}
+	if old.RequestTimeout != new.RequestTimeout {
+		change |= ChangeMiddleware
+	}
+	if old.Tracing != new.Tracing {
+		change |= ChangeTracing
+	}
+	if old.Log != new.Log {
+		change |= ChangeLog
+	}
+	return change
+}
+
+// ConfigKey 返回服务实例在配置中心中的key
+func ConfigKey(service, id string) string {
+	return "/config/" + service + "/" + id
+}
+
+// ConfigCenter 基于etcd的配置中心
+type ConfigCenter struct {
+	cli *clientv3.Client
+}
+
+// NewConfigCenter 新建配置中心
+func NewConfigCenter(endpoints []string, opts ...EtcdOption) (*ConfigCenter, error) {
+	cli, err := NewEtcdClient(endpoints, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigCenter{cli: cli}, nil
+}
+
+// NewConfigCenterFromClient 使用已有的etcd客户端创建配置中心
+func NewConfigCenterFromClient(cli *clientv3.Client) *ConfigCenter {
+	return &ConfigCenter{cli: cli}
+}
+
+func decodeConfig(kv *mvccpb.KeyValue) (*ServiceConfig, error) {
+	cfg := &ServiceConfig{}
+	if err := json.Unmarshal(kv.Value, cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config %s: %w", kv.Key, err)
+	}
+	cfg.Revision = kv.ModRevision
+	cfg.Version = kv.Version
+	return cfg, nil
+}
+
+// Get 获取最新的配置
+func (c *ConfigCenter) Get(ctx context.Context, key string) (*ServiceConfig, error) {
+	return c.GetRevision(ctx, key, 0)
+}
+
+// GetRevision 获取指定etcd修订版本时的配置，rev为0时获取最新配置
+func (c *ConfigCenter) GetRevision(ctx context.Context, key string, rev int64) (*ServiceConfig, error) {
+	var opts []clientv3.OpOption
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev))
+	}
+	resp, err := c.cli.Get(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrConfigNotFound
+	}
+	return decodeConfig(resp.Kvs[0])
+}
+
+// Put 写入配置并返回新的修订版本
+// cfg.Revision 不为0时进行乐观锁校验，为0时只在配置不存在时写入
+// 配置已被其他人修改或已经存在时返回 ErrConfigConflict
+func (c *ConfigCenter) Put(ctx context.Context, key string, cfg *ServiceConfig) (int64, error) {
+	if err := cfg.Validate(); err != nil {
+		return 0, err
+	}
+	val, err := json.Marshal(cfg)
+	if err != nil {
+		return 0, err
+	}
+	// 不存在的key的ModRevision为0
+	cmp := clientv3.Compare(clientv3.ModRevision(key), "=", cfg.Revision)
+	resp, err := c.cli.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, string(val))).Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, ErrConfigConflict
+	}
+	return resp.Header.Revision, nil
+}
+
+// History 从新到旧返回配置的历史版本，最多limit个（已被etcd压缩的版本无法获取）
+func (c *ConfigCenter) History(ctx context.Context, key string, limit int) ([]*ServiceConfig, error) {
+	history := make([]*ServiceConfig, 0, limit)
+	var rev int64
+	for len(history) < limit {
+		cfg, err := c.GetRevision(ctx, key, rev)
+		if errors.Is(err, ErrConfigNotFound) || errors.Is(err, rpctypes.ErrCompacted) {
+			break
+		}
+		if err != nil {
+			return history, err
+		}
+		history = append(history, cfg)
+		if cfg.Version <= 1 {
+			break
+		}
+		rev = cfg.Revision - 1
+	}
+	return history, nil
+}
+
+// Rollback 将配置回滚到指定修订版本时的内容，回滚本身会产生一个新的修订版本
+func (c *ConfigCenter) Rollback(ctx context.Context, key string, rev int64) (int64, error) {
+	target, err := c.GetRevision(ctx, key, rev)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get config at revision %d: %w", rev, err)
+	}
+	current, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	target.Revision = current.Revision
+	newRev, err := c.Put(ctx, key, target)
+	if err != nil {
+		return 0, err
+	}
+	slog.Info("config rolled back", "key", key, "revision", rev, "new_revision", newRev)
+	return newRev, nil
+}
+
+// Watch 从fromRev开始监听配置变更，ctx结束时关闭返回的通道
+func (c *ConfigCenter) Watch(ctx context.Context, key string, fromRev int64) <-chan *ServiceConfig {
+	ch := make(chan *ServiceConfig)
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil {
+			opts := []clientv3.OpOption{clientv3.WithFilterDelete()}
+			if fromRev > 0 {
+				opts = append(opts, clientv3.WithRev(fromRev))
+			}
+			for wresp := range c.cli.Watch(clientv3.WithRequireLeader(ctx), key, opts...) {
+				if err := wresp.Err(); err != nil {
+					slog.Warn("config watch error", "key", key, "error", err)
+					if wresp.CompactRevision > 0 {
+						fromRev = wresp.CompactRevision
+					}
+					break
+				}
+				for _, ev := range wresp.Events {
+					fromRev = ev.Kv.ModRevision + 1
+					cfg, err := decodeConfig(ev.Kv)
+					if err != nil {
+						slog.Warn("invalid config", "key", key, "error", err)
+						continue
+					}
+					select {
+					case ch <- cfg:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			// 监听中断（etcd重连、压缩等），稍后从上次的版本继续监听
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return ch
+}
+
+// Close 关闭配置中心
+func (c *ConfigCenter) Close() error {
+	return c.cli.Close()
+}
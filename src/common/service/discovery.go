@@ -2,98 +2,309 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
-	"log"
+	"log/slog"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 )
 
+// droppedEvents 订阅者处理不及时被丢弃的事件数
+var droppedEvents = NewCounter("service_discovery_dropped_events_total", "Total number of service discovery events dropped because the subscriber was too slow.", "subscriber")
+
+// ServiceEventType 服务实例变更类型
+type ServiceEventType int
+
+const (
+	ServiceJoin  ServiceEventType = iota // 实例上线或信息更新
+	ServiceLeave                         // 实例下线
+)
+
+// ServiceEvent 服务实例变更事件
+type ServiceEvent struct {
+	Type    ServiceEventType
+	Key     string
+	Service ServiceInfo
+}
+
+type subscriber struct {
+	name string // 订阅的服务名称，为空时订阅所有服务
+	ch   chan ServiceEvent
+}
+
 // ServiceDiscovery 服务发现
 type ServiceDiscovery struct {
-	cli        *clientv3.Client  // etcd client
-	serverList map[string]string // 服务列表
-	lock       sync.RWMutex
+	cli         *clientv3.Client       // etcd client
+	serverList  map[string]ServiceInfo // 服务列表，key为实例在etcd中的key
+	revision    int64                  // 最后一次同步的etcd修订版本
+	resyncing   bool                   // 监听中断后尚未追上etcd，服务列表可能缺少实例
+	subscribers map[int]*subscriber    // 变更订阅者
+	nextSubId   int                    // 下一个订阅者ID
+	ctx         context.Context        // 控制watcher退出
+	cancel      context.CancelFunc     // 关闭服务发现时取消watcher
+	lock        sync.RWMutex
 }
 
 // NewServiceDiscovery 新建服务发现
-func NewServiceDiscovery(endpoints []string) *ServiceDiscovery {
+func NewServiceDiscovery(endpoints []string, opts ...EtcdOption) (*ServiceDiscovery, error) {
 	// 初始化etcd client
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: time.Duration(5) * time.Second,
-	})
+	cli, err := NewEtcdClient(endpoints, opts...)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, fmt.Errorf("create etcd client: %w", err)
 	}
 
+	return NewServiceDiscoveryFromClient(cli), nil
+}
+
+// NewServiceDiscoveryFromClient 使用已有的etcd客户端创建服务发现，Close时关闭客户端
+func NewServiceDiscoveryFromClient(cli *clientv3.Client) *ServiceDiscovery {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &ServiceDiscovery{
-		cli:        cli,
-		serverList: make(map[string]string),
+		cli:         cli,
+		serverList:  make(map[string]ServiceInfo),
+		subscribers: make(map[int]*subscriber),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 }
 
 // WatchService 初始化服务列表和监视
 func (s *ServiceDiscovery) WatchService(prefix string) error {
+	if err := s.sync(prefix); err != nil {
+		return err
+	}
+
+	// 监视前缀，修改变更server
+	go s.watcher(prefix)
+	return nil
+}
+
+// sync 全量拉取前缀下的服务，并与本地缓存比较产生变更事件
+func (s *ServiceDiscovery) sync(prefix string) error {
 	// 根据前缀获取现有的key
-	resp, err := s.cli.Get(context.Background(), prefix, clientv3.WithPrefix())
+	resp, err := s.cli.Get(s.ctx, prefix, clientv3.WithPrefix())
 	if err != nil {
 		return err
 	}
 
+	exists := make(map[string]struct{}, len(resp.Kvs))
 	// 遍历获取得到的k和v
 	for _, ev := range resp.Kvs {
+		exists[string(ev.Key)] = struct{}{}
 		s.SetServiceList(string(ev.Key), string(ev.Value))
 	}
 
-	// 监视前缀，修改变更server
-	go s.watcher(prefix)
+	s.lock.RLock()
+	var stale []string
+	for key := range s.serverList {
+		if _, ok := exists[key]; !ok && strings.HasPrefix(key, prefix) {
+			stale = append(stale, key)
+		}
+	}
+	s.lock.RUnlock()
+	for _, key := range stale {
+		s.DelServiceList(key)
+	}
+
+	s.lock.Lock()
+	s.revision = resp.Header.Revision
+	s.resyncing = false
+	s.lock.Unlock()
 	return nil
 }
 
-// watcher 监听Key的前缀
+// watcher 监听Key的前缀，etcd断开后从最后同步的版本继续监听
 func (s *ServiceDiscovery) watcher(prefix string) {
-	rch := s.cli.Watch(context.Background(), prefix, clientv3.WithPrefix())
-	log.Printf("watching prefix:%s now...", prefix)
-	for wresp := range rch {
-		for _, ev := range wresp.Events {
-			switch ev.Type {
-			case mvccpb.PUT: // 修改或者新增
-				s.SetServiceList(string(ev.Kv.Key), string(ev.Kv.Value))
-			case mvccpb.DELETE: // 删除
-				s.DelServiceList(string(ev.Kv.Key))
+	slog.Info("watching prefix", "prefix", prefix)
+	for s.ctx.Err() == nil {
+		s.lock.RLock()
+		rev := s.revision + 1
+		s.lock.RUnlock()
+
+		ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(s.ctx))
+		rch := s.cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev), clientv3.WithProgressNotify())
+		go s.requestProgress(ctx, prefix)
+		for wresp := range rch {
+			if wresp.CompactRevision > 0 {
+				// 需要的版本已被压缩，只能重新全量同步
+				slog.Warn("watch compacted, resyncing", "prefix", prefix, "compact_revision", wresp.CompactRevision)
+				if err := s.sync(prefix); err != nil {
+					slog.Error("resync services failed", "prefix", prefix, "error", err)
+				}
+				break
 			}
+			if err := wresp.Err(); err != nil {
+				slog.Warn("watch error", "prefix", prefix, "error", err)
+				break
+			}
+			for _, ev := range wresp.Events {
+				switch ev.Type {
+				case mvccpb.PUT: // 修改或者新增
+					s.SetServiceList(string(ev.Kv.Key), string(ev.Kv.Value))
+				case mvccpb.DELETE: // 删除
+					s.DelServiceList(string(ev.Kv.Key))
+				}
+			}
+			s.lock.Lock()
+			s.revision = wresp.Header.Revision
+			if wresp.IsProgressNotify() {
+				s.resyncing = false
+			}
+			s.lock.Unlock()
+		}
+		cancel()
+		s.lock.Lock()
+		s.resyncing = true
+		s.lock.Unlock()
+
+		select {
+		case <-s.ctx.Done():
+		case <-time.After(time.Second):
+			slog.Info("rewatching prefix", "prefix", prefix, "revision", s.Revision()+1)
 		}
 	}
 }
 
+// progressInterval 重新监听后请求进度通知的间隔
+const progressInterval = time.Second
+
+// requestProgress 重新监听后定期请求进度通知，直到收到通知或监听结束
+// etcd在监听追上最新版本前会忽略进度请求，监听建立前发出的请求也可能丢失，只请求一次可能永远收不到通知
+func (s *ServiceDiscovery) requestProgress(ctx context.Context, prefix string) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for s.Resyncing() {
+		if err := s.cli.RequestProgress(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("request watch progress failed", "prefix", prefix, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetServiceList 更新实例信息，只有实例新增或信息变化时才通知订阅者
 func (s *ServiceDiscovery) SetServiceList(key, val string) {
+	var info ServiceInfo
+	if err := json.Unmarshal([]byte(val), &info); err != nil {
+		slog.Warn("invalid service info", "key", key, "error", err)
+		return
+	}
+	if info.InstanceId == "" {
+		// 旧版本注册的实例没有InstanceId，使用key中的实例ID
+		info.InstanceId = key[strings.LastIndex(key, "/")+1:]
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.serverList[key] = string(val)
-	log.Println("put key:", key, "val:", val)
+	if old, ok := s.serverList[key]; ok && reflect.DeepEqual(old, info) {
+		return
+	}
+	s.serverList[key] = info
+	s.publish(ServiceEvent{Type: ServiceJoin, Key: key, Service: info})
+	slog.Debug("service put", "key", key, "val", val)
 }
 
 func (s *ServiceDiscovery) DelServiceList(key string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	info, ok := s.serverList[key]
+	if !ok {
+		return
+	}
 	delete(s.serverList, key)
-	log.Println("Del key :", key)
+	s.publish(ServiceEvent{Type: ServiceLeave, Key: key, Service: info})
+	slog.Debug("service deleted", "key", key)
+}
+
+// publish 通知订阅者，调用方需持有写锁
+func (s *ServiceDiscovery) publish(event ServiceEvent) {
+	for _, sub := range s.subscribers {
+		if sub.name != "" && sub.name != event.Service.Name {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// 订阅者应在收到事件后读取完整的实例列表，丢弃的事件由之后的事件或全量对账弥补
+			droppedEvents.WithLabelValues(sub.name).Inc()
+			slog.Warn("subscriber is too slow, drop event", "subscriber", sub.name, "key", event.Key)
+		}
+	}
 }
 
-// GetServices 获取服务地址
-func (s *ServiceDiscovery) GetServices() []string {
+// Subscribe 订阅服务实例的上下线事件，name为空时订阅所有服务
+// 返回的函数用于取消订阅
+func (s *ServiceDiscovery) Subscribe(name string) (<-chan ServiceEvent, func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := s.nextSubId
+	s.nextSubId++
+	sub := &subscriber{name: name, ch: make(chan ServiceEvent, 64)}
+	s.subscribers[id] = sub
+	return sub.ch, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if _, ok := s.subscribers[id]; ok {
+			delete(s.subscribers, id)
+			close(sub.ch)
+		}
+	}
+}
+
+// GetServices 获取所有服务实例
+func (s *ServiceDiscovery) GetServices() []ServiceInfo {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	addrs := make([]string, 0, len(s.serverList))
+	services := make([]ServiceInfo, 0, len(s.serverList))
 
 	for _, v := range s.serverList {
-		addrs = append(addrs, v)
+		services = append(services, v)
 	}
-	return addrs
+	return services
+}
+
+// GetService 根据服务名称获取服务实例
+func (s *ServiceDiscovery) GetService(name string) []ServiceInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	services := make([]ServiceInfo, 0)
+
+	for _, v := range s.serverList {
+		if v.Name == name {
+			services = append(services, v)
+		}
+	}
+	return services
+}
+
+// Revision 返回最后同步的etcd修订版本
+func (s *ServiceDiscovery) Revision() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.revision
+}
+
+// Resyncing 监听中断后重新同步期间返回true，此时缺少的实例不代表已经下线
+func (s *ServiceDiscovery) Resyncing() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.resyncing
 }
 
 // Close 关闭服务
 func (s *ServiceDiscovery) Close() error {
+	s.cancel()
+	s.lock.Lock()
+	for id, sub := range s.subscribers {
+		delete(s.subscribers, id)
+		close(sub.ch)
+	}
+	s.lock.Unlock()
 	return s.cli.Close()
 }
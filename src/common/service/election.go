@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"log/slog"
+	"time"
+)
+
+// ElectionPrefix etcd中选主使用的前缀
+const ElectionPrefix = "/election/"
+
+// RunAsLeader 通过etcd选主，当选后执行fn；失去leader身份时取消fn的ctx并重新参选，直到ctx结束
+// 同一个key下同一时间只有一个id在执行fn
+func RunAsLeader(ctx context.Context, cli *clientv3.Client, key, id string, fn func(ctx context.Context) error) error {
+	for ctx.Err() == nil {
+		session, err := concurrency.NewSession(cli, concurrency.WithTTL(10), concurrency.WithContext(ctx))
+		if err != nil {
+			slog.Warn("failed to create election session", "key", key, "error", err)
+			sleepContext(ctx, time.Second)
+			continue
+		}
+		election := concurrency.NewElection(session, key)
+		if err := election.Campaign(ctx, id); err != nil {
+			session.Close()
+			if ctx.Err() == nil {
+				slog.Warn("campaign failed", "key", key, "error", err)
+				sleepContext(ctx, time.Second)
+			}
+			continue
+		}
+		slog.Info("became leader", "key", key, "id", id)
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			// 会话过期说明其他节点可能已经当选
+			select {
+			case <-session.Done():
+			case <-leaderCtx.Done():
+			}
+			cancel()
+		}()
+		err = fn(leaderCtx)
+		cancel()
+
+		resignCtx, resignCancel := context.WithTimeout(context.Background(), 3*time.Second)
+		election.Resign(resignCtx)
+		resignCancel()
+		session.Close()
+		if ctx.Err() == nil {
+			slog.Warn("lost leadership, campaigning again", "key", key, "id", id, "error", err)
+			sleepContext(ctx, time.Second)
+		}
+	}
+	return ctx.Err()
+}
+
+// sleepContext 等待d，ctx提前结束时返回ctx的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"log/slog"
+	"metrics"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"tracing"
+)
+
+// gatewayHeaders 直接映射为标准HTTP响应头的gRPC响应metadata
+var gatewayHeaders = map[string]string{
+	"retry-after": "Retry-After", // 限流时的重试间隔，配合 ResourceExhausted -> 429
+	RequestIDKey:  textproto.CanonicalMIMEHeaderKey(RequestIDKey),
+}
+
+// GatewayHeaderMatcher 网关的响应头映射，其余metadata保持grpc-gateway默认的 Grpc-Metadata- 前缀
+func GatewayHeaderMatcher(key string) (string, bool) {
+	if h, ok := gatewayHeaders[strings.ToLower(key)]; ok {
+		return h, true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// GatewayMarshaler 网关的JSON格式：使用proto中的字段名，输出零值字段，忽略未知字段
+var GatewayMarshaler = &runtime.JSONPb{
+	MarshalOptions: protojson.MarshalOptions{
+		UseProtoNames:   true,
+		EmitUnpopulated: true,
+	},
+	UnmarshalOptions: protojson.UnmarshalOptions{
+		DiscardUnknown: true,
+	},
+}
+
+// GatewayErrorHandler 服务端错误写入日志，响应格式与grpc-gateway默认一致
+func GatewayErrorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	switch status.Code(err) {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded:
+		slog.ErrorContext(ctx, "gateway request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, r, err)
+}
+
+// NewGatewayMux 创建所有服务共用格式的网关，opts可以覆盖默认选项
+func NewGatewayMux(opts ...runtime.ServeMuxOption) *runtime.ServeMux {
+	return runtime.NewServeMux(append([]runtime.ServeMuxOption{
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, GatewayMarshaler),
+		runtime.WithOutgoingHeaderMatcher(GatewayHeaderMatcher),
+		runtime.WithErrorHandler(GatewayErrorHandler),
+	}, opts...)...)
+}
+
+// GatewayDialOptions 网关连接本服务gRPC端口的选项，将HTTP请求的链路信息传递给gRPC服务
+func GatewayDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor()),
+	}
+}
+
+// MetricsPath Prometheus抓取指标的路径，与网关共用HTTP端口
+const MetricsPath = "/metrics"
+
+// GatewayHandler 包装网关的ServeMux：挂载 /metrics，并为每个HTTP请求创建span、读取Kong传入的traceparent
+func GatewayHandler(gwmux http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, metrics.Handler())
+	mux.Handle("/", tracing.Handler(gwmux))
+	return mux
+}
@@ -4,6 +4,7 @@ go 1.22
 
 require (
 	github.com/go-swagger/go-swagger v0.31.0
+	github.com/prometheus/client_golang v1.20.5
 	go.etcd.io/etcd/api/v3 v3.5.17
 	go.etcd.io/etcd/client/v3 v3.5.17
 	google.golang.org/grpc v1.59.0
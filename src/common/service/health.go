@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 探针的HTTP路径，与网关共用HTTP端口
+const (
+	LivenessPath  = "/healthz" // 进程存活，失败时应当重启实例
+	ReadinessPath = "/readyz"  // 依赖可用，失败时Kong停止转发流量
+)
+
+// ErrShuttingDown 实例正在退出，不再接收新的请求
+var ErrShuttingDown = errors.New("service is shutting down")
+
+var healthStatus = NewGauge("service_ready", "Whether the service is ready to serve (1) or not (0).")
+
+// CheckFunc 依赖检查，返回错误表示依赖不可用
+type CheckFunc func(ctx context.Context) error
+
+// HealthResult 一次就绪检查的结果
+type HealthResult struct {
+	Status string            `json:"status"` // ok、fail、shutting_down
+	Checks map[string]string `json:"checks"` // 每个依赖的结果，ok或错误信息
+}
+
+// Health 聚合服务注册的依赖检查，提供 grpc.health.v1 服务以及 /healthz、/readyz 探针
+type Health struct {
+	Timeout  time.Duration // 单个检查的超时
+	CacheTTL time.Duration // 检查结果的缓存时间，避免探针频繁访问依赖
+
+	server   *health.Server
+	shutdown atomic.Bool
+
+	lock     sync.Mutex
+	checks   map[string]CheckFunc
+	last     HealthResult
+	lastErr  error
+	lastTime time.Time
+	inflight *healthCall // 正在执行的检查，缓存过期后并发的调用等待同一次检查
+	services []string    // 已注册的gRPC服务
+}
+
+type healthCall struct {
+	done   chan struct{}
+	result HealthResult
+	err    error
+}
+
+// HealthChecker 提供依赖检查的组件，如 storage.BaseStorage
+type HealthChecker interface {
+	HealthChecks() map[string]func(ctx context.Context) error
+}
+
+func NewHealth() *Health {
+	h := &Health{
+		Timeout:  time.Second,
+		CacheTTL: time.Second,
+		server:   health.NewServer(),
+		checks:   make(map[string]CheckFunc),
+	}
+	h.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+// AddCheck 添加或替换名为name的依赖检查
+func (h *Health) AddCheck(name string, check CheckFunc) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.checks[name] = check
+	h.lastTime = time.Time{}
+}
+
+// AddChecks 添加组件的所有依赖检查，在创建存储等组件后调用
+func (h *Health) AddChecks(c HealthChecker) {
+	for name, check := range c.HealthChecks() {
+		h.AddCheck(name, check)
+	}
+}
+
+func (h *Health) RemoveCheck(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.checks, name)
+	h.lastTime = time.Time{}
+}
+
+// Register 在gRPC服务器上注册 grpc.health.v1，需要在注册完业务服务之后调用
+func (h *Health) Register(server *grpc.Server) {
+	h.lock.Lock()
+	h.services = h.services[:0]
+	for name := range server.GetServiceInfo() {
+		h.services = append(h.services, name)
+	}
+	h.lock.Unlock()
+	healthpb.RegisterHealthServer(server, h.server)
+	h.Check(context.Background())
+}
+
+// Check 执行所有依赖检查并更新gRPC的服务状态，CacheTTL内返回缓存的结果
+// 同一时间只执行一次检查，其他调用等待它的结果
+func (h *Health) Check(ctx context.Context) (HealthResult, error) {
+	if h.shutdown.Load() {
+		return HealthResult{Status: "shutting_down", Checks: map[string]string{}}, ErrShuttingDown
+	}
+	h.lock.Lock()
+	if !h.lastTime.IsZero() && time.Since(h.lastTime) < h.CacheTTL {
+		defer h.lock.Unlock()
+		return h.last, h.lastErr
+	}
+	if call := h.inflight; call != nil {
+		h.lock.Unlock()
+		select {
+		case <-call.done:
+			return call.result, call.err
+		case <-ctx.Done():
+			return HealthResult{Status: "fail", Checks: map[string]string{}}, ctx.Err()
+		}
+	}
+	call := &healthCall{done: make(chan struct{})}
+	h.inflight = call
+	checks := make(map[string]CheckFunc, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.lock.Unlock()
+
+	// 其他调用共享这次检查的结果，调用方取消时检查仍执行到Timeout
+	call.result, call.err = h.run(context.WithoutCancel(ctx), checks)
+	h.lock.Lock()
+	h.last, h.lastErr, h.lastTime = call.result, call.err, time.Now()
+	h.inflight = nil
+	h.lock.Unlock()
+	close(call.done)
+	h.setServing(call.err == nil)
+	return call.result, call.err
+}
+
+// run 并发执行依赖检查
+func (h *Health) run(ctx context.Context, checks map[string]CheckFunc) (HealthResult, error) {
+	result := HealthResult{Status: "ok", Checks: make(map[string]string, len(checks))}
+	errs := make(map[string]error, len(checks))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+			err := check(ctx)
+			mu.Lock()
+			errs[name] = err
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	var failed []string
+	for name, err := range errs {
+		if err != nil {
+			result.Checks[name] = err.Error()
+			failed = append(failed, name)
+		} else {
+			result.Checks[name] = "ok"
+		}
+	}
+	var err error
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		result.Status = "fail"
+		err = fmt.Errorf("unhealthy dependencies: %v", failed)
+	}
+	return result, err
+}
+
+// Ready 所有依赖可用且未在退出时返回nil
+func (h *Health) Ready(ctx context.Context) error {
+	_, err := h.Check(ctx)
+	return err
+}
+
+// Shutdown 标记为不可用，之后的就绪检查全部失败，gRPC客户端和Kong会停止向本实例发送请求
+func (h *Health) Shutdown() {
+	if h.shutdown.Swap(true) {
+		return
+	}
+	h.server.Shutdown()
+	healthStatus.WithLabelValues().Set(0)
+	slog.Info("readiness set to not serving")
+}
+
+// ShuttingDown 是否已经调用Shutdown
+func (h *Health) ShuttingDown() bool {
+	return h.shutdown.Load()
+}
+
+// Watch 定期执行检查，使gRPC的Watch调用能收到状态变化，直到ctx结束或Shutdown
+func (h *Health) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for !h.shutdown.Load() {
+		if _, err := h.Check(ctx); err != nil && !errors.Is(err, ErrShuttingDown) {
+			slog.Warn("service not ready", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Health) setServing(ok bool) {
+	if h.shutdown.Load() {
+		return
+	}
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	value := 0.0
+	if ok {
+		status = healthpb.HealthCheckResponse_SERVING
+		value = 1
+	}
+	h.lock.Lock()
+	services := append([]string{""}, h.services...)
+	h.lock.Unlock()
+	for _, name := range services {
+		h.server.SetServingStatus(name, status)
+	}
+	healthStatus.WithLabelValues().Set(value)
+}
+
+// LivenessHandler 进程能够处理请求即为存活，不检查依赖，避免依赖故障时实例被反复重启
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, HealthResult{Status: "ok", Checks: map[string]string{}})
+	})
+}
+
+// ReadinessHandler 依赖全部可用时返回200，否则返回503
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := h.Check(r.Context())
+		code := http.StatusOK
+		if err != nil {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, result)
+	})
+}
+
+func writeHealth(w http.ResponseWriter, code int, result HealthResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(result)
+}
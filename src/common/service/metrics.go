@@ -0,0 +1,35 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"metrics"
+)
+
+// 业务指标，注册到公共的指标注册中心并通过 /metrics 暴露
+// 同名指标重复注册时返回已注册的指标；标签的取值应当是有限的集合，不要使用商品ID、用户ID等，例如：
+//
+//	seckillAttempts := service.NewCounter("seckill_attempts_total", "Total seckill attempts.", "result")
+//	seckillAttempts.WithLabelValues("oversell").Inc()
+
+// NewCounter 注册只增不减的计数器
+func NewCounter(name, help string, labels ...string) *prometheus.CounterVec {
+	return mustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels))
+}
+
+// NewGauge 注册可增可减的仪表（如库存余量、排队长度）
+func NewGauge(name, help string, labels ...string) *prometheus.GaugeVec {
+	return mustRegister(prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels))
+}
+
+// NewHistogram 注册直方图（如下单耗时），buckets为nil时使用默认的桶
+func NewHistogram(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	return mustRegister(prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels))
+}
+
+func mustRegister[C prometheus.Collector](c C) C {
+	c, err := metrics.Register(c)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,457 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"logging"
+	"metrics"
+	"ratelimit"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tracing"
+)
+
+// 内置中间件名称
+const (
+	MiddlewareRecovery  = "recovery"
+	MiddlewareRequestID = "request-id"
+	MiddlewareLogging   = "logging"
+	MiddlewareDeadline  = "deadline"
+	MiddlewareTracing   = "tracing"
+	MiddlewareMetrics   = "metrics"
+	MiddlewareRateLimit = "ratelimit"
+)
+
+// RequestIDKey 请求ID在gRPC metadata中的key
+const RequestIDKey = "x-request-id"
+
+// Middleware gRPC中间件，可以只实现其中一部分拦截器
+type Middleware struct {
+	Name         string
+	Order        int // 越小越先执行（越靠外层）
+	Unary        grpc.UnaryServerInterceptor
+	Stream       grpc.StreamServerInterceptor
+	UnaryClient  grpc.UnaryClientInterceptor
+	StreamClient grpc.StreamClientInterceptor
+}
+
+// MiddlewareRegistry 可热插拔的中间件注册中心
+// 服务端和客户端只注册一次由注册中心生成的拦截器，每次请求时读取当前启用的中间件快照
+type MiddlewareRegistry struct {
+	lock        sync.Mutex
+	middlewares map[string]*Middleware
+	enabled     map[string]bool
+	chain       atomic.Pointer[[]*Middleware] // 按Order排序的已启用中间件
+}
+
+func NewMiddlewareRegistry() *MiddlewareRegistry {
+	r := &MiddlewareRegistry{
+		middlewares: make(map[string]*Middleware),
+		enabled:     make(map[string]bool),
+	}
+	r.chain.Store(&[]*Middleware{})
+	return r
+}
+
+// NewDefaultMiddlewareRegistry 创建注册了内置中间件的注册中心
+// timeout 为请求的最长处理时间，为0时不强制超时
+func NewDefaultMiddlewareRegistry(timeout time.Duration) *MiddlewareRegistry {
+	r := NewMiddlewareRegistry()
+	r.Register(RecoveryMiddleware())
+	r.Register(TracingMiddleware())
+	r.Register(MetricsMiddleware())
+	r.Register(RequestIDMiddleware())
+	r.Register(LoggingMiddleware())
+	r.Register(DeadlineMiddleware(timeout))
+	return r
+}
+
+// Register 注册（或替换同名）中间件，新注册的中间件默认启用
+func (r *MiddlewareRegistry) Register(m Middleware) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.middlewares[m.Name] = &m
+	if _, ok := r.enabled[m.Name]; !ok {
+		r.enabled[m.Name] = true
+	}
+	r.rebuild()
+}
+
+// Unregister 移除中间件
+func (r *MiddlewareRegistry) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.middlewares, name)
+	delete(r.enabled, name)
+	r.rebuild()
+}
+
+// Enable 启用中间件
+func (r *MiddlewareRegistry) Enable(name string) {
+	r.SetEnabled(map[string]bool{name: true})
+}
+
+// Disable 禁用中间件
+func (r *MiddlewareRegistry) Disable(name string) {
+	r.SetEnabled(map[string]bool{name: false})
+}
+
+// SetEnabled 批量设置中间件开关（通常来自配置中心），未出现在enabled中的中间件保持原状态
+func (r *MiddlewareRegistry) SetEnabled(enabled map[string]bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, on := range enabled {
+		r.enabled[name] = on
+	}
+	r.rebuild()
+}
+
+// Enabled 返回当前启用的中间件名称（按执行顺序）
+func (r *MiddlewareRegistry) Enabled() []string {
+	chain := *r.chain.Load()
+	names := make([]string, 0, len(chain))
+	for _, m := range chain {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// rebuild 重建已启用中间件的快照，调用方需持有锁
+func (r *MiddlewareRegistry) rebuild() {
+	chain := make([]*Middleware, 0, len(r.middlewares))
+	for name, m := range r.middlewares {
+		if r.enabled[name] {
+			chain = append(chain, m)
+		}
+	}
+	sort.SliceStable(chain, func(i, j int) bool {
+		if chain[i].Order == chain[j].Order {
+			return chain[i].Name < chain[j].Name
+		}
+		return chain[i].Order < chain[j].Order
+	})
+	r.chain.Store(&chain)
+}
+
+// UnaryServerInterceptor 返回执行当前中间件链的一元服务端拦截器
+func (r *MiddlewareRegistry) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		chain := *r.chain.Load()
+		var next func(i int, ctx context.Context, req any) (any, error)
+		next = func(i int, ctx context.Context, req any) (any, error) {
+			for ; i < len(chain); i++ {
+				if m := chain[i]; m.Unary != nil {
+					return m.Unary(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+						return next(i+1, ctx, req)
+					})
+				}
+			}
+			return handler(ctx, req)
+		}
+		return next(0, ctx, req)
+	}
+}
+
+// StreamServerInterceptor 返回执行当前中间件链的流式服务端拦截器
+func (r *MiddlewareRegistry) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chain := *r.chain.Load()
+		var next func(i int, srv any, ss grpc.ServerStream) error
+		next = func(i int, srv any, ss grpc.ServerStream) error {
+			for ; i < len(chain); i++ {
+				if m := chain[i]; m.Stream != nil {
+					return m.Stream(srv, ss, info, func(srv any, ss grpc.ServerStream) error {
+						return next(i+1, srv, ss)
+					})
+				}
+			}
+			return handler(srv, ss)
+		}
+		return next(0, srv, ss)
+	}
+}
+
+// UnaryClientInterceptor 返回执行当前中间件链的一元客户端拦截器
+func (r *MiddlewareRegistry) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		chain := *r.chain.Load()
+		var next func(i int, ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error
+		next = func(i int, ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			for ; i < len(chain); i++ {
+				if m := chain[i]; m.UnaryClient != nil {
+					return m.UnaryClient(ctx, method, req, reply, cc, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+						return next(i+1, ctx, method, req, reply, cc, opts...)
+					}, opts...)
+				}
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return next(0, ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor 返回执行当前中间件链的流式客户端拦截器
+func (r *MiddlewareRegistry) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		chain := *r.chain.Load()
+		var next func(i int, ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error)
+		next = func(i int, ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			for ; i < len(chain); i++ {
+				if m := chain[i]; m.StreamClient != nil {
+					return m.StreamClient(ctx, desc, cc, method, func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+						return next(i+1, ctx, desc, cc, method, opts...)
+					}, opts...)
+				}
+			}
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		return next(0, ctx, desc, cc, method, opts...)
+	}
+}
+
+// ServerOptions 返回挂载中间件链的服务端选项
+func (r *MiddlewareRegistry) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(r.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(r.StreamServerInterceptor()),
+	}
+}
+
+// DialOptions 返回挂载中间件链的客户端选项
+func (r *MiddlewareRegistry) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(r.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(r.StreamClientInterceptor()),
+	}
+}
+
+// wrappedStream 替换ServerStream的context
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
+
+// ---------------- 内置中间件 ----------------
+
+// RecoveryMiddleware 捕获handler中的panic并转换为 codes.Internal 错误
+func RecoveryMiddleware() Middleware {
+	recoverErr := func(method string, p any) error {
+		slog.Error("panic recovered", "method", method, "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+		return status.Errorf(codes.Internal, "internal error: %v", p)
+	}
+	return Middleware{
+		Name:  MiddlewareRecovery,
+		Order: 0,
+		Unary: func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = recoverErr(info.FullMethod, p)
+				}
+			}()
+			return handler(ctx, req)
+		},
+		Stream: func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = recoverErr(info.FullMethod, p)
+				}
+			}()
+			return handler(srv, ss)
+		},
+	}
+}
+
+// TracingMiddleware 创建链路span并通过metadata传递W3C traceparent
+func TracingMiddleware() Middleware {
+	return Middleware{
+		Name:         MiddlewareTracing,
+		Order:        5,
+		Unary:        tracing.UnaryServerInterceptor(),
+		Stream:       tracing.StreamServerInterceptor(),
+		UnaryClient:  tracing.UnaryClientInterceptor(),
+		StreamClient: tracing.StreamClientInterceptor(),
+	}
+}
+
+// MetricsMiddleware 统计gRPC请求数和耗时，通过 /metrics 暴露
+func MetricsMiddleware() Middleware {
+	return Middleware{
+		Name:         MiddlewareMetrics,
+		Order:        6,
+		Unary:        metrics.UnaryServerInterceptor(),
+		Stream:       metrics.StreamServerInterceptor(),
+		UnaryClient:  metrics.UnaryClientInterceptor(),
+		StreamClient: metrics.StreamClientInterceptor(),
+	}
+}
+
+type requestIDCtxKey struct{}
+
+// RequestIDFromContext 获取当前请求的请求ID
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return id
+}
+
+// ContextWithRequestID 在context中设置请求ID，客户端中间件会将其传递给下游服务
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey{}, id)
+}
+
+// NewRequestID 生成随机请求ID
+func NewRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// incomingRequestID 从metadata中读取请求ID，没有时生成新的ID
+func incomingRequestID(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = NewRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, id))
+	// 之后使用该context记录的日志都会带上 request_id
+	return logging.WithFields(ContextWithRequestID(ctx, id), logging.KeyRequestID, id)
+}
+
+// outgoingRequestID 将context中的请求ID写入发往下游的metadata
+func outgoingRequestID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIDKey)) > 0 {
+		return ctx
+	}
+	id := RequestIDFromContext(ctx)
+	if id == "" {
+		id = NewRequestID()
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDKey, id)
+}
+
+// RequestIDMiddleware 在服务间传递 x-request-id，入口请求没有时自动生成
+func RequestIDMiddleware() Middleware {
+	return Middleware{
+		Name:  MiddlewareRequestID,
+		Order: 10,
+		Unary: func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			return handler(incomingRequestID(ctx), req)
+		},
+		Stream: func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, &wrappedStream{ServerStream: ss, ctx: incomingRequestID(ss.Context())})
+		},
+		UnaryClient: func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+		},
+		StreamClient: func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+		},
+	}
+}
+
+// LoggingMiddleware 记录每个请求的方法、耗时和状态码
+func LoggingMiddleware() Middleware {
+	logRequest := func(ctx context.Context, kind, method string, start time.Time, err error) {
+		lvl := slog.LevelInfo
+		if err != nil {
+			lvl = slog.LevelWarn
+		}
+		slog.Log(ctx, lvl, "request", "kind", kind, "method", method, "code", status.Code(err).String(), "cost", time.Since(start))
+	}
+	return Middleware{
+		Name:  MiddlewareLogging,
+		Order: 20,
+		Unary: func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			logRequest(ctx, "grpc-server", info.FullMethod, start, err)
+			return resp, err
+		},
+		Stream: func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			logRequest(ss.Context(), "grpc-server-stream", info.FullMethod, start, err)
+			return err
+		},
+		UnaryClient: func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			start := time.Now()
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			logRequest(ctx, "grpc-client", method, start, err)
+			return err
+		},
+	}
+}
+
+// RateLimitMiddleware 按keyFn限流，被限流的请求返回 codes.ResourceExhausted 并附带 retry-after
+// 在日志中间件之后执行，被拒绝的请求同样记录日志和指标；按IP限流时使用 ratelimit.ByClientIPBehind 配置可信代理
+func RateLimitMiddleware(l ratelimit.Limiter, keyFn ratelimit.KeyFunc) Middleware {
+	return Middleware{
+		Name:   MiddlewareRateLimit,
+		Order:  25,
+		Unary:  ratelimit.UnaryServerInterceptor(l, keyFn),
+		Stream: ratelimit.StreamServerInterceptor(l, keyFn),
+	}
+}
+
+// DefaultRequestTimeout 服务端处理一元调用的默认最长时间（秒），可以通过配置中心的request_timeout修改
+const DefaultRequestTimeout = 30
+
+// DeadlineMiddleware 强制一元调用的最长处理时间，已经超时的请求直接拒绝
+// 流式调用通常是长连接（如健康检查的Watch），只拒绝已经超时的请求，不限制处理时间
+func DeadlineMiddleware(timeout time.Duration) Middleware {
+	withDeadline := func(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, error) {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= 0 {
+			return ctx, func() {}, status.Error(codes.DeadlineExceeded, "deadline exceeded before handling")
+		}
+		if timeout <= 0 {
+			return ctx, func() {}, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			return ctx, func() {}, nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, nil
+	}
+	return Middleware{
+		Name:  MiddlewareDeadline,
+		Order: 30,
+		Unary: func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, cancel, err := withDeadline(ctx, timeout)
+			defer cancel()
+			if err != nil {
+				return nil, err
+			}
+			resp, err := handler(ctx, req)
+			if err == nil && ctx.Err() == context.DeadlineExceeded {
+				return nil, status.Error(codes.DeadlineExceeded, fmt.Sprintf("%s exceeded %s", info.FullMethod, timeout))
+			}
+			return resp, err
+		},
+		Stream: func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			_, cancel, err := withDeadline(ss.Context(), 0)
+			defer cancel()
+			if err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		},
+	}
+}
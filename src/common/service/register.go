@@ -2,17 +2,56 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"metrics"
+	"strconv"
 	"time"
 )
 
-func RegisterService(ss *Service, endpoints []string) (err error) {
-	client, err := clientv3.New(clientv3.Config{
+// EtcdOption 修改etcd客户端的配置
+type EtcdOption func(*clientv3.Config)
+
+// WithEtcdTLS 使用TLS连接etcd，配置客户端证书即为mTLS
+func WithEtcdTLS(cfg *tls.Config) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.TLS = cfg
+	}
+}
+
+// withEtcdCerts 使用r的证书连接etcd，每个节点按其地址校验服务端证书
+func withEtcdCerts(r *CertReloader) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.TLS = r.ClientTLS()
+		// 在etcd客户端根据TLS创建的传输凭证之后添加，替换为按地址校验的凭证
+		c.DialOptions = append(c.DialOptions, grpc.WithTransportCredentials(r.TransportCredentials()))
+	}
+}
+
+func WithEtcdDialTimeout(timeout time.Duration) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.DialTimeout = timeout
+	}
+}
+
+// NewEtcdClient 新建etcd客户端
+func NewEtcdClient(endpoints []string, opts ...EtcdOption) (*clientv3.Client, error) {
+	cfg := clientv3.Config{
 		Endpoints:   endpoints,
-		DialTimeout: time.Second * 10,
-	})
+		DialTimeout: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return clientv3.New(cfg)
+}
+
+func RegisterService(ss *Service, endpoints []string) (err error) {
+	client, err := NewEtcdClient(endpoints, append(ss.etcdOptions(), WithEtcdDialTimeout(time.Second*10))...)
 	if err != nil {
 		return err
 	}
@@ -20,38 +59,53 @@ func RegisterService(ss *Service, endpoints []string) (err error) {
 	return
 }
 
+// etcdOptions 服务连接etcd的选项
+func (s *Service) etcdOptions() []EtcdOption {
+	if s.etcdCerts == nil {
+		return nil
+	}
+	return []EtcdOption{withEtcdCerts(s.etcdCerts)}
+}
+
 func (s *Service) StartCheckAlive(ctx context.Context) (err error) {
 
 	alive, err := s.KeepAlive(ctx)
 	if err != nil {
+		metrics.EtcdKeepAlives.WithLabelValues("lost").Inc()
 		return
 	}
+	metrics.EtcdLeaseAlive.Set(1)
+	s.setLeaseCheck(nil)
+	defer func() {
+		metrics.EtcdLeaseAlive.Set(0)
+		s.setLeaseCheck(errors.New("etcd lease is not alive"))
+	}()
 	for {
 		select {
 		case err = <-s.stop: // 服务端关闭返回错误
 			return err
 		case <-s.client.Ctx().Done(): // etcd关闭
 			return errors.New("server closed")
-		case _, ok := <-alive:
+		case resp, ok := <-alive:
 			if !ok { // 保活通道关闭
+				metrics.EtcdKeepAlives.WithLabelValues("lost").Inc()
 				return s.Revoke(ctx)
 			}
+			metrics.EtcdKeepAlives.WithLabelValues("ok").Inc()
+			metrics.EtcdLeaseTTL.Set(float64(resp.TTL))
 		}
 	}
 }
 
 func (s *Service) KeepAlive(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
-	info := s.ServiceInfo
-	key := s.getKey()
-	val, _ := json.Marshal(info)
+	info := s.Info()
 	// 创建租约
 	leaseResp, err := s.client.Grant(ctx, 5)
 	if err != nil {
 		return nil, err
 	}
 	// 写入etcd
-	_, err = s.client.Put(ctx, key, string(val), clientv3.WithLease(leaseResp.ID))
-	if err != nil {
+	if err := PutRegistration(ctx, s.client, info, leaseResp.ID); err != nil {
 		return nil, err
 	}
 
@@ -59,6 +113,86 @@ func (s *Service) KeepAlive(ctx context.Context) (<-chan *clientv3.LeaseKeepAliv
 	return s.client.KeepAlive(ctx, leaseResp.ID)
 }
 
+// updateRegistration 使用当前租约更新etcd中的服务信息
+func (s *Service) updateRegistration(ctx context.Context) error {
+	if s.leaseId == 0 {
+		return nil
+	}
+	return PutRegistration(ctx, s.client, s.Info(), s.leaseId)
+}
+
+// PutRegistration 写入实例的注册信息，控制器通过SetInstanceWeight设置过权重时使用该权重；
+// 读取权重之后权重被修改时重新读取，不会覆盖控制器写入的权重
+func PutRegistration(ctx context.Context, client *clientv3.Client, info ServiceInfo, lease clientv3.LeaseID) error {
+	key, weightKey := ServiceKey(info.Name, info.InstanceId), WeightKey(info.Name, info.InstanceId)
+	for {
+		resp, err := client.Get(ctx, weightKey)
+		if err != nil {
+			return err
+		}
+		var rev int64
+		if len(resp.Kvs) > 0 {
+			rev = resp.Kvs[0].ModRevision
+			if weight, err := strconv.Atoi(string(resp.Kvs[0].Value)); err == nil {
+				info.Weight = weight
+			}
+		}
+		val, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		txn, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(weightKey), "=", rev)).
+			Then(clientv3.OpPut(key, string(val), clientv3.WithLease(lease))).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+	}
+}
+
+// SetInstanceWeight 设置实例的权重并同步修改注册信息，实例之后更新注册信息时保留该权重；
+// 权重使用实例的租约，实例下线后随注册信息一起过期
+func SetInstanceWeight(ctx context.Context, client *clientv3.Client, name, instanceId string, weight int) error {
+	key := ServiceKey(name, instanceId)
+	for {
+		resp, err := client.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return fmt.Errorf("instance %s of %s is not registered", instanceId, name)
+		}
+		kv := resp.Kvs[0]
+		var info ServiceInfo
+		if err := json.Unmarshal(kv.Value, &info); err != nil {
+			return fmt.Errorf("decode registration of %s: %w", instanceId, err)
+		}
+		info.Weight = weight
+		val, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		// 注册信息在读取之后被实例修改时重新读取
+		txn, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+			Then(
+				clientv3.OpPut(WeightKey(name, instanceId), strconv.Itoa(weight), clientv3.WithLease(clientv3.LeaseID(kv.Lease))),
+				clientv3.OpPut(key, string(val), clientv3.WithIgnoreLease()),
+			).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+	}
+}
+
 // 取消租约
 func (s *Service) Revoke(ctx context.Context) error {
 	_, err := s.client.Revoke(ctx, s.leaseId)
@@ -66,6 +200,31 @@ func (s *Service) Revoke(ctx context.Context) error {
 	return err
 }
 
+// ServicePrefix etcd中服务注册信息的前缀
+const ServicePrefix = "/services/"
+
+// ServiceKey 返回服务实例在etcd中的注册key：/services/<name>/<instance-id>
+func ServiceKey(name, instanceId string) string {
+	return ServicePrefix + name + "/" + instanceId
+}
+
+// WeightPrefix 控制器为实例设置的权重的前缀，优先于实例配置的权重
+const WeightPrefix = "/weights/"
+
+// WeightKey 返回实例权重在etcd中的key：/weights/<name>/<instance-id>
+func WeightKey(name, instanceId string) string {
+	return WeightPrefix + name + "/" + instanceId
+}
+
 func (s *Service) getKey() string {
-	return "/services/" + s.ServiceInfo.Name
+	info := s.Info()
+	return ServiceKey(info.Name, info.InstanceId)
+}
+
+// setLeaseCheck 把租约状态作为就绪检查，err为nil表示租约有效
+func (s *Service) setLeaseCheck(err error) {
+	if s.Probes == nil {
+		return
+	}
+	s.Probes.AddCheck("etcd", func(ctx context.Context) error { return err })
 }
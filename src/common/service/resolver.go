@@ -0,0 +1,137 @@
+package service
+
+import (
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ResolverScheme 基于服务发现的gRPC解析器协议，目标地址形如 etcd:///product
+const ResolverScheme = "etcd"
+
+type instanceAttrKey struct{}
+
+// instanceAttr 随地址传递给负载均衡器的实例信息
+type instanceAttr struct {
+	Weight   int
+	Metadata map[string]string
+}
+
+func (a instanceAttr) Equal(o any) bool {
+	oa, ok := o.(instanceAttr)
+	return ok && a.Weight == oa.Weight && reflect.DeepEqual(a.Metadata, oa.Metadata)
+}
+
+// getInstanceAttr 读取地址上的实例信息，不是由服务发现产生的地址按权重1处理
+func getInstanceAttr(addr resolver.Address) instanceAttr {
+	attr, ok := addr.BalancerAttributes.Value(instanceAttrKey{}).(instanceAttr)
+	if !ok {
+		attr.Weight = 1
+	}
+	return attr
+}
+
+// NewInstanceAddress 将服务实例转换为gRPC地址，权重和元数据供负载均衡器使用
+func NewInstanceAddress(info ServiceInfo) resolver.Address {
+	addr := resolver.Address{
+		Addr:       info.GrpcAddr(),
+		ServerName: info.Name,
+	}
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(instanceAttrKey{}, instanceAttr{
+		Weight:   info.Weight,
+		Metadata: info.Metadata,
+	})
+	return addr
+}
+
+// EtcdResolverBuilder 使用ServiceDiscovery解析 etcd:///<service> 形式的目标
+// 调用方需要先执行 discovery.WatchService(ServicePrefix)
+type EtcdResolverBuilder struct {
+	discovery *ServiceDiscovery
+}
+
+func NewEtcdResolverBuilder(discovery *ServiceDiscovery) *EtcdResolverBuilder {
+	return &EtcdResolverBuilder{discovery: discovery}
+}
+
+func (b *EtcdResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	name := strings.TrimPrefix(target.Endpoint(), "/")
+	if name == "" {
+		return nil, fmt.Errorf("invalid target %s, service name is required", target.URL.String())
+	}
+	events, cancel := b.discovery.Subscribe(name)
+	r := &etcdResolver{
+		name:      name,
+		discovery: b.discovery,
+		cc:        cc,
+		cancel:    cancel,
+	}
+	r.update()
+	go func() {
+		for range events {
+			r.update()
+		}
+	}()
+	return r, nil
+}
+
+func (b *EtcdResolverBuilder) Scheme() string {
+	return ResolverScheme
+}
+
+type etcdResolver struct {
+	name      string
+	discovery *ServiceDiscovery
+	cc        resolver.ClientConn
+	cancel    func()
+}
+
+// update 将服务发现中的实例列表推送给gRPC，权重为0的实例（如灰度发布前的新版本）不接收请求
+func (r *etcdResolver) update() {
+	services := r.discovery.GetService(r.name)
+	addrs := make([]resolver.Address, 0, len(services))
+	for _, info := range services {
+		if info.Weight <= 0 {
+			continue
+		}
+		addrs = append(addrs, NewInstanceAddress(info))
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Addr < addrs[j].Addr })
+	if len(addrs) == 0 {
+		r.cc.ReportError(fmt.Errorf("no available instance of service %s", r.name))
+		return
+	}
+	if err := r.cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		slog.Warn("failed to update resolver state", "target", r.name, "error", err)
+	}
+}
+
+func (r *etcdResolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.update()
+}
+
+func (r *etcdResolver) Close() {
+	r.cancel()
+}
+
+// DialService 通过服务发现连接内部服务，balancerName 为空时使用加权轮询
+// opts中需要提供传输凭证，服务内部调用使用 Service.DialService
+func DialService(discovery *ServiceDiscovery, name string, balancerName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if balancerName == "" {
+		balancerName = WeightedRoundRobin
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithResolvers(NewEtcdResolverBuilder(discovery)),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, balancerName)),
+	}, opts...)
+	return grpc.NewClient(ResolverScheme+":///"+name, opts...)
+}
+
+// DialService 使用本服务的传输凭证通过服务发现连接内部服务，启用gRPC TLS时为mTLS
+func (s *Service) DialService(discovery *ServiceDiscovery, name string, balancerName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return DialService(discovery, name, balancerName, append([]grpc.DialOption{s.ClientCredentials()}, opts...)...)
+}
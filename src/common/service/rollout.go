@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	k "kongApi"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rolloutTraffic 灰度发布中新版本的流量比例
+var rolloutTraffic = NewGauge("rollout_traffic_percent", "Percentage of traffic routed to the new version during a rollout.", "service", "version")
+
+// ErrRolloutAborted 检查失败或被取消，流量已切回旧版本
+var ErrRolloutAborted = errors.New("rollout aborted")
+
+// RolloutChecker 每一步观察期结束后检查新版本，返回错误时回滚
+type RolloutChecker interface {
+	Check(ctx context.Context, service, version string, instances []ServiceInfo) error
+}
+
+type RolloutCheckerFunc func(ctx context.Context, service, version string, instances []ServiceInfo) error
+
+func (f RolloutCheckerFunc) Check(ctx context.Context, service, version string, instances []ServiceInfo) error {
+	return f(ctx, service, version, instances)
+}
+
+// KongHealthChecker 根据Kong主动和被动健康检查的结果判断新版本实例是否健康
+type KongHealthChecker struct {
+	Kong         *k.Client
+	MaxUnhealthy float64 // 允许不健康实例的比例
+}
+
+func (c *KongHealthChecker) Check(ctx context.Context, service, version string, instances []ServiceInfo) error {
+	health, err := c.Kong.UpstreamHealth(ctx, service)
+	if err != nil {
+		return fmt.Errorf("get upstream health: %w", err)
+	}
+	status := make(map[string]string, len(health))
+	for _, h := range health {
+		status[h.Target] = h.Health
+	}
+	var unhealthy []string
+	for _, info := range instances {
+		target := info.HttpAddr()
+		if h := status[target]; h == k.HealthUnhealthy || h == k.HealthDNSError {
+			unhealthy = append(unhealthy, target)
+		}
+	}
+	if len(instances) > 0 && float64(len(unhealthy))/float64(len(instances)) > c.MaxUnhealthy {
+		return fmt.Errorf("%d/%d instances of %s unhealthy in kong: %v", len(unhealthy), len(instances), version, unhealthy)
+	}
+	return nil
+}
+
+// PrometheusChecker 通过Prometheus查询新版本的错误率
+// Query中的$version替换为新版本号，结果为多条时取最大值，没有数据时视为0
+type PrometheusChecker struct {
+	URL          string
+	Query        string
+	MaxErrorRate float64
+	Client       *http.Client
+}
+
+func (c *PrometheusChecker) Check(ctx context.Context, service, version string, instances []ServiceInfo) error {
+	query := strings.ReplaceAll(c.Query, "$version", version)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.URL, "/")+"/api/v1/query?query="+url.QueryEscape(query), nil)
+	if err != nil {
+		return err
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("query prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Value [2]any `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("decode prometheus response: %w", err)
+	}
+	if res.Status != "success" {
+		return fmt.Errorf("query prometheus: %s", res.Error)
+	}
+	rate := 0.0
+	for _, r := range res.Data.Result {
+		s, _ := r.Value[1].(string)
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid prometheus value %q", s)
+		}
+		if !math.IsNaN(v) && v > rate {
+			rate = v
+		}
+	}
+	if rate > c.MaxErrorRate {
+		return fmt.Errorf("error rate of %s is %.4f, exceeds %.4f", version, rate, c.MaxErrorRate)
+	}
+	return nil
+}
+
+// Rollout 灰度发布：按步骤把Kong中旧版本的target权重转移到新版本，每一步观察后检查，失败时回滚
+// 新版本实例应当以Weight 0启动并注册，由Rollout分配流量；蓝绿发布使用 Steps = []int{100}
+type Rollout struct {
+	Service  string
+	From     string        // 旧版本，为空时为除To以外的所有实例
+	To       string        // 新版本
+	Steps    []int         // 每一步新版本的流量百分比
+	Interval time.Duration // 每一步之后的观察时间
+	Weight   int           // 新版本实例全量后的权重
+	Checkers []RolloutChecker
+	Etcd     *clientv3.Client // 同时设置etcd中的实例权重，避免TargetController和实例更新注册信息时把权重改回；为nil时只修改Kong
+
+	discovery *ServiceDiscovery
+	kong      *k.Client
+}
+
+func NewRollout(discovery *ServiceDiscovery, kong *k.Client, service, to string) *Rollout {
+	return &Rollout{
+		Service:   service,
+		To:        to,
+		Steps:     []int{10, 25, 50, 100},
+		Interval:  time.Minute,
+		Weight:    100,
+		discovery: discovery,
+		kong:      kong,
+	}
+}
+
+// Run 执行所有步骤，检查失败或ctx结束时把流量切回旧版本并返回ErrRolloutAborted
+func (r *Rollout) Run(ctx context.Context) error {
+	olds, news := r.instances()
+	if len(news) == 0 {
+		return fmt.Errorf("rollout: no instances of %s version %s", r.Service, r.To)
+	}
+	for i, p := range r.Steps {
+		if p < 0 || p > 100 || (i > 0 && p < r.Steps[i-1]) {
+			return fmt.Errorf("rollout: invalid steps %v", r.Steps)
+		}
+	}
+	// 旧版本的权重在回滚时恢复
+	baseline := make(map[string]int, len(olds))
+	for _, info := range olds {
+		baseline[info.InstanceId] = info.Weight
+	}
+
+	for _, percent := range r.Steps {
+		slog.Info("rollout step", "upstream", r.Service, "version", r.To, "percent", percent)
+		if err := r.apply(ctx, olds, news, baseline, percent); err != nil {
+			return r.rollback(olds, news, baseline, percent, err)
+		}
+		if err := sleepContext(ctx, r.Interval); err != nil {
+			return r.rollback(olds, news, baseline, percent, err)
+		}
+		for _, checker := range r.Checkers {
+			if err := checker.Check(ctx, r.Service, r.To, news); err != nil {
+				return r.rollback(olds, news, baseline, percent, err)
+			}
+		}
+	}
+	slog.Info("rollout finished", "upstream", r.Service, "version", r.To)
+	return nil
+}
+
+// rollback 新版本权重置0，旧版本恢复原来的权重；ctx可能已经结束，使用新的ctx
+func (r *Rollout) rollback(olds, news []ServiceInfo, baseline map[string]int, percent int, cause error) error {
+	slog.Error("rollout failed, rolling back", "upstream", r.Service, "version", r.To, "percent", percent, "error", cause)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := r.apply(ctx, olds, news, baseline, 0); err != nil {
+		return fmt.Errorf("%w at %d%%: %v; rollback failed: %v", ErrRolloutAborted, percent, cause, err)
+	}
+	return fmt.Errorf("%w at %d%%: %w", ErrRolloutAborted, percent, cause)
+}
+
+// instances 按版本划分服务实例
+func (r *Rollout) instances() (olds, news []ServiceInfo) {
+	for _, info := range r.discovery.GetService(r.Service) {
+		switch {
+		case info.Version == r.To:
+			news = append(news, info)
+		case r.From == "" || info.Version == r.From:
+			olds = append(olds, info)
+		}
+	}
+	sort.Slice(olds, func(i, j int) bool { return olds[i].InstanceId < olds[j].InstanceId })
+	sort.Slice(news, func(i, j int) bool { return news[i].InstanceId < news[j].InstanceId })
+	return olds, news
+}
+
+// Weights 计算新版本占percent流量时每个实例的权重
+// 新版本实例平分流量，旧版本按原来的权重比例分配剩余的流量
+func (r *Rollout) Weights(olds, news []ServiceInfo, baseline map[string]int, percent int) map[string]int {
+	weights := make(map[string]int, len(olds)+len(news))
+	newTotal := float64(r.Weight * len(news))
+	for _, info := range news {
+		weights[info.InstanceId] = scaleWeight(float64(r.Weight), percent)
+	}
+	oldTotal := 0
+	for _, info := range olds {
+		oldTotal += baseline[info.InstanceId]
+	}
+	for _, info := range olds {
+		base := baseline[info.InstanceId]
+		switch {
+		case percent == 0:
+			weights[info.InstanceId] = base
+		case oldTotal == 0:
+			weights[info.InstanceId] = 0
+		default:
+			weights[info.InstanceId] = scaleWeight(float64(base)*newTotal/float64(oldTotal), 100-percent)
+		}
+	}
+	return weights
+}
+
+func scaleWeight(base float64, percent int) int {
+	w := int(math.Round(base * float64(percent) / 100))
+	if w == 0 && base > 0 && percent > 0 {
+		w = 1
+	}
+	return w
+}
+
+// apply 修改Kong中target的权重，并同步到etcd中的注册信息
+func (r *Rollout) apply(ctx context.Context, olds, news []ServiceInfo, baseline map[string]int, percent int) error {
+	weights := r.Weights(olds, news, baseline, percent)
+	for _, info := range append(append([]ServiceInfo(nil), olds...), news...) {
+		info.Weight = weights[info.InstanceId]
+		target := &k.Target{Target: info.HttpAddr(), Weight: info.Weight}
+		if _, err := r.kong.UpsertTarget(ctx, r.Service, target); err != nil {
+			return fmt.Errorf("set weight of %s: %w", target.Target, err)
+		}
+		if r.Etcd == nil {
+			continue
+		}
+		if err := SetInstanceWeight(ctx, r.Etcd, r.Service, info.InstanceId, info.Weight); err != nil {
+			slog.Warn("failed to update registered weight", "instance", info.InstanceId, "error", err)
+		}
+	}
+	rolloutTraffic.WithLabelValues(r.Service, r.To).Set(float64(percent))
+	return nil
+}
@@ -2,53 +2,119 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	k "kongApi"
-	"log"
+	"log/slog"
+	"logging"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"storage"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"tracing"
 )
 
 type ServiceInfo struct {
-	Id          string   //服务运行的ID
-	Name        string   //服务运行的名称
-	Ip          string   //服务运行的IP
-	Port        int      //服务运行的端口
-	HttpPort    int      //服务运行的http端口
-	Protocol    string   //服务协议（默认http）
-	Weight      int      //服务权重
-	HealthPath  string   //健康检查路径
-	ServicePath string   //转发到下游的请求路径
-	RoutesName  string   //Kong路由名称
-	Paths       []string //kong路由路径
+	Id           string            //服务运行的ID
+	InstanceId   string            //服务实例ID（默认为ip:port），用于区分同一服务的多个副本
+	Name         string            //服务运行的名称
+	Ip           string            //服务运行的IP
+	Port         int               //服务运行的端口
+	HttpPort     int               //服务运行的http端口
+	Protocol     string            //服务协议（默认http）
+	Weight       int               //服务权重
+	HealthPath   string            //健康检查路径
+	HealthChecks *k.HealthChecks   `json:",omitempty"` //Kong健康检查配置，为空时使用HealthPath的默认配置
+	ServicePath  string            //转发到下游的请求路径
+	RoutesName   string            //Kong路由名称
+	Paths        []string          //kong路由路径
+	Version      string            //服务版本，灰度发布时区分新旧实例（默认读取环境变量SERVICE_VERSION）
+	Metadata     map[string]string //实例元数据（版本、机房等）
+	Plugins      []k.Plugin        `json:"-"` //作用在Kong Service上的插件，启动时同步，为nil时不管理插件；不写入etcd
+}
+
+// GrpcAddr gRPC服务的监听地址
+func (info ServiceInfo) GrpcAddr() string {
+	return net.JoinHostPort(info.Ip, strconv.Itoa(info.Port))
+}
+
+// HttpAddr 网关的监听地址，也是实例在Kong中的target
+func (info ServiceInfo) HttpAddr() string {
+	return net.JoinHostPort(info.Ip, strconv.Itoa(info.HttpPort))
 }
 
 type Service struct {
-	ServiceInfo    ServiceInfo
-	UpdateOnStart  bool
-	context        context.Context
-	stop           chan error
-	leaseId        clientv3.LeaseID
-	client         *clientv3.Client
-	grpcServer     *grpc.Server
-	grpcClientConn *grpc.ClientConn
-	listener       net.Listener
-	GormDB         *gorm.DB
+	ServiceInfo     ServiceInfo
+	KongCreateOnly  bool                     // 只创建Kong中缺少的实体，不修正已存在实体与代码的差异
+	Middlewares     *MiddlewareRegistry      // gRPC中间件
+	Probes          *Health                  // 依赖检查，提供gRPC健康检查和 /healthz、/readyz
+	Kong            *k.Client                // Kong Admin API 客户端
+	GatewayOptions  []runtime.ServeMuxOption // 覆盖网关的默认选项
+	context         context.Context
+	stop            chan error
+	leaseId         clientv3.LeaseID
+	client          *clientv3.Client
+	grpcServer      *grpc.Server
+	grpcClientConn  *grpc.ClientConn
+	listener        net.Listener
+	gwServer        *http.Server
+	GormDB          *gorm.DB
+	gormModels      []interface{}
+	dbConfig        DatabaseConfig
+	configCenter    *ConfigCenter
+	configKey       string
+	config          *ServiceConfig
+	tracer          *tracing.Tracer
+	tracingConfig   TracingConfig
+	shutdownConfig  ShutdownConfig
+	requestTimeout  int // 一元调用的最长处理时间（秒）
+	shutdownHooks   []shutdownHook
+	grpcRegister    GrpcRegister
+	gatewayRegister GatewayRegister
+	tlsSettings     TLSSettings
+	grpcCerts       *CertReloader
+	gatewayCerts    *CertReloader
+	etcdCerts       *CertReloader
+	stopCertWatch   context.CancelFunc
+	infoLock        sync.RWMutex // 保护热更新时替换的ServiceInfo和配置
+	configLock      sync.Mutex   // 串行执行LoadConfig和ApplyConfig
+}
+
+// Info 返回当前的服务信息，配置热更新期间也可以安全调用
+func (s *Service) Info() ServiceInfo {
+	s.infoLock.RLock()
+	defer s.infoLock.RUnlock()
+	return s.ServiceInfo
+}
+
+// setConfig 替换运行中的配置
+func (s *Service) setConfig(cfg *ServiceConfig) {
+	s.infoLock.Lock()
+	defer s.infoLock.Unlock()
+	s.ServiceInfo = cfg.Info
+	s.dbConfig = cfg.Database
+	s.shutdownConfig = cfg.Shutdown
+	s.requestTimeout = cfg.RequestTimeout
+	s.config = cfg
 }
 
 type ServiceManager struct {
 	ServiceGo ServiceGo
-	Reload    bool //配置热更新的选项
+	Reload    bool   //配置热更新的选项
+	ConfigKey string //配置中心中的配置key，为空时使用 /config/<Name>/default
 	sigs      chan os.Signal
 }
 
@@ -58,20 +124,18 @@ func NewServiceManager(serviceGo ServiceGo) *ServiceManager {
 	}
 }
 
-func (m *ServiceManager) listenForReSet() error {
-	for {
-		//todo 监听到对应ID的配置文件变动消息
-		err := m.ServiceGo.ServiceQuit()
-		if err != nil {
-			return err
-		}
-		//todo 拉取最新的配置并更新实体
-		//m.ServiceGo.LoadConfig()
-		err = m.ServiceGo.ServiceStart(m)
-		if err != nil {
-			return err
+// listenForReSet 监听配置中心的配置变动，仅重启发生变化的组件
+func (m *ServiceManager) listenForReSet(ctx context.Context) error {
+	configs, err := m.ServiceGo.WatchConfig(ctx)
+	if err != nil {
+		return err
+	}
+	for cfg := range configs {
+		if err := m.ServiceGo.ApplyConfig(m, cfg); err != nil {
+			slog.Error("failed to apply config", "revision", cfg.Revision, "error", err)
 		}
 	}
+	return nil
 }
 func (m *ServiceManager) StopService() error {
 	m.ServiceGo.ServiceQuit()
@@ -79,22 +143,30 @@ func (m *ServiceManager) StopService() error {
 }
 func (m *ServiceManager) StartService(ctx context.Context) error {
 	//m.ServiceGo.SetContext(ctx)
-	err := m.ServiceGo.ServiceStart(m)
+	if m.Reload {
+		if err := m.ServiceGo.LoadConfig(m.ConfigKey); err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+	}
 	m.sigs = make(chan os.Signal, 1)
 	signal.Notify(m.sigs, syscall.SIGINT, syscall.SIGTERM)
-
-	if err != nil {
-		panic(err)
+	defer signal.Stop(m.sigs)
+	if err := m.ServiceGo.ServiceStart(m); err != nil {
+		return fmt.Errorf("start service: %w", err)
 	}
 	if m.Reload {
-		go m.listenForReSet()
+		go func() {
+			if err := m.listenForReSet(ctx); err != nil {
+				slog.Warn("config watcher stopped", "error", err)
+			}
+		}()
 	}
 	select {
 	case <-ctx.Done():
 		m.StopService()
 	case sig := <-m.sigs:
 		m.StopService()
-		fmt.Printf("Received signal: %s. Exiting...\n", sig)
+		slog.Info("received signal, exiting", "signal", sig.String())
 	}
 
 	return nil
@@ -102,6 +174,8 @@ func (m *ServiceManager) StartService(ctx context.Context) error {
 
 type ServiceGo interface {
 	LoadConfig(key string) error
+	WatchConfig(ctx context.Context) (<-chan *ServiceConfig, error)
+	ApplyConfig(m *ServiceManager, cfg *ServiceConfig) error
 	SetContext(ctx context.Context)
 	ServiceStart(m *ServiceManager) error
 	ServiceQuit() error
@@ -117,7 +191,7 @@ func NewService(serviceInfo *ServiceInfo) (*Service, error) {
 	if serviceInfo.Port == 0 || serviceInfo.HttpPort == 0 || serviceInfo.Ip == "" {
 		ips, ports, err := FindAvailableEndpoint(1, 2)
 		if err != nil {
-			log.Fatal("FindAvailableEndpoint err:", err)
+			return nil, fmt.Errorf("find available endpoint: %w", err)
 		}
 		if serviceInfo.Port == 0 {
 			serviceInfo.Port = ports[0]
@@ -129,64 +203,364 @@ func NewService(serviceInfo *ServiceInfo) (*Service, error) {
 			serviceInfo.Ip = ips[0]
 		}
 	}
+	if serviceInfo.InstanceId == "" {
+		serviceInfo.InstanceId = serviceInfo.GrpcAddr()
+	}
+	if serviceInfo.Version == "" {
+		serviceInfo.Version = os.Getenv("SERVICE_VERSION")
+	}
 	service := &Service{
-		ServiceInfo: *serviceInfo,
-		context:     context.Background(),
+		ServiceInfo:    *serviceInfo,
+		Middlewares:    NewDefaultMiddlewareRegistry(seconds(DefaultRequestTimeout)),
+		Probes:         NewHealth(),
+		Kong:           k.NewClient(k.KongAdminURL),
+		context:        context.Background(),
+		shutdownConfig: DefaultShutdownConfig(),
+		requestTimeout: DefaultRequestTimeout,
+	}
+	// 日志带上服务名和实例ID，便于按实例检索
+	if err := logging.Setup(logging.Options{Service: serviceInfo.Name, Instance: serviceInfo.InstanceId}); err != nil {
+		return nil, err
 	}
 	return service, nil
 }
+
+// SetConfigCenter 使用指定的配置中心，未设置时LoadConfig连接默认的etcd
+func (s *Service) SetConfigCenter(cc *ConfigCenter) {
+	s.configCenter = cc
+}
+
+// LoadConfig 从配置中心加载配置，配置不存在时将当前配置写入配置中心
+// 配置key默认被同一服务的所有副本共享，地址、端口和实例ID始终使用本实例的值
 func (s *Service) LoadConfig(key string) error {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if key == "" {
+		key = ConfigKey(s.ServiceInfo.Name, "default")
+	}
+	if s.configCenter == nil {
+		cc, err := NewConfigCenter(endpoints, s.etcdOptions()...)
+		if err != nil {
+			return err
+		}
+		s.configCenter = cc
+	}
+	cfg, err := s.configCenter.Get(s.context, key)
+	if errors.Is(err, ErrConfigNotFound) {
+		cfg = s.runningConfig()
+		// 写入共享配置时不包含本实例的身份
+		cfg.Info.Ip, cfg.Info.Port, cfg.Info.HttpPort, cfg.Info.InstanceId = "", 0, 0, ""
+		cfg.Revision = 0
+		cfg.Revision, err = s.configCenter.Put(s.context, key, cfg)
+		if errors.Is(err, ErrConfigConflict) {
+			// 其他副本同时写入了初始配置，使用它写入的配置
+			cfg, err = s.configCenter.Get(s.context, key)
+		} else if err == nil {
+			slog.Info("config not found, initialized with current config", "key", key)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.configKey = key
+	s.fillDefaults(cfg)
+	s.Middlewares.SetEnabled(cfg.Middlewares)
+	if cfg.RequestTimeout != s.requestTimeout {
+		s.Middlewares.Register(DeadlineMiddleware(seconds(cfg.RequestTimeout)))
+	}
+	if cfg.Log.Level != "" {
+		if err := logging.SetLevel(cfg.Log.Level); err != nil {
+			return err
+		}
+	}
+	if cfg.Tracing != s.tracingConfig {
+		if err := s.SetupTracing(cfg.Tracing); err != nil {
+			return err
+		}
+	}
+	if cfg.TLS != s.tlsSettings {
+		if err := s.SetTLS(cfg.TLS); err != nil {
+			return err
+		}
+	}
+	reopenDB := s.GormDB != nil && cfg.Database != s.dbConfig
+	s.setConfig(cfg)
+	if reopenDB {
+		return s.GormMigrate(cfg.Database.DSN, s.gormModels...)
+	}
+	return nil
+}
+
+// WatchConfig 监听LoadConfig加载的配置
+func (s *Service) WatchConfig(ctx context.Context) (<-chan *ServiceConfig, error) {
+	if s.configCenter == nil || s.config == nil {
+		return nil, errors.New("config not loaded, call LoadConfig first")
+	}
+	return s.configCenter.Watch(ctx, s.configKey, s.config.Revision+1), nil
+}
+
+// runningConfig 返回当前运行中的配置
+func (s *Service) runningConfig() *ServiceConfig {
+	s.infoLock.RLock()
+	defer s.infoLock.RUnlock()
+	cfg := &ServiceConfig{
+		Info:           s.ServiceInfo,
+		Database:       s.dbConfig,
+		Tracing:        s.tracingConfig,
+		Shutdown:       s.shutdownConfig,
+		TLS:            s.tlsSettings,
+		RequestTimeout: s.requestTimeout,
+	}
+	if s.config != nil {
+		cfg.Middlewares = s.config.Middlewares
+		cfg.Log = s.config.Log
+		cfg.Revision = s.config.Revision
+		cfg.Version = s.config.Version
+	}
+	return cfg
+}
+
+// fillDefaults 地址、端口、实例ID和插件使用本实例的值，配置中未指定的其他字段沿用当前值
+// 共享配置中的地址属于写入它的副本，使用它会让其他副本冒用该副本的身份
+func (s *Service) fillDefaults(cfg *ServiceConfig) {
+	info := s.Info()
+	cfg.Info.Ip, cfg.Info.Port, cfg.Info.HttpPort, cfg.Info.InstanceId = info.Ip, info.Port, info.HttpPort, info.InstanceId
+	// 插件在代码中声明，不保存在配置中心
+	cfg.Info.Plugins = info.Plugins
+	if cfg.Info.Id == "" {
+		cfg.Info.Id = info.Id
+	}
+	if cfg.Database.DSN == "" {
+		cfg.Database.DSN = s.dbConfig.DSN
+	}
+	if cfg.TLS == (TLSSettings{}) {
+		cfg.TLS = s.tlsSettings
+	}
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = s.requestTimeout
+	}
+	// 未配置退出参数时沿用当前值；超时为0没有意义，同样沿用当前值
+	if cfg.Shutdown == (ShutdownConfig{}) {
+		cfg.Shutdown = s.shutdownConfig
+	}
+	if cfg.Shutdown.GatewayTimeout == 0 {
+		cfg.Shutdown.GatewayTimeout = s.shutdownConfig.GatewayTimeout
+	}
+	if cfg.Shutdown.GrpcTimeout == 0 {
+		cfg.Shutdown.GrpcTimeout = s.shutdownConfig.GrpcTimeout
+	}
+	if cfg.Shutdown.HookTimeout == 0 {
+		cfg.Shutdown.HookTimeout = s.shutdownConfig.HookTimeout
+	}
+}
+
+// ApplyConfig 热更新配置，仅重启发生变化的组件，失败时恢复到原来的配置
+func (s *Service) ApplyConfig(m *ServiceManager, cfg *ServiceConfig) error {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	old := s.runningConfig()
+	s.fillDefaults(cfg)
+	err := s.applyConfig(m, old, cfg)
+	if err == nil {
+		return nil
+	}
+	if rerr := s.applyConfig(m, cfg, old); rerr != nil {
+		slog.Error("failed to restore config", "revision", old.Revision, "error", rerr)
+	}
+	return err
+}
+
+func (s *Service) applyConfig(m *ServiceManager, old, cfg *ServiceConfig) error {
+	change := DiffConfig(old, cfg)
+	slog.Info("applying config", "revision", cfg.Revision, "changed", change.String())
+	s.setConfig(cfg)
+	if old.TLS != cfg.TLS {
+		if err := s.SetTLS(cfg.TLS); err != nil {
+			return err
+		}
+	}
+	if change.Has(ChangeGrpc) {
+		if s.grpcServer != nil {
+			// 长连接的流（如健康检查的Watch）不会主动结束，超时后强制关闭
+			s.stopGrpc(seconds(s.shutdownConfig.GrpcTimeout))
+		}
+		listener, grpcServer, err := m.ServiceGo.StartGrpcService()
+		if err != nil {
+			return fmt.Errorf("failed to restart grpc service: %w", err)
+		}
+		s.listener, s.grpcServer = listener, grpcServer
+	}
+	if change.Has(ChangeGateway) {
+		if err := s.stopGateway(seconds(s.shutdownConfig.GatewayTimeout)); err != nil {
+			return err
+		}
+		clientConn, err := m.ServiceGo.StartGrpcGatewayService()
+		if err != nil {
+			return fmt.Errorf("failed to restart gateway: %w", err)
+		}
+		s.grpcClientConn = clientConn
+	}
+	if change.Has(ChangeKong) {
+		oldTarget := old.Info.HttpAddr()
+		if oldTarget != cfg.Info.HttpAddr() {
+			if _, err := s.Kong.UpsertTarget(s.context, old.Info.Name, &k.Target{Target: oldTarget, Weight: 0}); err != nil {
+				return err
+			}
+		}
+		createOnly := s.KongCreateOnly
+		s.KongCreateOnly = false
+		err := m.ServiceGo.ServiceRegisterToKong()
+		s.KongCreateOnly = createOnly
+		if err != nil {
+			return err
+		}
+	}
+	if change.Has(ChangeEtcd) && s.client != nil {
+		if err := s.updateRegistration(s.context); err != nil {
+			return err
+		}
+	}
+	if change.Has(ChangeMiddleware) {
+		// 中间件在每次请求时读取开关，无需重启gRPC服务；替换同名中间件时保留原来的开关
+		s.Middlewares.SetEnabled(cfg.Middlewares)
+		if old.RequestTimeout != cfg.RequestTimeout {
+			s.Middlewares.Register(DeadlineMiddleware(seconds(cfg.RequestTimeout)))
+		}
+	}
+	if change.Has(ChangeTracing) {
+		if err := s.SetupTracing(cfg.Tracing); err != nil {
+			return err
+		}
+	}
+	if change.Has(ChangeLog) && cfg.Log.Level != "" {
+		if err := logging.SetLevel(cfg.Log.Level); err != nil {
+			return err
+		}
+	}
+	if change.Has(ChangeDatabase) && s.GormDB != nil {
+		if err := s.GormMigrate(cfg.Database.DSN, s.gormModels...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GatewayHandler 在网关的http服务上挂载 /metrics 和健康检查探针
+func (s *Service) GatewayHandler(gwmux http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(LivenessPath, s.Probes.LivenessHandler())
+	mux.Handle(ReadinessPath, s.Probes.ReadinessHandler())
+	mux.Handle("/", GatewayHandler(gwmux))
+	return mux
+}
+
+// SetGatewayServer 记录网关使用的http服务，便于热更新和退出时关闭
+func (s *Service) SetGatewayServer(srv *http.Server) {
+	s.gwServer = srv
+}
+
+func (s *Service) stopGateway(timeout time.Duration) error {
+	if s.gwServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := s.gwServer.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shutdown gateway: %w", err)
+		}
+		s.gwServer = nil
+	}
+	if s.grpcClientConn != nil {
+		return s.grpcClientConn.Close()
+	}
 	return nil
 }
 func (s *Service) SetContext(ctx context.Context) {
 	s.context = ctx
 }
 func (s *Service) ServiceStart(m *ServiceManager) error {
-	fmt.Println(s.ServiceInfo)
+	slog.Info("starting service", "name", s.ServiceInfo.Name, "ip", s.ServiceInfo.Ip, "port", s.ServiceInfo.Port, "http_port", s.ServiceInfo.HttpPort)
 	listener, grpcserver, err := m.ServiceGo.StartGrpcService()
 	s.grpcServer = grpcserver
 	s.listener = listener
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("start grpc service: %w", err)
 	}
 	clientConn, err := m.ServiceGo.StartGrpcGatewayService()
 	s.grpcClientConn = clientConn
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("start grpc gateway: %w", err)
 	}
-
-	if err != nil {
-		log.Fatalln("Failed to load swagger spec:", err)
+	if err := m.ServiceGo.ServiceRegisterToEtcd(); err != nil {
+		return fmt.Errorf("register to etcd: %w", err)
 	}
-
-	err = m.ServiceGo.ServiceRegisterToEtcd()
-	if err != nil {
-		log.Panic(err)
-	}
-	err = m.ServiceGo.ServiceRegisterToKong()
-	if err != nil {
-		log.Panic(err)
+	if err := m.ServiceGo.ServiceRegisterToKong(); err != nil {
+		return fmt.Errorf("register to kong: %w", err)
 	}
+	go s.Probes.Watch(s.context, 5*time.Second)
 	return nil
 }
+
+// ServiceQuit 按顺序退出：标记未就绪、注销、等待传播、关闭网关和gRPC、停止消费者、关闭存储
 func (s *Service) ServiceQuit() error {
+	cfg := s.shutdownConfig
+	// 先标记为未就绪，Kong和gRPC客户端停止发送新请求后再关闭监听
+	s.Probes.Shutdown()
+	// 注销失败不影响后续资源的释放
 	if err := s.UnregisterKong(); err != nil {
-		log.Fatalln("error in unregister Kong", err)
+		slog.Error("failed to unregister from kong", "error", err)
 	}
-	if err := s.Revoke(context.Background()); err != nil {
-		log.Fatalln("error in unregister etcd", err)
+	if s.client != nil {
+		if err := s.Revoke(context.Background()); err != nil {
+			slog.Error("failed to unregister from etcd", "error", err)
+		}
+	}
+	errs := []error{s.runHooks(ShutdownDeregister)}
+	// 等待Kong同步target权重、客户端的服务发现删除本实例
+	if cfg.PropagationDelay > 0 {
+		slog.Info("waiting for deregistration to propagate", "delay", seconds(cfg.PropagationDelay))
+		time.Sleep(seconds(cfg.PropagationDelay))
+	}
+	if err := s.stopGateway(seconds(cfg.GatewayTimeout)); err != nil { // 关闭网关和 gRPC 客户端连接
+		slog.Error("failed to stop gateway", "error", err)
+		errs = append(errs, err)
+	}
+	if s.grpcServer != nil {
+		s.stopGrpc(seconds(cfg.GrpcTimeout)) // 关闭 gRPC 服务器和网络监听器
+	}
+	errs = append(errs, s.runHooks(ShutdownConsumers), s.runHooks(ShutdownStorage))
+	if s.GormDB != nil {
+		if sqlDB, err := s.GormDB.DB(); err == nil {
+			sqlDB.Close() // 关闭数据库连接池
+		}
+	}
+	if s.client != nil {
+		s.client.Close() // 关闭 etcd 客户端
+	}
+	if s.configCenter != nil {
+		s.configCenter.Close() // 关闭配置中心
+	}
+	s.shutdownTracing() // 导出剩余的span
+	if s.stopCertWatch != nil {
+		s.stopCertWatch() // 停止监听证书文件
 	}
-	s.grpcServer.GracefulStop() // 关闭 gRPC 服务器
-	s.listener.Close()          // 关闭网络监听器
-	s.grpcClientConn.Close()    // 关闭 gRPC 客户端连接
-	s.client.Close()            // 关闭 etcd 客户端
-
-	log.Println("service quit safely")
 
+	if err := errors.Join(errs...); err != nil {
+		slog.Warn("service quit with errors", "error", err)
+		return err
+	}
+	slog.Info("service quit safely")
 	return nil
 }
 func (s *Service) GormMigrate(dsn string, models ...interface{}) error {
 	// 默认 DSN
+	if dsn == "" {
+		dsn = s.dbConfig.DSN
+	}
 	if dsn == "" {
 		dsn = "root:root@tcp(127.0.0.1:3306)/msmall?charset=utf8mb4&parseTime=True&loc=Local"
 	}
@@ -201,186 +575,221 @@ func (s *Service) GormMigrate(dsn string, models ...interface{}) error {
 	// 连接到 MySQL Server（不包括数据库名）
 	serverDB, err := gorm.Open(mysql.Open(dsnWithoutDB), &gorm.Config{})
 	if err != nil {
-		fmt.Printf("Failed to connect to database server: %v\n", err)
-		return err
+		return fmt.Errorf("connect to database server: %w", err)
 	}
 
 	// 检查数据库是否存在，如果不存在则创建
 	createDBQuery := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s` CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;", dbName)
 	if err := serverDB.Exec(createDBQuery).Error; err != nil {
-		fmt.Printf("Failed to create database: %v\n", err)
-		return err
+		return fmt.Errorf("create database %s: %w", dbName, err)
 	}
 
 	// 重新连接到目标数据库
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("connect to database %s: %w", dbName, err)
+	}
+	// 与storage中的连接一样统计SQL耗时并生成span
+	if err := storage.RegisterTracing(db); err != nil {
+		return fmt.Errorf("register tracing callbacks: %w", err)
+	}
+	if err := storage.RegisterMetrics(db); err != nil {
+		return fmt.Errorf("register metrics callbacks: %w", err)
+	}
 	sqlDB, _ := db.DB()
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetMaxIdleConns(50)
 	sqlDB.SetConnMaxLifetime(10 * time.Minute)
-	if err != nil {
-		fmt.Printf("Failed to connect to database: %v\n", err)
-		return err
+	if s.dbConfig.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(s.dbConfig.MaxOpenConns)
+	}
+	if s.dbConfig.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(s.dbConfig.MaxIdleConns)
+	}
+	if s.dbConfig.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(time.Duration(s.dbConfig.ConnMaxLifetime) * time.Second)
 	}
 
 	// 自动迁移模型
 	for _, model := range models {
 		if err := db.AutoMigrate(model); err != nil {
-			fmt.Printf("Failed to auto migrate: %v\n", err)
+			slog.Error("failed to auto migrate", "model", fmt.Sprintf("%T", model), "error", err)
 		}
 	}
 
-	// 保存 DB 实例到 Service
+	// 保存 DB 实例到 Service，关闭旧的连接池
+	if s.GormDB != nil {
+		if oldDB, err := s.GormDB.DB(); err == nil {
+			oldDB.Close()
+		}
+	}
 	s.GormDB = db
+	s.gormModels = models
+	s.Probes.AddCheck("database", sqlDB.PingContext)
+	s.dbConfig.DSN = dsn
 	return nil
 }
 
-func (s *Service) StartGrpcService() (net.Listener, *grpc.Server, error) {
-	return nil, nil, errors.New("must implement StartGrpcService")
-}
-func (s *Service) StartGrpcGatewayService() (*grpc.ClientConn, error) {
-	return nil, errors.New("must implement StartGrpcGatewayService")
+// NewGrpcServer 创建挂载了中间件链的gRPC服务器
+func (s *Service) NewGrpcServer(opts ...grpc.ServerOption) *grpc.Server {
+	if s.grpcCerts != nil {
+		opts = append([]grpc.ServerOption{grpc.Creds(credentials.NewTLS(s.grpcCerts.ServerTLS()))}, opts...)
+	}
+	return grpc.NewServer(append(s.Middlewares.ServerOptions(), opts...)...)
 }
 
-// ServiceRegister 注册服务到etcd
-func (s *Service) ServiceRegisterToEtcd() error {
-	// 注册服务到服务注册中心
-	err := RegisterService(s, endpoints)
-
+// ServeGrpc 在ServiceInfo指定的地址上启动gRPC服务，register用于注册具体的服务实现
+func (s *Service) ServeGrpc(register func(server *grpc.Server), opts ...grpc.ServerOption) (net.Listener, *grpc.Server, error) {
+	lis, err := net.Listen("tcp", s.ServiceInfo.GrpcAddr())
 	if err != nil {
-		log.Fatal(err)
-		return err
+		return nil, nil, err
 	}
-	go s.StartCheckAlive(s.context)
 
-	log.Println("Service registered successfully")
+	grpcServer := s.NewGrpcServer(opts...)
+	register(grpcServer)
+	s.Probes.Register(grpcServer)
+	s.listener, s.grpcServer = lis, grpcServer
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			slog.Warn("grpc server stopped", "error", err)
+		}
+	}()
 
-	return nil
+	slog.Info("gRPC server is running", "addr", lis.Addr().String())
+	return lis, grpcServer, nil
 }
 
-// ServiceKong 注册服务到kong
-func (s *Service) ServiceRegisterToKong() error {
-
-	// 创建 Upstream
-	upstreamExists, err := k.UpstreamExists(s.ServiceInfo.Name)
+// ServeGateway 连接本服务的gRPC端口，在HttpPort上启动网关，register用于注册HTTP转发
+func (s *Service) ServeGateway(register GatewayRegister, opts ...runtime.ServeMuxOption) (*grpc.ClientConn, error) {
+	conn, err := grpc.Dial(s.ServiceInfo.GrpcAddr(), append(GatewayDialOptions(), s.ClientCredentials())...)
 	if err != nil {
-		log.Fatalf("Error checking upstream: %v", err)
-		return err
+		return nil, fmt.Errorf("failed to dial server: %w", err)
 	}
-	if upstreamExists && s.UpdateOnStart {
-		log.Println("Upstream already exists, updating ...")
-
-	} else {
-		log.Println("Upstream does not exist, creating...")
-		if err := k.CreateUpstream(s.ServiceInfo.Name); err != nil {
-			log.Fatalf("Error creating upstream: %v", err)
-			return err
-		}
+	gwmux := NewGatewayMux(opts...)
+	if err := register(context.Background(), gwmux, conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to register gateway: %w", err)
 	}
-	if s.ServiceInfo.HealthPath != "" {
-
-		healthChecks := k.HealthChecks{
-			Active: k.ActiveHealthCheck{
-				HTTPPath: s.ServiceInfo.HealthPath,
-				Type:     "http",
-				Healthy: k.HealthyStatus{
-					HTTPStatuses: []int{200, 201},
-					Interval:     5,
-				},
-				Unhealthy: k.HealthyStatus{
-					HTTPStatuses: []int{500, 503},
-					Interval:     3,
-				},
-			},
-			Passive: k.PassiveHealthCheck{
-				Healthy: k.HealthyStatus{
-					HTTPStatuses: []int{200, 201},
-				},
-				Unhealthy: k.HealthyStatus{
-					HTTPStatuses: []int{500, 503},
-				},
-			},
-		}
-
-		// 注册健康检查
-		err = k.UpdateHealthChecks(s.ServiceInfo.Name, healthChecks)
-		if err != nil {
-			log.Fatalf("Error updating health checks: %v", err)
-			return err
+	// 先监听端口，端口被占用时直接返回错误
+	lis, err := net.Listen("tcp", s.ServiceInfo.HttpAddr())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	scheme := "http"
+	if s.gatewayCerts != nil {
+		lis, scheme = tls.NewListener(lis, s.gatewayCerts.ServerTLS()), "https"
+	}
+	gwServer := &http.Server{Handler: s.GatewayHandler(gwmux)}
+	s.SetGatewayServer(gwServer)
+	go func() {
+		if err := gwServer.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("gateway server stopped", "error", err)
 		}
-	}
+	}()
 
-	// 创建 Target
-	targetExists, err := k.TargetExists(s.ServiceInfo.Name, s.ServiceInfo.Ip+":"+strconv.Itoa(s.ServiceInfo.HttpPort))
-	if err != nil {
-		log.Fatalf("Error checking target: %v", err)
-		return err
+	slog.Info("gRPC-Gateway is running", "addr", scheme+"://"+lis.Addr().String())
+	return conn, nil
+}
+
+// GrpcRegister 在gRPC服务器上注册服务实现
+type GrpcRegister func(server *grpc.Server)
+
+// GatewayRegister 在网关上注册HTTP转发，与生成的 pb.RegisterXxxHandler 签名一致
+type GatewayRegister func(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error
+
+// Register 设置服务的注册函数，StartGrpcService和StartGrpcGatewayService据此启动服务
+// gateway为nil时不启动网关；GatewayOptions用于覆盖网关的默认选项
+func (s *Service) Register(grpcRegister GrpcRegister, gatewayRegister GatewayRegister) {
+	s.grpcRegister = grpcRegister
+	s.gatewayRegister = gatewayRegister
+}
+
+func (s *Service) StartGrpcService() (net.Listener, *grpc.Server, error) {
+	if s.grpcRegister == nil {
+		return nil, nil, errors.New("grpc service not registered, call Register first")
 	}
-	if !targetExists {
-		log.Println("Target does not exist, adding...")
-		if err := k.AddTargetToUpstream(s.ServiceInfo.Name, s.ServiceInfo.Ip+":"+strconv.Itoa(s.ServiceInfo.HttpPort), s.ServiceInfo.Weight); err != nil {
-			log.Fatalf("Error adding target: %v", err)
-			return err
-		}
-	} else {
-		if s.UpdateOnStart {
-			log.Println("Target already exists, updating ...")
-			k.UpdateTargetInUpstream(s.ServiceInfo.Name, s.ServiceInfo.Ip+":"+strconv.Itoa(s.ServiceInfo.HttpPort), s.ServiceInfo.Weight)
-		}
+	return s.ServeGrpc(s.grpcRegister)
+}
+func (s *Service) StartGrpcGatewayService() (*grpc.ClientConn, error) {
+	if s.gatewayRegister == nil {
+		return nil, nil
 	}
+	return s.ServeGateway(s.gatewayRegister, s.GatewayOptions...)
+}
 
-	// 创建 Service
-	serviceExists, err := k.ServiceExists(s.ServiceInfo.Name)
-	if err != nil {
-		log.Fatalf("Error checking service: %v", err)
-		return err
+// ServiceRegister 注册服务到etcd
+func (s *Service) ServiceRegisterToEtcd() error {
+	// 注册服务到服务注册中心
+	if err := RegisterService(s, endpoints); err != nil {
+		return fmt.Errorf("register service %s: %w", s.ServiceInfo.Name, err)
 	}
+	go s.StartCheckAlive(s.context)
 
-	if serviceExists && s.UpdateOnStart {
-		log.Println("Service already exists, updating...")
+	slog.Info("service registered to etcd", "name", s.ServiceInfo.Name)
 
-		sid, err := k.GetServiceID(s.ServiceInfo.Name)
-		if err != nil {
-			log.Fatalf("Error getting service ID: %v", err)
-			return err
-		}
-		s.ServiceInfo.Id = sid
-	} else {
-		log.Println("Service does not exist, creating...")
-		sid, err := k.CreateService(s.ServiceInfo.Name, s.ServiceInfo.Name, s.ServiceInfo.Protocol, s.ServiceInfo.ServicePath)
-		if err != nil {
-			log.Fatalf("Error creating service: %v", err)
-			return err
+	return nil
+}
+
+// DesiredKongState 返回当前实例在Kong中的期望状态
+func (s *Service) DesiredKongState() *k.DesiredState {
+	info := s.Info()
+	if info.Protocol == "" && s.gatewayCerts != nil {
+		info.Protocol = "https"
+	}
+	desired := &k.DesiredState{
+		Upstream: k.Upstream{Name: info.Name},
+		Targets:  []k.Target{{Target: info.HttpAddr(), Weight: info.Weight}},
+		Service: k.Service{
+			Name:     info.Name,
+			Host:     info.Name, // 指向同名的Upstream
+			Protocol: info.Protocol,
+			Path:     info.ServicePath,
+		},
+		Routes:  []k.Route{{Name: info.RoutesName, Paths: info.Paths}},
+		Plugins: info.Plugins,
+	}
+	switch {
+	case info.HealthChecks != nil:
+		desired.Upstream.HealthChecks = info.HealthChecks
+	case info.HealthPath != "":
+		checks := k.DefaultHealthChecks(info.HealthPath)
+		if info.Protocol == "https" {
+			checks.Active.Type = "https"
 		}
-		s.ServiceInfo.Id = sid
+		desired.Upstream.HealthChecks = &checks
 	}
+	return desired
+}
 
-	// 创建 Route
-	routeExists, err := k.RouteExists(s.ServiceInfo.RoutesName)
+// ServiceKong 将Kong同步到期望状态，KongCreateOnly为true时只创建缺少的实体
+func (s *Service) ServiceRegisterToKong() error {
+	reconciler := k.NewReconciler(s.Kong)
+	reconciler.CreateOnly = s.KongCreateOnly
+	plan, err := reconciler.Reconcile(s.context, s.DesiredKongState())
 	if err != nil {
-		log.Fatalf("Error checking route: %v", err)
-		return err
+		return fmt.Errorf("reconcile kong for %s: %w", s.ServiceInfo.Name, err)
 	}
-	if routeExists && s.UpdateOnStart {
-		log.Println("Route already exists, updating...")
-
-	} else {
-		log.Println("Route does not exist, creating...")
-		if err := k.CreateRoute(s.ServiceInfo.RoutesName, s.ServiceInfo.Id, s.ServiceInfo.Paths); err != nil {
-			log.Fatalf("Error creating route: %v", err)
-			return err
-		}
+	for _, op := range plan.Operations {
+		slog.Info("kong reconciled", "action", string(op.Action), "kind", op.Kind, "name", op.Name, "fields", op.Fields)
 	}
-
-	fmt.Println("Service started successfully!")
+	service, err := s.Kong.GetService(s.context, s.ServiceInfo.Name)
+	if err != nil {
+		return fmt.Errorf("get kong service %s: %w", s.ServiceInfo.Name, err)
+	}
+	s.infoLock.Lock()
+	s.ServiceInfo.Id = service.ID
+	s.infoLock.Unlock()
+	slog.Info("service registered to kong", "route", s.ServiceInfo.RoutesName)
 	return nil
 }
 
+// UnregisterKong 将当前实例的target权重置为0，Kong不再转发流量
 func (s *Service) UnregisterKong() error {
-	err := k.UpdateTargetInUpstream(s.ServiceInfo.Name, s.ServiceInfo.Ip+":"+strconv.Itoa(s.ServiceInfo.HttpPort), 0)
-	if err != nil {
-		log.Println("Error updating target: %v", err)
-		return err
+	info := s.Info()
+	target := &k.Target{Target: info.HttpAddr(), Weight: 0}
+	if _, err := s.Kong.UpsertTarget(s.context, info.Name, target); err != nil {
+		return fmt.Errorf("disable target %s: %w", target.Target, err)
 	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ShutdownPhase 退出钩子执行的阶段
+type ShutdownPhase int
+
+const (
+	ShutdownDeregister ShutdownPhase = iota // 从Kong和etcd注销之后，如停止定时任务、取消秒杀路由
+	ShutdownConsumers                       // gRPC和网关停止之后，停止MQ消费者并确认处理中的消息
+	ShutdownStorage                         // 消费者停止之后，刷新缓存、关闭连接池
+)
+
+func (p ShutdownPhase) String() string {
+	switch p {
+	case ShutdownDeregister:
+		return "deregister"
+	case ShutdownConsumers:
+		return "consumers"
+	case ShutdownStorage:
+		return "storage"
+	}
+	return fmt.Sprintf("phase(%d)", int(p))
+}
+
+// ShutdownConfig 退出时各步骤的等待时间（秒）
+type ShutdownConfig struct {
+	PropagationDelay int `json:"propagation_delay"` // 注销后等待Kong和客户端感知的时间
+	GatewayTimeout   int `json:"gateway_timeout"`   // 网关http服务等待请求结束的时间
+	GrpcTimeout      int `json:"grpc_timeout"`      // GracefulStop的期限，超时后强制Stop
+	HookTimeout      int `json:"hook_timeout"`      // 每个退出钩子的期限
+}
+
+// DefaultShutdownConfig Kong默认每5秒同步一次配置
+func DefaultShutdownConfig() ShutdownConfig {
+	return ShutdownConfig{
+		PropagationDelay: 5,
+		GatewayTimeout:   10,
+		GrpcTimeout:      10,
+		HookTimeout:      10,
+	}
+}
+
+type shutdownHook struct {
+	phase ShutdownPhase
+	name  string
+	fn    func(ctx context.Context) error
+}
+
+// OnShutdown 注册退出钩子，同一阶段的钩子按注册的相反顺序执行
+// 需要在ServiceStart之前注册
+func (s *Service) OnShutdown(phase ShutdownPhase, name string, fn func(ctx context.Context) error) {
+	s.shutdownHooks = append(s.shutdownHooks, shutdownHook{phase: phase, name: name, fn: fn})
+}
+
+// SetShutdownConfig 设置退出时的等待时间
+func (s *Service) SetShutdownConfig(cfg ShutdownConfig) {
+	s.shutdownConfig = cfg
+}
+
+// runHooks 执行phase阶段的钩子，失败不影响后续钩子
+func (s *Service) runHooks(phase ShutdownPhase) error {
+	var errs []error
+	for i := len(s.shutdownHooks) - 1; i >= 0; i-- {
+		hook := s.shutdownHooks[i]
+		if hook.phase != phase {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), seconds(s.shutdownConfig.HookTimeout))
+		err := hook.fn(ctx)
+		cancel()
+		if err != nil {
+			slog.Error("shutdown hook failed", "phase", phase.String(), "hook", hook.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s hook %s: %w", phase, hook.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopGrpc 等待进行中的调用结束，超过timeout后强制关闭所有连接
+func (s *Service) stopGrpc(timeout time.Duration) {
+	done := make(chan struct{})
+	server := s.grpcServer
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("grpc graceful stop timed out, forcing stop", "timeout", timeout)
+		server.Stop()
+		<-done
+	}
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	k "kongApi"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// targetDrift Kong与etcd不一致的target数量
+var targetDrift = NewCounter("kong_target_drift_total", "Total number of kong targets found out of sync with etcd.", "upstream", "kind")
+
+// ErrDeleteRefused 删除保护生效，没有删除残留的target
+var ErrDeleteRefused = errors.New("refused to delete kong targets")
+
+// TargetDrift 一个upstream中Kong与etcd不一致的target
+type TargetDrift struct {
+	Upstream string
+	Missing  []string // 实例已注册到etcd，Kong中缺少target
+	Stale    []string // 实例的租约已过期，Kong中残留的target
+	Weight   []string // 权重与etcd中的注册信息不一致
+}
+
+func (d TargetDrift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Stale) == 0 && len(d.Weight) == 0
+}
+
+// TargetController 根据etcd中的服务注册信息持续同步Kong的upstream target
+// 崩溃的实例无法调用UnregisterKong，租约过期后由控制器删除它的target
+type TargetController struct {
+	discovery      *ServiceDiscovery
+	kong           *k.Client
+	Interval       time.Duration // 全量对账的周期
+	DryRun         bool          // 只报告差异，不修改Kong
+	MaxDeleteRatio float64       // 一次对账最多删除的target比例，超过时不删除；不会删除upstream的全部target
+
+	lock  sync.Mutex
+	known map[string]bool // 出现过的服务，所有实例下线后仍需对账
+}
+
+func NewTargetController(discovery *ServiceDiscovery, kong *k.Client) *TargetController {
+	return &TargetController{
+		discovery:      discovery,
+		kong:           kong,
+		Interval:       30 * time.Second,
+		MaxDeleteRatio: 0.5,
+		known:          make(map[string]bool),
+	}
+}
+
+// Run 实例上下线时同步对应的upstream，并定期全量对账，直到ctx结束
+func (c *TargetController) Run(ctx context.Context) error {
+	events, cancel := c.discovery.Subscribe("")
+	defer cancel()
+	if _, err := c.ReconcileAll(ctx); err != nil {
+		slog.Warn("kong target reconcile failed", "error", err)
+	}
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				return fmt.Errorf("service discovery closed")
+			}
+			if _, err := c.Reconcile(ctx, event.Service.Name); err != nil {
+				slog.Warn("kong target reconcile failed", "upstream", event.Service.Name, "error", err)
+			}
+		case <-ticker.C:
+			if _, err := c.ReconcileAll(ctx); err != nil {
+				slog.Warn("kong target reconcile failed", "error", err)
+			}
+		}
+	}
+}
+
+// ReconcileAll 同步所有已知服务的upstream，返回存在差异的upstream
+func (c *TargetController) ReconcileAll(ctx context.Context) ([]TargetDrift, error) {
+	c.lock.Lock()
+	for _, info := range c.discovery.GetServices() {
+		c.known[info.Name] = true
+	}
+	names := make([]string, 0, len(c.known))
+	for name := range c.known {
+		names = append(names, name)
+	}
+	c.lock.Unlock()
+	sort.Strings(names)
+
+	var drifts []TargetDrift
+	var firstErr error
+	for _, name := range names {
+		drift, err := c.Reconcile(ctx, name)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if !drift.Empty() {
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts, firstErr
+}
+
+// Reconcile 比较etcd中的实例和Kong中的target：补充缺少的target、删除租约过期的target、修正权重
+func (c *TargetController) Reconcile(ctx context.Context, name string) (TargetDrift, error) {
+	drift := TargetDrift{Upstream: name}
+	c.lock.Lock()
+	c.known[name] = true
+	c.lock.Unlock()
+
+	desired := make(map[string]int)
+	for _, info := range c.discovery.GetService(name) {
+		desired[info.HttpAddr()] = info.Weight
+	}
+	targets, err := c.kong.ListTargets(ctx, name)
+	if k.IsNotFound(err) {
+		// upstream由实例注册时创建，这里不创建
+		return drift, nil
+	}
+	if err != nil {
+		return drift, fmt.Errorf("list targets of %s: %w", name, err)
+	}
+	actual := make(map[string]int, len(targets))
+	for _, t := range targets {
+		actual[t.Target] = t.Weight
+	}
+	for target, weight := range desired {
+		w, ok := actual[target]
+		if !ok {
+			drift.Missing = append(drift.Missing, target)
+		} else if w != weight {
+			drift.Weight = append(drift.Weight, target)
+		}
+	}
+	for target := range actual {
+		if _, ok := desired[target]; !ok {
+			drift.Stale = append(drift.Stale, target)
+		}
+	}
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Stale)
+	sort.Strings(drift.Weight)
+	if drift.Empty() {
+		return drift, nil
+	}
+
+	targetDrift.WithLabelValues(name, "missing").Add(float64(len(drift.Missing)))
+	targetDrift.WithLabelValues(name, "stale").Add(float64(len(drift.Stale)))
+	targetDrift.WithLabelValues(name, "weight").Add(float64(len(drift.Weight)))
+	slog.Warn("kong targets drifted from etcd", "upstream", name, "missing", drift.Missing, "stale", drift.Stale, "weight", drift.Weight, "dry_run", c.DryRun)
+	if c.DryRun {
+		return drift, nil
+	}
+
+	for _, target := range append(drift.Missing, drift.Weight...) {
+		if _, err := c.kong.UpsertTarget(ctx, name, &k.Target{Target: target, Weight: desired[target]}); err != nil {
+			return drift, fmt.Errorf("upsert target %s of %s: %w", target, name, err)
+		}
+	}
+	if len(drift.Stale) == 0 {
+		return drift, nil
+	}
+	if err := c.deleteGuard(len(drift.Stale), len(actual)); err != nil {
+		return drift, fmt.Errorf("delete stale targets of %s: %w", name, err)
+	}
+	for _, target := range drift.Stale {
+		if err := c.kong.DeleteTarget(ctx, name, target); err != nil && !k.IsNotFound(err) {
+			return drift, fmt.Errorf("delete target %s of %s: %w", target, name, err)
+		}
+	}
+	return drift, nil
+}
+
+// deleteGuard 服务发现重新同步期间服务列表可能不完整；全部或大量target同时失效通常是etcd或服务发现的故障，而不是实例下线
+func (c *TargetController) deleteGuard(stale, total int) error {
+	switch {
+	case c.discovery.Resyncing():
+		return fmt.Errorf("%w: service discovery is resyncing", ErrDeleteRefused)
+	case stale >= total:
+		return fmt.Errorf("%w: all %d targets are stale", ErrDeleteRefused, total)
+	case float64(stale) > c.MaxDeleteRatio*float64(total):
+		return fmt.Errorf("%w: %d of %d targets are stale, exceeds %.0f%%", ErrDeleteRefused, stale, total, c.MaxDeleteRatio*100)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig 证书文件配置，CertFile和CAFile都为空时不启用TLS
+type TLSConfig struct {
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	CAFile     string `json:"ca_file"`     // 校验对端证书的CA，为空时使用系统CA
+	ServerName string `json:"server_name"` // 作为客户端时校验的服务端名称，为空时使用连接地址
+	ClientAuth bool   `json:"client_auth"` // 作为服务端时要求并校验客户端证书（mTLS）
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.CAFile != ""
+}
+
+func (c TLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("tls: cert_file and key_file must be set together")
+	}
+	if c.ClientAuth && (c.CertFile == "" || c.CAFile == "") {
+		return errors.New("tls: client_auth requires cert_file and ca_file")
+	}
+	return nil
+}
+
+// TLSSettings 各连接的TLS配置，未配置的连接使用明文
+type TLSSettings struct {
+	Grpc    TLSConfig `json:"grpc"`    // gRPC服务端证书，网关回环连接和内部服务之间的调用也使用该证书
+	Gateway TLSConfig `json:"gateway"` // 网关的HTTPS证书
+	Etcd    TLSConfig `json:"etcd"`    // etcd客户端证书，修改后需要重启服务
+	Kong    TLSConfig `json:"kong"`    // Kong Admin API的客户端证书
+}
+
+func (s TLSSettings) Validate() error {
+	names := []string{"grpc", "gateway", "etcd", "kong"}
+	for i, c := range []TLSConfig{s.Grpc, s.Gateway, s.Etcd, s.Kong} {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("%s %w", names[i], err)
+		}
+	}
+	return nil
+}
+
+// CertReloader 从磁盘加载证书，文件修改后重新加载，已建立的连接不受影响
+type CertReloader struct {
+	cfg TLSConfig
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+	modTime map[string]time.Time
+}
+
+func NewCertReloader(cfg TLSConfig) (*CertReloader, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	r := &CertReloader{cfg: cfg}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 证书文件有变化时重新加载，加载失败时继续使用原来的证书
+func (r *CertReloader) Reload() (bool, error) {
+	modTime := make(map[string]time.Time, 3)
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("tls: %w", err)
+		}
+		modTime[file] = info.ModTime()
+	}
+	r.lock.RLock()
+	changed := !sameModTime(r.modTime, modTime)
+	r.lock.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("tls: load certificate: %w", err)
+		}
+		cert = &c
+	}
+	var roots *x509.CertPool
+	if r.cfg.CAFile != "" {
+		pem, err := os.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return false, fmt.Errorf("tls: read ca file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("tls: no certificate found in %s", r.cfg.CAFile)
+		}
+	}
+	r.lock.Lock()
+	r.cert, r.roots, r.modTime = cert, roots, modTime
+	r.lock.Unlock()
+	return true, nil
+}
+
+// Watch 每隔interval检查一次证书文件，直到ctx结束
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.Reload()
+		if err != nil {
+			slog.Warn("failed to reload certificate", "cert", r.cfg.CertFile, "error", err)
+		} else if changed {
+			slog.Info("certificate reloaded", "cert", r.cfg.CertFile, "ca", r.cfg.CAFile)
+		}
+	}
+}
+
+func (r *CertReloader) certificate() *tls.Certificate {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.cert == nil {
+		return &tls.Certificate{}
+	}
+	return r.cert
+}
+
+// ServerTLS 服务端配置，每次握手使用最新的证书和CA
+func (r *CertReloader) ServerTLS() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+	}
+	if r.cfg.ClientAuth {
+		// 由VerifyPeerCertificate使用最新的CA校验客户端证书
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return r.verify(raw, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+	return cfg
+}
+
+// ClientTLS 客户端配置，有证书时提供客户端证书，配置了CA时使用最新的CA校验服务端证书
+// 校验的服务端名称为ServerName，未配置时为握手的SNI；连接IP地址时没有SNI，需要配置ServerName或使用 ClientTLSFor
+func (r *CertReloader) ClientTLS() *tls.Config {
+	return r.clientTLS(r.cfg.ServerName, true)
+}
+
+// ClientTLSFor 连接addr（host或host:port）的客户端配置，未配置ServerName时按addr的主机名或IP校验服务端证书
+func (r *CertReloader) ClientTLSFor(addr string) *tls.Config {
+	name := r.cfg.ServerName
+	if name == "" {
+		name = addr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			name = host
+		}
+	}
+	return r.clientTLS(name, false)
+}
+
+// clientTLS name为空且fromSNI为true时按握手的SNI校验
+func (r *CertReloader) clientTLS(name string, fromSNI bool) *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: name}
+	if r.cfg.CertFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		}
+	}
+	if r.cfg.CAFile != "" {
+		// 使用最新的CA校验，跳过使用固定RootCAs的默认校验
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			verifyName := name
+			if verifyName == "" && fromSNI {
+				verifyName = cs.ServerName
+			}
+			if verifyName == "" {
+				return errors.New("tls: no server name to verify, set server_name or dial by host name")
+			}
+			raw := make([][]byte, len(cs.PeerCertificates))
+			for i, c := range cs.PeerCertificates {
+				raw[i] = c.Raw
+			}
+			return r.verify(raw, verifyName, x509.ExtKeyUsageServerAuth)
+		}
+	}
+	return cfg
+}
+
+// TransportCredentials gRPC客户端的传输凭证，每次连接按拨号的目标地址校验服务端证书
+func (r *CertReloader) TransportCredentials() credentials.TransportCredentials {
+	return &dialCredentials{TransportCredentials: credentials.NewTLS(r.ClientTLS()), reloader: r}
+}
+
+// dialCredentials 握手时使用按目标地址生成的TLS配置
+type dialCredentials struct {
+	credentials.TransportCredentials
+	reloader *CertReloader
+}
+
+func (c *dialCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.reloader.ClientTLSFor(authority)).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *dialCredentials) Clone() credentials.TransportCredentials {
+	return &dialCredentials{TransportCredentials: c.TransportCredentials.Clone(), reloader: c.reloader}
+}
+
+// verify 使用当前的CA校验对端证书链，name不为空时同时校验域名或IP
+func (r *CertReloader) verify(raw [][]byte, name string, usage x509.ExtKeyUsage) error {
+	if len(raw) == 0 {
+		return errors.New("tls: no peer certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, b := range raw {
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return fmt.Errorf("tls: parse peer certificate: %w", err)
+		}
+		certs[i] = c
+	}
+	r.lock.RLock()
+	roots := r.roots
+	r.lock.RUnlock()
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+func sameModTime(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, t := range a {
+		if !b[file].Equal(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientCredentials 调用内部服务时使用的传输凭证，启用gRPC TLS时提供本服务的证书（mTLS）
+func (s *Service) ClientCredentials() grpc.DialOption {
+	if s.grpcCerts == nil {
+		return grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+	return grpc.WithTransportCredentials(s.grpcCerts.TransportCredentials())
+}
+
+// EtcdTLSOptions 命令行工具连接etcd的TLS选项，cfg未启用TLS时返回nil
+func EtcdTLSOptions(cfg TLSConfig) ([]EtcdOption, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	r, err := NewCertReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return []EtcdOption{withEtcdCerts(r)}, nil
+}
+
+// certReloadInterval 检查证书文件变化的间隔
+const certReloadInterval = 30 * time.Second
+
+// SetTLS 设置各连接的TLS配置并开始监听证书文件的变化
+// 需要在ServiceStart之前调用，etcd的配置需要在LoadConfig之前调用；Kong使用当前s.Kong的地址
+func (s *Service) SetTLS(settings TLSSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	configs := []TLSConfig{settings.Grpc, settings.Gateway, settings.Etcd, settings.Kong}
+	reloaders := make([]*CertReloader, len(configs))
+	for i, cfg := range configs {
+		if !cfg.Enabled() {
+			continue
+		}
+		r, err := NewCertReloader(cfg)
+		if err != nil {
+			return err
+		}
+		reloaders[i] = r
+	}
+	if s.stopCertWatch != nil {
+		s.stopCertWatch()
+		s.stopCertWatch = nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, r := range reloaders {
+		if r != nil {
+			go r.Watch(ctx, certReloadInterval)
+		}
+	}
+	s.stopCertWatch = cancel
+	if settings.Kong != s.tlsSettings.Kong {
+		var cfg *tls.Config
+		if r := reloaders[3]; r != nil {
+			addr := s.Kong.BaseURL()
+			if u, err := url.Parse(addr); err == nil {
+				addr = u.Host
+			}
+			cfg = r.ClientTLSFor(addr)
+		}
+		// 保留token、超时等已有的选项，只替换TLS配置
+		s.Kong = s.Kong.WithTLS(cfg)
+	}
+	s.grpcCerts, s.gatewayCerts, s.etcdCerts = reloaders[0], reloaders[1], reloaders[2]
+	s.tlsSettings = settings
+	return nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"tracing"
+)
+
+// SetupTracing 按配置创建全局Tracer，替换并关闭原来的Tracer
+func (s *Service) SetupTracing(cfg TracingConfig) error {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case "":
+	case TracingExporterFile:
+		e, err := tracing.NewFileExporter(cfg.Endpoint)
+		if err != nil {
+			return err
+		}
+		exporter = e
+	case TracingExporterOTLP:
+		exporter = tracing.NewOTLPExporter(cfg.Endpoint, nil)
+	default:
+		return fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	var opts []tracing.TracerOption
+	if cfg.SampleRatio > 0 {
+		opts = append(opts, tracing.WithSampleRatio(cfg.SampleRatio))
+	}
+	tracer := tracing.NewTracer(s.ServiceInfo.Name, exporter, opts...)
+	tracing.SetTracer(tracer)
+	s.shutdownTracing()
+	s.tracer, s.tracingConfig = tracer, cfg
+	return nil
+}
+
+// shutdownTracing 导出剩余的span并关闭当前的Tracer
+func (s *Service) shutdownTracing() {
+	if s.tracer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.tracer.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shutdown tracer", "error", err)
+	}
+	s.tracer = nil
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// BloomFilter 判断键是否可能存在，返回false时键一定不存在
+type BloomFilter interface {
+	// 添加键
+	Add(ctx context.Context, keys ...string) error
+
+	// 判断键是否可能存在
+	MightContain(ctx context.Context, key string) (bool, error)
+
+	// 使用fill添加的键替换全部内容，重建期间查询使用旧内容，新添加的键同时写入新旧内容
+	Rebuild(ctx context.Context, fill func(add func(keys ...string) error) error) error
+}
+
+// BloomParams 预计n个键、误判率为p时的位数和哈希函数个数
+func BloomParams(n uint64, p float64) (bits uint64, hashes int) {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	bits = uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	hashes = int(math.Round(float64(bits) / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return bits, hashes
+}
+
+// bloomPositions 使用双重哈希计算key的k个位置
+func bloomPositions(key string, bits uint64, hashes int) []uint64 {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+	positions := make([]uint64, hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % bits
+	}
+	return positions
+}
+
+// MemoryBloom 进程内的布隆过滤器，首次重建成功前认为所有键都可能存在
+// 只有本实例的 Add 会写入，多副本时需要通过 BaseStorage.StartInvalidation 广播新增的键，否则使用 RedisBloom
+type MemoryBloom struct {
+	bits   uint64
+	hashes int
+
+	lock       sync.RWMutex
+	words      []uint64
+	rebuilding []uint64 // 重建中的新内容
+	ready      bool     // 已从数据源重建，之后的查询才可能返回false
+}
+
+// NewMemoryBloom 创建预计n个键、误判率为p的布隆过滤器
+func NewMemoryBloom(n uint64, p float64) *MemoryBloom {
+	bits, hashes := BloomParams(n, p)
+	return &MemoryBloom{bits: bits, hashes: hashes, words: make([]uint64, (bits+63)/64)}
+}
+
+func setBits(words []uint64, positions []uint64) {
+	for _, pos := range positions {
+		words[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *MemoryBloom) Add(ctx context.Context, keys ...string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, key := range keys {
+		positions := bloomPositions(key, b.bits, b.hashes)
+		setBits(b.words, positions)
+		if b.rebuilding != nil {
+			setBits(b.rebuilding, positions)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBloom) MightContain(ctx context.Context, key string) (bool, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if !b.ready {
+		return true, nil
+	}
+	for _, pos := range bloomPositions(key, b.bits, b.hashes) {
+		if b.words[pos/64]&(1<<(pos%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (b *MemoryBloom) Rebuild(ctx context.Context, fill func(add func(keys ...string) error) error) error {
+	b.lock.Lock()
+	if b.rebuilding != nil {
+		b.lock.Unlock()
+		return fmt.Errorf("bloom: rebuild already in progress")
+	}
+	b.rebuilding = make([]uint64, len(b.words))
+	b.lock.Unlock()
+
+	err := fill(func(keys ...string) error {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for _, key := range keys {
+			setBits(b.rebuilding, bloomPositions(key, b.bits, b.hashes))
+		}
+		return nil
+	})
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err == nil {
+		b.words = b.rebuilding
+		b.ready = true
+	}
+	b.rebuilding = nil
+	return err
+}
+
+// MarkStale 可能错过了其他实例新增的键，之后认为所有键都可能存在，直到下一次重建成功
+func (b *MemoryBloom) MarkStale() {
+	b.lock.Lock()
+	b.ready = false
+	b.lock.Unlock()
+}
+
+// 设置键在当前位图中的位，重建中的位图存在时同时写入；当前位图不存在说明尚未重建，不创建
+var bloomAddScript = redis.NewScript(`
+local built = redis.call('EXISTS', KEYS[1]) == 1
+local rebuilding = redis.call('EXISTS', KEYS[2]) == 1
+for i = 1, #ARGV do
+	if built then
+		redis.call('SETBIT', KEYS[1], ARGV[i], 1)
+	end
+	if rebuilding then
+		redis.call('SETBIT', KEYS[2], ARGV[i], 1)
+	end
+end
+return 0
+`)
+
+// bloomRebuildTTL 重建中的位图的过期时间，重建进程异常退出后自动清理
+const bloomRebuildTTL = time.Hour
+
+// RedisBloom 基于redis位图的布隆过滤器，所有副本共享，位图不存在（首次重建完成前）时认为所有键都可能存在
+type RedisBloom struct {
+	client redis.UniversalClient
+	key    string
+	bits   uint64
+	hashes int
+}
+
+// NewRedisBloom 创建预计n个键、误判率为p的布隆过滤器，位图保存在key中
+func NewRedisBloom(client redis.UniversalClient, key string, n uint64, p float64) *RedisBloom {
+	bits, hashes := BloomParams(n, p)
+	return &RedisBloom{client: client, key: key, bits: bits, hashes: hashes}
+}
+
+// rebuildKey 重建中的位图，使用hash tag与位图在redis集群的同一个slot
+func (b *RedisBloom) rebuildKey() string {
+	return "{" + b.key + "}:rebuild"
+}
+
+func (b *RedisBloom) args(keys []string) []interface{} {
+	args := make([]interface{}, 0, len(keys)*b.hashes)
+	for _, key := range keys {
+		for _, pos := range bloomPositions(key, b.bits, b.hashes) {
+			args = append(args, pos)
+		}
+	}
+	return args
+}
+
+func (b *RedisBloom) Add(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := bloomAddScript.Run(ctx, b.client, []string{b.key, b.rebuildKey()}, b.args(keys)...).Err(); err != nil {
+		return fmt.Errorf("bloom add: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisBloom) MightContain(ctx context.Context, key string) (bool, error) {
+	pipe := b.client.Pipeline()
+	built := pipe.Exists(ctx, b.key)
+	positions := bloomPositions(key, b.bits, b.hashes)
+	cmds := make([]*redis.IntCmd, len(positions))
+	for i, pos := range positions {
+		cmds[i] = pipe.GetBit(ctx, b.key, int64(pos))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("bloom check: %w", err)
+	}
+	if built.Val() == 0 {
+		return true, nil
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (b *RedisBloom) Rebuild(ctx context.Context, fill func(add func(keys ...string) error) error) error {
+	tmp := b.rebuildKey()
+	// 先创建重建中的位图，使重建期间的Add同时写入
+	if err := b.client.SetBit(ctx, tmp, 0, 0).Err(); err != nil {
+		return fmt.Errorf("bloom rebuild: %w", err)
+	}
+	b.client.Expire(ctx, tmp, bloomRebuildTTL)
+	err := fill(func(keys ...string) error {
+		if len(keys) == 0 {
+			return nil
+		}
+		pipe := b.client.Pipeline()
+		for _, pos := range b.args(keys) {
+			pipe.SetBit(ctx, tmp, int64(pos.(uint64)), 1)
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil {
+		b.client.Del(context.WithoutCancel(ctx), tmp)
+		return fmt.Errorf("bloom rebuild: %w", err)
+	}
+	if err := b.client.Rename(ctx, tmp, b.key).Err(); err != nil {
+		return fmt.Errorf("bloom rebuild: %w", err)
+	}
+	return b.client.Persist(ctx, b.key).Err()
+}
@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"math/rand"
+	"metrics"
+	"reflect"
+	"time"
+)
+
+// CacheConfig 读路径的缓存配置，各TTL为逻辑过期时间
+type CacheConfig struct {
+	LocalTTL    time.Duration // 本地缓存的有效期
+	RedisTTL    time.Duration // 缓存中间件的有效期
+	Jitter      float64       // 有效期随机浮动的比例，避免大量数据同时过期（缓存雪崩）
+	StaleTTL    time.Duration // 过期后继续保留的时间，期间返回旧值并在后台重建
+	LockTTL     time.Duration // 重建锁的有效期，也是加载数据的超时时间
+	LockWait    time.Duration // 其他进程正在重建时等待其结果的最长时间，超时后直接加载
+	NegativeTTL time.Duration // 不存在的数据的缓存时间，为0时不缓存（防止缓存穿透）
+
+	// RedeleteDelay 数据库写入后再次删除缓存的延迟，删除从库复制延迟期间读到旧数据的回填，为0时不再次删除
+	RedeleteDelay time.Duration
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		LocalTTL:    time.Minute,
+		RedisTTL:    10 * time.Minute,
+		Jitter:      0.1,
+		StaleTTL:    time.Minute,
+		LockTTL:     5 * time.Second,
+		LockWait:    2 * time.Second,
+		NegativeTTL: 30 * time.Second,
+
+		RedeleteDelay: time.Second,
+	}
+}
+
+// Loader 缓存未命中时从数据源加载数据，返回值按JSON序列化后写入缓存
+// 数据不存在时返回 ErrNotFound，该结果按 NegativeTTL 缓存
+type Loader func(ctx context.Context) (interface{}, error)
+
+// cacheEntry 缓存中保存的数据，逻辑过期后在物理删除前仍可作为旧值返回
+type cacheEntry struct {
+	Value   json.RawMessage `json:"v,omitempty"`
+	Expire  int64           `json:"e"`           // 逻辑过期时间（unix毫秒）
+	Missing bool            `json:"m,omitempty"` // 数据不存在，过期后不作为旧值返回
+}
+
+func (e *cacheEntry) fresh() bool {
+	return time.Now().UnixMilli() < e.Expire
+}
+
+// rebuildPoll 等待其他进程重建时查询缓存中间件的间隔
+const rebuildPoll = 20 * time.Millisecond
+
+// SetCacheConfig 设置读路径的缓存配置，未设置时使用 DefaultCacheConfig
+func (s *BaseStorage[T]) SetCacheConfig(cfg CacheConfig) {
+	s.cacheConfig = cfg
+}
+
+func (s *BaseStorage[T]) config() CacheConfig {
+	if s.cacheConfig == (CacheConfig{}) {
+		return DefaultCacheConfig()
+	}
+	return s.cacheConfig
+}
+
+// Get 按全局ID读取数据到data.Value（模型指针），缓存未命中时从数据库查询
+func (s *BaseStorage[T]) Get(ctx context.Context, data *STData) error {
+	key, err := dataKey[T](data)
+	if err != nil {
+		return err
+	}
+	t := reflect.TypeOf(data.Value)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return fmt.Errorf("storage: data value must be a model pointer, got %T", data.Value)
+	}
+	return s.Load(ctx, key, data.Value, func(ctx context.Context) (interface{}, error) {
+		return s.orm(ctx).Find(reflect.New(t.Elem()).Interface(), data.ID)
+	})
+}
+
+// Load 按 本地缓存 → 缓存中间件 → loader 的顺序读取key并解码到dst
+// 未命中时回填上层缓存；同一key的并发未命中在进程内合并，进程间通过分布式锁只由一个进程加载；
+// 缓存逻辑过期但未删除时直接返回旧值，并在后台重建
+// 设置了布隆过滤器时，过滤器中不存在的key直接返回 ErrNotFound，不访问数据源
+func (s *BaseStorage[T]) Load(ctx context.Context, key T, dst interface{}, loader Loader) error {
+	entry, err := s.load(ctx, key, loader)
+	if err != nil {
+		return err
+	}
+	if entry.Missing {
+		metrics.CacheRequests.WithLabelValues("negative", "hit").Inc()
+		return ErrNotFound
+	}
+	return json.Unmarshal(entry.Value, dst)
+}
+
+func (s *BaseStorage[T]) load(ctx context.Context, key T, loader Loader) (*cacheEntry, error) {
+	var stale *cacheEntry
+	if e := s.localEntry(ctx, key); e != nil {
+		if e.fresh() {
+			return e, nil
+		}
+		if !e.Missing {
+			stale = e
+		}
+	}
+	if e := s.redisEntry(ctx, key); e != nil {
+		if e.fresh() {
+			s.setLocal(ctx, key, e)
+			return e, nil
+		}
+		if !e.Missing && (stale == nil || e.Expire > stale.Expire) {
+			stale = e
+		}
+	}
+	if stale == nil && !s.mightExist(ctx, key) {
+		metrics.CacheRequests.WithLabelValues("bloom", "reject").Inc()
+		return nil, ErrNotFound
+	}
+
+	flightKey := fmt.Sprint(key)
+	// 加载不受调用者取消的影响，合并的其他调用者共享结果
+	rebuild := func() (interface{}, error) {
+		return s.rebuild(context.WithoutCancel(ctx), key, loader)
+	}
+	if stale != nil {
+		if s.flight.Go(flightKey, rebuild) {
+			slog.DebugContext(ctx, "serving stale value while rebuilding", "key", flightKey)
+		}
+		return stale, nil
+	}
+	v, err := s.flight.Do(ctx, flightKey, rebuild)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*cacheEntry), nil
+}
+
+// rebuild 加载数据并回填缓存，缓存中间件支持分布式锁时只有持锁的进程访问数据源
+func (s *BaseStorage[T]) rebuild(ctx context.Context, key T, loader Loader) (*cacheEntry, error) {
+	cfg := s.config()
+	ctx, cancel := context.WithTimeout(ctx, cfg.LockTTL)
+	defer cancel()
+
+	var lock *RedisLock
+	client := s.redisClient()
+	if client != nil {
+		l, err := TryLock(ctx, client, fmt.Sprint(key), cfg.LockTTL)
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "failed to acquire rebuild lock, loading without lock", "key", key, "error", err)
+		case l == nil:
+			// 其他进程正在重建，等待其写入缓存中间件
+			if e := s.waitRebuild(ctx, key, cfg.LockWait); e != nil {
+				s.setLocal(ctx, key, e)
+				return e, nil
+			}
+			slog.WarnContext(ctx, "timed out waiting for rebuild, loading directly", "key", key)
+		default:
+			lock = l
+			defer func() {
+				if err := lock.Unlock(context.WithoutCancel(ctx)); err != nil && !errors.Is(err, ErrLockNotHeld) {
+					slog.WarnContext(ctx, "failed to release rebuild lock", "key", key, "error", err)
+				}
+			}()
+		}
+	}
+
+	// 读取数据源前取得数据版本，加载期间数据库被写入时不回填读到的旧值
+	var version int64
+	if client != nil {
+		v, err := DataVersion(ctx, client, fmt.Sprint(key))
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read data version", "key", key, "error", err)
+		}
+		version = v
+	}
+	value, err := loader(ctx)
+	if errors.Is(err, ErrNotFound) && cfg.NegativeTTL > 0 {
+		entry := &cacheEntry{Missing: true, Expire: time.Now().Add(cfg.NegativeTTL).UnixMilli()}
+		s.backfill(ctx, key, entry, cfg.NegativeTTL, lock, version)
+		return entry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %v: %w", key, err)
+	}
+	ttl := jitter(cfg.RedisTTL, cfg.Jitter)
+	entry := &cacheEntry{Value: raw, Expire: time.Now().Add(ttl).UnixMilli()}
+	s.backfill(ctx, key, entry, ttl+cfg.StaleTTL, lock, version)
+	return entry, nil
+}
+
+// mightExist 查询布隆过滤器，未设置或查询失败时认为可能存在
+func (s *BaseStorage[T]) mightExist(ctx context.Context, key T) bool {
+	if s.Bloom == nil {
+		return true
+	}
+	ok, err := s.Bloom.MightContain(ctx, fmt.Sprint(key))
+	if err != nil {
+		slog.WarnContext(ctx, "failed to check bloom filter", "key", key, "error", err)
+		return true
+	}
+	return ok
+}
+
+// waitRebuild 等待其他进程写入未过期的数据，超时返回nil
+func (s *BaseStorage[T]) waitRebuild(ctx context.Context, key T, wait time.Duration) *cacheEntry {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	ticker := time.NewTicker(rebuildPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout.C:
+			return nil
+		case <-ticker.C:
+		}
+		if e := s.redisEntry(ctx, key); e != nil && e.fresh() {
+			return e
+		}
+	}
+}
+
+func (s *BaseStorage[T]) redisClient() redis.UniversalClient {
+	if c, ok := s.MiddlewareCache.(interface{ Client() redis.UniversalClient }); ok {
+		return c.Client()
+	}
+	return nil
+}
+
+func (s *BaseStorage[T]) localEntry(ctx context.Context, key T) *cacheEntry {
+	if s.LocalCache == nil {
+		return nil
+	}
+	v, found, err := s.LocalCache.Get(ctx, key)
+	if err != nil || !found {
+		return nil
+	}
+	e, _ := v.(*cacheEntry)
+	return e
+}
+
+// redisEntry 读取缓存中间件，读取失败时按未命中处理
+func (s *BaseStorage[T]) redisEntry(ctx context.Context, key T) *cacheEntry {
+	if s.MiddlewareCache == nil {
+		return nil
+	}
+	v, found, err := s.MiddlewareCache.Get(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read middleware cache", "key", key, "error", err)
+		return nil
+	}
+	if !found {
+		return nil
+	}
+	switch v := v.(type) {
+	case *cacheEntry:
+		return v
+	case string:
+		var e cacheEntry
+		if err := json.Unmarshal([]byte(v), &e); err != nil || e.Expire == 0 {
+			slog.WarnContext(ctx, "invalid cache entry", "key", key, "error", err)
+			return nil
+		}
+		return &e
+	}
+	return nil
+}
+
+// setLocal 回填本地缓存，有效期不超过缓存中间件中的数据
+func (s *BaseStorage[T]) setLocal(ctx context.Context, key T, e *cacheEntry) {
+	if s.LocalCache == nil {
+		return
+	}
+	cfg := s.config()
+	expire := time.Now().Add(jitter(cfg.LocalTTL, cfg.Jitter)).UnixMilli()
+	if expire > e.Expire {
+		expire = e.Expire
+	}
+	local := &cacheEntry{Value: e.Value, Expire: expire, Missing: e.Missing}
+	ttl := time.Until(time.UnixMilli(expire))
+	if !e.Missing {
+		ttl += cfg.StaleTTL
+	}
+	if err := s.LocalCache.Set(ctx, key, local, ttl); err != nil {
+		slog.WarnContext(ctx, "failed to backfill local cache", "key", key, "error", err)
+	}
+}
+
+// backfill 回填缓存中间件和本地缓存
+// 缓存中间件为redis时，数据版本不再是读取数据源前的version，或持有的重建锁已被其他持有者获得时不回填
+func (s *BaseStorage[T]) backfill(ctx context.Context, key T, e *cacheEntry, ttl time.Duration, lock *RedisLock, version int64) {
+	if s.MiddlewareCache != nil {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		client := s.redisClient()
+		switch {
+		case lock != nil:
+			err = lock.SetFenced(ctx, string(raw), ttl, version)
+		case client != nil:
+			err = SetIfVersion(ctx, client, fmt.Sprint(key), string(raw), ttl, version)
+		default:
+			err = s.MiddlewareCache.Set(ctx, key, string(raw), ttl)
+		}
+		if errors.Is(err, ErrVersionChanged) || errors.Is(err, ErrLockNotHeld) {
+			slog.DebugContext(ctx, "skip backfill of outdated value", "key", key, "reason", err)
+			return
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "failed to backfill middleware cache", "key", key, "error", err)
+		}
+	}
+	s.setLocal(ctx, key, e)
+}
+
+// bumpVersions 递增keys的数据版本，正在加载的旧数据不再回填
+func (s *BaseStorage[T]) bumpVersions(ctx context.Context, keys ...T) error {
+	client := s.redisClient()
+	if client == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := BumpVersion(ctx, client, fmt.Sprint(key)); err != nil {
+			return fmt.Errorf("failed to bump data version: %w", err)
+		}
+	}
+	return nil
+}
+
+// dropCached 数据库写入后递增keys的数据版本，并删除缓存中间件和各实例本地缓存中的keys，之后的读取从数据库重新加载
+// RedeleteDelay 后再删除一次，删除从库复制延迟期间读到旧数据的回填
+func (s *BaseStorage[T]) dropCached(ctx context.Context, keys ...T) error {
+	if err := s.evict(ctx, keys...); err != nil {
+		return err
+	}
+	if delay := s.config().RedeleteDelay; delay > 0 {
+		ctx := context.WithoutCancel(ctx)
+		time.AfterFunc(delay, func() {
+			if err := s.evict(ctx, keys...); err != nil {
+				slog.WarnContext(ctx, "failed to delete cache after database write", "keys", keys, "error", err)
+			}
+		})
+	}
+	return nil
+}
+
+func (s *BaseStorage[T]) evict(ctx context.Context, keys ...T) error {
+	if err := s.bumpVersions(ctx, keys...); err != nil {
+		return err
+	}
+	if s.MiddlewareCache != nil {
+		for _, key := range keys {
+			if err := s.MiddlewareCache.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete from middleware cache: %w", err)
+			}
+		}
+	}
+	return s.Invalidate(ctx, keys...)
+}
+
+// writeCache 将value写入缓存中间件，ttl为0时使用 CacheConfig.RedisTTL
+func (s *BaseStorage[T]) writeCache(ctx context.Context, key T, value json.RawMessage, ttl time.Duration) error {
+	cfg := s.config()
+	if ttl == 0 {
+		ttl = jitter(cfg.RedisTTL, cfg.Jitter)
+	}
+	raw, err := json.Marshal(&cacheEntry{Value: value, Expire: time.Now().Add(ttl).UnixMilli()})
+	if err != nil {
+		return err
+	}
+	return s.MiddlewareCache.Set(ctx, key, string(raw), ttl+cfg.StaleTTL)
+}
+
+// jitter 在d的基础上随机浮动ratio比例
+func jitter(d time.Duration, ratio float64) time.Duration {
+	if ratio <= 0 || d <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*ratio*float64(d))
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup 合并同一个键的并发加载，只有第一个调用者执行加载，其余调用者等待并共享结果
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// start 登记key的加载，已有加载在进行时返回该加载和false
+func (g *flightGroup) start(key string) (*flightCall, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+func (g *flightGroup) finish(key string, c *flightCall) {
+	g.lock.Lock()
+	delete(g.calls, key)
+	g.lock.Unlock()
+	close(c.done)
+}
+
+// Do 执行或等待key的加载，ctx结束时不再等待，但不会中断正在进行的加载
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	c, first := g.start(key)
+	if first {
+		c.val, c.err = fn()
+		g.finish(key, c)
+		return c.val, c.err
+	}
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Go 在后台执行key的加载，已有加载在进行时直接返回false
+func (g *flightGroup) Go(key string, fn func() (interface{}, error)) bool {
+	c, first := g.start(key)
+	if !first {
+		return false
+	}
+	go func() {
+		c.val, c.err = fn()
+		g.finish(key, c)
+	}()
+	return true
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"metrics"
+	"mqApi"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Invalidation 一条本地缓存的失效通知
+type Invalidation struct {
+	Keys    []string `json:"keys"`
+	Source  string   `json:"source"`            // 发出通知的实例
+	Version int64    `json:"version"`           // 发出时的时间戳（纳秒）
+	Created bool     `json:"created,omitempty"` // 新创建的数据，接收的实例同时将键加入进程内的布隆过滤器
+}
+
+// InvalidationBus 在实例之间广播本地缓存的失效通知
+type InvalidationBus interface {
+	// 广播通知
+	Publish(ctx context.Context, inv Invalidation) error
+
+	// 持续接收通知直到ctx结束，断线重连后调用onGap，期间的通知可能已经丢失
+	Subscribe(ctx context.Context, handle func(Invalidation), onGap func()) error
+}
+
+// GapPolicy 订阅中断期间可能丢失失效通知，重新订阅后对本地缓存的处理方式
+type GapPolicy int
+
+const (
+	GapFlush   GapPolicy = iota // 清空本地缓存
+	GapRecheck                  // 与缓存中间件逐个比较，删除不一致的键
+)
+
+// StartInvalidation 订阅bus上其他实例的失效通知并删除本地缓存中的键，直到ctx结束
+// 之后 Invalidate（Storage、Update、Delete）删除本地缓存时同时广播，Storage 新增的键同时加入其他实例的 MemoryBloom
+func (s *BaseStorage[T]) StartInvalidation(ctx context.Context, bus InvalidationBus, policy GapPolicy) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	s.instance = hex.EncodeToString(b)
+	s.bus = bus
+	go func() {
+		err := bus.Subscribe(ctx, func(inv Invalidation) {
+			if inv.Source != s.instance {
+				s.applyInvalidation(ctx, inv)
+			}
+		}, func() {
+			s.handleGap(ctx, policy)
+		})
+		if err != nil {
+			slog.Error("cache invalidation subscription stopped", "error", err)
+		}
+	}()
+}
+
+// Invalidate 删除本实例本地缓存中的keys，并通知其他实例删除
+// 广播失败时只记录日志，其他实例的本地缓存在有效期后过期
+func (s *BaseStorage[T]) Invalidate(ctx context.Context, keys ...T) error {
+	return s.invalidate(ctx, false, keys...)
+}
+
+// invalidate 删除并广播，created为true时其他实例同时将keys加入进程内的布隆过滤器
+func (s *BaseStorage[T]) invalidate(ctx context.Context, created bool, keys ...T) error {
+	if s.LocalCache != nil {
+		for _, key := range keys {
+			if err := s.LocalCache.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete from local cache: %w", err)
+			}
+		}
+	}
+	if s.bus == nil || len(keys) == 0 {
+		return nil
+	}
+	inv := Invalidation{Keys: make([]string, len(keys)), Source: s.instance, Version: time.Now().UnixNano(), Created: created}
+	for i, key := range keys {
+		inv.Keys[i] = fmt.Sprint(key)
+	}
+	if err := s.bus.Publish(ctx, inv); err != nil {
+		slog.WarnContext(ctx, "failed to publish cache invalidation", "keys", inv.Keys, "error", err)
+	}
+	return nil
+}
+
+func (s *BaseStorage[T]) applyInvalidation(ctx context.Context, inv Invalidation) {
+	if b, ok := s.Bloom.(*MemoryBloom); ok && inv.Created {
+		b.Add(ctx, inv.Keys...)
+	}
+	if s.LocalCache == nil {
+		return
+	}
+	for _, k := range inv.Keys {
+		key, err := parseKey[T](k)
+		if err != nil {
+			slog.Warn("invalid invalidation key", "key", k, "error", err)
+			continue
+		}
+		s.LocalCache.Delete(ctx, key)
+		metrics.CacheEvictions.WithLabelValues("local", "invalidated").Inc()
+	}
+}
+
+// handleGap 重新订阅后按policy处理可能错过通知的本地缓存，进程内的布隆过滤器可能错过新增的键，直到下一次重建前不再过滤
+func (s *BaseStorage[T]) handleGap(ctx context.Context, policy GapPolicy) {
+	if b, ok := s.Bloom.(*MemoryBloom); ok {
+		b.MarkStale()
+		slog.Warn("invalidation subscription resumed, bloom filter disabled until next rebuild")
+	}
+	local, ok := s.LocalCache.(interface {
+		Keys() []T
+		Clear()
+	})
+	if !ok {
+		slog.Warn("local cache does not support listing keys, skip invalidation gap handling")
+		return
+	}
+	if policy == GapFlush {
+		local.Clear()
+		slog.Info("invalidation subscription resumed, local cache flushed")
+		metrics.CacheEvictions.WithLabelValues("local", "gap").Inc()
+		return
+	}
+	removed := 0
+	for _, key := range local.Keys() {
+		e := s.localEntry(ctx, key)
+		if e == nil {
+			continue
+		}
+		remote := s.redisEntry(ctx, key)
+		if remote != nil && remote.Missing == e.Missing && bytes.Equal(remote.Value, e.Value) {
+			continue
+		}
+		s.LocalCache.Delete(ctx, key)
+		removed++
+	}
+	metrics.CacheEvictions.WithLabelValues("local", "gap").Add(float64(removed))
+	slog.Info("invalidation subscription resumed, local cache rechecked", "removed", removed)
+}
+
+// parseKey 将通知中的字符串还原为缓存的键
+func parseKey[T Key](s string) (T, error) {
+	var key T
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return key, nil
+	}
+	n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+	if err != nil {
+		return key, err
+	}
+	v.SetInt(n)
+	return key, nil
+}
+
+// RedisInvalidationBus 通过redis的发布订阅广播失效通知
+type RedisInvalidationBus struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+func NewRedisInvalidationBus(client redis.UniversalClient, channel string) *RedisInvalidationBus {
+	return &RedisInvalidationBus{client: client, channel: channel}
+}
+
+func (b *RedisInvalidationBus) Publish(ctx context.Context, inv Invalidation) error {
+	raw, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, raw).Err()
+}
+
+func (b *RedisInvalidationBus) Subscribe(ctx context.Context, handle func(Invalidation), onGap func()) error {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+	// 断线重连后重新订阅时会再次收到订阅确认
+	ch := pubsub.ChannelWithSubscriptions()
+	subscribed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			switch m := m.(type) {
+			case *redis.Subscription:
+				if m.Kind != "subscribe" {
+					continue
+				}
+				if subscribed {
+					onGap()
+				}
+				subscribed = true
+			case *redis.Message:
+				var inv Invalidation
+				if err := json.Unmarshal([]byte(m.Payload), &inv); err != nil {
+					slog.Warn("invalid invalidation message", "channel", b.channel, "error", err)
+					continue
+				}
+				handle(inv)
+			}
+		}
+	}
+}
+
+// mqReconnectDelay RabbitMQ连接断开后重新连接的间隔
+const mqReconnectDelay = time.Second
+
+// MQInvalidationBus 通过RabbitMQ的fanout交换机广播失效通知，每个实例使用自己的独占队列
+type MQInvalidationBus struct {
+	url      string
+	exchange string
+
+	lock sync.Mutex
+	pub  *mqApi.RabbitMQApi
+}
+
+func NewMQInvalidationBus(amqpURL, exchange string) *MQInvalidationBus {
+	return &MQInvalidationBus{url: amqpURL, exchange: exchange}
+}
+
+// publisher 返回发送用的连接，连接不可用时重新连接
+func (b *MQInvalidationBus) publisher(ctx context.Context) (*mqApi.RabbitMQApi, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.pub != nil && b.pub.Ping(ctx) == nil {
+		return b.pub, nil
+	}
+	if b.pub != nil {
+		b.pub.Close()
+		b.pub = nil
+	}
+	api, err := mqApi.NewRabbitMQApi(b.url, b.exchange, "fanout")
+	if err != nil {
+		return nil, err
+	}
+	b.pub = api
+	return api, nil
+}
+
+func (b *MQInvalidationBus) Publish(ctx context.Context, inv Invalidation) error {
+	api, err := b.publisher(ctx)
+	if err != nil {
+		return err
+	}
+	return api.SendMsg(mqApi.MqMsg{MsgType: mqApi.CacheInvalidate, Data: inv}.WithContext(ctx), "")
+}
+
+func (b *MQInvalidationBus) Subscribe(ctx context.Context, handle func(Invalidation), onGap func()) error {
+	subscribed := false
+	for {
+		err := b.consume(ctx, handle, func() {
+			if subscribed {
+				onGap()
+			}
+			subscribed = true
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Warn("invalidation consumer disconnected, reconnecting", "exchange", b.exchange, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(mqReconnectDelay):
+		}
+	}
+}
+
+// consume 使用新的连接和独占队列消费通知，直到连接断开或ctx结束
+func (b *MQInvalidationBus) consume(ctx context.Context, handle func(Invalidation), subscribed func()) error {
+	api, err := mqApi.NewRabbitMQApi(b.url, b.exchange, "fanout")
+	if err != nil {
+		return err
+	}
+	defer api.Close()
+	queue, err := api.BindExclusiveQ("")
+	if err != nil {
+		return err
+	}
+	subscribed()
+	stop := context.AfterFunc(ctx, func() {
+		api.StopConsumers(context.Background())
+	})
+	defer stop()
+	return api.Consume(queue, func(ctx context.Context, msg mqApi.MqMsg) error {
+		raw, err := json.Marshal(msg.Data)
+		if err != nil {
+			return nil
+		}
+		var inv Invalidation
+		if err := json.Unmarshal(raw, &inv); err != nil {
+			slog.Warn("invalid invalidation message", "exchange", b.exchange, "error", err)
+			return nil
+		}
+		handle(inv)
+		return nil
+	})
+}
@@ -4,135 +4,367 @@ import (
 	"container/list"
 	"context"
 	"errors"
+	"hash/maphash"
+	"metrics"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
+// 预先取得本地缓存命中和未命中的计数器，避免每次查询按标签查找
+var (
+	localHits   = metrics.CacheRequests.WithLabelValues("local", "hit")
+	localMisses = metrics.CacheRequests.WithLabelValues("local", "miss")
+)
+
+// EvictReason 元素被淘汰的原因
+type EvictReason int
+
+const (
+	EvictExpired  EvictReason = iota // 过期
+	EvictCapacity                    // 超过容量
+	EvictRejected                    // 准入策略拒绝写入或元素大于分片容量，回调的键值是没有写入的新元素
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// AdmissionPolicy 缓存已满时新元素的准入策略
+type AdmissionPolicy int
+
+const (
+	AdmitAll AdmissionPolicy = iota // 总是写入，淘汰最久未使用的元素（LRU）
+	TinyLFU                         // 新元素的访问频率高于被淘汰的元素时才写入
+	WTinyLFU                        // 新元素先进入窗口LRU，离开窗口时与主缓存（SLRU）的淘汰候选比较访问频率
+)
+
+// LocalCacheConfig 本地缓存的配置
+type LocalCacheConfig[T Key] struct {
+	Shards        int                                                // 分片数，向上取整为2的幂，容量较小时减少分片
+	MaxCost       int64                                              // 总容量，未设置Cost时为元素个数
+	Cost          func(value interface{}) int64                      // 元素的大小（如字节数），为空时每个元素为1
+	MaxItemCost   int64                                              // 单个元素的最大大小，减少分片数使每个分片都能放下，为0时设置Cost则为MaxCost/4
+	Admission     AdmissionPolicy                                    // 准入策略
+	Items         int                                                // TinyLFU统计访问频率时预计的元素个数，为0时按容量估计
+	CleanInterval time.Duration                                      // 后台清理过期元素的间隔
+	OnEvict       func(key T, value interface{}, reason EvictReason) // 元素被淘汰或拒绝写入时调用，在锁外执行，Delete和Clear不调用
+}
+
+// DefaultLocalCacheConfig 默认配置：16个分片、10000个元素、LRU
+func DefaultLocalCacheConfig[T Key]() LocalCacheConfig[T] {
+	return LocalCacheConfig[T]{
+		Shards:        16,
+		MaxCost:       10000,
+		Admission:     AdmitAll,
+		CleanInterval: time.Minute,
+	}
+}
+
+// minShardCost 每个分片的最小容量，容量较小时减少分片数以保持接近全局的LRU顺序
+const minShardCost = 64
+
+// defaultByteItems 按大小限制容量时默认预计的元素个数
+const defaultByteItems = 1 << 13
+
+// LocalCache 分片的进程内缓存，每个分片有独立的锁、LRU链表和访问频率统计
 type LocalCache[T Key] struct {
-	capacity int                 // 缓存容量
-	data     map[T]*list.Element // 存储缓存项
-	lruList  *list.List          // 双向链表用于实现 LRU
-	lock     sync.RWMutex        // 读写锁，确保线程安全
-	ticker   *time.Ticker        // 定时器按时清理过期数据
-	stopChan chan struct{}       // 用于停止清理协程
-	stopWG   sync.WaitGroup      // 等待清理协程停止
-	shutdown bool                // 缓存是否关闭
+	shards    []*shard[T]
+	mask      uint64
+	seed      maphash.Seed
+	stringKey bool // 键的底层类型是否为字符串
+	cost      func(value interface{}) int64
+	onEvict   func(key T, value interface{}, reason EvictReason)
+	ticker    *time.Ticker   // 定时器按时清理过期数据
+	stopChan  chan struct{}  // 用于停止清理协程
+	stopWG    sync.WaitGroup // 等待清理协程停止
+	shutdown  atomic.Bool    // 缓存是否关闭
+}
+
+// 分片内的链表
+const (
+	segWindow    = iota // W-TinyLFU的窗口
+	segProbation        // 主缓存中只访问过一次的元素，其他策略只使用这个链表
+	segProtected        // 主缓存中多次访问的元素
+)
+
+type shard[T Key] struct {
+	lock    sync.Mutex
+	policy  AdmissionPolicy
+	data    map[T]*list.Element
+	segs    [3]*list.List
+	segCost [3]int64
+	segMax  [3]int64
+	maxCost int64
+	sketch  *cmSketch
 }
 
 type cacheItem[T Key] struct {
-	key        T
-	value      interface{}
-	expiration time.Time // 过期时间
+	key    T
+	value  interface{}
+	hash   uint64
+	cost   int64
+	expire int64 // 过期时间（UnixNano），0表示不过期
+	seg    int
+}
+
+func (i *cacheItem[T]) expired(now int64) bool {
+	return i.expire != 0 && now >= i.expire
 }
 
-// NewCache 创建新的缓存
+// evicted 锁内淘汰的元素，解锁后统计并回调
+type evicted[T Key] struct {
+	item   *cacheItem[T]
+	reason EvictReason
+}
+
+// NewCache 创建容量为capacity个元素的LRU缓存，每隔cleanTime清理过期元素
 func NewCache[T Key](capacity int, cleanTime time.Duration) *LocalCache[T] {
-	cache := &LocalCache[T]{
-		capacity: capacity,
-		data:     make(map[T]*list.Element),
-		lruList:  list.New(),
-		ticker:   time.NewTicker(cleanTime),
-		stopChan: make(chan struct{}),
+	cfg := DefaultLocalCacheConfig[T]()
+	cfg.MaxCost = int64(capacity)
+	cfg.CleanInterval = cleanTime
+	return NewLocalCache(cfg)
+}
+
+// NewLocalCache 按配置创建缓存
+func NewLocalCache[T Key](cfg LocalCacheConfig[T]) *LocalCache[T] {
+	def := DefaultLocalCacheConfig[T]()
+	if cfg.Shards <= 0 {
+		cfg.Shards = def.Shards
+	}
+	if cfg.MaxCost <= 0 {
+		cfg.MaxCost = def.MaxCost
+	}
+	if cfg.CleanInterval <= 0 {
+		cfg.CleanInterval = def.CleanInterval
+	}
+	if cfg.MaxItemCost <= 0 {
+		cfg.MaxItemCost = 1
+		if cfg.Cost != nil {
+			cfg.MaxItemCost = cfg.MaxCost / 4
+		}
+	}
+	// 每个分片的容量不小于单个元素的最大大小，否则大元素总会被拒绝
+	n := nextPow2(cfg.Shards)
+	for n > 1 && cfg.MaxCost/int64(n) < max(minShardCost, cfg.MaxItemCost) {
+		n >>= 1
+	}
+	items := cfg.Items
+	if items <= 0 {
+		items = int(cfg.MaxCost)
+		if cfg.Cost != nil {
+			items = defaultByteItems
+		}
+	}
+	c := &LocalCache[T]{
+		shards:    make([]*shard[T], n),
+		mask:      uint64(n - 1),
+		seed:      maphash.MakeSeed(),
+		stringKey: reflect.TypeFor[T]().Kind() == reflect.String,
+		cost:      cfg.Cost,
+		onEvict:   cfg.OnEvict,
+		ticker:    time.NewTicker(cfg.CleanInterval),
+		stopChan:  make(chan struct{}),
+	}
+	for i := range c.shards {
+		// 容量不能整除时前面的分片多分配一个
+		maxCost := cfg.MaxCost / int64(n)
+		if int64(i) < cfg.MaxCost%int64(n) {
+			maxCost++
+		}
+		c.shards[i] = newShard[T](cfg.Admission, maxCost, items/n)
 	}
 
 	// 启动定期清理的后台协程
-	cache.stopWG.Add(1)
-	go cache.cleanupExpiredItems()
+	c.stopWG.Add(1)
+	go c.cleanupExpiredItems()
 
-	return cache
+	return c
 }
 
-// Get 从缓存中获取数据
-func (c *LocalCache[T]) Get(ctx context.Context, key T) (interface{}, bool, error) {
-	if c.shutdown {
-		return nil, false, errors.New("local cache shutting down")
+func newShard[T Key](policy AdmissionPolicy, maxCost int64, items int) *shard[T] {
+	s := &shard[T]{policy: policy, data: make(map[T]*list.Element), maxCost: maxCost}
+	for i := range s.segs {
+		s.segs[i] = list.New()
+	}
+	s.segMax[segProbation] = maxCost
+	if policy == WTinyLFU {
+		// 窗口占1%，主缓存中受保护的部分占80%
+		s.segMax[segWindow] = max(1, maxCost/100)
+		main := maxCost - s.segMax[segWindow]
+		s.segMax[segProtected] = main * 8 / 10
+		s.segMax[segProbation] = main - s.segMax[segProtected]
 	}
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+	if policy != AdmitAll {
+		s.sketch = newSketch(items)
+	}
+	return s
+}
 
-	if c.shutdown {
-		return nil, false, nil
+// hash 计算键的哈希值，用于选择分片和统计访问频率
+// Key的底层类型只有字符串和4、8字节的整数，直接读取底层值以避免转换为interface时的内存分配
+func (c *LocalCache[T]) hash(key T) uint64 {
+	p := unsafe.Pointer(&key)
+	switch {
+	case c.stringKey:
+		return maphash.String(c.seed, *(*string)(p))
+	case unsafe.Sizeof(key) == 4:
+		return mix64(uint64(*(*int32)(p)))
+	default:
+		return mix64(uint64(*(*int64)(p)))
 	}
+}
 
-	if elem, found := c.data[key]; found {
-		// 检查是否过期
-		item := elem.Value.(*cacheItem[T])
-		if time.Now().Before(item.expiration) {
-			// 移动到链表头部 (最近使用)
-			c.lruList.MoveToFront(elem)
-			return item.value, true, nil
-		}
-		// 如果过期，删除缓存项
-		c.removeElement(elem)
+func (c *LocalCache[T]) shard(h uint64) *shard[T] {
+	return c.shards[(h>>32)&c.mask]
+}
+
+// Get 从缓存中获取数据，过期的元素在读取时删除
+func (c *LocalCache[T]) Get(ctx context.Context, key T) (interface{}, bool, error) {
+	if c.shutdown.Load() {
+		return nil, false, errors.New("local cache shutting down")
+	}
+	h := c.hash(key)
+	s := c.shard(h)
+	s.lock.Lock()
+	value, found, ev := s.get(key, h, time.Now().UnixNano())
+	s.lock.Unlock()
+	c.notify(ev)
+	if found {
+		localHits.Inc()
+	} else {
+		localMisses.Inc()
 	}
-	return nil, false, nil
+	return value, found, nil
 }
 
-// Set 向缓存中添加或更新数据
+// Set 向缓存中添加或更新数据，ttl不大于0时不过期
+// 准入策略拒绝写入或元素大于分片容量时返回nil，元素不会被缓存，以EvictRejected调用OnEvict
 func (c *LocalCache[T]) Set(ctx context.Context, key T, value interface{}, ttl time.Duration) error {
-	if c.shutdown {
+	if c.shutdown.Load() {
 		return errors.New("local cache shutting down")
 	}
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
-	// 如果已经存在，更新并移动到链表头部
-	if elem, found := c.data[key]; found {
-		elem.Value.(*cacheItem[T]).value = value
-		elem.Value.(*cacheItem[T]).expiration = time.Now().Add(ttl)
-		c.lruList.MoveToFront(elem)
-	} else {
-		// 否则插入新条目
-		if c.lruList.Len() == c.capacity {
-			// 删除最旧的条目（LRU）
-			oldest := c.lruList.Back()
-			if oldest != nil {
-				c.removeElement(oldest)
-			}
-		}
-		newItem := &cacheItem[T]{
-			key:        key,
-			value:      value,
-			expiration: time.Now().Add(ttl),
-		}
-		newElem := c.lruList.PushFront(newItem)
-		c.data[key] = newElem
+	item := &cacheItem[T]{key: key, value: value, hash: c.hash(key), cost: 1}
+	if c.cost != nil {
+		item.cost = c.cost(value)
 	}
+	if ttl > 0 {
+		item.expire = time.Now().Add(ttl).UnixNano()
+	}
+	s := c.shard(item.hash)
+	s.lock.Lock()
+	ev := s.set(item)
+	s.lock.Unlock()
+	c.notify(ev)
 	return nil
 }
 
-// Exists 判断缓存是否存在
+// Exists 判断缓存是否存在且未过期
 func (c *LocalCache[T]) Exists(ctx context.Context, key T) (bool, error) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	_, found := c.data[key]
-	return found, nil
+	s := c.shard(c.hash(key))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	elem, found := s.data[key]
+	return found && !elem.Value.(*cacheItem[T]).expired(time.Now().UnixNano()), nil
 }
 
-// Delete 删除缓存元素
+// Delete 删除缓存元素，键不存在时忽略
 func (c *LocalCache[T]) Delete(ctx context.Context, key T) error {
-	c.removeElement(c.data[key])
+	s := c.shard(c.hash(key))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if elem, found := s.data[key]; found {
+		s.remove(elem)
+	}
 	return nil
 }
 
-// removeElement 从缓存中删除元素
-func (c *LocalCache[T]) removeElement(elem *list.Element) {
-	c.lruList.Remove(elem)
-	delete(c.data, elem.Value.(*cacheItem[T]).key)
+// Keys 返回缓存中所有的键
+func (c *LocalCache[T]) Keys() []T {
+	var keys []T
+	for _, s := range c.shards {
+		s.lock.Lock()
+		for key := range s.data {
+			keys = append(keys, key)
+		}
+		s.lock.Unlock()
+	}
+	return keys
 }
 
-// CleanUp 清理过期缓存项
+// Clear 删除缓存中所有的元素
+func (c *LocalCache[T]) Clear() {
+	for _, s := range c.shards {
+		s.lock.Lock()
+		s.data = make(map[T]*list.Element)
+		for i := range s.segs {
+			s.segs[i].Init()
+			s.segCost[i] = 0
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Len 返回缓存中元素的个数
+func (c *LocalCache[T]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.lock.Lock()
+		n += len(s.data)
+		s.lock.Unlock()
+	}
+	return n
+}
+
+// Cost 返回缓存中元素的总大小
+func (c *LocalCache[T]) Cost() int64 {
+	var total int64
+	for _, s := range c.shards {
+		s.lock.Lock()
+		total += s.segCost[segWindow] + s.segCost[segProbation] + s.segCost[segProtected]
+		s.lock.Unlock()
+	}
+	return total
+}
+
+// CleanUp 清理过期缓存项，每次只锁定一个分片
 func (c *LocalCache[T]) CleanUp() {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
-	for e := c.lruList.Back(); e != nil; {
-		next := e.Prev()
-		item := e.Value.(*cacheItem[T])
-		if time.Now().After(item.expiration) {
-			c.removeElement(e)
+	now := time.Now().UnixNano()
+	for _, s := range c.shards {
+		s.lock.Lock()
+		var ev []evicted[T]
+		for _, l := range s.segs {
+			for e := l.Back(); e != nil; {
+				prev := e.Prev()
+				if item := e.Value.(*cacheItem[T]); item.expired(now) {
+					s.remove(e)
+					ev = append(ev, evicted[T]{item, EvictExpired})
+				}
+				e = prev
+			}
+		}
+		s.lock.Unlock()
+		c.notify(ev)
+	}
+}
+
+// notify 统计淘汰的元素并调用OnEvict
+func (c *LocalCache[T]) notify(ev []evicted[T]) {
+	for _, e := range ev {
+		metrics.CacheEvictions.WithLabelValues("local", e.reason.String()).Inc()
+		if c.onEvict != nil {
+			c.onEvict(e.item.key, e.item.value, e.reason)
 		}
-		e = next
 	}
 }
 
@@ -154,16 +386,169 @@ func (c *LocalCache[T]) cleanupExpiredItems() {
 
 // Stop 清理并停止定时器和清理协程
 func (c *LocalCache[T]) Stop() {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
-	if c.shutdown {
+	if !c.shutdown.CompareAndSwap(false, true) {
 		return
 	}
-
-	c.shutdown = true
 	// 发送停止信号，优雅地停止清理协程
 	close(c.stopChan)
 	// 等待清理协程退出
 	c.stopWG.Wait()
 }
+
+func (s *shard[T]) get(key T, h uint64, now int64) (interface{}, bool, []evicted[T]) {
+	if s.sketch != nil {
+		s.sketch.increment(h)
+	}
+	elem, found := s.data[key]
+	if !found {
+		return nil, false, nil
+	}
+	item := elem.Value.(*cacheItem[T])
+	if item.expired(now) {
+		s.remove(elem)
+		return nil, false, []evicted[T]{{item, EvictExpired}}
+	}
+	s.touch(elem)
+	return item.value, true, nil
+}
+
+func (s *shard[T]) set(item *cacheItem[T]) []evicted[T] {
+	if s.sketch != nil {
+		s.sketch.increment(item.hash)
+	}
+	if item.cost > s.maxCost {
+		if elem, found := s.data[item.key]; found {
+			s.remove(elem)
+		}
+		return []evicted[T]{{item, EvictRejected}}
+	}
+	// 已经存在时原地更新，按新的大小淘汰同一链表中的其他元素
+	if elem, found := s.data[item.key]; found {
+		old := elem.Value.(*cacheItem[T])
+		s.segCost[old.seg] += item.cost - old.cost
+		old.value, old.cost, old.expire = item.value, item.cost, item.expire
+		s.touch(elem)
+		if old.seg == segWindow {
+			return s.drainWindow(nil)
+		}
+		// 晋升到保护区后链表元素已经改变
+		return s.evictMain(s.data[item.key], nil)
+	}
+
+	switch s.policy {
+	case TinyLFU:
+		victims, ok := s.victims(item, item.cost-(s.segMax[segProbation]-s.segCost[segProbation]))
+		if !ok {
+			return []evicted[T]{{item, EvictRejected}}
+		}
+		ev := s.evict(victims, nil)
+		s.push(item, segProbation)
+		return ev
+	case WTinyLFU:
+		s.push(item, segWindow)
+		return s.drainWindow(nil)
+	default:
+		s.push(item, segProbation)
+		return s.evictMain(s.data[item.key], nil)
+	}
+}
+
+// touch 记录一次命中：移动到链表头部，W-TinyLFU中试用区的元素晋升到保护区
+func (s *shard[T]) touch(elem *list.Element) {
+	item := elem.Value.(*cacheItem[T])
+	if s.policy != WTinyLFU || item.seg != segProbation {
+		s.segs[item.seg].MoveToFront(elem)
+		return
+	}
+	s.remove(elem)
+	s.push(item, segProtected)
+	// 保护区超出容量时最久未使用的元素降级回试用区
+	for s.segCost[segProtected] > s.segMax[segProtected] {
+		tail := s.segs[segProtected].Back()
+		if tail == nil || tail.Value == item {
+			break
+		}
+		demoted := tail.Value.(*cacheItem[T])
+		s.remove(tail)
+		s.push(demoted, segProbation)
+	}
+}
+
+// drainWindow 窗口超出容量时，最久未使用的元素作为候选进入主缓存
+// 主缓存已满时候选与主缓存的淘汰对象比较访问频率，频率低的一方被淘汰
+func (s *shard[T]) drainWindow(ev []evicted[T]) []evicted[T] {
+	for s.segCost[segWindow] > s.segMax[segWindow] {
+		tail := s.segs[segWindow].Back()
+		candidate := tail.Value.(*cacheItem[T])
+		s.remove(tail)
+		free := s.segMax[segProbation] + s.segMax[segProtected] - s.segCost[segProbation] - s.segCost[segProtected]
+		victims, ok := s.victims(candidate, candidate.cost-free)
+		if !ok {
+			ev = append(ev, evicted[T]{candidate, EvictCapacity})
+			continue
+		}
+		ev = s.evict(victims, ev)
+		s.push(candidate, segProbation)
+	}
+	return ev
+}
+
+// victims 找出为candidate腾出need大小需要淘汰的元素，从试用区尾部开始，不足时使用保护区
+// 任何一个被淘汰的元素访问频率不低于candidate时拒绝candidate
+func (s *shard[T]) victims(candidate *cacheItem[T], need int64) ([]*list.Element, bool) {
+	if need <= 0 {
+		return nil, true
+	}
+	var victims []*list.Element
+	freq := s.sketch.estimate(candidate.hash)
+	for _, seg := range []int{segProbation, segProtected} {
+		for e := s.segs[seg].Back(); e != nil && need > 0; e = e.Prev() {
+			victim := e.Value.(*cacheItem[T])
+			if s.sketch.estimate(victim.hash) >= freq {
+				return nil, false
+			}
+			victims = append(victims, e)
+			need -= victim.cost
+		}
+	}
+	return victims, need <= 0
+}
+
+// evictMain 主缓存超出容量时从尾部淘汰，keep不会被淘汰
+func (s *shard[T]) evictMain(keep *list.Element, ev []evicted[T]) []evicted[T] {
+	for _, seg := range []int{segProbation, segProtected} {
+		for s.segCost[segProbation]+s.segCost[segProtected] > s.segMax[segProbation]+s.segMax[segProtected] {
+			tail := s.segs[seg].Back()
+			if tail == keep && tail != nil {
+				tail = tail.Prev()
+			}
+			if tail == nil {
+				break
+			}
+			ev = s.evict([]*list.Element{tail}, ev)
+		}
+	}
+	return ev
+}
+
+func (s *shard[T]) evict(elems []*list.Element, ev []evicted[T]) []evicted[T] {
+	for _, e := range elems {
+		ev = append(ev, evicted[T]{e.Value.(*cacheItem[T]), EvictCapacity})
+		s.remove(e)
+	}
+	return ev
+}
+
+func (s *shard[T]) push(item *cacheItem[T], seg int) {
+	item.seg = seg
+	s.data[item.key] = s.segs[seg].PushFront(item)
+	s.segCost[seg] += item.cost
+}
+
+// remove 从缓存中删除元素
+func (s *shard[T]) remove(elem *list.Element) {
+	item := elem.Value.(*cacheItem[T])
+	s.segs[item.seg].Remove(elem)
+	s.segCost[item.seg] -= item.cost
+	delete(s.data, item.key)
+}
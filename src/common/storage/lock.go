@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// ErrLockNotHeld 锁已经过期，或者已被其他持有者重新获得
+var ErrLockNotHeld = errors.New("storage: lock not held")
+
+// ErrVersionChanged 读取数据源之后数据被写入过，读到的可能是旧值
+var ErrVersionChanged = errors.New("storage: data version changed")
+
+// fenceTTL fencing计数器的过期时间，每次加锁时刷新
+const fenceTTL = 24 * time.Hour
+
+// 加锁成功时递增fencing计数器，返回新的token；锁被占用时返回0
+var lockScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	local token = redis.call('INCR', KEYS[2])
+	redis.call('PEXPIRE', KEYS[2], ARGV[3])
+	return token
+end
+return 0
+`)
+
+// 只有持有者才能释放锁
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// fencing计数器仍是当前token（ARGV[3]为0时不检查），且数据版本仍是读取数据源前的版本时才写入，
+// 防止锁过期后的旧持有者或读到旧数据的加载覆盖新数据；token不一致返回0，版本不一致返回-1
+var guardedSetScript = redis.NewScript(`
+if ARGV[3] ~= '0' and redis.call('GET', KEYS[2]) ~= ARGV[3] then
+	return 0
+end
+if (redis.call('GET', KEYS[3]) or '0') ~= ARGV[4] then
+	return -1
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// 递增数据版本
+var bumpVersionScript = redis.NewScript(`
+local v = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return v
+`)
+
+// lockKey 资源的锁的键，键名使用hash tag，与资源本身、fencing计数器和数据版本在redis集群的同一个slot
+func lockKey(resource string) string {
+	return "lock:{" + resource + "}"
+}
+
+func fenceKey(resource string) string {
+	return lockKey(resource) + ":fence"
+}
+
+// versionKey 数据版本的键，数据库写入后递增
+func versionKey(key string) string {
+	return "{" + key + "}:version"
+}
+
+// DataVersion 返回key当前的数据版本，从未写入过时为0
+// 读取数据源前取得版本，回填缓存时通过 SetFenced 或 SetIfVersion 检查版本没有变化
+func DataVersion(ctx context.Context, client redis.UniversalClient, key string) (int64, error) {
+	v, err := client.Get(ctx, versionKey(key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// BumpVersion 递增key的数据版本，之前取得版本的回填不再写入
+func BumpVersion(ctx context.Context, client redis.UniversalClient, key string) error {
+	return bumpVersionScript.Run(ctx, client, []string{versionKey(key)}, fenceTTL.Milliseconds()).Err()
+}
+
+// SetIfVersion 在key的数据版本仍为version时写入，否则返回 ErrVersionChanged
+func SetIfVersion(ctx context.Context, client redis.UniversalClient, key string, value string, ttl time.Duration, version int64) error {
+	return guardedSet(ctx, client, key, value, ttl, 0, version)
+}
+
+func guardedSet(ctx context.Context, client redis.UniversalClient, key string, value string, ttl time.Duration, token, version int64) error {
+	n, err := guardedSetScript.Run(ctx, client, []string{key, fenceKey(key), versionKey(key)},
+		value, ttl.Milliseconds(), token, version).Int64()
+	if err != nil {
+		return fmt.Errorf("set %s: %w", key, err)
+	}
+	switch n {
+	case 0:
+		return ErrLockNotHeld
+	case -1:
+		return ErrVersionChanged
+	}
+	return nil
+}
+
+// RedisLock 基于 SET NX PX 的分布式锁，每次加锁成功获得递增的fencing token
+type RedisLock struct {
+	client   redis.UniversalClient
+	resource string
+	owner    string
+	token    int64
+}
+
+// TryLock 尝试获得resource的锁，锁被其他持有者占用时返回nil
+// 锁保存在 lock:{resource}，fencing计数器保存在 lock:{resource}:fence
+func TryLock(ctx context.Context, client redis.UniversalClient, resource string, ttl time.Duration) (*RedisLock, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	l := &RedisLock{client: client, resource: resource, owner: hex.EncodeToString(b)}
+	token, err := lockScript.Run(ctx, client, []string{lockKey(resource), fenceKey(resource)},
+		l.owner, ttl.Milliseconds(), fenceTTL.Milliseconds()).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", resource, err)
+	}
+	if token == 0 {
+		return nil, nil
+	}
+	l.token = token
+	return l, nil
+}
+
+// Token 本次加锁获得的fencing token，后加锁的持有者token更大
+func (l *RedisLock) Token() int64 {
+	return l.token
+}
+
+// Unlock 释放锁，锁已经过期或被其他持有者获得时返回 ErrLockNotHeld
+func (l *RedisLock) Unlock(ctx context.Context) error {
+	n, err := unlockScript.Run(ctx, l.client, []string{lockKey(l.resource)}, l.owner).Int64()
+	if err != nil {
+		return fmt.Errorf("unlock %s: %w", l.resource, err)
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// SetFenced 写入锁住的资源，之后有其他持有者加锁时返回 ErrLockNotHeld，
+// 数据版本不再是读取数据源前的version时返回 ErrVersionChanged
+func (l *RedisLock) SetFenced(ctx context.Context, value string, ttl time.Duration, version int64) error {
+	return guardedSet(ctx, l.client, l.resource, value, ttl, l.token, version)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/redis/go-redis/v9"
+	"metrics"
+	"net"
 	"time"
 )
 
@@ -17,6 +19,7 @@ func NewRedisClusterCache(addrs []string, password string) *RedisCache {
 		Addrs:    addrs,
 		Password: password,
 	})
+	client.AddHook(metricsHook{})
 	return &RedisCache{
 		client: client,
 	}
@@ -29,22 +32,35 @@ func NewRedisCache(addr string, password string, db int) *RedisCache {
 		Password: password, // 如果没有密码则传空字符串
 		DB:       db,
 	})
+	client.AddHook(metricsHook{})
 	return &RedisCache{
 		client: client,
 	}
 }
 
+// Client 返回底层的 redis 客户端，供限流、分布式锁等需要执行脚本的组件使用
+func (rc *RedisCache) Client() redis.UniversalClient {
+	return rc.client
+}
+
+// Ping 检查Redis连接，用于服务的就绪检查
+func (rc *RedisCache) Ping(ctx context.Context) error {
+	return rc.client.Ping(ctx).Err()
+}
+
 // Get 从 Redis 缓存中获取数据
 func (rc *RedisCache) Get(ctx context.Context, key string) (interface{}, bool, error) {
 	result, err := rc.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		// 缓存中没有该键
+		metrics.CacheRequests.WithLabelValues("redis", "miss").Inc()
 		return nil, false, nil
 	} else if err != nil {
 		// 发生错误
 		return nil, false, err
 	}
 
+	metrics.CacheRequests.WithLabelValues("redis", "hit").Inc()
 	return result, true, nil
 }
 
@@ -93,3 +109,37 @@ func serializeValue(value interface{}) (string, error) {
 		return string(data), nil
 	}
 }
+
+// metricsHook 记录每条redis命令的耗时，redis.Nil 不计为错误
+type metricsHook struct{}
+
+func (metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		observeRedis(cmd.Name(), start, err)
+		return err
+	}
+}
+
+func (metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		observeRedis("pipeline", start, err)
+		return err
+	}
+}
+
+func observeRedis(command string, start time.Time, err error) {
+	if err == redis.Nil {
+		err = nil
+	}
+	metrics.RedisDuration.WithLabelValues(command, metrics.Status(err)).Observe(metrics.Since(start))
+}
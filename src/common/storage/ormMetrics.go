@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"metrics"
+	"time"
+)
+
+const metricsStartKey = "metrics:start"
+
+// RegisterMetrics 为gorm注册耗时统计回调
+func RegisterMetrics(db *gorm.DB) error {
+	return registerAround(db, "metrics", func(string) func(*gorm.DB) {
+		return func(db *gorm.DB) {
+			db.InstanceSet(metricsStartKey, time.Now())
+		}
+	}, observeDB)
+}
+
+// registerAround 在gorm每种操作的前后注册回调，回调名为 prefix:before_<op> 和 prefix:after_<op>
+func registerAround(db *gorm.DB, prefix string, before, after func(op string) func(*gorm.DB)) error {
+	cb := db.Callback()
+	register := []struct {
+		op     string
+		before func(name string, fn func(*gorm.DB)) error
+		after  func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+	for _, r := range register {
+		if err := r.before(prefix+":before_"+r.op, before(r.op)); err != nil {
+			return err
+		}
+		if err := r.after(prefix+":after_"+r.op, after(r.op)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func observeDB(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		err := db.Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+		}
+		metrics.DBDuration.WithLabelValues(op, db.Statement.Table, metrics.Status(err)).Observe(metrics.Since(v.(time.Time)))
+	}
+}
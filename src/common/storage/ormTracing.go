@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"tracing"
+)
+
+const tracingSpanKey = "tracing:span"
+
+// RegisterTracing 为gorm注册链路追踪回调，每条SQL生成一个span，父span取自 db.WithContext(ctx) 传入的ctx
+func RegisterTracing(db *gorm.DB) error {
+	return registerAround(db, "tracing", startDBSpan, func(string) func(*gorm.DB) {
+		return endDBSpan
+	})
+}
+
+func startDBSpan(op string) func(*gorm.DB) {
+	name := "gorm." + op
+	return func(db *gorm.DB) {
+		if db.Statement == nil || db.Statement.Context == nil {
+			return
+		}
+		_, span := tracing.Start(db.Statement.Context, name, tracing.KindClient)
+		db.InstanceSet(tracingSpanKey, span)
+	}
+}
+
+func endDBSpan(db *gorm.DB) {
+	v, ok := db.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(*tracing.Span)
+	span.SetAttribute("db.system", db.Dialector.Name())
+	span.SetAttribute("db.sql.table", db.Statement.Table)
+	span.SetAttribute("db.statement", db.Statement.SQL.String())
+	span.SetAttribute("db.rows_affected", db.RowsAffected)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+	}
+	span.End()
+}
@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	"log"
 	"math/rand"
 	"reflect"
 )
@@ -27,36 +29,65 @@ type ORM interface {
 }
 
 type GORM struct {
-	masters []*gorm.DB // 主库连接池
-	slaves  []*gorm.DB // 从库连接池
+	masters []*gorm.DB      // 主库连接池
+	slaves  []*gorm.DB      // 从库连接池
+	ctx     context.Context // 通过WithContext传入，用于链路追踪
 }
 
 // NewGORM 初始化 GORM 连接，支持多个主库和从库
-func NewGORM(masterDSNs, slaveDSNs []string) *GORM {
+func NewGORM(masterDSNs, slaveDSNs []string) (*GORM, error) {
 	masters := make([]*gorm.DB, 0)
 	slaves := make([]*gorm.DB, 0)
 
 	// 初始化主库连接池
 	for _, masterDSN := range masterDSNs {
-		mysqlConn := mysql.Open(masterDSN)
-		db, err := gorm.Open(mysqlConn, &gorm.Config{})
+		db, err := openDB(masterDSN)
 		if err != nil {
-			log.Fatal("Error connecting to master DB:", err)
+			return nil, fmt.Errorf("connect to master db: %w", err)
 		}
 		masters = append(masters, db)
 	}
 
 	// 初始化从库连接池
 	for _, slaveDSN := range slaveDSNs {
-		mysqlConn := mysql.Open(slaveDSN)
-		db, err := gorm.Open(mysqlConn, &gorm.Config{})
+		db, err := openDB(slaveDSN)
 		if err != nil {
-			log.Fatal("Error connecting to slave DB:", err)
+			return nil, fmt.Errorf("connect to slave db: %w", err)
 		}
 		slaves = append(slaves, db)
 	}
 
-	return &GORM{masters: masters, slaves: slaves}
+	return &GORM{masters: masters, slaves: slaves}, nil
+}
+
+// Ping 检查所有主库和从库的连接，用于服务的就绪检查
+func (g *GORM) Ping(ctx context.Context) error {
+	for _, db := range append(append([]*gorm.DB(nil), g.masters...), g.slaves...) {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return fmt.Errorf("ping %s: %w", db.Dialector.Name(), err)
+		}
+	}
+	return nil
+}
+
+// openDB 打开数据库连接并注册链路追踪和指标回调
+func openDB(dsn string) (*gorm.DB, error) {
+	// TranslateError 使主键冲突返回 gorm.ErrDuplicatedKey，写回消费者据此判断重复写入
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := RegisterTracing(db); err != nil {
+		return nil, fmt.Errorf("register tracing callbacks: %w", err)
+	}
+	if err := RegisterMetrics(db); err != nil {
+		return nil, fmt.Errorf("register metrics callbacks: %w", err)
+	}
+	return db, nil
 }
 func (g *GORM) Migrate(model interface{}) error {
 	g.getRandomDB(true).AutoMigrate(model)
@@ -64,14 +95,25 @@ func (g *GORM) Migrate(model interface{}) error {
 	return nil
 }
 
+// WithContext 返回使用ctx执行SQL的GORM，SQL的span会挂在ctx中的调用链下
+func (g *GORM) WithContext(ctx context.Context) *GORM {
+	return &GORM{masters: g.masters, slaves: g.slaves, ctx: ctx}
+}
+
 // getRandomDB 根据是否写操作选择主库或从库
 func (g *GORM) getRandomDB(isWrite bool) *gorm.DB {
+	var db *gorm.DB
 	if isWrite {
 		// 从主库中随机选择
-		return g.masters[rand.Intn(len(g.masters))]
+		db = g.masters[rand.Intn(len(g.masters))]
+	} else {
+		// 从从库中随机选择
+		db = g.slaves[rand.Intn(len(g.slaves))]
 	}
-	// 从从库中随机选择
-	return g.slaves[rand.Intn(len(g.slaves))]
+	if g.ctx != nil {
+		return db.WithContext(g.ctx)
+	}
+	return db
 }
 
 // Create 创建记录
@@ -80,13 +122,43 @@ func (g *GORM) Create(model interface{}) error {
 	return g.getRandomDB(true).Create(model).Error
 }
 
+// CreateBatch 批量创建记录，models为模型的切片，每批最多batchSize条
+func (g *GORM) CreateBatch(models interface{}, batchSize int) error {
+	return g.getRandomDB(true).CreateInBatches(models, batchSize).Error
+}
+
+// ScanIDs 按主键顺序分批读取model的所有ID
+func (g *GORM) ScanIDs(model interface{}, batchSize int, fn func(ids []int64) error) error {
+	var last int64
+	for {
+		var ids []int64
+		err := g.getRandomDB(false).Model(model).Where("id > ?", last).Order("id").Limit(batchSize).Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := fn(ids); err != nil {
+			return err
+		}
+		if len(ids) < batchSize {
+			return nil
+		}
+		last = ids[len(ids)-1]
+	}
+}
+
 // Find 查找单条记录，根据主键查找
 func (g *GORM) Find(model interface{}, id interface{}) (interface{}, error) {
 	// 使用从库进行读操作
 	db := g.getRandomDB(true)
 
-	// 执行查找操作
-	err := db.Model(model).Where("id = ?", id).Find(model).Error
+	// 执行查找操作，记录不存在时返回 ErrNotFound
+	err := db.Model(model).Where("id = ?", id).Take(model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,83 @@
+package storage
+
+// cmSketch 4位计数器的Count-Min Sketch，估计键的访问频率
+// 计数总数达到resetAt后所有计数减半，使频率随时间衰减
+type cmSketch struct {
+	rows      [sketchDepth][]uint64 // 每个uint64保存16个4位计数器
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+const sketchDepth = 4
+
+var sketchSeeds = [sketchDepth]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+// sketchWidth 每行计数器个数与元素个数的比例，减少哈希冲突
+const sketchWidth = 8
+
+// newSketch 创建统计约items个元素访问频率的sketch，计数总数达到元素个数的10倍时衰减
+func newSketch(items int) *cmSketch {
+	items = max(items, 1)
+	n := nextPow2(max(items*sketchWidth, 16))
+	s := &cmSketch{mask: uint64(n - 1), resetAt: 10 * items}
+	for i := range s.rows {
+		s.rows[i] = make([]uint64, n/16)
+	}
+	return s
+}
+
+func (s *cmSketch) index(h uint64, row int) (word int, shift uint) {
+	i := mix64(h^sketchSeeds[row]) & s.mask
+	return int(i / 16), uint(i%16) * 4
+}
+
+// increment 记录一次访问
+func (s *cmSketch) increment(h uint64) {
+	for row := range s.rows {
+		word, shift := s.index(h, row)
+		if (s.rows[row][word]>>shift)&0xf < 0xf {
+			s.rows[row][word] += 1 << shift
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// estimate 返回访问频率的估计值
+func (s *cmSketch) estimate(h uint64) uint64 {
+	est := uint64(0xf)
+	for row := range s.rows {
+		word, shift := s.index(h, row)
+		est = min(est, (s.rows[row][word]>>shift)&0xf)
+	}
+	return est
+}
+
+// reset 所有计数器减半
+func (s *cmSketch) reset() {
+	for row := range s.rows {
+		for i, w := range s.rows[row] {
+			s.rows[row][i] = (w >> 1) & 0x7777777777777777
+		}
+	}
+	s.additions /= 2
+}
+
+// mix64 splitmix64的最终混合步骤
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// nextPow2 不小于n的最小的2的幂
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
@@ -0,0 +1,598 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log/slog"
+	"metrics"
+	"mqApi"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteBehindConfig 写回消费者的配置
+type WriteBehindConfig struct {
+	Workers    int           // 按数据ID分片并发写入，同一ID的消息由同一个协程按顺序写入
+	BatchSize  int           // 每次从队列取出的消息数，同一模型连续的创建合并为一次批量插入
+	BatchWait  time.Duration // 凑批的最长等待时间
+	MaxRetries int           // 写入失败后的重试次数，超过后转入死信队列
+	RetryDelay time.Duration // 第一次重试的间隔，之后每次翻倍
+	CacheTTL   time.Duration // 写入缓存中间件的有效期，为0时使用 CacheConfig.RedisTTL
+
+	// DeadLetter 处理最终失败的消息，为nil时发送到RabbitMQ中该队列的死信队列
+	DeadLetter func(queue string, msg mqApi.MqMsg, reason error) error
+}
+
+func DefaultWriteBehindConfig() WriteBehindConfig {
+	return WriteBehindConfig{
+		Workers:    8,
+		BatchSize:  100,
+		BatchWait:  50 * time.Millisecond,
+		MaxRetries: 3,
+		RetryDelay: 100 * time.Millisecond,
+	}
+}
+
+const (
+	// appliedRetention 进程内缓存已写入版本的时长
+	appliedRetention = 10 * time.Minute
+	// appliedTTL 缓存中间件中保存已写入版本的时长，超过后无法再识别重复投递的消息
+	appliedTTL = 24 * time.Hour
+	// applyLockTTL 写入一条数据时持有的锁的有效期
+	applyLockTTL = 10 * time.Second
+	// applyLockWait 等待其他实例写入同一条数据的最长时间，超时后消息重新入队
+	applyLockWait = time.Second
+)
+
+var (
+	// errPermanent 重试也无法成功的错误，直接转入死信队列
+	errPermanent = errors.New("storage: permanent write-behind error")
+	// errBlocked 同一数据之前的消息重新入队，之后的消息也重新入队以保证顺序
+	errBlocked = errors.New("storage: earlier write of the same data was requeued")
+	// errBusy 其他实例正在写入同一条数据
+	errBusy = errors.New("storage: data is being written by another consumer")
+)
+
+// 已写入的版本小于ARGV[1]时更新并返回1
+var markAppliedScript = redis.NewScript(`
+local v = tonumber(redis.call('GET', KEYS[1]) or '0')
+if v < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0
+`)
+
+func permanent(err error) error {
+	return fmt.Errorf("%w: %w", errPermanent, err)
+}
+
+// batchCreator 支持批量插入的ORM
+type batchCreator interface {
+	CreateBatch(models interface{}, batchSize int) error
+}
+
+type appliedKey struct {
+	queue string
+	id    int64
+}
+
+type appliedVersion struct {
+	version int64
+	at      time.Time
+}
+
+// WriteBehind 消费BaseStorage发出的写回消息，将数据写入缓存中间件和数据库
+// 消息手动确认：写入成功、重复或过期的消息被确认，最终失败的消息转入死信队列
+// 缓存中间件为redis时，已写入的版本保存在redis中，多个实例消费同一队列时同一条数据持锁依次写入
+type WriteBehind[T Key] struct {
+	storage *BaseStorage[T]
+	cfg     WriteBehindConfig
+
+	lock    sync.Mutex
+	models  map[string]reflect.Type
+	applied map[appliedKey]appliedVersion // 每个队列中每条数据已写入的最新版本，缓存中间件中版本的本地缓存
+}
+
+func NewWriteBehind[T Key](s *BaseStorage[T], cfg WriteBehindConfig) *WriteBehind[T] {
+	def := DefaultWriteBehindConfig()
+	if cfg.Workers <= 0 {
+		cfg.Workers = def.Workers
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = def.BatchWait
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	return &WriteBehind[T]{
+		storage: s,
+		cfg:     cfg,
+		models:  make(map[string]reflect.Type),
+		applied: make(map[appliedKey]appliedVersion),
+	}
+}
+
+// RegisterModel 注册写入数据库的模型，消息中的数据按 ModelName(model) 还原为该类型
+func (w *WriteBehind[T]) RegisterModel(model interface{}) {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	w.lock.Lock()
+	w.models[t.String()] = t
+	w.lock.Unlock()
+}
+
+// Start 在后台消费midCacheSys和OrmSys队列，直到Stop
+func (w *WriteBehind[T]) Start() {
+	for queue, handler := range map[string]mqApi.BatchHandler{
+		MidCacheQueue: w.HandleCache,
+		OrmQueue:      w.HandleORM,
+	} {
+		go func(queue string, handler mqApi.BatchHandler) {
+			err := w.storage.stMq.ConsumeBatch(queue, w.cfg.BatchSize, w.cfg.BatchWait, handler)
+			if err != nil && !errors.Is(err, mqApi.ErrConsumerStopped) {
+				slog.Error("write-behind consumer stopped", "queue", queue, "error", err)
+			}
+		}(queue, handler)
+	}
+}
+
+// Stop 停止消费并等待处理中的消息确认，可注册为服务 ShutdownConsumers 阶段的退出钩子
+func (w *WriteBehind[T]) Stop(ctx context.Context) error {
+	return w.storage.stMq.StopConsumers(ctx)
+}
+
+// HandleCache 将一批消息写入缓存中间件，是midCacheSys队列的 mqApi.BatchHandler
+func (w *WriteBehind[T]) HandleCache(msgs []mqApi.MqMsg) []error {
+	return w.handle(MidCacheQueue, msgs, w.applyCache, nil)
+}
+
+// HandleORM 将一批消息写入数据库，是OrmSys队列的 mqApi.BatchHandler
+func (w *WriteBehind[T]) HandleORM(msgs []mqApi.MqMsg) []error {
+	return w.handle(OrmQueue, msgs, w.applyORM, w.createBatch)
+}
+
+// writeOp 一条写回消息解析后的写操作
+type writeOp struct {
+	msg  mqApi.MqMsg
+	data STData // 要写入的数据，更新时为新数据
+	err  error  // 解析消息失败的原因
+}
+
+func parseOp(msg mqApi.MqMsg) writeOp {
+	op := writeOp{msg: msg}
+	raw, err := json.Marshal(msg.Data)
+	if err != nil {
+		op.err = permanent(err)
+		return op
+	}
+	switch msg.MsgType {
+	case mqApi.StorageUpdate:
+		// 更新消息的内容为 [旧数据, 新数据]
+		var pair []STData
+		if err := json.Unmarshal(raw, &pair); err != nil || len(pair) != 2 {
+			op.err = permanent(fmt.Errorf("invalid update data: %s", raw))
+			return op
+		}
+		op.data = pair[1]
+		if op.data.ID == 0 {
+			op.data.ID, op.data.Key = pair[0].ID, pair[0].Key
+		}
+		if op.data.Model == "" {
+			op.data.Model = pair[0].Model
+		}
+	case mqApi.StorageCreate, mqApi.StorageDelete:
+		if err := json.Unmarshal(raw, &op.data); err != nil {
+			op.err = permanent(fmt.Errorf("invalid data: %w", err))
+		}
+	default:
+		op.err = permanent(fmt.Errorf("unexpected message type %d", msg.MsgType))
+	}
+	return op
+}
+
+// handle 按数据ID将消息分片，各分片并发、分片内按顺序写入
+func (w *WriteBehind[T]) handle(queue string, msgs []mqApi.MqMsg, apply func(*writeOp) error, batch func([]*writeOp) error) []error {
+	errs := make([]error, len(msgs))
+	ops := make([]writeOp, len(msgs))
+	shards := make([][]int, w.cfg.Workers)
+	for i, msg := range msgs {
+		ops[i] = parseOp(msg)
+		n := uint64(ops[i].data.ID) % uint64(len(shards))
+		shards[n] = append(shards[n], i)
+	}
+	var wg sync.WaitGroup
+	for _, idx := range shards {
+		if len(idx) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(idx []int) {
+			defer wg.Done()
+			w.applyShard(queue, ops, idx, errs, apply, batch)
+		}(idx)
+	}
+	wg.Wait()
+	w.prune()
+	return errs
+}
+
+// applyShard 按顺序写入一个分片的消息，某条数据的消息重新入队后，该数据之后的消息也重新入队
+func (w *WriteBehind[T]) applyShard(queue string, ops []writeOp, idx []int, errs []error, apply func(*writeOp) error, batch func([]*writeOp) error) {
+	blocked := make(map[int64]bool)
+	batchFrom := 0 // 批量插入失败的消息逐条写入，不再尝试批量
+	for i := 0; i < len(idx); i++ {
+		op := &ops[idx[i]]
+		switch {
+		case op.err != nil:
+			errs[idx[i]] = w.fail(queue, op, op.err)
+		case blocked[op.data.ID]:
+			errs[idx[i]] = errBlocked
+			metrics.WriteBehindOps.WithLabelValues(queue, "requeued").Inc()
+		case w.stale(queue, &op.data):
+			slog.Debug("skip duplicate or outdated write", "queue", queue, "id", op.data.ID, "version", op.data.Version)
+			metrics.WriteBehindOps.WithLabelValues(queue, "skipped").Inc()
+		default:
+			if batch != nil && i >= batchFrom {
+				if run := w.createRun(queue, ops, idx[i:], blocked); len(run) > 1 {
+					err := batch(run)
+					if err == nil {
+						for _, op := range run {
+							w.markApplied(queue, &op.data)
+						}
+						metrics.WriteBehindOps.WithLabelValues(queue, "ok").Add(float64(len(run)))
+						i += len(run) - 1
+						continue
+					}
+					slog.Warn("batch insert failed, writing one by one", "queue", queue, "count", len(run), "error", err)
+					batchFrom = i + len(run)
+				}
+			}
+			if errs[idx[i]] = w.applyLocked(queue, op, apply); errs[idx[i]] != nil {
+				blocked[op.data.ID] = true
+			}
+		}
+	}
+}
+
+// createRun 从idx开始连续的、同一模型的、可以合并插入的创建操作
+// 批量插入不加锁，重复的创建由主键冲突去重
+func (w *WriteBehind[T]) createRun(queue string, ops []writeOp, idx []int, blocked map[int64]bool) []*writeOp {
+	var run []*writeOp
+	seen := make(map[int64]bool)
+	for _, i := range idx {
+		op := &ops[i]
+		if len(run) == w.cfg.BatchSize || op.err != nil || op.msg.MsgType != mqApi.StorageCreate ||
+			blocked[op.data.ID] || seen[op.data.ID] || w.stale(queue, &op.data) ||
+			(len(run) > 0 && op.data.Model != run[0].data.Model) {
+			break
+		}
+		seen[op.data.ID] = true
+		run = append(run, op)
+	}
+	return run
+}
+
+// applyLocked 持有该数据的锁写入一条消息，获得锁后再次检查版本，跳过其他实例已经写入的相同或更新的版本
+func (w *WriteBehind[T]) applyLocked(queue string, op *writeOp, apply func(*writeOp) error) error {
+	client := w.storage.redisClient()
+	if client == nil {
+		return w.applyOne(queue, op, apply)
+	}
+	ctx := op.msg.Context(context.Background())
+	lock, err := w.lockData(ctx, client, queue, op.data.ID)
+	if err != nil {
+		slog.Warn("failed to lock data for write-behind, requeued", "queue", queue, "id", op.data.ID, "error", err)
+		metrics.WriteBehindOps.WithLabelValues(queue, "requeued").Inc()
+		return err
+	}
+	defer func() {
+		if err := lock.Unlock(context.WithoutCancel(ctx)); err != nil && !errors.Is(err, ErrLockNotHeld) {
+			slog.Warn("failed to release write-behind lock", "queue", queue, "id", op.data.ID, "error", err)
+		}
+	}()
+	if w.stale(queue, &op.data) {
+		slog.Debug("skip write applied by another consumer", "queue", queue, "id", op.data.ID, "version", op.data.Version)
+		metrics.WriteBehindOps.WithLabelValues(queue, "skipped").Inc()
+		return nil
+	}
+	return w.applyOne(queue, op, apply)
+}
+
+// lockData 获得队列中一条数据的锁，其他实例持有时等待 applyLockWait
+func (w *WriteBehind[T]) lockData(ctx context.Context, client redis.UniversalClient, queue string, id int64) (*RedisLock, error) {
+	deadline := time.Now().Add(applyLockWait)
+	for {
+		lock, err := TryLock(ctx, client, appliedRedisKey(queue, id), applyLockTTL)
+		if err != nil || lock != nil {
+			return lock, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errBusy
+		}
+		time.Sleep(rebuildPoll)
+	}
+}
+
+// applyOne 写入一条消息，失败后按间隔翻倍重试，超过重试次数后转入死信队列
+func (w *WriteBehind[T]) applyOne(queue string, op *writeOp, apply func(*writeOp) error) error {
+	delay := w.cfg.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := apply(op)
+		if err == nil {
+			w.markApplied(queue, &op.data)
+			metrics.WriteBehindOps.WithLabelValues(queue, "ok").Inc()
+			return nil
+		}
+		if errors.Is(err, errPermanent) || attempt >= w.cfg.MaxRetries {
+			return w.fail(queue, op, err)
+		}
+		slog.Warn("write-behind failed, retrying", "queue", queue, "id", op.data.ID, "attempt", attempt+1, "error", err)
+		metrics.WriteBehindOps.WithLabelValues(queue, "retried").Inc()
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// fail 将最终失败的消息转入死信队列，转入失败时返回错误使消息重新入队
+func (w *WriteBehind[T]) fail(queue string, op *writeOp, reason error) error {
+	slog.Error("write-behind failed", "queue", queue, "id", op.data.ID, "model", op.data.Model, "error", reason)
+	deadLetter := w.cfg.DeadLetter
+	if deadLetter == nil && w.storage.stMq != nil {
+		deadLetter = w.storage.stMq.DeadLetter
+	}
+	if deadLetter == nil {
+		metrics.WriteBehindOps.WithLabelValues(queue, "requeued").Inc()
+		return reason
+	}
+	if err := deadLetter(queue, op.msg, reason); err != nil {
+		metrics.WriteBehindOps.WithLabelValues(queue, "requeued").Inc()
+		return fmt.Errorf("dead-letter: %w", err)
+	}
+	metrics.WriteBehindOps.WithLabelValues(queue, "dead").Inc()
+	return nil
+}
+
+// appliedRedisKey 缓存中间件中保存队列中一条数据已写入版本的键
+func appliedRedisKey(queue string, id int64) string {
+	return "applied:" + queue + ":" + strconv.FormatInt(id, 10)
+}
+
+// stale 数据已经写入过相同或更新的版本，先查询本地记录，再查询其他实例写入的版本
+func (w *WriteBehind[T]) stale(queue string, data *STData) bool {
+	w.lock.Lock()
+	v, ok := w.applied[appliedKey{queue, data.ID}]
+	w.lock.Unlock()
+	if ok && data.Version <= v.version {
+		return true
+	}
+	client := w.storage.redisClient()
+	if client == nil {
+		return false
+	}
+	version, err := client.Get(context.Background(), appliedRedisKey(queue, data.ID)).Int64()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Warn("failed to read applied version", "queue", queue, "id", data.ID, "error", err)
+		}
+		return false
+	}
+	return data.Version <= version
+}
+
+func (w *WriteBehind[T]) markApplied(queue string, data *STData) {
+	w.lock.Lock()
+	k := appliedKey{queue, data.ID}
+	if data.Version > w.applied[k].version {
+		w.applied[k] = appliedVersion{version: data.Version, at: time.Now()}
+	}
+	w.lock.Unlock()
+	if client := w.storage.redisClient(); client != nil {
+		err := markAppliedScript.Run(context.Background(), client, []string{appliedRedisKey(queue, data.ID)},
+			data.Version, appliedTTL.Milliseconds()).Err()
+		if err != nil {
+			slog.Warn("failed to save applied version", "queue", queue, "id", data.ID, "error", err)
+		}
+	}
+}
+
+// prune 清理写入超过 appliedRetention 的版本记录
+func (w *WriteBehind[T]) prune() {
+	expired := time.Now().Add(-appliedRetention)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for k, v := range w.applied {
+		if v.at.Before(expired) {
+			delete(w.applied, k)
+		}
+	}
+}
+
+func (w *WriteBehind[T]) applyCache(op *writeOp) error {
+	key, err := dataKey[T](&op.data)
+	if err != nil {
+		return permanent(err)
+	}
+	ctx := op.msg.Context(context.Background())
+	// 正在加载的旧数据不再覆盖写入的数据
+	if err := w.storage.bumpVersions(ctx, key); err != nil {
+		return err
+	}
+	switch op.msg.MsgType {
+	case mqApi.StorageCreate, mqApi.StorageUpdate:
+		value, err := json.Marshal(op.data.Value)
+		if err != nil {
+			return permanent(err)
+		}
+		err = w.storage.writeCache(ctx, key, value, w.cfg.CacheTTL)
+	case mqApi.StorageDelete:
+		err = w.storage.MiddlewareCache.Delete(ctx, key)
+	default:
+		return permanent(fmt.Errorf("unexpected message type %d", op.msg.MsgType))
+	}
+	if err != nil {
+		return err
+	}
+	// 写回前其他实例可能已从缓存中间件读到旧值，再次广播失效
+	return w.storage.Invalidate(ctx, key)
+}
+
+// applyORM 写入数据库后删除缓存中的数据，写入前读取数据库回填的旧值或不存在记录不会保留
+func (w *WriteBehind[T]) applyORM(op *writeOp) error {
+	ctx := op.msg.Context(context.Background())
+	key, err := dataKey[T](&op.data)
+	if err != nil {
+		return permanent(err)
+	}
+	if err := w.writeORM(ctx, op); err != nil {
+		return err
+	}
+	return w.storage.dropCached(ctx, key)
+}
+
+func (w *WriteBehind[T]) writeORM(ctx context.Context, op *writeOp) error {
+	orm := w.storage.orm(ctx)
+	t, err := w.model(op.data.Model)
+	if err != nil {
+		return err
+	}
+	switch op.msg.MsgType {
+	case mqApi.StorageCreate:
+		model, err := decodeModel(t, &op.data)
+		if err != nil {
+			return err
+		}
+		if err := orm.Create(model); err != nil && !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+		// 主键冲突说明是重复投递，已经写入
+		return nil
+	case mqApi.StorageUpdate:
+		model, err := decodeModel(t, &op.data)
+		if err != nil {
+			return err
+		}
+		fields, err := updateFields(model, op.data.Value)
+		if err != nil {
+			return err
+		}
+		return orm.Update(reflect.New(t).Interface(), op.data.ID, fields)
+	case mqApi.StorageDelete:
+		return orm.Delete(reflect.New(t).Interface(), op.data.ID)
+	}
+	return permanent(fmt.Errorf("unexpected message type %d", op.msg.MsgType))
+}
+
+// createBatch 将同一模型的创建操作合并为一次批量插入，ORM不支持时返回错误
+func (w *WriteBehind[T]) createBatch(ops []*writeOp) error {
+	t, err := w.model(ops[0].data.Model)
+	if err != nil {
+		return err
+	}
+	ctx := ops[0].msg.Context(context.Background())
+	orm, ok := w.storage.orm(ctx).(batchCreator)
+	if !ok {
+		return errors.New("storage: orm does not support batch insert")
+	}
+	models := reflect.MakeSlice(reflect.SliceOf(reflect.PointerTo(t)), 0, len(ops))
+	keys := make([]T, len(ops))
+	for i, op := range ops {
+		model, err := decodeModel(t, &op.data)
+		if err != nil {
+			return err
+		}
+		if keys[i], err = dataKey[T](&op.data); err != nil {
+			return err
+		}
+		models = reflect.Append(models, reflect.ValueOf(model))
+	}
+	if err := orm.CreateBatch(models.Interface(), len(ops)); err != nil {
+		return err
+	}
+	return w.storage.dropCached(ctx, keys...)
+}
+
+func (w *WriteBehind[T]) model(name string) (reflect.Type, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	t, ok := w.models[name]
+	if !ok {
+		return nil, permanent(fmt.Errorf("model %q is not registered", name))
+	}
+	return t, nil
+}
+
+// decodeModel 将数据还原为模型指针，模型的ID为零值时使用数据的全局ID
+func decodeModel(t reflect.Type, data *STData) (interface{}, error) {
+	raw, err := json.Marshal(data.Value)
+	if err != nil {
+		return nil, permanent(err)
+	}
+	model := reflect.New(t)
+	if err := json.Unmarshal(raw, model.Interface()); err != nil {
+		return nil, permanent(fmt.Errorf("decode %s: %w", t, err))
+	}
+	if id := model.Elem().FieldByName("ID"); id.IsValid() && id.CanSet() && id.IsZero() {
+		switch id.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			id.SetInt(data.ID)
+		case reflect.Uint, reflect.Uint32, reflect.Uint64:
+			id.SetUint(uint64(data.ID))
+		}
+	}
+	return model.Interface(), nil
+}
+
+// updateFields 返回新数据中出现的字段，键为模型的字段名，不包含主键ID
+func updateFields(model interface{}, value interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, permanent(err)
+	}
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &present); err != nil {
+		return nil, permanent(fmt.Errorf("update data is not an object: %w", err))
+	}
+	fields := make(map[string]interface{})
+	collectFields(reflect.ValueOf(model).Elem(), present, fields)
+	delete(fields, "ID")
+	return fields, nil
+}
+
+// collectFields 按json名称匹配结构体字段，匿名嵌入的结构体（如gorm.Model）展开匹配
+func collectFields(v reflect.Value, present map[string]json.RawMessage, fields map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			collectFields(v.Field(i), present, fields)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := present[name]; ok {
+			fields[f.Name] = v.Field(i).Interface()
+		}
+	}
+}
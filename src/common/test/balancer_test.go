@@ -0,0 +1,211 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"net"
+	ss "service"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startCountingServers 启动n个只注册了健康检查服务的gRPC服务器，并统计每个服务器收到的请求数
+func startCountingServers(t *testing.T, weights []int) ([]resolver.Address, map[string]int, *sync.Mutex) {
+	counts := make(map[string]int)
+	lock := &sync.Mutex{}
+	addrs := make([]resolver.Address, 0, len(weights))
+	for _, weight := range weights {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := lis.Addr().String()
+		server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			lock.Lock()
+			counts[addr]++
+			lock.Unlock()
+			return handler(ctx, req)
+		}))
+		healthpb.RegisterHealthServer(server, health.NewServer())
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+
+		port := lis.Addr().(*net.TCPAddr).Port
+		addrs = append(addrs, ss.NewInstanceAddress(ss.ServiceInfo{Name: "test", Ip: "127.0.0.1", Port: port, Weight: weight}))
+	}
+	return addrs, counts, lock
+}
+
+func dialWithBalancer(t *testing.T, balancerName string, addrs []resolver.Address) *grpc.ClientConn {
+	r := manual.NewBuilderWithScheme("balancertest" + strconv.FormatInt(time.Now().UnixNano(), 36))
+	r.InitialState(resolver.State{Addresses: addrs})
+	conn, err := grpc.NewClient(r.Scheme()+":///test",
+		grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, balancerName)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	addrs, counts, lock := startCountingServers(t, []int{1, 3})
+	conn := dialWithBalancer(t, ss.WeightedRoundRobin, addrs)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 等待所有连接就绪后再开始统计
+	for i := 0; i < 20; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	for k := range counts {
+		counts[k] = 0
+	}
+	lock.Unlock()
+
+	for i := 0; i < 400; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 100, counts[addrs[0].Addr])
+	assert.Equal(t, 300, counts[addrs[1].Addr])
+}
+
+func TestConsistentHash(t *testing.T) {
+	addrs, counts, lock := startCountingServers(t, []int{1, 1, 1})
+	conn := dialWithBalancer(t, ss.ConsistentHash, addrs)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 30; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	for k := range counts {
+		counts[k] = 0
+	}
+	lock.Unlock()
+
+	// 相同的用户ID总是落到同一个实例上
+	userCtx := ss.WithHashKey(ctx, "10086")
+	for i := 0; i < 50; i++ {
+		_, err := client.Check(userCtx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	hit := 0
+	for _, c := range counts {
+		if c > 0 {
+			hit++
+			assert.Equal(t, 50, c)
+		}
+	}
+	assert.Equal(t, 1, hit)
+}
+
+func TestConsistentHashWeight(t *testing.T) {
+	// Kong中常见的权重100和300，虚拟节点按相对权重分配
+	addrs, counts, lock := startCountingServers(t, []int{100, 300})
+	conn := dialWithBalancer(t, ss.ConsistentHash, addrs)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 20; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	for k := range counts {
+		counts[k] = 0
+	}
+	lock.Unlock()
+
+	for i := 0; i < 2000; i++ {
+		_, err := client.Check(ss.WithHashKey(ctx, strconv.Itoa(i)), &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	assert.InDelta(t, 500, counts[addrs[0].Addr], 250)
+	assert.InDelta(t, 1500, counts[addrs[1].Addr], 250)
+}
+
+func TestLeastRequest(t *testing.T) {
+	addrs, counts, lock := startCountingServers(t, []int{1, 1})
+	conn := dialWithBalancer(t, ss.LeastRequest, addrs)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 100; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 100, counts[addrs[0].Addr]+counts[addrs[1].Addr])
+}
+
+func TestResolverWeight(t *testing.T) {
+	addrs, counts, lock := startCountingServers(t, []int{1, 0, 2})
+	discovery, err := ss.NewServiceDiscovery([]string{"127.0.0.1:0"})
+	assert.NoError(t, err)
+	defer discovery.Close()
+	for i, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr.Addr)
+		p, _ := strconv.Atoi(port)
+		weight := []int{1, 0, 2}[i]
+		registerInstance(t, discovery, ss.ServiceInfo{Name: "test", InstanceId: addr.Addr, Ip: host, Port: p, Weight: weight})
+	}
+	// 不提供传输凭证时拒绝连接，不会默认使用明文
+	_, err = ss.DialService(discovery, "test", "")
+	assert.Error(t, err)
+	conn, err := ss.DialService(discovery, "test", "", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 30; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	for k := range counts {
+		counts[k] = 0
+	}
+	lock.Unlock()
+
+	// 权重为0的实例不接收请求
+	for i := 0; i < 300; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 100, counts[addrs[0].Addr])
+	assert.Zero(t, counts[addrs[1].Addr])
+	assert.Equal(t, 200, counts[addrs[2].Addr])
+}
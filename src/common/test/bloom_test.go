@@ -0,0 +1,172 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"storage"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBloomParams(t *testing.T) {
+	bits, hashes := storage.BloomParams(1000, 0.01)
+	assert.Equal(t, uint64(9586), bits)
+	assert.Equal(t, 7, hashes)
+}
+
+// bloomFalsePositives 添加n个键后，统计另外n个不存在的键的误判率
+func bloomFalsePositives(t *testing.T, b storage.BloomFilter, n int) float64 {
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		assert.NoError(t, b.Add(ctx, fmt.Sprintf("product:%d", i)))
+	}
+	for i := 0; i < n; i++ {
+		ok, err := b.MightContain(ctx, fmt.Sprintf("product:%d", i))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if ok, _ := b.MightContain(ctx, fmt.Sprintf("product:%d", i)); ok {
+			fp++
+		}
+	}
+	return float64(fp) / float64(n)
+}
+
+// emptyFill 不添加任何键的重建
+func emptyFill(add func(keys ...string) error) error {
+	return nil
+}
+
+func TestMemoryBloom(t *testing.T) {
+	ctx := context.Background()
+	b := storage.NewMemoryBloom(2000, 0.01)
+	// 首次重建前认为所有键都可能存在
+	ok, _ := b.MightContain(ctx, "unknown")
+	assert.True(t, ok)
+	assert.NoError(t, b.Rebuild(ctx, emptyFill))
+	assert.Less(t, bloomFalsePositives(t, b, 2000), 0.03)
+
+	// 重建后只包含新的键，重建期间添加的键不会丢失
+	err := b.Rebuild(ctx, func(add func(keys ...string) error) error {
+		assert.NoError(t, b.Add(ctx, "added-during-rebuild"))
+		return add("a", "b")
+	})
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b", "added-during-rebuild"} {
+		ok, _ := b.MightContain(ctx, key)
+		assert.True(t, ok, key)
+	}
+	ok, _ = b.MightContain(ctx, "product:1")
+	assert.False(t, ok)
+
+	// 可能错过新增的键后不再过滤，直到下一次重建
+	b.MarkStale()
+	ok, _ = b.MightContain(ctx, "product:1")
+	assert.True(t, ok)
+}
+
+func TestRedisBloom(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := storage.NewRedisCache(mr.Addr(), "", 0).Client()
+	b := storage.NewRedisBloom(client, "bloom:product", 500, 0.01)
+	ctx := context.Background()
+	// 首次重建前认为所有键都可能存在，添加的键不会创建位图
+	assert.NoError(t, b.Add(ctx, "early"))
+	assert.False(t, mr.Exists("bloom:product"))
+	ok, _ := b.MightContain(ctx, "unknown")
+	assert.True(t, ok)
+	assert.NoError(t, b.Rebuild(ctx, emptyFill))
+	assert.Less(t, bloomFalsePositives(t, b, 500), 0.03)
+
+	err := b.Rebuild(ctx, func(add func(keys ...string) error) error {
+		assert.NoError(t, b.Add(ctx, "added-during-rebuild"))
+		return add("a", "b")
+	})
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b", "added-during-rebuild"} {
+		ok, _ := b.MightContain(ctx, key)
+		assert.True(t, ok, key)
+	}
+	ok, _ = b.MightContain(ctx, "product:1")
+	assert.False(t, ok)
+	// 重建中的位图使用hash tag，与位图在redis集群的同一个slot
+	assert.False(t, mr.Exists("{bloom:product}:rebuild"))
+}
+
+func TestBloomBroadcast(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newInvalidated(t, mr, storage.GapFlush)
+	bloom := storage.NewMemoryBloom(100, 0.01)
+	s.Bloom = bloom
+	ctx := context.Background()
+	assert.NoError(t, bloom.Rebuild(ctx, emptyFill))
+	waitSubscribed(t, mr, 1)
+
+	// 其他实例新增的键随失效通知加入本实例的布隆过滤器
+	bus := storage.NewRedisInvalidationBus(storage.NewRedisCache(mr.Addr(), "", 0).Client(), "cache:invalidate")
+	assert.NoError(t, bus.Publish(ctx, storage.Invalidation{Keys: []string{"p1"}, Source: "other"}))
+	assert.NoError(t, bus.Publish(ctx, storage.Invalidation{Keys: []string{"p2"}, Source: "other", Created: true}))
+	assert.Eventually(t, func() bool {
+		ok, _ := bloom.MightContain(ctx, "p2")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	ok, _ := bloom.MightContain(ctx, "p1")
+	assert.False(t, ok)
+
+	// 重新订阅后可能错过了新增的键，不再过滤
+	mr.Close()
+	assert.NoError(t, mr.Restart())
+	assert.Eventually(t, func() bool {
+		ok, _ := bloom.MightContain(ctx, "p1")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCachePenetration(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newCacheAside(t, mr, storage.DefaultCacheConfig())
+	orm := newFakeORM()
+	orm.rows[1] = wbProduct{ID: 1, Name: "apple"}
+	var finds atomic.Int32
+	ctx := context.Background()
+	loader := func(id int64) storage.Loader {
+		return func(ctx context.Context) (interface{}, error) {
+			finds.Add(1)
+			var p wbProduct
+			return orm.Find(&p, id)
+		}
+	}
+
+	// 不存在的数据缓存为空对象，之后的读取不再访问数据库
+	var p wbProduct
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, s.Load(ctx, "p404", &p, loader(404)), storage.ErrNotFound)
+	}
+	assert.Equal(t, int32(1), finds.Load())
+	assert.InDelta(t, float64(30*time.Second), float64(mr.TTL("p404")), float64(time.Second))
+	// 本地缓存过期后由缓存中间件中的空对象返回
+	assert.NoError(t, s.LocalCache.Delete(ctx, "p404"))
+	assert.ErrorIs(t, s.Load(ctx, "p404", &p, loader(404)), storage.ErrNotFound)
+	assert.Equal(t, int32(1), finds.Load())
+
+	// 布隆过滤器中不存在的键不访问缓存中间件和数据库
+	s.Bloom = storage.NewMemoryBloom(100, 0.01)
+	s.ORM = orm
+	assert.NoError(t, s.RebuildBloom(ctx, &wbProduct{}))
+	for i := 0; i < 100; i++ {
+		err := s.Load(ctx, fmt.Sprintf("scraper-%d", i), &p, loader(int64(1000+i)))
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+	assert.Equal(t, int32(1), finds.Load())
+	assert.False(t, mr.Exists("scraper-1"))
+
+	// 存在的数据按全局ID通过过滤器
+	data := newWbData(t, 1, wbProduct{})
+	assert.NoError(t, s.Get(ctx, data))
+	assert.Equal(t, "apple", data.Value.(*wbProduct).Name)
+}
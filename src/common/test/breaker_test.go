@@ -0,0 +1,165 @@
+package test
+
+import (
+	"breaker"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestBreakerStateMachine(t *testing.T) {
+	var events []breaker.Event
+	b := breaker.NewBreaker("stock", breaker.Settings{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         50 * time.Millisecond,
+		HalfOpenRequests:    2,
+	}, func(e breaker.Event) { events = append(events, e) })
+	fail := errors.New("fail")
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fail, b.Execute(func() error { return fail }))
+	}
+	assert.Equal(t, breaker.StateOpen, b.State())
+	assert.ErrorIs(t, b.Execute(func() error { return nil }), breaker.ErrOpen)
+
+	// 超时后进入半开，只放行有限的探测请求
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, breaker.StateHalfOpen, b.State())
+	done1, err := b.Allow()
+	assert.NoError(t, err)
+	done2, err := b.Allow()
+	assert.NoError(t, err)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, breaker.ErrTooManyRequests)
+	done1(true)
+	done2(true)
+	assert.Equal(t, breaker.StateClosed, b.State())
+
+	// 半开状态下探测失败重新打开
+	for i := 0; i < 3; i++ {
+		b.Execute(func() error { return fail })
+	}
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, fail, b.Execute(func() error { return fail }))
+	assert.Equal(t, breaker.StateOpen, b.State())
+
+	var transitions []string
+	for _, e := range events {
+		transitions = append(transitions, e.From.String()+"->"+e.To.String())
+	}
+	assert.Equal(t, []string{
+		"closed->open", "open->half-open", "half-open->closed",
+		"closed->open", "open->half-open", "half-open->open",
+	}, transitions)
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	b := breaker.NewBreaker("ratio", breaker.Settings{MinRequests: 10, FailureRatio: 0.5}, nil)
+	fail := errors.New("fail")
+	// 请求数不足时不熔断
+	for i := 0; i < 5; i++ {
+		b.Execute(func() error { return fail })
+	}
+	assert.Equal(t, breaker.StateClosed, b.State())
+	for i := 0; i < 4; i++ {
+		b.Execute(func() error { return nil })
+	}
+	assert.Equal(t, breaker.StateClosed, b.State())
+	b.Execute(func() error { return fail })
+	assert.Equal(t, breaker.StateOpen, b.State())
+}
+
+func TestBackoff(t *testing.T) {
+	b := breaker.Backoff{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+	assert.Equal(t, 10*time.Millisecond, b.Next(0))
+	assert.Equal(t, 40*time.Millisecond, b.Next(2))
+	assert.Equal(t, 50*time.Millisecond, b.Next(5))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Next(1)
+		assert.True(t, d > 10*time.Millisecond && d <= 20*time.Millisecond)
+	}
+}
+
+func TestBreakerInterceptors(t *testing.T) {
+	cc, err := grpc.NewClient("passthrough:///stock-service", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	g := breaker.NewGroup(breaker.Settings{ConsecutiveFailures: 2, OpenTimeout: time.Minute})
+	events, cancel := g.Subscribe()
+	defer cancel()
+	policy := breaker.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     breaker.Backoff{Base: time.Millisecond},
+		Idempotent:  breaker.MethodPrefixes("Get"),
+	}
+	retry := breaker.RetryUnaryClientInterceptor(policy)
+	cb := breaker.UnaryClientInterceptor(g, nil)
+
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.Unavailable, "stock-service down")
+	}
+	call := func(ctx context.Context, method string) error {
+		return retry(ctx, method, nil, nil, cc, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return cb(ctx, method, req, reply, cc, invoker, opts...)
+		})
+	}
+
+	// 非幂等方法不重试
+	assert.Equal(t, codes.Unavailable, status.Code(call(context.Background(), "/stock.StockService/DeductStock")))
+	assert.Equal(t, 1, calls)
+
+	// 幂等方法重试，熔断器打开后停止重试
+	calls = 0
+	err = call(context.Background(), "/stock.StockService/GetStock")
+	assert.Equal(t, 2, calls)
+	assert.True(t, breaker.IsRejected(err))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	select {
+	case e := <-events:
+		assert.Equal(t, "passthrough:///stock-service/stock.StockService/GetStock", e.Name)
+		assert.Equal(t, breaker.StateOpen, e.To)
+	case <-time.After(time.Second):
+		t.Fatal("no breaker event")
+	}
+	assert.Equal(t, breaker.StateOpen, g.States()["passthrough:///stock-service/stock.StockService/GetStock"])
+	assert.Equal(t, breaker.StateClosed, g.States()["passthrough:///stock-service/stock.StockService/DeductStock"])
+
+	// 显式标记为幂等的调用也会重试，业务错误不计入熔断
+	calls = 0
+	invoker = func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.NotFound, "no such product")
+	}
+	for i := 0; i < 3; i++ {
+		err = call(breaker.WithIdempotent(context.Background(), true), "/stock.StockService/ReserveStock")
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, breaker.StateClosed, g.States()["passthrough:///stock-service/stock.StockService/ReserveStock"])
+
+	// 被限流的请求默认不重试，也不计入熔断
+	calls = 0
+	invoker = func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	retry = breaker.RetryUnaryClientInterceptor(breaker.DefaultRetryPolicy)
+	for i := 0; i < 3; i++ {
+		err = call(context.Background(), "/stock.StockService/GetLimited")
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, breaker.StateClosed, g.States()["passthrough:///stock-service/stock.StockService/GetLimited"])
+}
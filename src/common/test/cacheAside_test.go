@@ -0,0 +1,224 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"mqApi"
+	"storage"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCacheAside(t *testing.T, mr *miniredis.Miniredis, cfg storage.CacheConfig) *storage.BaseStorage[string] {
+	local := storage.NewCache[string](100, time.Minute)
+	t.Cleanup(local.Stop)
+	s := &storage.BaseStorage[string]{LocalCache: local, MiddlewareCache: storage.NewRedisCache(mr.Addr(), "", 0)}
+	s.SetCacheConfig(cfg)
+	return s
+}
+
+func TestCacheAsideLoad(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newCacheAside(t, mr, storage.DefaultCacheConfig())
+	ctx := context.Background()
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		loads.Add(1)
+		return wbProduct{ID: 1, Name: "apple"}, nil
+	}
+
+	var p wbProduct
+	assert.NoError(t, s.Load(ctx, "p1", &p, loader))
+	assert.Equal(t, "apple", p.Name)
+	// 回填缓存中间件，TTL为随机浮动的有效期加上旧值保留时间
+	assert.True(t, mr.Exists("p1"))
+	assert.InDelta(t, float64(11*time.Minute), float64(mr.TTL("p1")), float64(time.Minute))
+	assert.False(t, mr.Exists("lock:{p1}"))
+
+	// 命中本地缓存
+	assert.NoError(t, s.Load(ctx, "p1", &p, loader))
+	// 本地缓存未命中时命中缓存中间件
+	assert.NoError(t, s.LocalCache.Delete(ctx, "p1"))
+	assert.NoError(t, s.Load(ctx, "p1", &p, loader))
+	assert.Equal(t, int32(1), loads.Load())
+
+	// 按全局ID从数据库读取
+	orm := newFakeORM()
+	orm.rows[2] = wbProduct{ID: 2, Name: "banana"}
+	s.ORM = orm
+	data := newWbData(t, 2, wbProduct{})
+	assert.NoError(t, s.Get(ctx, data))
+	assert.Equal(t, "banana", data.Value.(*wbProduct).Name)
+	assert.ErrorIs(t, s.Get(ctx, newWbData(t, 3, wbProduct{})), storage.ErrNotFound)
+}
+
+func TestCacheAsideSingleflight(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := storage.DefaultCacheConfig()
+	// 两个进程共享缓存中间件，各自有本地缓存
+	s1, s2 := newCacheAside(t, mr, cfg), newCacheAside(t, mr, cfg)
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		loads.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return wbProduct{ID: 1, Name: "hot"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		s := s1
+		if i%2 == 1 {
+			s = s2
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var p wbProduct
+			assert.NoError(t, s.Load(context.Background(), "hot", &p, loader))
+			assert.Equal(t, "hot", p.Name)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestCacheAsideStale(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newCacheAside(t, mr, storage.CacheConfig{
+		LocalTTL: 30 * time.Millisecond,
+		RedisTTL: 30 * time.Millisecond,
+		StaleTTL: time.Minute,
+		LockTTL:  time.Second,
+		LockWait: time.Second,
+	})
+	ctx := context.Background()
+	var p wbProduct
+	assert.NoError(t, s.Load(ctx, "p1", &p, func(ctx context.Context) (interface{}, error) {
+		return wbProduct{Name: "v1"}, nil
+	}))
+
+	time.Sleep(50 * time.Millisecond)
+	release := make(chan struct{})
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		loads.Add(1)
+		<-release
+		return wbProduct{Name: "v2"}, nil
+	}
+	// 过期后返回旧值，后台只有一个重建
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Load(ctx, "p1", &p, loader))
+		assert.Equal(t, "v1", p.Name)
+	}
+	close(release)
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, s.Load(ctx, "p1", &p, loader))
+		return p.Name == "v2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestRedisLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := storage.NewRedisCache(mr.Addr(), "", 0).Client()
+	ctx := context.Background()
+
+	l1, err := storage.TryLock(ctx, client, "p1", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), l1.Token())
+	// 锁、fencing计数器和数据版本使用hash tag，与数据在redis集群的同一个slot
+	assert.True(t, mr.Exists("lock:{p1}"))
+	assert.True(t, mr.Exists("lock:{p1}:fence"))
+	l, err := storage.TryLock(ctx, client, "p1", time.Second)
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+
+	// l1过期后其他持有者加锁，l1不能再写入或释放
+	mr.FastForward(time.Second)
+	l2, err := storage.TryLock(ctx, client, "p1", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), l2.Token())
+	assert.ErrorIs(t, l1.SetFenced(ctx, "old", time.Minute, 0), storage.ErrLockNotHeld)
+	assert.NoError(t, l2.SetFenced(ctx, "new", time.Minute, 0))
+	value, _ := mr.Get("p1")
+	assert.Equal(t, "new", value)
+
+	// 读取数据源之后数据被写入，不再写入读到的旧值
+	version, err := storage.DataVersion(ctx, client, "p1")
+	assert.NoError(t, err)
+	assert.NoError(t, storage.BumpVersion(ctx, client, "p1"))
+	assert.True(t, mr.Exists("{p1}:version"))
+	assert.ErrorIs(t, l2.SetFenced(ctx, "stale", time.Minute, version), storage.ErrVersionChanged)
+	assert.ErrorIs(t, storage.SetIfVersion(ctx, client, "p1", "stale", time.Minute, version), storage.ErrVersionChanged)
+	assert.NoError(t, storage.SetIfVersion(ctx, client, "p1", "newer", time.Minute, version+1))
+	value, _ = mr.Get("p1")
+	assert.Equal(t, "newer", value)
+
+	assert.ErrorIs(t, l1.Unlock(ctx), storage.ErrLockNotHeld)
+	assert.True(t, mr.Exists("lock:{p1}"))
+	assert.NoError(t, l2.Unlock(ctx))
+	assert.False(t, mr.Exists("lock:{p1}"))
+}
+
+func TestCacheAsideWriteRace(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := storage.DefaultCacheConfig()
+	cfg.RedeleteDelay = 50 * time.Millisecond
+	s := newCacheAside(t, mr, cfg)
+	orm := newFakeORM()
+	orm.rows[1] = wbProduct{ID: 1, Name: "apple", Price: 1}
+	s.ORM = orm
+	w := storage.NewWriteBehind(s, storage.WriteBehindConfig{Workers: 1})
+	w.RegisterModel(wbProduct{})
+	ctx := context.Background()
+	data := newWbData(t, 1, wbProduct{})
+	update := newWbData(t, 1, wbProduct{})
+	update.Value = map[string]interface{}{"price": 2}
+
+	// 加载读到旧数据后数据库被写入，旧数据不回填
+	loading, release := make(chan struct{}), make(chan struct{})
+	var p wbProduct
+	go func() {
+		<-loading
+		assert.Equal(t, []error{nil}, w.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageUpdate, 1, data, update)}))
+		close(release)
+	}()
+	assert.NoError(t, s.Load(ctx, data.Key, &p, func(ctx context.Context) (interface{}, error) {
+		old := orm.rows[1]
+		close(loading)
+		<-release
+		return old, nil
+	}))
+	assert.Equal(t, float64(1), p.Price)
+	assert.False(t, mr.Exists(data.Key))
+	assert.NoError(t, s.Get(ctx, data))
+	assert.Equal(t, float64(2), data.Value.(*wbProduct).Price)
+
+	// 创建写入前读到的不存在记录不回填
+	created := newWbData(t, 2, wbProduct{Name: "banana"})
+	loading, release = make(chan struct{}), make(chan struct{})
+	go func() {
+		<-loading
+		assert.Equal(t, []error{nil}, w.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageCreate, 1, created)}))
+		close(release)
+	}()
+	err := s.Load(ctx, created.Key, &p, func(ctx context.Context) (interface{}, error) {
+		close(loading)
+		<-release
+		return nil, storage.ErrNotFound
+	})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.False(t, mr.Exists(created.Key))
+	assert.NoError(t, s.Get(ctx, created))
+	assert.Equal(t, "banana", created.Value.(*wbProduct).Name)
+
+	// 从库延迟期间回填的旧数据在延迟后再次删除
+	stale, _ := json.Marshal(map[string]interface{}{"v": map[string]interface{}{"name": "stale"}, "e": time.Now().Add(time.Minute).UnixMilli()})
+	assert.Equal(t, []error{nil}, w.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageUpdate, 2, data, update)}))
+	mr.Set(data.Key, string(stale))
+	assert.Eventually(t, func() bool { return !mr.Exists(data.Key) }, time.Second, 10*time.Millisecond)
+}
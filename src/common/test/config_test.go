@@ -0,0 +1,296 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	k "kongApi"
+	"kongApi/kongtest"
+	ss "service"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestConfig() *ss.ServiceConfig {
+	return &ss.ServiceConfig{
+		Info: ss.ServiceInfo{
+			Name:        "product",
+			Ip:          "127.0.0.1",
+			Port:        50001,
+			HttpPort:    50002,
+			Weight:      100,
+			RoutesName:  "product-route",
+			Protocol:    "http",
+			HealthPath:  "/health",
+			ServicePath: "/products",
+			Paths:       []string{"/service/products"},
+		},
+		Database: ss.DatabaseConfig{
+			DSN: "root:root@tcp(127.0.0.1:3307)/msmall",
+		},
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := newTestConfig()
+
+	same := newTestConfig()
+	assert.Equal(t, ss.ConfigChange(0), ss.DiffConfig(old, same))
+
+	grpcPort := newTestConfig()
+	grpcPort.Info.Port = 50003
+	change := ss.DiffConfig(old, grpcPort)
+	assert.True(t, change.Has(ss.ChangeGrpc))
+	assert.True(t, change.Has(ss.ChangeGateway))
+	assert.True(t, change.Has(ss.ChangeKong))
+	assert.False(t, change.Has(ss.ChangeDatabase))
+
+	paths := newTestConfig()
+	paths.Info.Paths = append(paths.Info.Paths, "/service/productsB")
+	change = ss.DiffConfig(old, paths)
+	assert.Equal(t, ss.ChangeKong|ss.ChangeEtcd, change)
+	assert.Equal(t, "kong|etcd", change.String())
+
+	db := newTestConfig()
+	db.Database.MaxOpenConns = 10
+	assert.Equal(t, ss.ChangeDatabase, ss.DiffConfig(old, db))
+
+	level := newTestConfig()
+	level.Log.Level = "debug"
+	assert.Equal(t, "log", ss.DiffConfig(old, level).String())
+
+	checks := newTestConfig()
+	hc := k.DefaultHealthChecks("/health")
+	checks.Info.HealthChecks = &hc
+	assert.Equal(t, "kong|etcd", ss.DiffConfig(old, checks).String())
+
+	timeout := newTestConfig()
+	timeout.RequestTimeout = 5
+	assert.Equal(t, ss.ChangeMiddleware, ss.DiffConfig(old, timeout))
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := newTestConfig()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Info.HttpPort = cfg.Info.Port
+	assert.Error(t, cfg.Validate())
+
+	cfg = newTestConfig()
+	cfg.Info.Name = ""
+	assert.Error(t, cfg.Validate())
+
+	cfg = newTestConfig()
+	cfg.Info.Port = 70000
+	assert.Error(t, cfg.Validate())
+
+	cfg = newTestConfig()
+	cfg.Tracing = ss.TracingConfig{Exporter: ss.TracingExporterOTLP}
+	assert.Error(t, cfg.Validate())
+	cfg.Tracing.Endpoint = "http://127.0.0.1:4318"
+	assert.NoError(t, cfg.Validate())
+	cfg.Tracing.Exporter = "jaeger"
+	assert.Error(t, cfg.Validate())
+
+	cfg = newTestConfig()
+	cfg.Log.Level = "debug"
+	assert.NoError(t, cfg.Validate())
+	cfg.Log.Level = "verbose"
+	assert.Error(t, cfg.Validate())
+
+	cfg = newTestConfig()
+	hc := k.DefaultHealthChecks("/health")
+	cfg.Info.HealthChecks = &hc
+	assert.NoError(t, cfg.Validate())
+	hc.Passive.Unhealthy.Interval = 3
+	assert.Error(t, cfg.Validate())
+	hc.Passive.Unhealthy.Interval = 0
+	hc.Active.Type = "udp"
+	assert.Error(t, cfg.Validate())
+}
+
+func TestConfigCenter(t *testing.T) {
+	cli, etcd := newFakeEtcdClient(t)
+	cc := ss.NewConfigCenterFromClient(cli)
+	ctx := context.Background()
+	key := ss.ConfigKey("product", "default")
+
+	// 修订版本为0时只在配置不存在时写入，之后按修订版本做乐观锁校验
+	cfg := newTestConfig()
+	rev1, err := cc.Put(ctx, key, cfg)
+	assert.NoError(t, err)
+	_, err = cc.Put(ctx, key, cfg)
+	assert.ErrorIs(t, err, ss.ErrConfigConflict)
+	cfg.Info.Weight = 50
+	_, err = cc.Put(ctx, key, &ss.ServiceConfig{Info: cfg.Info, Revision: rev1 - 1})
+	assert.ErrorIs(t, err, ss.ErrConfigConflict)
+	cfg.Revision = rev1
+	rev2, err := cc.Put(ctx, key, cfg)
+	assert.NoError(t, err)
+	cfg.Revision = rev1
+	_, err = cc.Put(ctx, key, cfg)
+	assert.ErrorIs(t, err, ss.ErrConfigConflict)
+
+	// 回滚产生新的修订版本，历史从新到旧
+	rev3, err := cc.Rollback(ctx, key, rev1)
+	assert.NoError(t, err)
+	current, err := cc.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, current.Info.Weight)
+	assert.Equal(t, rev3, current.Revision)
+	history, err := cc.History(ctx, key, 10)
+	assert.NoError(t, err)
+	var revs []int64
+	for _, h := range history {
+		revs = append(revs, h.Revision)
+	}
+	assert.Equal(t, []int64{rev3, rev2, rev1}, revs)
+
+	// 已被压缩的版本不再返回
+	etcd.Compact(ctx, rev2)
+	history, err = cc.History(ctx, key, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	_, err = cc.Rollback(ctx, key, rev1)
+	assert.Error(t, err)
+}
+
+func TestConfigWatchResume(t *testing.T) {
+	cli, etcd := newFakeEtcdClient(t)
+	cc := ss.NewConfigCenterFromClient(cli)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := ss.ConfigKey("product", "default")
+	cfg := newTestConfig()
+	rev, err := cc.Put(ctx, key, cfg)
+	assert.NoError(t, err)
+
+	configs := cc.Watch(ctx, key, rev+1)
+	put := func(weight int) {
+		current, err := cc.Get(ctx, key)
+		assert.NoError(t, err)
+		current.Info.Weight = weight
+		_, err = cc.Put(ctx, key, current)
+		assert.NoError(t, err)
+	}
+	next := func() int {
+		select {
+		case cfg := <-configs:
+			return cfg.Info.Weight
+		case <-time.After(3 * time.Second):
+			t.Fatal("config not received")
+			return 0
+		}
+	}
+	put(10)
+	assert.Equal(t, 10, next())
+	// 断开期间的修改在重新监听后从上次的版本继续收到
+	etcd.disconnect()
+	put(20)
+	put(30)
+	assert.Equal(t, 20, next())
+	assert.Equal(t, 30, next())
+}
+
+func TestLoadConfigIdentity(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	cli, _ := newFakeEtcdClient(t)
+	cc := ss.NewConfigCenterFromClient(cli)
+	newReplica := func(ip string) *ss.Service {
+		info := newTestConfig().Info
+		info.Ip = ip
+		info.Plugins = []k.Plugin{{Name: "cors"}}
+		s, err := ss.NewService(&info)
+		assert.NoError(t, err)
+		s.Kong = k.NewClient(kong.URL)
+		s.SetConfigCenter(cc)
+		return s
+	}
+	// 两个副本共享默认的配置key
+	s1, s2 := newReplica("10.0.0.1"), newReplica("10.0.0.2")
+	assert.NoError(t, s1.LoadConfig(""))
+	stored, err := cc.Get(context.Background(), ss.ConfigKey("product", "default"))
+	assert.NoError(t, err)
+	assert.Empty(t, stored.Info.Ip)
+	assert.Empty(t, stored.Info.InstanceId)
+	assert.NoError(t, s2.LoadConfig(""))
+	assert.Equal(t, "10.0.0.2", s2.Info().Ip)
+	assert.Equal(t, "10.0.0.2:50001", s2.Info().InstanceId)
+	// 代码中声明的插件不保存在配置中，加载后保留
+	assert.Equal(t, []k.Plugin{{Name: "cors"}}, s2.Info().Plugins)
+
+	// 热更新时同样保留本实例的身份，更新期间可以并发读取服务信息
+	pushed := newTestConfig()
+	pushed.Info.Ip, pushed.Info.Port, pushed.Info.InstanceId = "10.0.0.1", 60001, "10.0.0.1:60001"
+	pushed.Info.Weight = 30
+	pushed.Middlewares = map[string]bool{"recovery": true}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = s2.Info().Weight
+			}
+		}
+	}()
+	assert.NoError(t, s2.ApplyConfig(ss.NewServiceManager(s2), pushed))
+	close(stop)
+	wg.Wait()
+	info := s2.Info()
+	assert.Equal(t, "10.0.0.2", info.Ip)
+	assert.Equal(t, 50001, info.Port)
+	assert.Equal(t, "10.0.0.2:50001", info.InstanceId)
+	assert.Equal(t, 30, info.Weight)
+	assert.Equal(t, []k.Plugin{{Name: "cors"}}, info.Plugins)
+	target, err := s2.Kong.GetTarget(context.Background(), "product", "10.0.0.2:50002")
+	assert.NoError(t, err)
+	assert.Equal(t, 30, target.Weight)
+	_, err = s2.Kong.GetTarget(context.Background(), "product", "10.0.0.1:50002")
+	assert.Error(t, err)
+}
+
+func TestApplyConfigRequestTimeout(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	cli, _ := newFakeEtcdClient(t)
+	info := newTestConfig().Info
+	s, err := ss.NewService(&info)
+	assert.NoError(t, err)
+	s.Kong = k.NewClient(kong.URL)
+	s.SetConfigCenter(ss.NewConfigCenterFromClient(cli))
+	assert.NoError(t, s.LoadConfig(""))
+
+	// 返回服务端处理请求时的剩余时间
+	remaining := func() time.Duration {
+		var left time.Duration
+		_, err := s.Middlewares.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Test/Get"},
+			func(ctx context.Context, req any) (any, error) {
+				deadline, ok := ctx.Deadline()
+				assert.True(t, ok)
+				left = time.Until(deadline)
+				return nil, nil
+			})
+		assert.NoError(t, err)
+		return left
+	}
+	// 未配置时使用默认的超时时间
+	assert.InDelta(t, float64(ss.DefaultRequestTimeout*time.Second), float64(remaining()), float64(time.Second))
+
+	// 热更新超时时间后立即生效，中间件开关保持不变
+	enabled := s.Middlewares.Enabled()
+	pushed := newTestConfig()
+	pushed.RequestTimeout = 2
+	assert.NoError(t, s.ApplyConfig(ss.NewServiceManager(s), pushed))
+	assert.InDelta(t, float64(2*time.Second), float64(remaining()), float64(time.Second))
+	assert.Equal(t, enabled, s.Middlewares.Enabled())
+
+	pushed.RequestTimeout = -1
+	assert.Error(t, s.ApplyConfig(ss.NewServiceManager(s), pushed))
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	ss "service"
+	"testing"
+	"time"
+)
+
+func putInstance(t *testing.T, cli *clientv3.Client, info ss.ServiceInfo) {
+	val, _ := json.Marshal(info)
+	_, err := cli.Put(context.Background(), ss.ServiceKey(info.Name, info.InstanceId), string(val))
+	assert.NoError(t, err)
+}
+
+// nextEvent 等待下一个事件，超时返回false
+func nextEvent(ch <-chan ss.ServiceEvent) (ss.ServiceEvent, bool) {
+	select {
+	case ev := <-ch:
+		return ev, true
+	case <-time.After(300 * time.Millisecond):
+		return ss.ServiceEvent{}, false
+	}
+}
+
+func watchFakeEtcd(t *testing.T) (*ss.ServiceDiscovery, *clientv3.Client, *fakeEtcd) {
+	cli, etcd := newFakeEtcdClient(t)
+	discovery := ss.NewServiceDiscoveryFromClient(cli)
+	t.Cleanup(func() { discovery.Close() })
+	assert.NoError(t, discovery.WatchService(ss.ServicePrefix))
+	assert.Eventually(t, func() bool { return etcd.watching() == 1 }, time.Second, 10*time.Millisecond)
+	return discovery, cli, etcd
+}
+
+func TestDiscoverySubscribe(t *testing.T) {
+	discovery, cli, _ := watchFakeEtcd(t)
+	events, cancel := discovery.Subscribe("product")
+	defer cancel()
+
+	a := ss.ServiceInfo{Name: "product", InstanceId: "a", Ip: "10.0.0.1", Port: 9000, Weight: 1}
+	putInstance(t, cli, a)
+	ev, ok := nextEvent(events)
+	assert.True(t, ok)
+	assert.Equal(t, ss.ServiceJoin, ev.Type)
+	assert.Equal(t, a, ev.Service)
+
+	// 重复写入相同的信息（如续租重新注册）不产生事件
+	putInstance(t, cli, a)
+	_, ok = nextEvent(events)
+	assert.False(t, ok)
+
+	a.Weight = 5
+	putInstance(t, cli, a)
+	ev, ok = nextEvent(events)
+	assert.True(t, ok)
+	assert.Equal(t, ss.ServiceJoin, ev.Type)
+	assert.Equal(t, 5, ev.Service.Weight)
+
+	// 只收到订阅的服务的事件
+	putInstance(t, cli, ss.ServiceInfo{Name: "order", InstanceId: "b", Ip: "10.0.0.2", Port: 9000, Weight: 1})
+	_, ok = nextEvent(events)
+	assert.False(t, ok)
+
+	_, err := cli.Delete(context.Background(), ss.ServiceKey("product", "a"))
+	assert.NoError(t, err)
+	ev, ok = nextEvent(events)
+	assert.True(t, ok)
+	assert.Equal(t, ss.ServiceLeave, ev.Type)
+	assert.Equal(t, "a", ev.Service.InstanceId)
+
+	// 取消订阅后关闭通道
+	cancel()
+	_, ok = <-events
+	assert.False(t, ok)
+}
+
+func TestDiscoverySubscribeFillInstanceId(t *testing.T) {
+	discovery, cli, _ := watchFakeEtcd(t)
+	events, cancel := discovery.Subscribe("")
+	defer cancel()
+
+	// 旧版本注册的值中没有实例ID
+	_, err := cli.Put(context.Background(), ss.ServiceKey("product", "old"), `{"name":"product","ip":"10.0.0.1","port":9000}`)
+	assert.NoError(t, err)
+	ev, ok := nextEvent(events)
+	assert.True(t, ok)
+	assert.Equal(t, "old", ev.Service.InstanceId)
+	assert.Equal(t, "old", discovery.GetService("product")[0].InstanceId)
+}
+
+func TestDiscoverySubscribeDropped(t *testing.T) {
+	discovery, cli, _ := watchFakeEtcd(t)
+	events, cancel := discovery.Subscribe("slow")
+	defer cancel()
+
+	before := metricValue(t, "service_discovery_dropped_events_total", map[string]string{"subscriber": "slow"})
+	for i := 0; i < 70; i++ {
+		putInstance(t, cli, ss.ServiceInfo{Name: "slow", InstanceId: string(rune('a' + i%26)), Ip: "10.0.0.1", Port: 9000 + i})
+	}
+	assert.Eventually(t, func() bool {
+		return metricValue(t, "service_discovery_dropped_events_total", map[string]string{"subscriber": "slow"})-before == 6
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, events, 64)
+}
+
+func TestDiscoveryResume(t *testing.T) {
+	discovery, cli, etcd := watchFakeEtcd(t)
+	events, cancel := discovery.Subscribe("product")
+	defer cancel()
+
+	a := ss.ServiceInfo{Name: "product", InstanceId: "a", Ip: "10.0.0.1", Port: 9000, Weight: 1}
+	putInstance(t, cli, a)
+	_, ok := nextEvent(events)
+	assert.True(t, ok)
+
+	// 断开期间注册的实例在重新监听后从断开的版本继续收到，已有实例不会重复通知
+	etcd.disconnect()
+	b := ss.ServiceInfo{Name: "product", InstanceId: "b", Ip: "10.0.0.2", Port: 9000, Weight: 1}
+	putInstance(t, cli, b)
+	ev, ok := <-events
+	assert.True(t, ok)
+	assert.Equal(t, ss.ServiceJoin, ev.Type)
+	assert.Equal(t, b, ev.Service)
+	_, ok = nextEvent(events)
+	assert.False(t, ok)
+	assert.Len(t, discovery.GetService("product"), 2)
+}
+
+func TestDiscoveryResyncCompacted(t *testing.T) {
+	discovery, cli, etcd := watchFakeEtcd(t)
+	ctx := context.Background()
+	a := ss.ServiceInfo{Name: "product", InstanceId: "a", Ip: "10.0.0.1", Port: 9000, Weight: 1}
+	b := ss.ServiceInfo{Name: "product", InstanceId: "b", Ip: "10.0.0.2", Port: 9000, Weight: 1}
+	putInstance(t, cli, a)
+	putInstance(t, cli, b)
+	assert.Eventually(t, func() bool { return len(discovery.GetService("product")) == 2 }, time.Second, 10*time.Millisecond)
+	events, cancel := discovery.Subscribe("product")
+	defer cancel()
+
+	// 断开期间的历史被压缩，重新监听时全量同步，只通知有变化的实例
+	etcd.disconnect()
+	c := ss.ServiceInfo{Name: "product", InstanceId: "c", Ip: "10.0.0.3", Port: 9000, Weight: 1}
+	putInstance(t, cli, c)
+	resp, err := cli.Delete(ctx, ss.ServiceKey("product", "b"))
+	assert.NoError(t, err)
+	_, err = cli.Compact(ctx, resp.Header.Revision)
+	assert.NoError(t, err)
+
+	got := map[string]ss.ServiceEventType{}
+	for len(got) < 2 {
+		ev, ok := <-events
+		assert.True(t, ok)
+		got[ev.Service.InstanceId] = ev.Type
+	}
+	assert.Equal(t, map[string]ss.ServiceEventType{"c": ss.ServiceJoin, "b": ss.ServiceLeave}, got)
+	_, ok := nextEvent(events)
+	assert.False(t, ok)
+	assert.Len(t, discovery.GetService("product"), 2)
+}
+
+func TestDiscoveryResyncProgressDropped(t *testing.T) {
+	discovery, cli, etcd := watchFakeEtcd(t)
+	putInstance(t, cli, ss.ServiceInfo{Name: "product", InstanceId: "a", Ip: "10.0.0.1", Port: 9000, Weight: 1})
+
+	// 重新监听后的第一次进度请求没有回复，之后的请求使服务列表恢复为完整
+	etcd.dropProgress(1)
+	etcd.disconnect()
+	assert.Eventually(t, discovery.Resyncing, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return !discovery.Resyncing() }, 5*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, etcd.progressRequests(), 2)
+}
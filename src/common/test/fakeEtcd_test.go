@@ -0,0 +1,299 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	ss "service"
+	"sync"
+	"testing"
+)
+
+// fakeEtcd 内存中的etcd，实现KV和Watcher，保存全部修订历史用于按版本读取、压缩和监听续传
+type fakeEtcd struct {
+	lock     sync.Mutex
+	rev      int64
+	compact  int64
+	kvs      map[string]*mvccpb.KeyValue
+	history  []*mvccpb.Event
+	watchers map[*fakeWatch]struct{}
+	drop     int // 忽略接下来的几次进度请求，模拟etcd在追上最新版本前不回复
+	progress int // 收到的进度请求数
+}
+
+type fakeWatch struct {
+	key, end []byte
+	ch       chan clientv3.WatchResponse
+	ctx      context.Context
+}
+
+// newFakeEtcdClient 返回使用fakeEtcd的etcd客户端，客户端不会连接任何节点
+func newFakeEtcdClient(t *testing.T) (*clientv3.Client, *fakeEtcd) {
+	cli, err := ss.NewEtcdClient([]string{"127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etcd := &fakeEtcd{rev: 1, kvs: make(map[string]*mvccpb.KeyValue), watchers: make(map[*fakeWatch]struct{})}
+	cli.KV, cli.Watcher = etcd, etcd
+	return cli, etcd
+}
+
+func (e *fakeEtcd) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: e.rev}
+}
+
+func inRange(key, start, end []byte) bool {
+	if len(end) == 0 {
+		return bytes.Equal(key, start)
+	}
+	return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) < 0
+}
+
+// stateAt 按历史重放出修订版本rev时的键值
+func (e *fakeEtcd) stateAt(rev int64) map[string]*mvccpb.KeyValue {
+	state := make(map[string]*mvccpb.KeyValue)
+	for _, ev := range e.history {
+		if ev.Kv.ModRevision > rev {
+			break
+		}
+		if ev.Type == mvccpb.DELETE {
+			delete(state, string(ev.Kv.Key))
+		} else {
+			state[string(ev.Kv.Key)] = ev.Kv
+		}
+	}
+	return state
+}
+
+func (e *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	op := clientv3.OpGet(key, opts...)
+	state := e.kvs
+	if op.Rev() > 0 {
+		if op.Rev() < e.compact {
+			return nil, rpctypes.ErrCompacted
+		}
+		state = e.stateAt(op.Rev())
+	}
+	resp := &clientv3.GetResponse{Header: e.header()}
+	for k, kv := range state {
+		if inRange([]byte(k), op.KeyBytes(), op.RangeBytes()) {
+			resp.Kvs = append(resp.Kvs, kv)
+		}
+	}
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+// apply 写入一个事件并通知监听者，调用方需持有锁
+func (e *fakeEtcd) apply(typ mvccpb.Event_EventType, key, val []byte) {
+	e.rev++
+	kv := &mvccpb.KeyValue{Key: key, Value: val, ModRevision: e.rev, CreateRevision: e.rev, Version: 1}
+	if old, ok := e.kvs[string(key)]; ok {
+		kv.CreateRevision, kv.Version = old.CreateRevision, old.Version+1
+	}
+	ev := &mvccpb.Event{Type: typ, Kv: kv}
+	if typ == mvccpb.DELETE {
+		delete(e.kvs, string(key))
+	} else {
+		e.kvs[string(key)] = kv
+	}
+	e.history = append(e.history, ev)
+	for w := range e.watchers {
+		if inRange(key, w.key, w.end) {
+			e.send(w, clientv3.WatchResponse{Header: *e.header(), Events: []*clientv3.Event{(*clientv3.Event)(ev)}})
+		}
+	}
+}
+
+func (e *fakeEtcd) send(w *fakeWatch, resp clientv3.WatchResponse) {
+	select {
+	case w.ch <- resp:
+	case <-w.ctx.Done():
+	}
+}
+
+func (e *fakeEtcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.apply(mvccpb.PUT, []byte(key), []byte(val))
+	return &clientv3.PutResponse{Header: e.header()}, nil
+}
+
+func (e *fakeEtcd) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	op := clientv3.OpDelete(key, opts...)
+	resp := &clientv3.DeleteResponse{}
+	for k := range e.kvs {
+		if inRange([]byte(k), op.KeyBytes(), op.RangeBytes()) {
+			e.apply(mvccpb.DELETE, []byte(k), nil)
+			resp.Deleted++
+		}
+	}
+	resp.Header = e.header()
+	return resp, nil
+}
+
+func (e *fakeEtcd) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.compact = rev
+	return &clientv3.CompactResponse{Header: e.header()}, nil
+}
+
+func (e *fakeEtcd) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	panic("fakeEtcd: Do is not supported")
+}
+
+func (e *fakeEtcd) Txn(ctx context.Context) clientv3.Txn {
+	return &fakeTxn{etcd: e}
+}
+
+type fakeTxn struct {
+	etcd      *fakeEtcd
+	cmps      []clientv3.Cmp
+	then, els []clientv3.Op
+}
+
+func (t *fakeTxn) If(cs ...clientv3.Cmp) clientv3.Txn   { t.cmps = cs; return t }
+func (t *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn { t.then = ops; return t }
+func (t *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn { t.els = ops; return t }
+
+func (t *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	e := t.etcd
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ok := true
+	for _, c := range t.cmps {
+		var actual, want int64
+		kv := e.kvs[string(c.Key)]
+		switch c.Target {
+		case pb.Compare_MOD:
+			want = c.TargetUnion.(*pb.Compare_ModRevision).ModRevision
+			if kv != nil {
+				actual = kv.ModRevision
+			}
+		case pb.Compare_VERSION:
+			want = c.TargetUnion.(*pb.Compare_Version).Version
+			if kv != nil {
+				actual = kv.Version
+			}
+		default:
+			panic("fakeEtcd: unsupported compare target")
+		}
+		switch c.Result {
+		case pb.Compare_EQUAL:
+			ok = ok && actual == want
+		case pb.Compare_GREATER:
+			ok = ok && actual > want
+		case pb.Compare_LESS:
+			ok = ok && actual < want
+		case pb.Compare_NOT_EQUAL:
+			ok = ok && actual != want
+		}
+	}
+	ops := t.then
+	if !ok {
+		ops = t.els
+	}
+	for _, op := range ops {
+		switch {
+		case op.IsPut():
+			e.apply(mvccpb.PUT, op.KeyBytes(), op.ValueBytes())
+		case op.IsDelete():
+			if _, found := e.kvs[string(op.KeyBytes())]; found {
+				e.apply(mvccpb.DELETE, op.KeyBytes(), nil)
+			}
+		default:
+			panic("fakeEtcd: unsupported txn op")
+		}
+	}
+	return &clientv3.TxnResponse{Header: e.header(), Succeeded: ok}, nil
+}
+
+// Watch 先回放fromRev之后的历史事件再推送新的事件，fromRev已被压缩时返回CompactRevision并关闭
+func (e *fakeEtcd) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	op := clientv3.OpGet(key, opts...)
+	w := &fakeWatch{key: op.KeyBytes(), end: op.RangeBytes(), ch: make(chan clientv3.WatchResponse, 256), ctx: ctx}
+	if op.Rev() > 0 && op.Rev() < e.compact {
+		w.ch <- clientv3.WatchResponse{Header: *e.header(), CompactRevision: e.compact, Canceled: true}
+		close(w.ch)
+		return w.ch
+	}
+	if op.Rev() > 0 {
+		for _, ev := range e.history {
+			if ev.Kv.ModRevision >= op.Rev() && inRange(ev.Kv.Key, w.key, w.end) {
+				w.ch <- clientv3.WatchResponse{Header: pb.ResponseHeader{Revision: ev.Kv.ModRevision}, Events: []*clientv3.Event{(*clientv3.Event)(ev)}}
+			}
+		}
+	}
+	e.watchers[w] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		if _, ok := e.watchers[w]; ok {
+			delete(e.watchers, w)
+			close(w.ch)
+		}
+	}()
+	return w.ch
+}
+
+// watching 返回当前的监听数量
+func (e *fakeEtcd) watching() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return len(e.watchers)
+}
+
+// disconnect 模拟与etcd断开连接，关闭所有监听
+func (e *fakeEtcd) disconnect() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for w := range e.watchers {
+		delete(e.watchers, w)
+		close(w.ch)
+	}
+}
+
+// RequestProgress 向所有监听发送进度通知，fakeEtcd的监听总是已经追上最新版本，dropProgress设置的请求除外
+func (e *fakeEtcd) RequestProgress(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.progress++
+	if e.drop > 0 {
+		e.drop--
+		return nil
+	}
+	for w := range e.watchers {
+		e.send(w, clientv3.WatchResponse{Header: *e.header()})
+	}
+	return nil
+}
+
+// dropProgress 忽略接下来的n次进度请求
+func (e *fakeEtcd) dropProgress(n int) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.drop = n
+}
+
+// progressRequests 返回收到的进度请求数
+func (e *fakeEtcd) progressRequests() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.progress
+}
+
+func (e *fakeEtcd) Close() error {
+	e.disconnect()
+	return nil
+}
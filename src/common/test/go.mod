@@ -3,17 +3,21 @@ module test
 go 1.22
 
 require (
+	github.com/alicebob/miniredis/v2 v2.33.0
 	github.com/bwmarrin/snowflake v0.3.0
 	github.com/grpc-ecosystem/grpc-gateway/v2 v2.26.0
+	github.com/prometheus/client_model v0.6.1
 	github.com/stretchr/testify v1.10.0
 	google.golang.org/grpc v1.70.0
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.13.1 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
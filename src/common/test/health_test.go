@@ -0,0 +1,197 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"net/http/httptest"
+	ss "service"
+	"storage"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecks(t *testing.T) {
+	h := ss.NewHealth()
+	h.CacheTTL = 0
+	var redisDown atomic.Bool
+	var calls atomic.Int32
+	h.AddCheck("database", func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	})
+	h.AddCheck("redis", func(ctx context.Context) error {
+		if redisDown.Load() {
+			return errors.New("dial tcp 127.0.0.1:6379: connection refused")
+		}
+		return nil
+	})
+	h.AddCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	h.Timeout = 10 * time.Millisecond
+
+	result, err := h.Check(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, "fail", result.Status)
+	assert.Equal(t, "ok", result.Checks["database"])
+	assert.Equal(t, "context deadline exceeded", result.Checks["slow"])
+	h.RemoveCheck("slow")
+	assert.NoError(t, h.Ready(context.Background()))
+
+	// 缓存期内不重复访问依赖
+	h.CacheTTL = time.Minute
+	before := calls.Load()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.Ready(context.Background()))
+	}
+	assert.Equal(t, before, calls.Load())
+	h.CacheTTL = 0
+
+	server := httptest.NewServer(func() http.Handler {
+		mux := http.NewServeMux()
+		mux.Handle(ss.LivenessPath, h.LivenessHandler())
+		mux.Handle(ss.ReadinessPath, h.ReadinessHandler())
+		return mux
+	}())
+	defer server.Close()
+	get := func(path string) (int, ss.HealthResult) {
+		resp, err := http.Get(server.URL + path)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var result ss.HealthResult
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return resp.StatusCode, result
+	}
+
+	code, _ := get(ss.ReadinessPath)
+	assert.Equal(t, http.StatusOK, code)
+	// 依赖故障时未就绪，但仍然存活
+	redisDown.Store(true)
+	code, result = get(ss.ReadinessPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, result.Checks["redis"], "connection refused")
+	code, _ = get(ss.LivenessPath)
+	assert.Equal(t, http.StatusOK, code)
+	redisDown.Store(false)
+
+	// 退出时就绪检查始终失败
+	h.Shutdown()
+	code, result = get(ss.ReadinessPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "shutting_down", result.Status)
+	assert.ErrorIs(t, h.Ready(context.Background()), ss.ErrShuttingDown)
+	code, _ = get(ss.LivenessPath)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestHealthCheckConcurrent(t *testing.T) {
+	h := ss.NewHealth()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h.AddCheck("database", func(ctx context.Context) error {
+		calls.Add(1)
+		<-release
+		return nil
+	})
+
+	// 缓存过期后并发的调用只执行一次检查
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, h.Ready(context.Background()))
+		}()
+	}
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestHealthStorageChecks(t *testing.T) {
+	mr := miniredis.RunT(t)
+	st := &storage.BaseStorage[string]{MiddlewareCache: storage.NewRedisCache(mr.Addr(), "", 0)}
+	h := ss.NewHealth()
+	h.CacheTTL = 0
+	h.AddChecks(st)
+	assert.NoError(t, h.Ready(context.Background()))
+
+	mr.Close()
+	result, err := h.Check(context.Background())
+	assert.Error(t, err)
+	assert.NotEqual(t, "ok", result.Checks["redis"])
+}
+
+func TestGrpcHealth(t *testing.T) {
+	s, err := ss.NewService(&ss.ServiceInfo{Name: "health", Ip: "127.0.0.1", Port: 50021, HttpPort: 50022})
+	assert.NoError(t, err)
+	s.Probes.CacheTTL = 0
+	var down atomic.Bool
+	s.Probes.AddCheck("mq", func(ctx context.Context) error {
+		if down.Load() {
+			return errors.New("rabbitmq: channel closed")
+		}
+		return nil
+	})
+
+	lis, server, err := s.ServeGrpc(func(*grpc.Server) {})
+	assert.NoError(t, err)
+	defer lis.Close()
+	defer server.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	ctx := context.Background()
+
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// Watch收到依赖故障和退出的状态变化
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	resp, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	down.Store(true)
+	assert.Error(t, s.Probes.Ready(ctx))
+	resp, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+	down.Store(false)
+	assert.NoError(t, s.Probes.Ready(ctx))
+	resp, _ = stream.Recv()
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	s.Probes.Shutdown()
+	resp, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	// 网关挂载探针
+	gateway := httptest.NewServer(s.GatewayHandler(http.NotFoundHandler()))
+	defer gateway.Close()
+	r, err := http.Get(gateway.URL + ss.ReadinessPath)
+	assert.NoError(t, err)
+	r.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, r.StatusCode)
+	r, err = http.Get(gateway.URL + ss.MetricsPath)
+	assert.NoError(t, err)
+	r.Body.Close()
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+}
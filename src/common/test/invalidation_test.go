@@ -0,0 +1,87 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"storage"
+	"testing"
+	"time"
+)
+
+// newInvalidated 创建共享缓存中间件和失效通知频道的存储实例
+func newInvalidated(t *testing.T, mr *miniredis.Miniredis, policy storage.GapPolicy) *storage.BaseStorage[string] {
+	s := newCacheAside(t, mr, storage.DefaultCacheConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bus := storage.NewRedisInvalidationBus(storage.NewRedisCache(mr.Addr(), "", 0).Client(), "cache:invalidate")
+	s.StartInvalidation(ctx, bus, policy)
+	return s
+}
+
+func localExists(s *storage.BaseStorage[string], key string) bool {
+	ok, _ := s.LocalCache.Exists(context.Background(), key)
+	return ok
+}
+
+// waitSubscribed 等待n个实例订阅失效通知频道
+func waitSubscribed(t *testing.T, mr *miniredis.Miniredis, n int) {
+	assert.Eventually(t, func() bool {
+		return mr.PubSubNumSub("cache:invalidate")["cache:invalidate"] == n
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s1, s2 := newInvalidated(t, mr, storage.GapFlush), newInvalidated(t, mr, storage.GapFlush)
+	waitSubscribed(t, mr, 2)
+	ctx := context.Background()
+	loader := func(ctx context.Context) (interface{}, error) {
+		return wbProduct{ID: 1, Name: "apple"}, nil
+	}
+	var p wbProduct
+	assert.NoError(t, s1.Load(ctx, "p1", &p, loader))
+	assert.NoError(t, s2.Load(ctx, "p1", &p, loader))
+	assert.NoError(t, s2.Load(ctx, "p2", &p, loader))
+
+	// 一个实例删除本地缓存时其他实例同时删除
+	assert.NoError(t, s1.Invalidate(ctx, "p1"))
+	assert.False(t, localExists(s1, "p1"))
+	assert.Eventually(t, func() bool { return !localExists(s2, "p1") }, time.Second, 10*time.Millisecond)
+	assert.True(t, localExists(s2, "p2"))
+}
+
+func TestInvalidationGap(t *testing.T) {
+	mr := miniredis.RunT(t)
+	flush, recheck := newInvalidated(t, mr, storage.GapFlush), newInvalidated(t, mr, storage.GapRecheck)
+	waitSubscribed(t, mr, 2)
+	ctx := context.Background()
+	var p wbProduct
+	for _, key := range []string{"a", "b"} {
+		loader := func(ctx context.Context) (interface{}, error) {
+			return wbProduct{Name: key}, nil
+		}
+		assert.NoError(t, flush.Load(ctx, key, &p, loader))
+		assert.NoError(t, recheck.Load(ctx, key, &p, loader))
+	}
+
+	// 断线期间b在缓存中间件中被修改，通知丢失
+	mr.Close()
+	assert.NoError(t, mr.Restart())
+	value, _ := json.Marshal(wbProduct{Name: "b2"})
+	entry, _ := json.Marshal(map[string]interface{}{"v": json.RawMessage(value), "e": time.Now().Add(time.Minute).UnixMilli()})
+	mr.Set("b", string(entry))
+	raw, _ := json.Marshal(wbProduct{Name: "a"})
+	entry, _ = json.Marshal(map[string]interface{}{"v": json.RawMessage(raw), "e": time.Now().Add(time.Minute).UnixMilli()})
+	mr.Set("a", string(entry))
+
+	// 重新订阅后清空或逐个比较本地缓存
+	assert.Eventually(t, func() bool {
+		return !localExists(flush, "a") && !localExists(flush, "b")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return !localExists(recheck, "b") }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, localExists(recheck, "a"))
+	assert.NoError(t, recheck.Load(ctx, "b", &p, nil))
+	assert.Equal(t, "b2", p.Name)
+}
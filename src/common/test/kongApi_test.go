@@ -1,82 +1,543 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	k "kongApi"
+	"kongApi/kongtest"
+	"net/http"
+	ss "service"
 	"testing"
+	"time"
 )
 
 func TestKongApi(t *testing.T) {
-	// 定义 Upstream、Service 和 Route 的名称
-	upstreamName := "example-upstream"
-	serviceName := "example-service"
-	routeName := "example-route"
-
-	// 定义 Target
-	target := "localhost:8080"
-	weight := 100
-
-	// 定义 Route 路径
-	paths := []string{"/example"}
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
 
 	// 注册 Upstream
-	if err := k.CreateUpstream(upstreamName); err != nil {
-		t.Fatalf("Error creating upstream: %v", err)
-	}
+	upstream, err := client.CreateUpstream(ctx, &k.Upstream{Name: "example-upstream"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, upstream.ID)
+	assert.Equal(t, "round-robin", upstream.Algorithm)
+
+	// 注册健康检查
 	healthChecks := k.HealthChecks{
 		Active: k.ActiveHealthCheck{
-			HTTPPath: "/health",
-			Type:     "http",
-			Healthy: k.HealthyStatus{
-				HTTPStatuses: []int{200, 201},
-				Interval:     5,
-			},
-			Unhealthy: k.HealthyStatus{
-				HTTPStatuses: []int{500, 503},
-				Interval:     3,
-			},
+			HTTPPath:  "/health",
+			Type:      "http",
+			Healthy:   k.HealthyStatus{HTTPStatuses: []int{200, 201}, Interval: 5},
+			Unhealthy: k.UnhealthyStatus{HTTPStatuses: []int{500, 503}, Interval: 3, HTTPFailures: 3},
 		},
-		Passive: k.PassiveHealthCheck{
-			Healthy: k.HealthyStatus{
-				HTTPStatuses: []int{200, 201},
-				Interval:     10,
-			},
-			Unhealthy: k.HealthyStatus{
-				HTTPStatuses: []int{500, 503},
-				Interval:     5,
-			},
+	}
+	assert.NoError(t, client.UpdateHealthChecks(ctx, "example-upstream", healthChecks))
+	upstream, err = client.GetUpstream(ctx, upstream.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "/health", upstream.HealthChecks.Active.HTTPPath)
+
+	// 添加 Target，权重为0表示下线
+	_, err = client.AddTarget(ctx, "example-upstream", &k.Target{Target: "localhost:8080", Weight: 100})
+	assert.NoError(t, err)
+	_, err = client.UpsertTarget(ctx, "example-upstream", &k.Target{Target: "localhost:8080", Weight: 0})
+	assert.NoError(t, err)
+	target, err := client.GetTarget(ctx, "example-upstream", "localhost:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, target.Weight)
+	assert.Equal(t, upstream.ID, target.Upstream.ID)
+
+	// 创建 Service 和 Route
+	service, err := client.CreateService(ctx, &k.Service{Name: "example-service", Host: "example-upstream", Path: "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, 80, service.Port)
+	route, err := client.CreateRoute(ctx, &k.Route{Name: "example-route", Paths: []string{"/example"}, Service: &k.Ref{Name: "example-service"}})
+	assert.NoError(t, err)
+	assert.Equal(t, service.ID, route.Service.ID)
+	routes, err := client.ListServiceRoutes(ctx, "example-service")
+	assert.NoError(t, err)
+	assert.Len(t, routes, 1)
+
+	// 插件和Consumer
+	consumer, err := client.CreateConsumer(ctx, &k.Consumer{Username: "app"})
+	assert.NoError(t, err)
+	plugin, err := client.CreatePlugin(ctx, &k.Plugin{
+		Name:     "rate-limiting",
+		Service:  &k.Ref{ID: service.ID},
+		Consumer: &k.Ref{ID: consumer.ID},
+		Config:   map[string]any{"second": float64(10)},
+	})
+	assert.NoError(t, err)
+	assert.True(t, *plugin.Enabled)
+	plugins, err := client.ListServicePlugins(ctx, "example-service")
+	assert.NoError(t, err)
+	assert.Len(t, plugins, 1)
+	assert.Equal(t, float64(10), plugins[0].Config["second"])
+
+	// 删除Service时级联删除插件
+	assert.NoError(t, client.DeleteRoute(ctx, "example-route"))
+	assert.NoError(t, client.DeleteService(ctx, "example-service"))
+	plugins, err = client.ListPlugins(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, plugins)
+}
+
+func TestKongErrors(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
+
+	_, err := client.GetService(ctx, "missing")
+	assert.True(t, k.IsNotFound(err))
+
+	_, err = client.CreateUpstream(ctx, &k.Upstream{Name: "order"})
+	assert.NoError(t, err)
+	_, err = client.CreateUpstream(ctx, &k.Upstream{Name: "order"})
+	assert.ErrorIs(t, err, k.ErrConflict)
+
+	_, err = client.CreateService(ctx, &k.Service{Name: "order"})
+	assert.ErrorIs(t, err, k.ErrValidation)
+	var apiErr *k.APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Fields, "host")
+
+	_, err = client.CreateRoute(ctx, &k.Route{Name: "order-route", Paths: []string{"/order"}, Service: &k.Ref{Name: "missing"}})
+	assert.ErrorIs(t, err, k.ErrValidation)
+
+	// 鉴权
+	kong.Token = "secret"
+	_, err = client.ListUpstreams(ctx)
+	assert.ErrorIs(t, err, k.ErrUnauthorized)
+	upstreams, err := k.NewClient(kong.URL, k.WithAdminToken("secret")).ListUpstreams(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, upstreams, 1)
+
+	// 已取消的context
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.GetUpstream(cancelled, "order")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestKongPagination(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	kong.PageSize = 2
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := client.CreateConsumer(ctx, &k.Consumer{Username: fmt.Sprintf("user-%d", i)})
+		assert.NoError(t, err)
+	}
+	consumers, err := client.ListConsumers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, consumers, 5)
+	assert.Equal(t, "user-4", consumers[4].Username)
+
+	pages := 0
+	for _, req := range kong.Requests() {
+		if req == "GET /consumers" {
+			pages++
+		}
+	}
+	assert.Equal(t, 3, pages)
+}
+
+func TestKongTLS(t *testing.T) {
+	kong := kongtest.NewTLSServer()
+	defer kong.Close()
+	tlsConfig := kong.Client().Transport.(*http.Transport).TLSClientConfig
+
+	_, err := k.NewClient(kong.URL).ListServices(context.Background())
+	assert.Error(t, err)
+	_, err = k.NewClient(kong.URL, k.WithTLSConfig(tlsConfig)).ListServices(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestServiceRegisterToKong(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	s, err := ss.NewService(&ss.ServiceInfo{
+		Name:        "product",
+		Ip:          "127.0.0.1",
+		Port:        50011,
+		HttpPort:    50012,
+		Weight:      100,
+		Protocol:    "http",
+		HealthPath:  "/health",
+		RoutesName:  "product-route",
+		ServicePath: "/product",
+		Paths:       []string{"/service/product"},
+		Plugins:     []k.Plugin{k.RateLimitingConfig{Second: 10}.Plugin()},
+	})
+	assert.NoError(t, err)
+	s.Kong = k.NewClient(kong.URL)
+
+	assert.NoError(t, s.ServiceRegisterToKong())
+	service, ok := kong.Get("services", "product")
+	assert.True(t, ok)
+	assert.Equal(t, service["id"], s.ServiceInfo.Id)
+	route, _ := kong.Get("routes", "product-route")
+	assert.Equal(t, map[string]any{"id": s.ServiceInfo.Id}, route["service"])
+	targets := kong.List("targets")
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "127.0.0.1:50012", targets[0]["target"])
+	plugin, err := s.Kong.FindPlugin(context.Background(), k.PluginRateLimiting, k.PluginScope{Service: "product"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(10), plugin.Config["second"])
+
+	// 重复注册是幂等的；默认修正代码与Kong的差异，开启KongCreateOnly时不修改已存在的路由
+	s.ServiceInfo.Paths = []string{"/service/product", "/service/productB"}
+	s.KongCreateOnly = true
+	assert.NoError(t, s.ServiceRegisterToKong())
+	route, _ = kong.Get("routes", "product-route")
+	assert.Equal(t, []any{"/service/product"}, route["paths"])
+	s.KongCreateOnly = false
+	assert.NoError(t, s.ServiceRegisterToKong())
+	route, _ = kong.Get("routes", "product-route")
+	assert.Equal(t, []any{"/service/product", "/service/productB"}, route["paths"])
+
+	assert.NoError(t, s.UnregisterKong())
+	targets = kong.List("targets")
+	assert.Len(t, targets, 1)
+	assert.Equal(t, float64(0), targets[0]["weight"])
+}
+
+func TestKongReconcile(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	reconciler := k.NewReconciler(client)
+	ctx := context.Background()
+
+	desired := &k.DesiredState{
+		Upstream: k.Upstream{Name: "order"},
+		Targets:  []k.Target{{Target: "10.0.0.1:8080", Weight: 100}},
+		Service:  k.Service{Name: "order", Path: "/order"},
+		Routes: []k.Route{
+			{Name: "order-route", Paths: []string{"/service/order"}},
+			{Name: "order-admin", Paths: []string{"/admin/order"}},
 		},
+		Plugins: []k.Plugin{{Name: "rate-limiting", Config: map[string]any{"second": 10}}},
 	}
 
-	// 注册健康检查
-	err := k.UpdateHealthChecks(upstreamName, healthChecks)
-	if err != nil {
-		t.Fatalf("Error updating health checks: %v", err)
+	// DryRun只输出计划
+	var out bytes.Buffer
+	reconciler.DryRun, reconciler.Output = true, &out
+	plan, err := reconciler.Reconcile(ctx, desired)
+	assert.NoError(t, err)
+	assert.Len(t, plan.Operations, 6)
+	assert.Contains(t, out.String(), "+ create route order-admin")
+	assert.Empty(t, kong.List("routes"))
+
+	reconciler.DryRun = false
+	_, err = reconciler.Reconcile(ctx, desired)
+	assert.NoError(t, err)
+	assert.Len(t, kong.List("routes"), 2)
+	service, _ := kong.Get("services", "order")
+	assert.Equal(t, "order", service["host"])
+
+	// 再次同步没有变化，Kong填充的默认值不视为差异
+	plan, err = reconciler.Plan(ctx, desired)
+	assert.NoError(t, err)
+	assert.True(t, plan.Empty(), plan.String())
+
+	// Paths变化、路由改名、插件配置变化、权重变化
+	desired.Routes = []k.Route{
+		{Name: "order-route", Paths: []string{"/service/order", "/service/orderB"}},
+		{Name: "order-internal", Paths: []string{"/internal/order"}},
 	}
-	// 添加 Target 到 Upstream
-	if err := k.AddTargetToUpstream(upstreamName, target, weight); err != nil {
-		t.Fatalf("Error adding target: %v", err)
+	desired.Plugins[0].Config["second"] = 20
+	desired.Targets[0].Weight = 0
+	plan, err = reconciler.Reconcile(ctx, desired)
+	assert.NoError(t, err)
+	var ops []string
+	for _, op := range plan.Operations {
+		ops = append(ops, op.String())
+	}
+	assert.Equal(t, []string{
+		"~ update target 10.0.0.1:8080 (weight)",
+		"~ update route order-route (paths)",
+		"+ create route order-internal",
+		"- delete route order-admin",
+		"~ update plugin rate-limiting (config)",
+	}, ops)
+	_, ok := kong.Get("routes", "order-admin")
+	assert.False(t, ok)
+	route, _ := kong.Get("routes", "order-route")
+	assert.Equal(t, []any{"/service/order", "/service/orderB"}, route["paths"])
+	plugins, err := client.ListServicePlugins(ctx, "order")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(20), plugins[0].Config["second"])
+
+	// 从期望状态中移除插件会删除它
+	desired.Plugins = []k.Plugin{}
+	_, err = reconciler.Reconcile(ctx, desired)
+	assert.NoError(t, err)
+	assert.Empty(t, kong.List("plugins"))
+
+	plan, err = reconciler.Plan(ctx, desired)
+	assert.NoError(t, err)
+	assert.True(t, plan.Empty(), plan.String())
+
+	// 清空的字段同样视为差异，Kong中的值恢复为默认值
+	noStrip := false
+	desired.Routes[0].StripPath, desired.Routes[0].Tags = &noStrip, []string{"v1"}
+	_, err = reconciler.Reconcile(ctx, desired)
+	assert.NoError(t, err)
+	desired.Routes[0].StripPath, desired.Routes[0].Tags = nil, nil
+	desired.Routes[1].Paths, desired.Routes[1].Hosts = nil, []string{"internal.example.com"}
+	plan, err = reconciler.Reconcile(ctx, desired)
+	assert.NoError(t, err)
+	ops = nil
+	for _, op := range plan.Operations {
+		ops = append(ops, op.String())
 	}
+	assert.Equal(t, []string{
+		"~ update route order-route (strip_path, tags)",
+		"~ update route order-internal (hosts, paths)",
+	}, ops)
+	route, _ = kong.Get("routes", "order-route")
+	assert.Equal(t, true, route["strip_path"])
+	assert.Nil(t, route["tags"])
+	route, _ = kong.Get("routes", "order-internal")
+	assert.Nil(t, route["paths"])
+	plan, err = reconciler.Plan(ctx, desired)
+	assert.NoError(t, err)
+	assert.True(t, plan.Empty(), plan.String())
+}
+
+func TestKongPlugins(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
+
+	_, err := client.CreateService(ctx, &k.Service{Name: "order", Host: "order"})
+	assert.NoError(t, err)
+	_, err = client.CreateRoute(ctx, &k.Route{Name: "order-route", Paths: []string{"/order"}, Service: &k.Ref{Name: "order"}})
+	assert.NoError(t, err)
+	_, err = client.CreateConsumer(ctx, &k.Consumer{Username: "app"})
+	assert.NoError(t, err)
+
+	// 同名插件在不同作用范围下互不影响
+	global, err := client.EnablePlugin(ctx, k.PluginScope{}, k.CORSConfig{Origins: []string{"*"}}.Plugin())
+	assert.NoError(t, err)
+	assert.Nil(t, global.Service)
+	service := k.PluginScope{Service: "order"}
+	_, err = client.EnablePlugin(ctx, service, k.RateLimitingConfig{Second: 10}.Plugin())
+	assert.NoError(t, err)
+	route := k.PluginScope{Route: "order-route"}
+	_, err = client.EnablePlugin(ctx, route, k.RateLimitingConfig{Second: 5}.Plugin())
+	assert.NoError(t, err)
+	consumer := k.PluginScope{Service: "order", Consumer: "app"}
+	_, err = client.EnablePlugin(ctx, consumer, k.RateLimitingConfig{Second: 100}.Plugin())
+	assert.NoError(t, err)
+	assert.Len(t, kong.List("plugins"), 4)
+
+	plugins, err := client.ListScopedPlugins(ctx, service)
+	assert.NoError(t, err)
+	assert.Len(t, plugins, 1)
+	assert.Equal(t, float64(10), plugins[0].Config["second"])
+	plugin, err := client.FindPlugin(ctx, k.PluginRateLimiting, consumer)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(100), plugin.Config["second"])
+	_, err = client.FindPlugin(ctx, k.PluginJWT, service)
+	assert.True(t, k.IsNotFound(err))
+
+	// 再次启用时更新配置
+	_, err = client.EnablePlugin(ctx, route, k.RateLimitingConfig{Second: 8}.Plugin())
+	assert.NoError(t, err)
+	plugin, err = client.ConfigurePlugin(ctx, k.PluginRateLimiting, route, map[string]any{"second": 6})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(6), plugin.Config["second"])
+	assert.Len(t, kong.List("plugins"), 4)
+
+	// 停用保留配置，删除不存在的插件不报错
+	assert.NoError(t, client.DisablePlugin(ctx, k.PluginRateLimiting, route))
+	plugin, err = client.FindPlugin(ctx, k.PluginRateLimiting, route)
+	assert.NoError(t, err)
+	assert.False(t, *plugin.Enabled)
+	assert.Equal(t, float64(6), plugin.Config["second"])
+	_, err = client.EnablePlugin(ctx, route, *plugin)
+	assert.NoError(t, err)
+	plugin, _ = client.FindPlugin(ctx, k.PluginRateLimiting, route)
+	assert.True(t, *plugin.Enabled)
+	assert.NoError(t, client.RemovePlugin(ctx, k.PluginCORS, k.PluginScope{}))
+	assert.NoError(t, client.RemovePlugin(ctx, k.PluginCORS, k.PluginScope{}))
+	assert.Len(t, kong.List("plugins"), 3)
+
+	_, err = client.EnablePlugin(ctx, k.PluginScope{Service: "missing"}, k.ACLConfig{Allow: []string{"admin"}}.Plugin())
+	assert.True(t, k.IsNotFound(err))
+}
+
+func TestKongCredentials(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
+
+	_, err := client.CreateConsumer(ctx, &k.Consumer{Username: "app"})
+	assert.NoError(t, err)
+
+	// jwt凭证，未指定时由Kong生成key和secret
+	jwt, err := client.CreateJWTCredential(ctx, "app", &k.JWTCredential{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, jwt.Key)
+	assert.NotEmpty(t, jwt.Secret)
+	assert.Equal(t, "HS256", jwt.Algorithm)
+	_, err = client.CreateJWTCredential(ctx, "app", &k.JWTCredential{Key: "issuer", Secret: "secret"})
+	assert.NoError(t, err)
+	_, err = client.CreateJWTCredential(ctx, "app", &k.JWTCredential{Key: "issuer"})
+	assert.ErrorIs(t, err, k.ErrConflict)
+	updated, err := client.UpdateJWTCredential(ctx, "app", "issuer", &k.JWTCredential{Secret: "rotated"})
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated", updated.Secret)
+	jwts, err := client.ListJWTCredentials(ctx, "app")
+	assert.NoError(t, err)
+	assert.Len(t, jwts, 2)
+	assert.NoError(t, client.DeleteJWTCredential(ctx, "app", jwt.ID))
+	_, err = client.GetJWTCredential(ctx, "app", jwt.Key)
+	assert.True(t, k.IsNotFound(err))
+
+	// key-auth和acl分组
+	key, err := client.CreateKeyAuthCredential(ctx, "app", &k.KeyAuthCredential{Key: "api-key"})
+	assert.NoError(t, err)
+	got, err := client.GetKeyAuthCredential(ctx, "app", "api-key")
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, got.ID)
+	_, err = client.AddACLGroup(ctx, "app", "admin")
+	assert.NoError(t, err)
+	groups, err := client.ListACLGroups(ctx, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", groups[0].Group)
+
+	_, err = client.CreateKeyAuthCredential(ctx, "missing", &k.KeyAuthCredential{})
+	assert.True(t, k.IsNotFound(err))
+
+	// 删除Consumer时级联删除凭证
+	assert.NoError(t, client.DeleteConsumer(ctx, "app"))
+	assert.Empty(t, kong.List("jwt"))
+	assert.Empty(t, kong.List("key-auth"))
+	assert.Empty(t, kong.List("acls"))
+}
+
+func TestKongRouteScheduler(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
 
-	// 创建 Service
-	sid, err := k.CreateService(serviceName, upstreamName, "http", "/")
-	if err != nil {
-		t.Fatalf("Error creating service: %v", err)
+	_, err := client.CreateService(ctx, &k.Service{Name: "seckill", Host: "seckill"})
+	assert.NoError(t, err)
+	scheduler := k.NewRouteScheduler(client)
+	start := time.Date(2026, 11, 11, 0, 0, 0, 0, time.Local)
+	priority, downgrade := 100, 0
+	route := k.ScheduledRoute{
+		Route: k.Route{
+			Name:          "seckill-1",
+			Paths:         []string{"~/service/seckill/1($|/)"},
+			Methods:       []string{"POST"},
+			Headers:       map[string][]string{"x-seckill": {"1"}},
+			RegexPriority: &priority,
+			Service:       &k.Ref{Name: "seckill"},
+		},
+		StartTime: start,
+		EndTime:   start.Add(time.Hour),
 	}
+	assert.Error(t, scheduler.Schedule(k.ScheduledRoute{Route: route.Route, StartTime: start, EndTime: start}))
+	assert.NoError(t, scheduler.Schedule(route))
+	downgraded := route
+	downgraded.Route.Name, downgraded.Route.Paths = "seckill-2", []string{"~/service/seckill/2($|/)"}
+	downgraded.Downgrade = &downgrade
+	assert.NoError(t, scheduler.Schedule(downgraded))
+
+	// 提前时间之前不创建路由
+	assert.NoError(t, scheduler.Sync(ctx, start.Add(-2*time.Minute)))
+	assert.Empty(t, kong.List("routes"))
 
-	// 创建 Route
-	if err := k.CreateRoute(routeName, sid, paths); err != nil {
-		t.Fatalf("Error creating route: %v", err)
+	// 提前一分钟创建高优先级路由
+	assert.NoError(t, scheduler.Sync(ctx, start.Add(-time.Minute)))
+	got, err := client.GetRoute(ctx, "seckill-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, *got.RegexPriority)
+	assert.Equal(t, []string{"1"}, got.Headers["x-seckill"])
+	assert.True(t, *got.StripPath)
+
+	// 路由被手动修改后重新同步，未变化时不发送请求
+	_, err = client.UpdateRoute(ctx, "seckill-1", &k.Route{Methods: []string{"GET"}})
+	assert.NoError(t, err)
+	assert.NoError(t, scheduler.Sync(ctx, start))
+	got, _ = client.GetRoute(ctx, "seckill-1")
+	assert.Equal(t, []string{"POST"}, got.Methods)
+	before := len(kong.Requests())
+	assert.NoError(t, scheduler.Sync(ctx, start.Add(time.Minute)))
+	for _, req := range kong.Requests()[before:] {
+		assert.NotContains(t, req, "PUT")
 	}
 
-	fmt.Println("Service and Route successfully registered!")
-	k.UpdateTargetInUpstream(upstreamName, target, 0)
+	// 结束后删除或降级
+	assert.NoError(t, scheduler.Sync(ctx, start.Add(time.Hour)))
+	_, err = client.GetRoute(ctx, "seckill-1")
+	assert.True(t, k.IsNotFound(err))
+	got, err = client.GetRoute(ctx, "seckill-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, *got.RegexPriority)
+	assert.Empty(t, scheduler.Scheduled())
+
+	// 取消计划时删除已创建的路由
+	route.StartTime, route.EndTime = start.Add(24*time.Hour), start.Add(25*time.Hour)
+	assert.NoError(t, scheduler.Schedule(route))
+	assert.NoError(t, scheduler.Sync(ctx, route.StartTime))
+	assert.NoError(t, scheduler.Cancel(ctx, "seckill-1"))
+	_, err = client.GetRoute(ctx, "seckill-1")
+	assert.True(t, k.IsNotFound(err))
+	assert.Empty(t, scheduler.Scheduled())
 }
 
-func TestGetServiceID(t *testing.T) {
-	res, err := k.GetServiceID("test")
-	if err != nil {
-		t.Fatalf("Error getting service ID: %v", err)
+func TestKongHealthReport(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
+
+	checks := k.DefaultHealthChecks("/health")
+	_, err := client.CreateUpstream(ctx, &k.Upstream{Name: "order", HealthChecks: &checks})
+	assert.NoError(t, err)
+	for _, target := range []k.Target{{Target: "10.0.0.1:8080", Weight: 100}, {Target: "10.0.0.2:8080", Weight: 100}, {Target: "10.0.0.3:8080", Weight: 0}} {
+		_, err = client.AddTarget(ctx, "order", &target)
+		assert.NoError(t, err)
 	}
-	fmt.Println(res)
+	upstream, err := client.GetUpstream(ctx, "order")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, upstream.HealthChecks.Active.Unhealthy.HTTPFailures)
+	assert.Equal(t, 2, upstream.HealthChecks.Passive.Unhealthy.TCPFailures)
+
+	assert.NoError(t, client.SetTargetHealth(ctx, "order", "10.0.0.2:8080", false))
+	report, err := client.HealthReport(ctx, "order")
+	assert.NoError(t, err)
+	assert.Len(t, report.Targets, 3)
+	unhealthy := report.Unhealthy()
+	assert.Len(t, unhealthy, 1)
+	assert.Equal(t, "10.0.0.2:8080", unhealthy[0].Target)
+	assert.Equal(t, "10.0.0.2", unhealthy[0].Data.Addresses[0].IP)
+	assert.Equal(t, "1/1 address(es) unhealthy: 10.0.0.2:8080; active http /health: unhealthy after 3 http failures [500 503] or 3 tcp failures or 3 timeouts; passive: unhealthy after 5 http failures [500 503] or 2 tcp failures or 3 timeouts", unhealthy[0].Reason)
+	assert.Equal(t, k.HealthChecksOff, report.Targets[2].Health)
+	assert.Equal(t, "target weight is 0", report.Targets[2].Reason)
+	assert.Contains(t, report.String(), "upstream order: 3 target(s), 1 unhealthy")
+
+	assert.NoError(t, client.SetTargetHealth(ctx, "order", "10.0.0.2:8080", true))
+	report, err = client.HealthReport(ctx, "order")
+	assert.NoError(t, err)
+	assert.Empty(t, report.Unhealthy())
+
+	_, err = client.HealthReport(ctx, "missing")
+	assert.True(t, k.IsNotFound(err))
 }
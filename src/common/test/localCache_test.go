@@ -1,9 +1,16 @@
 package test
 
 import (
+	"container/list"
 	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"metrics"
 	"storage"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -90,3 +97,258 @@ func TestCacheStop(t *testing.T) {
 	assert.Error(t, err)
 	assert.False(t, found) // key2 不会被写入
 }
+
+func TestLocalCacheExpiry(t *testing.T) {
+	var lock sync.Mutex
+	reasons := map[string]storage.EvictReason{}
+	cfg := storage.DefaultLocalCacheConfig[string]()
+	cfg.MaxCost = 2
+	cfg.OnEvict = func(key string, value interface{}, reason storage.EvictReason) {
+		lock.Lock()
+		defer lock.Unlock()
+		reasons[key] = reason
+	}
+	cache := storage.NewLocalCache(cfg)
+	defer cache.Stop()
+	ctx := context.Background()
+
+	// 每个元素有自己的有效期，ttl为0时不过期
+	cache.Set(ctx, "short", 1, 20*time.Millisecond)
+	cache.Set(ctx, "forever", 2, 0)
+	time.Sleep(30 * time.Millisecond)
+	exists, _ := cache.Exists(ctx, "short")
+	assert.False(t, exists)
+	_, found, _ := cache.Get(ctx, "short")
+	assert.False(t, found)
+	_, found, _ = cache.Get(ctx, "forever")
+	assert.True(t, found)
+
+	// 删除不存在的键
+	assert.NoError(t, cache.Delete(ctx, "missing"))
+	cache.Set(ctx, "a", 3, time.Minute)
+	cache.Set(ctx, "b", 4, time.Minute)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, map[string]storage.EvictReason{"short": storage.EvictExpired, "forever": storage.EvictCapacity}, reasons)
+}
+
+func TestLocalCacheCost(t *testing.T) {
+	var rejected []string
+	cfg := storage.DefaultLocalCacheConfig[string]()
+	cfg.MaxCost = 100
+	cfg.Cost = func(value interface{}) int64 { return int64(len(value.(string))) }
+	cfg.OnEvict = func(key string, value interface{}, reason storage.EvictReason) {
+		if reason == storage.EvictRejected {
+			rejected = append(rejected, key)
+		}
+	}
+	cache := storage.NewLocalCache(cfg)
+	defer cache.Stop()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		cache.Set(ctx, fmt.Sprint(i), strings.Repeat("x", 30), time.Minute)
+	}
+	assert.Equal(t, 3, cache.Len())
+	assert.Equal(t, int64(90), cache.Cost())
+	// 大于总容量的元素不写入
+	cache.Set(ctx, "huge", strings.Repeat("x", 101), time.Minute)
+	assert.Equal(t, []string{"huge"}, rejected)
+	exists, _ := cache.Exists(ctx, "9")
+	assert.True(t, exists)
+}
+
+func TestLocalCacheLargeItem(t *testing.T) {
+	ctx := context.Background()
+	for _, maxItemCost := range []int64{0, 300 << 10} {
+		cfg := storage.DefaultLocalCacheConfig[string]()
+		cfg.MaxCost = 1 << 20
+		cfg.Cost = func(value interface{}) int64 { return int64(len(value.([]byte))) }
+		cfg.MaxItemCost = maxItemCost
+		cache := storage.NewLocalCache(cfg)
+		// 大于MaxCost/16的元素也能写入
+		for i := 0; i < 3; i++ {
+			cache.Set(ctx, fmt.Sprint(i), make([]byte, 200<<10), time.Minute)
+			exists, _ := cache.Exists(ctx, fmt.Sprint(i))
+			assert.True(t, exists)
+		}
+		cache.Stop()
+	}
+}
+
+// productID 底层类型为int64的键
+type productID int64
+
+func TestLocalCacheAdmission(t *testing.T) {
+	ctx := context.Background()
+	// 100个热点键反复访问后，1000个只访问一次的键扫描缓存
+	hotSurvived := func(policy storage.AdmissionPolicy) int {
+		cfg := storage.DefaultLocalCacheConfig[productID]()
+		cfg.MaxCost = 200
+		cfg.Admission = policy
+		cache := storage.NewLocalCache(cfg)
+		defer cache.Stop()
+		for round := 0; round < 5; round++ {
+			for id := productID(0); id < 100; id++ {
+				if _, found, _ := cache.Get(ctx, id); !found {
+					cache.Set(ctx, id, id, time.Minute)
+				}
+			}
+		}
+		for id := productID(1000); id < 2000; id++ {
+			if _, found, _ := cache.Get(ctx, id); !found {
+				cache.Set(ctx, id, id, time.Minute)
+			}
+		}
+		assert.LessOrEqual(t, cache.Len(), 200)
+		survived := 0
+		for id := productID(0); id < 100; id++ {
+			if ok, _ := cache.Exists(ctx, id); ok {
+				survived++
+			}
+		}
+		return survived
+	}
+	assert.Equal(t, 0, hotSurvived(storage.AdmitAll))
+	assert.GreaterOrEqual(t, hotSurvived(storage.TinyLFU), 95)
+	assert.GreaterOrEqual(t, hotSurvived(storage.WTinyLFU), 95)
+}
+
+// 使用 go test -race 运行，检查并发读写时的数据竞争
+func TestLocalCacheConcurrent(t *testing.T) {
+	for _, policy := range []storage.AdmissionPolicy{storage.AdmitAll, storage.TinyLFU, storage.WTinyLFU} {
+		cfg := storage.DefaultLocalCacheConfig[int]()
+		cfg.MaxCost = 1000
+		cfg.Admission = policy
+		cfg.CleanInterval = time.Millisecond
+		cache := storage.NewLocalCache(cfg)
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(int64(g)))
+				for i := 0; i < 5000; i++ {
+					key := r.Intn(3000)
+					switch r.Intn(10) {
+					case 0:
+						cache.Delete(ctx, key)
+					case 1, 2:
+						cache.Set(ctx, key, i, time.Duration(r.Intn(10))*time.Millisecond)
+					case 3:
+						cache.Exists(ctx, key)
+					default:
+						if v, found, err := cache.Get(ctx, key); found {
+							assert.NoError(t, err)
+							assert.IsType(t, 0, v)
+						}
+					}
+				}
+				if g == 0 {
+					cache.Keys()
+					cache.CleanUp()
+				}
+			}(g)
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, cache.Len(), 1000)
+		assert.Equal(t, int64(cache.Len()), cache.Cost())
+		cache.Clear()
+		assert.Equal(t, 0, cache.Len())
+		cache.Stop()
+	}
+}
+
+// legacyCache 原来的实现：全局锁和一个LRU链表，Get改为互斥锁以便并发测试
+type legacyCache struct {
+	capacity int
+	data     map[int]*list.Element
+	lruList  *list.List
+	lock     sync.Mutex
+}
+
+type legacyItem struct {
+	key        int
+	value      interface{}
+	expiration time.Time
+}
+
+func (c *legacyCache) Get(ctx context.Context, key int) (interface{}, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, found := c.data[key]; found {
+		item := elem.Value.(*legacyItem)
+		if time.Now().Before(item.expiration) {
+			c.lruList.MoveToFront(elem)
+			metrics.CacheRequests.WithLabelValues("local", "hit").Inc()
+			return item.value, true, nil
+		}
+		c.lruList.Remove(elem)
+		delete(c.data, key)
+	}
+	metrics.CacheRequests.WithLabelValues("local", "miss").Inc()
+	return nil, false, nil
+}
+
+func (c *legacyCache) Set(ctx context.Context, key int, value interface{}, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, found := c.data[key]; found {
+		elem.Value.(*legacyItem).value = value
+		elem.Value.(*legacyItem).expiration = time.Now().Add(ttl)
+		c.lruList.MoveToFront(elem)
+		return nil
+	}
+	if c.lruList.Len() == c.capacity {
+		oldest := c.lruList.Back()
+		c.lruList.Remove(oldest)
+		delete(c.data, oldest.Value.(*legacyItem).key)
+	}
+	c.data[key] = c.lruList.PushFront(&legacyItem{key: key, value: value, expiration: time.Now().Add(ttl)})
+	return nil
+}
+
+// benchmarkCache 按Zipf分布并发读取，未命中时写入，同时报告命中率
+func benchmarkCache(b *testing.B, cache storage.Cache[int]) {
+	ctx := context.Background()
+	var seed, hits atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(seed.Add(1)))
+		zipf := rand.NewZipf(r, 1.01, 1, 100000)
+		for pb.Next() {
+			key := int(zipf.Uint64())
+			if _, found, _ := cache.Get(ctx, key); found {
+				hits.Add(1)
+			} else {
+				cache.Set(ctx, key, key, time.Minute)
+			}
+		}
+	})
+	b.ReportMetric(float64(hits.Load())/float64(b.N), "hit-ratio")
+}
+
+type legacyAdapter struct{ *legacyCache }
+
+func (legacyAdapter) Delete(ctx context.Context, key int) error         { return nil }
+func (legacyAdapter) Exists(ctx context.Context, key int) (bool, error) { return false, nil }
+
+func BenchmarkLocalCache(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		benchmarkCache(b, legacyAdapter{&legacyCache{capacity: 10000, data: make(map[int]*list.Element), lruList: list.New()}})
+	})
+	for _, policy := range []struct {
+		name   string
+		policy storage.AdmissionPolicy
+	}{{"lru", storage.AdmitAll}, {"tinylfu", storage.TinyLFU}, {"wtinylfu", storage.WTinyLFU}} {
+		b.Run(policy.name, func(b *testing.B) {
+			cfg := storage.DefaultLocalCacheConfig[int]()
+			cfg.Admission = policy.policy
+			cache := storage.NewLocalCache(cfg)
+			defer cache.Stop()
+			benchmarkCache(b, cache)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"log"
+	"log/slog"
+	"logging"
+	ss "service"
+	"strings"
+	"testing"
+	"tracing"
+)
+
+// decodeLogs 将JSON日志逐行解析
+func decodeLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggingFields(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+
+	var buf bytes.Buffer
+	assert.NoError(t, logging.Setup(logging.Options{Service: "product", Instance: "127.0.0.1:50001", Level: "info", Output: &buf}))
+
+	sc, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+	ctx := tracing.ContextWithRemoteSpanContext(context.Background(), sc)
+	ctx = logging.WithFields(ctx, logging.KeyRequestID, "req-1")
+	slog.InfoContext(ctx, "seckill", "product_id", 1)
+	// 标准库log的输出同样是结构化日志
+	log.Println("legacy message")
+
+	records := decodeLogs(t, &buf)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "seckill", records[0]["msg"])
+	assert.Equal(t, "product", records[0][logging.KeyService])
+	assert.Equal(t, "127.0.0.1:50001", records[0][logging.KeyInstance])
+	assert.Equal(t, "req-1", records[0][logging.KeyRequestID])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", records[0][logging.KeyTraceID])
+	assert.Equal(t, float64(1), records[0]["product_id"])
+	assert.Equal(t, "legacy message", records[1]["msg"])
+	assert.Equal(t, "product", records[1][logging.KeyService])
+}
+
+func TestLoggingLevel(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	defer logging.SetLevel("info")
+
+	var buf bytes.Buffer
+	assert.NoError(t, logging.Setup(logging.Options{Level: "warn", Output: &buf}))
+	slog.Info("dropped")
+	slog.Warn("kept")
+
+	// 配置中心推送新的级别后立即生效
+	assert.NoError(t, logging.SetLevel("debug"))
+	assert.Equal(t, slog.LevelDebug, logging.Level())
+	slog.Debug("debug kept")
+	assert.Error(t, logging.SetLevel("verbose"))
+
+	records := decodeLogs(t, &buf)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "kept", records[0]["msg"])
+	assert.Equal(t, "debug kept", records[1]["msg"])
+}
+
+func TestLoggingMiddlewareRequestID(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+
+	var buf bytes.Buffer
+	assert.NoError(t, logging.Setup(logging.Options{Service: "order", Output: &buf}))
+
+	interceptor := ss.NewDefaultMiddlewareRegistry(0).UnaryServerInterceptor()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ss.RequestIDKey, "req-42"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.OrderService/CreateOrder"}
+	_, err := interceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		slog.InfoContext(ctx, "creating order")
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	records := decodeLogs(t, &buf)
+	assert.Len(t, records, 2)
+	for _, record := range records {
+		assert.Equal(t, "req-42", record[logging.KeyRequestID])
+		assert.Equal(t, "order", record[logging.KeyService])
+	}
+	assert.Equal(t, "/order.OrderService/CreateOrder", records[1]["method"])
+	assert.Equal(t, "OK", records[1]["code"])
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"metrics"
+	"net/http"
+	"net/http/httptest"
+	ss "service"
+	"storage"
+	"testing"
+	"time"
+)
+
+// metricValue 从公共注册中心读取指定标签的计数器/仪表值，直方图返回样本数
+func metricValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := metrics.Registry.Gather()
+	assert.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if matchLabels(m, labels) {
+				switch {
+				case m.Counter != nil:
+					return m.Counter.GetValue()
+				case m.Gauge != nil:
+					return m.Gauge.GetValue()
+				case m.Histogram != nil:
+					return float64(m.Histogram.GetSampleCount())
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func matchLabels(m *dto.Metric, labels map[string]string) bool {
+	matched := 0
+	for _, l := range m.GetLabel() {
+		if v, ok := labels[l.GetName()]; ok {
+			if v != l.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
+
+func TestGrpcMetrics(t *testing.T) {
+	interceptor := ss.NewDefaultMiddlewareRegistry(0).UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/stock.StockService/DeductStock"}
+	ok := map[string]string{"grpc_service": "stock.StockService", "grpc_method": "DeductStock", "grpc_code": "OK"}
+	failed := map[string]string{"grpc_service": "stock.StockService", "grpc_method": "DeductStock", "grpc_code": "FailedPrecondition"}
+	okBefore, failedBefore := metricValue(t, "grpc_server_handled_total", ok), metricValue(t, "grpc_server_handled_total", failed)
+
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) { return nil, nil })
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.FailedPrecondition, "oversell")
+	})
+	assert.Equal(t, okBefore+1, metricValue(t, "grpc_server_handled_total", ok))
+	assert.Equal(t, failedBefore+1, metricValue(t, "grpc_server_handled_total", failed))
+	assert.True(t, metricValue(t, "grpc_server_handling_seconds", map[string]string{"grpc_method": "DeductStock"}) >= 2)
+
+	client := metrics.UnaryClientInterceptor()
+	before := metricValue(t, "grpc_client_handled_total", map[string]string{"grpc_method": "GetStock", "grpc_code": "Unavailable"})
+	client(context.Background(), "/stock.StockService/GetStock", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return status.Error(codes.Unavailable, "down")
+		})
+	assert.Equal(t, before+1, metricValue(t, "grpc_client_handled_total", map[string]string{"grpc_method": "GetStock", "grpc_code": "Unavailable"}))
+}
+
+func TestCacheMetrics(t *testing.T) {
+	hit := map[string]string{"cache": "local", "result": "hit"}
+	miss := map[string]string{"cache": "local", "result": "miss"}
+	evicted := map[string]string{"cache": "local", "reason": "capacity"}
+	hitBefore, missBefore, evictedBefore := metricValue(t, "cache_requests_total", hit), metricValue(t, "cache_requests_total", miss), metricValue(t, "cache_evictions_total", evicted)
+
+	cache := storage.NewCache[string](2, time.Minute)
+	defer cache.Stop()
+	ctx := context.Background()
+	cache.Set(ctx, "a", 1, time.Minute)
+	cache.Set(ctx, "b", 2, time.Minute)
+	cache.Set(ctx, "c", 3, time.Minute)
+	cache.Get(ctx, "c")
+	cache.Get(ctx, "a")
+	assert.Equal(t, hitBefore+1, metricValue(t, "cache_requests_total", hit))
+	assert.Equal(t, missBefore+1, metricValue(t, "cache_requests_total", miss))
+	assert.Equal(t, evictedBefore+1, metricValue(t, "cache_evictions_total", evicted))
+
+	mr := miniredis.RunT(t)
+	rc := storage.NewRedisCache(mr.Addr(), "", 0)
+	getBefore := metricValue(t, "redis_command_duration_seconds", map[string]string{"command": "get", "status": "ok"})
+	_, found, err := rc.Get(ctx, "missing")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, getBefore+1, metricValue(t, "redis_command_duration_seconds", map[string]string{"command": "get", "status": "ok"}))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	attempts := ss.NewCounter("seckill_attempts_total", "Total seckill attempts.", "result")
+	assert.Same(t, attempts, ss.NewCounter("seckill_attempts_total", "Total seckill attempts.", "result"))
+	attempts.WithLabelValues("oversell").Inc()
+
+	server := httptest.NewServer(ss.GatewayHandler(http.NotFoundHandler()))
+	defer server.Close()
+	resp, err := http.Get(server.URL + ss.MetricsPath)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `seckill_attempts_total{result="oversell"} 1`)
+	assert.Contains(t, string(body), "etcd_lease_alive")
+	assert.Contains(t, string(body), "go_goroutines")
+
+	// 其余路径仍然交给网关处理
+	resp, err = http.Get(server.URL + "/v1/product/1")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	ss "service"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRegistry(t *testing.T) {
+	r := ss.NewDefaultMiddlewareRegistry(time.Second)
+	assert.Equal(t, []string{ss.MiddlewareRecovery, ss.MiddlewareTracing, ss.MiddlewareMetrics, ss.MiddlewareRequestID, ss.MiddlewareLogging, ss.MiddlewareDeadline}, r.Enabled())
+
+	var order []string
+	record := func(name string) ss.Middleware {
+		return ss.Middleware{
+			Name:  name,
+			Order: 100,
+			Unary: func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				order = append(order, name)
+				return handler(ctx, req)
+			},
+		}
+	}
+	r.Register(record("b"))
+	r.Register(record("a"))
+	interceptor := r.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Test/Call"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	resp, err := interceptor(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+	assert.Equal(t, []string{"a", "b"}, order)
+
+	// 运行时禁用中间件，已创建的拦截器立即生效
+	order = nil
+	r.SetEnabled(map[string]bool{"a": false})
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, order)
+
+	order = nil
+	r.Enable("a")
+	r.Unregister("b")
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, order)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	interceptor := ss.NewDefaultMiddlewareRegistry(0).UnaryServerInterceptor()
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Test/Panic"},
+		func(ctx context.Context, req any) (any, error) {
+			panic("boom")
+		})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	interceptor := ss.NewDefaultMiddlewareRegistry(0).UnaryServerInterceptor()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ss.RequestIDKey, "req-1"))
+	var got string
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Test/Call"},
+		func(ctx context.Context, req any) (any, error) {
+			got = ss.RequestIDFromContext(ctx)
+			return nil, nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, "req-1", got)
+
+	// 没有请求ID时自动生成
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Test/Call"},
+		func(ctx context.Context, req any) (any, error) {
+			got = ss.RequestIDFromContext(ctx)
+			return nil, nil
+		})
+	assert.NoError(t, err)
+	assert.Len(t, got, 32)
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	interceptor := ss.NewDefaultMiddlewareRegistry(50 * time.Millisecond).UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Test/Slow"}
+	var deadline time.Time
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		deadline, _ = ctx.Deadline()
+		<-ctx.Done()
+		return "late", nil
+	})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.False(t, deadline.IsZero())
+
+	// 已经超时的请求直接拒绝
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	called := false
+	_, err = interceptor(expired, nil, info, func(ctx context.Context, req any) (any, error) {
+		called = true
+		return nil, nil
+	})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.False(t, called)
+}
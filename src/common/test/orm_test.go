@@ -24,10 +24,13 @@ func TestOrm(t *testing.T) {
 	}
 
 	// 创建 GORM 实例
-	db := storage.NewGORM(masterDSNs, slaveDSNs)
+	db, err := storage.NewGORM(masterDSNs, slaveDSNs)
+	if err != nil {
+		t.Fatal(err)
+	}
 	db.Migrate(User{})
 	// 创建记录
-	err := db.Create(&User{Name: "Alice", Age: 30})
+	err = db.Create(&User{Name: "Alice", Age: 30})
 	if err != nil {
 		log.Fatal("Error creating record:", err)
 	}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/netip"
+	"ratelimit"
+	ss "service"
+	"testing"
+	"time"
+)
+
+func countAllowed(t *testing.T, l ratelimit.Limiter, key string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		ok, err := l.Allow(context.Background(), key)
+		assert.NoError(t, err)
+		if ok {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestTokenBucket(t *testing.T) {
+	l := ratelimit.NewTokenBucket(10, 5)
+	// 突发最多5个请求
+	assert.Equal(t, 5, countAllowed(t, l, "a", 20))
+	// 不同key互不影响
+	assert.Equal(t, 5, countAllowed(t, l, "b", 20))
+
+	res, err := l.Reserve(context.Background(), "a", 0)
+	assert.NoError(t, err)
+	assert.False(t, res.OK)
+	assert.True(t, res.Delay > 0 && res.Delay <= 100*time.Millisecond)
+
+	time.Sleep(210 * time.Millisecond)
+	assert.Equal(t, 2, countAllowed(t, l, "a", 5))
+
+	// Wait 等待令牌生成
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	assert.NoError(t, l.Wait(ctx, "a"))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// 截止时间内无法获得令牌时立即返回
+	short, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	l2 := ratelimit.NewTokenBucket(1, 1)
+	assert.NoError(t, l2.Wait(short, "c"))
+	assert.ErrorIs(t, l2.Wait(short, "c"), ratelimit.ErrLimitExceeded)
+}
+
+func TestLeakyBucket(t *testing.T) {
+	l := ratelimit.NewLeakyBucket(100, 3)
+	// 第一个请求立即执行，其余请求排队（每10ms流出一个），桶满后拒绝
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		res, err := l.Reserve(context.Background(), "a", time.Second)
+		assert.NoError(t, err)
+		if res.OK {
+			delays = append(delays, res.Delay)
+		}
+	}
+	assert.Len(t, delays, 3)
+	assert.Equal(t, time.Duration(0), delays[0])
+	assert.True(t, delays[2] > delays[1] && delays[1] > delays[0])
+
+	ok, err := l.Allow(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = l.Allow(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	l := ratelimit.NewSlidingWindow(5, 200*time.Millisecond)
+	assert.Equal(t, 5, countAllowed(t, l, "a", 10))
+	res, err := l.Reserve(context.Background(), "a", 0)
+	assert.NoError(t, err)
+	assert.False(t, res.OK)
+	assert.True(t, res.Delay > 0 && res.Delay <= 200*time.Millisecond)
+
+	// 两个窗口之后计数清零
+	time.Sleep(400 * time.Millisecond)
+	assert.Equal(t, 5, countAllowed(t, l, "a", 10))
+}
+
+func TestRedisLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{mr.Addr()}})
+	defer client.Close()
+
+	tb := ratelimit.NewRedisTokenBucket(client, "test", 10, 5)
+	assert.Equal(t, 5, countAllowed(t, tb, "a", 20))
+	// 其他副本共享同一个桶
+	other := ratelimit.NewRedisTokenBucket(client, "test", 10, 5)
+	assert.Equal(t, 0, countAllowed(t, other, "a", 5))
+	res, err := other.Reserve(context.Background(), "a", 0)
+	assert.NoError(t, err)
+	assert.False(t, res.OK)
+	assert.True(t, res.Delay > 0)
+
+	lb := ratelimit.NewRedisLeakyBucket(client, "test", 100, 3)
+	okCount := 0
+	for i := 0; i < 5; i++ {
+		res, err := lb.Reserve(context.Background(), "a", time.Second)
+		assert.NoError(t, err)
+		if res.OK {
+			okCount++
+		}
+	}
+	assert.Equal(t, 3, okCount)
+
+	sw := ratelimit.NewRedisSlidingWindow(client, "test", 5, time.Minute)
+	assert.Equal(t, 5, countAllowed(t, sw, "a", 10))
+	res, err = sw.Reserve(context.Background(), "a", 0)
+	assert.NoError(t, err)
+	assert.False(t, res.OK)
+	assert.True(t, res.Delay > 0 && res.Delay <= time.Minute)
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	l := ratelimit.NewTokenBucket(1, 1)
+	interceptor := ratelimit.UnaryServerInterceptor(l, ratelimit.PerMethod(ratelimit.ByMetadata("user-id")))
+	info := &grpc.UnaryServerInfo{FullMethod: "/product.ProductService/GetProduct"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-id", "42"))
+
+	_, err := interceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 没有用户ID的请求不限流
+	for i := 0; i < 3; i++ {
+		_, err = interceptor(context.Background(), nil, info, handler)
+		assert.NoError(t, err)
+	}
+
+	// 作为内置中间件注册，可以通过配置开关
+	registry := ss.NewMiddlewareRegistry()
+	registry.Register(ss.RateLimitMiddleware(ratelimit.NewTokenBucket(1, 1), ratelimit.ByMethod))
+	assert.Equal(t, []string{ss.MiddlewareRateLimit}, registry.Enabled())
+	chain := registry.UnaryServerInterceptor()
+	_, err = chain(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+	_, err = chain(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	registry.Disable(ss.MiddlewareRateLimit)
+	_, err = chain(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	withPeer := func(addr string, pairs ...string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(pairs...))
+	}
+	method := "/product.ProductService/GetProduct"
+
+	// 默认只使用对端地址，客户端伪造的 x-forwarded-for 无效
+	spoofed := withPeer("203.0.113.7:5000", "x-forwarded-for", "10.0.0.1")
+	assert.Equal(t, "203.0.113.7", ratelimit.ByClientIP(spoofed, method))
+
+	byIP, err := ratelimit.ByClientIPBehind("127.0.0.1", "172.16.0.0/12")
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", byIP(spoofed, method))
+	// 可信代理之后从右向左取第一个不可信的地址，忽略客户端在最左侧伪造的地址
+	assert.Equal(t, "198.51.100.2", byIP(withPeer("127.0.0.1:5000", "x-forwarded-for", "10.0.0.1, 198.51.100.2, 172.16.0.1"), method))
+	assert.Equal(t, "198.51.100.2", byIP(withPeer("172.16.0.5:5000", "x-real-ip", "198.51.100.2"), method))
+	assert.Equal(t, "172.16.0.5", byIP(withPeer("172.16.0.5:5000"), method))
+	assert.Equal(t, "", byIP(context.Background(), method))
+
+	_, err = ratelimit.ByClientIPBehind("not-a-cidr")
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	k "kongApi"
+	"kongApi/kongtest"
+	"net/http"
+	"net/http/httptest"
+	ss "service"
+	"testing"
+)
+
+func setupRollout(t *testing.T) (*kongtest.Server, *k.Client, *ss.ServiceDiscovery) {
+	kong := kongtest.NewServer()
+	client := k.NewClient(kong.URL)
+	discovery, err := ss.NewServiceDiscovery([]string{"127.0.0.1:0"})
+	assert.NoError(t, err)
+
+	// 两个旧版本实例，一个以权重0启动的新版本实例
+	instances := []ss.ServiceInfo{
+		{Name: "product", InstanceId: "a", Ip: "10.0.0.1", HttpPort: 8080, Weight: 100, Version: "v1"},
+		{Name: "product", InstanceId: "b", Ip: "10.0.0.2", HttpPort: 8080, Weight: 50, Version: "v1"},
+		{Name: "product", InstanceId: "c", Ip: "10.0.0.3", HttpPort: 8080, Weight: 0, Version: "v2"},
+	}
+	_, err = client.CreateUpstream(context.Background(), &k.Upstream{Name: "product"})
+	assert.NoError(t, err)
+	for _, info := range instances {
+		registerInstance(t, discovery, info)
+		_, err = client.UpsertTarget(context.Background(), "product", &k.Target{Target: info.Ip + ":8080", Weight: info.Weight})
+		assert.NoError(t, err)
+	}
+	return kong, client, discovery
+}
+
+func targetWeights(t *testing.T, client *k.Client) map[string]int {
+	targets, err := client.ListTargets(context.Background(), "product")
+	assert.NoError(t, err)
+	res := make(map[string]int, len(targets))
+	for _, target := range targets {
+		res[target.Target] = target.Weight
+	}
+	return res
+}
+
+func TestRollout(t *testing.T) {
+	kong, client, discovery := setupRollout(t)
+	defer kong.Close()
+	defer discovery.Close()
+
+	rollout := ss.NewRollout(discovery, client, "product", "v2")
+	rollout.Steps, rollout.Interval = []int{10, 50, 100}, 0
+	var history []map[string]int
+	rollout.Checkers = []ss.RolloutChecker{
+		ss.RolloutCheckerFunc(func(ctx context.Context, service, version string, instances []ss.ServiceInfo) error {
+			assert.Equal(t, "v2", version)
+			assert.Len(t, instances, 1)
+			history = append(history, targetWeights(t, client))
+			return nil
+		}),
+		&ss.KongHealthChecker{Kong: client},
+	}
+	assert.NoError(t, rollout.Run(context.Background()))
+
+	// 新版本占10%时旧版本按原权重比例分配剩余的90%
+	assert.Equal(t, []map[string]int{
+		{"10.0.0.1:8080": 60, "10.0.0.2:8080": 30, "10.0.0.3:8080": 10},
+		{"10.0.0.1:8080": 33, "10.0.0.2:8080": 17, "10.0.0.3:8080": 50},
+		{"10.0.0.1:8080": 0, "10.0.0.2:8080": 0, "10.0.0.3:8080": 100},
+	}, history)
+}
+
+func TestRolloutRollback(t *testing.T) {
+	kong, client, discovery := setupRollout(t)
+	defer kong.Close()
+	defer discovery.Close()
+
+	// Kong被动健康检查把新版本实例标记为不健康
+	rollout := ss.NewRollout(discovery, client, "product", "v2")
+	rollout.Steps, rollout.Interval = []int{20, 100}, 0
+	rollout.Checkers = []ss.RolloutChecker{&ss.KongHealthChecker{Kong: client}}
+	kong.SetHealth("10.0.0.3:8080", k.HealthUnhealthy)
+	err := rollout.Run(context.Background())
+	assert.ErrorIs(t, err, ss.ErrRolloutAborted)
+	assert.Contains(t, err.Error(), "at 20%")
+	assert.Equal(t, map[string]int{"10.0.0.1:8080": 100, "10.0.0.2:8080": 50, "10.0.0.3:8080": 0}, targetWeights(t, client))
+
+	// Prometheus中的错误率超过阈值
+	kong.SetHealth("10.0.0.3:8080", k.HealthHealthy)
+	var queries []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		rate := "0.001"
+		if len(queries) > 1 {
+			rate = "0.2"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"%s"]}]}}`, rate)
+	}))
+	defer prom.Close()
+	rollout.Checkers = []ss.RolloutChecker{&ss.PrometheusChecker{URL: prom.URL, Query: `errors{version="$version"}`, MaxErrorRate: 0.05}}
+	err = rollout.Run(context.Background())
+	assert.ErrorIs(t, err, ss.ErrRolloutAborted)
+	assert.Contains(t, err.Error(), "at 100%")
+	assert.Equal(t, []string{`errors{version="v2"}`, `errors{version="v2"}`}, queries)
+	assert.Equal(t, map[string]int{"10.0.0.1:8080": 100, "10.0.0.2:8080": 50, "10.0.0.3:8080": 0}, targetWeights(t, client))
+
+	// 取消时同样回滚
+	ctx, cancel := context.WithCancel(context.Background())
+	rollout.Checkers = []ss.RolloutChecker{ss.RolloutCheckerFunc(func(context.Context, string, string, []ss.ServiceInfo) error {
+		cancel()
+		return nil
+	})}
+	err = rollout.Run(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 0, targetWeights(t, client)["10.0.0.3:8080"])
+
+	rollout.To = "v3"
+	assert.Error(t, rollout.Run(context.Background()))
+}
+
+func TestRolloutWeightKept(t *testing.T) {
+	kong, client, discovery := setupRollout(t)
+	defer kong.Close()
+	defer discovery.Close()
+	etcd, _ := newFakeEtcdClient(t)
+	ctx := context.Background()
+	for _, info := range discovery.GetService("product") {
+		assert.NoError(t, ss.PutRegistration(ctx, etcd, info, 0))
+	}
+	registered := func(instanceId string) ss.ServiceInfo {
+		resp, err := etcd.Get(ctx, ss.ServiceKey("product", instanceId))
+		assert.NoError(t, err)
+		var info ss.ServiceInfo
+		assert.NoError(t, json.Unmarshal(resp.Kvs[0].Value, &info))
+		return info
+	}
+
+	rollout := ss.NewRollout(discovery, client, "product", "v2")
+	rollout.Steps, rollout.Interval, rollout.Etcd = []int{50}, 0, etcd
+	assert.NoError(t, rollout.Run(ctx))
+	assert.Equal(t, 33, registered("a").Weight)
+	assert.Equal(t, 50, registered("c").Weight)
+
+	// 实例更新注册信息时保留灰度发布设置的权重，其他字段正常更新
+	for _, info := range discovery.GetService("product") {
+		info.Metadata = map[string]string{"zone": "b"}
+		assert.NoError(t, ss.PutRegistration(ctx, etcd, info, 0))
+	}
+	assert.Equal(t, 33, registered("a").Weight)
+	assert.Equal(t, 17, registered("b").Weight)
+	assert.Equal(t, 50, registered("c").Weight)
+	assert.Equal(t, "b", registered("c").Metadata["zone"])
+
+	assert.Error(t, ss.SetInstanceWeight(ctx, etcd, "product", "d", 10))
+}
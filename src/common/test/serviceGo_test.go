@@ -1,8 +1,15 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
 	"service"
+	"test-service/handler"
 	"testing"
 )
 
@@ -13,3 +20,67 @@ func TestFindAvailableEndpoint(t *testing.T) {
 	}
 	fmt.Println(ips, ports)
 }
+
+func TestServiceKey(t *testing.T) {
+	s, err := service.NewService(&service.ServiceInfo{
+		Name:     "product",
+		Ip:       "127.0.0.1",
+		Port:     50001,
+		HttpPort: 50002,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ServiceInfo.InstanceId != "127.0.0.1:50001" {
+		t.Fatalf("unexpected instance id %s", s.ServiceInfo.InstanceId)
+	}
+	if key := service.ServiceKey("product", s.ServiceInfo.InstanceId); key != "/services/product/127.0.0.1:50001" {
+		t.Fatalf("unexpected service key %s", key)
+	}
+}
+
+func TestServiceBootstrap(t *testing.T) {
+	s, err := service.NewService(&service.ServiceInfo{Name: "test", Ip: "127.0.0.1", Port: 50041, HttpPort: 50042})
+	assert.NoError(t, err)
+	_, _, err = s.StartGrpcService()
+	assert.Error(t, err, "Register not called")
+
+	handler.NewTestService(s)
+	lis, server, err := s.StartGrpcService()
+	assert.NoError(t, err)
+	defer lis.Close()
+	defer server.Stop()
+	conn, err := s.StartGrpcGatewayService()
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "127.0.0.1:50042", s.ServiceInfo.HttpAddr())
+
+	get := func(path string) (int, map[string]any) {
+		resp, err := http.Get("http://127.0.0.1:50042" + path)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var res map[string]any
+		assert.NoError(t, json.Unmarshal(body, &res), string(body))
+		return resp.StatusCode, res
+	}
+	code, res := get("/test/a")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "service A is ok from:127.0.0.1", res["msg"])
+	code, _ = get(service.ReadinessPath)
+	assert.Equal(t, http.StatusOK, code)
+
+	// 依赖故障时Health返回Unavailable，网关统一转换为503
+	s.Probes.CacheTTL = 0
+	s.Probes.AddCheck("database", func(ctx context.Context) error { return errors.New("connection refused") })
+	code, res = get("/health")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.EqualValues(t, 14, res["code"])
+
+	// 网关端口被占用时直接返回错误
+	other, err := service.NewService(&service.ServiceInfo{Name: "test", Ip: "127.0.0.1", Port: 50043, HttpPort: 50042})
+	assert.NoError(t, err)
+	handler.NewTestService(other)
+	_, err = other.StartGrpcGatewayService()
+	assert.Error(t, err)
+}
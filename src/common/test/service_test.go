@@ -2,14 +2,10 @@ package test
 
 import (
 	"context"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
 	"log"
-	"net"
-	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"test-service/handler"
 	"test-service/pb"
@@ -30,14 +26,11 @@ func TestServiceRegister(t *testing.T) {
 		Paths:       []string{"/service/test", "/service/testB"},
 	})
 
-	s.UpdateOnStart = true
 	if err != nil {
 		panic(err)
 	}
 
-	sm := ss.NewServiceManager(&handler.TestService{
-		Service: s,
-	})
+	sm := ss.NewServiceManager(handler.NewTestService(s))
 
 	ctx, _ := context.WithTimeout(context.Background(), 200*time.Second)
 	go func() {
@@ -47,16 +40,21 @@ func TestServiceRegister(t *testing.T) {
 			sm.StopService()
 		}
 	}()
-	sm.StartService(ctx)
+	if err := sm.StartService(ctx); err != nil {
+		t.Error(err)
+	}
 
 }
 
 func TestServiceDiscovery(t *testing.T) {
 	var endpoints = []string{"localhost:12379", "127.0.0.1:22379", "127.0.0.1:32379"}
-	ser := ss.NewServiceDiscovery(endpoints)
+	ser, err := ss.NewServiceDiscovery(endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer ser.Close()
 
-	err := ser.WatchService("/services/")
+	err = ser.WatchService("/services/")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -79,51 +77,7 @@ func TestServiceDiscovery(t *testing.T) {
 
 type TestService struct {
 	pb.UnimplementedCheckStatusServer
-	ss.Service
-}
-
-func (t TestService) StartGrpcService() (net.Listener, *grpc.Server, error) {
-	// 启动 grpc 服务
-	lis, err := net.Listen("tcp", t.ServiceInfo.Ip+":"+strconv.Itoa(t.ServiceInfo.Port))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	grpcServer := grpc.NewServer()
-	pb.RegisterCheckStatusServer(grpcServer, &t)
-	go func() {
-		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	log.Println("gRPC server is running on port " + strconv.Itoa(t.ServiceInfo.Port))
-	return lis, grpcServer, nil
-}
-func (t TestService) StartGrpcGatewayService() (*grpc.ClientConn, error) {
-
-	// 启动 gRPC-Gateway
-	conn, err := grpc.Dial(t.ServiceInfo.Ip+":"+strconv.Itoa(t.ServiceInfo.Port), grpc.WithInsecure())
-	if err != nil {
-		log.Fatalln("Failed to dial server:", err)
-	}
-	gwmux := runtime.NewServeMux()
-	err = pb.RegisterCheckStatusHandler(context.Background(), gwmux, conn)
-	if err != nil {
-		log.Fatalln("Failed to register gateway:", err)
-	}
-
-	gwServer := &http.Server{
-		Addr:    t.ServiceInfo.Ip + ":" + strconv.Itoa(t.ServiceInfo.HttpPort),
-		Handler: gwmux,
-	}
-
-	go func() {
-		if err := gwServer.ListenAndServe(); err != nil {
-			log.Println(err)
-		}
-	}()
-	log.Println("Serving gRPC-Gateway on http://" + gwServer.Addr)
-	return conn, nil
+	*ss.Service
 }
 
 func TestServiceGo(t *testing.T) {
@@ -137,9 +91,13 @@ func TestServiceGo(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	sm := ss.NewServiceManager(&TestService{
-		Service: *s,
-	})
+	ts := &TestService{Service: s}
+	s.Register(func(server *grpc.Server) {
+		pb.RegisterCheckStatusServer(server, ts)
+	}, pb.RegisterCheckStatusHandler)
+	sm := ss.NewServiceManager(ts)
 	ctx, _ := context.WithTimeout(context.Background(), time.Second*3)
-	sm.StartService(ctx)
+	if err := sm.StartService(ctx); err != nil {
+		t.Error(err)
+	}
 }
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	k "kongApi"
+	"kongApi/kongtest"
+	"net"
+	"net/http"
+	ss "service"
+	"testing"
+	"time"
+)
+
+func TestServiceQuitOrder(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	s, err := ss.NewService(&ss.ServiceInfo{Name: "drain", Ip: "127.0.0.1", Port: 50031, HttpPort: 50032})
+	assert.NoError(t, err)
+	s.Kong = k.NewClient(kong.URL)
+	ctx := context.Background()
+	_, err = s.Kong.CreateUpstream(ctx, &k.Upstream{Name: "drain"})
+	assert.NoError(t, err)
+	_, err = s.Kong.UpsertTarget(ctx, "drain", &k.Target{Target: "127.0.0.1:50032", Weight: 100})
+	assert.NoError(t, err)
+	s.SetShutdownConfig(ss.ShutdownConfig{PropagationDelay: 0, GatewayTimeout: 1, GrpcTimeout: 1, HookTimeout: 1})
+
+	_, _, err = s.ServeGrpc(func(*grpc.Server) {})
+	assert.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:50032")
+	assert.NoError(t, err)
+	gw := &http.Server{Handler: s.GatewayHandler(http.NotFoundHandler())}
+	go gw.Serve(lis)
+	s.SetGatewayServer(gw)
+	gatewayUp := func() bool {
+		resp, err := (&http.Client{Timeout: time.Second}).Get("http://127.0.0.1:50032" + ss.LivenessPath)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}
+	assert.True(t, gatewayUp())
+
+	// 未结束的Watch流使GracefulStop一直等待，超过GrpcTimeout后强制关闭
+	conn, err := grpc.Dial("127.0.0.1:50031", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+
+	var order []string
+	s.OnShutdown(ss.ShutdownDeregister, "scheduler", func(ctx context.Context) error {
+		order = append(order, "deregister")
+		// 注销后网关仍在服务，但已经未就绪，Kong中的权重为0
+		assert.True(t, gatewayUp())
+		assert.ErrorIs(t, s.Probes.Ready(ctx), ss.ErrShuttingDown)
+		target, _ := kong.Get("targets", "127.0.0.1:50032")
+		assert.EqualValues(t, 0, target["weight"])
+		return nil
+	})
+	s.OnShutdown(ss.ShutdownStorage, "db", func(ctx context.Context) error {
+		order = append(order, "db")
+		return nil
+	})
+	s.OnShutdown(ss.ShutdownStorage, "cache", func(ctx context.Context) error {
+		order = append(order, "cache")
+		return errors.New("flush failed")
+	})
+	s.OnShutdown(ss.ShutdownConsumers, "mq", func(ctx context.Context) error {
+		order = append(order, "consumers")
+		// 网关和gRPC已经关闭
+		assert.False(t, gatewayUp())
+		// Shutdown时推送的NOT_SERVING之后流被关闭
+		resp, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+		_, err = stream.Recv()
+		assert.Error(t, err)
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline)
+		return nil
+	})
+
+	start := time.Now()
+	err = s.ServiceQuit()
+	assert.ErrorContains(t, err, "storage hook cache: flush failed")
+	assert.Equal(t, []string{"deregister", "consumers", "cache", "db"}, order)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestShutdownConfig(t *testing.T) {
+	cfg := &ss.ServiceConfig{Info: ss.ServiceInfo{Name: "order"}, Shutdown: ss.ShutdownConfig{GrpcTimeout: -1}}
+	assert.Error(t, cfg.Validate())
+	cfg.Shutdown = ss.DefaultShutdownConfig()
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, "consumers", ss.ShutdownConsumers.String())
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	k "kongApi"
+	"kongApi/kongtest"
+	ss "service"
+	"testing"
+	"time"
+)
+
+func registerInstance(t *testing.T, discovery *ss.ServiceDiscovery, info ss.ServiceInfo) {
+	val, err := json.Marshal(info)
+	assert.NoError(t, err)
+	discovery.SetServiceList(ss.ServiceKey(info.Name, info.InstanceId), string(val))
+}
+
+func TestTargetController(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
+
+	discovery, err := ss.NewServiceDiscovery([]string{"127.0.0.1:0"})
+	assert.NoError(t, err)
+	defer discovery.Close()
+
+	// 两个实例在线；一个实例崩溃后租约过期，Kong中残留了它的target
+	registerInstance(t, discovery, ss.ServiceInfo{Name: "stock", InstanceId: "a", Ip: "10.0.0.1", HttpPort: 8080, Weight: 100})
+	registerInstance(t, discovery, ss.ServiceInfo{Name: "stock", InstanceId: "b", Ip: "10.0.0.2", HttpPort: 8080, Weight: 50})
+	_, err = client.CreateUpstream(ctx, &k.Upstream{Name: "stock"})
+	assert.NoError(t, err)
+	for _, target := range []k.Target{{Target: "10.0.0.1:8080", Weight: 100}, {Target: "10.0.0.2:8080", Weight: 100}, {Target: "10.0.0.3:8080", Weight: 100}} {
+		_, err = client.AddTarget(ctx, "stock", &target)
+		assert.NoError(t, err)
+	}
+
+	controller := ss.NewTargetController(discovery, client)
+	controller.DryRun = true
+	drifts, err := controller.ReconcileAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []ss.TargetDrift{{Upstream: "stock", Stale: []string{"10.0.0.3:8080"}, Weight: []string{"10.0.0.2:8080"}}}, drifts)
+	assert.Len(t, kong.List("targets"), 3)
+
+	controller.DryRun = false
+	_, err = controller.ReconcileAll(ctx)
+	assert.NoError(t, err)
+	targets, err := client.ListTargets(ctx, "stock")
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2)
+	target, err := client.GetTarget(ctx, "stock", "10.0.0.2:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, 50, target.Weight)
+
+	// 新实例上线补充target
+	registerInstance(t, discovery, ss.ServiceInfo{Name: "stock", InstanceId: "c", Ip: "10.0.0.4", HttpPort: 8080, Weight: 100})
+	drift, err := controller.Reconcile(ctx, "stock")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.4:8080"}, drift.Missing)
+
+	// 所有实例同时下线时不删除target
+	for _, id := range []string{"a", "b", "c"} {
+		discovery.DelServiceList(ss.ServiceKey("stock", id))
+	}
+	_, err = controller.ReconcileAll(ctx)
+	assert.ErrorIs(t, err, ss.ErrDeleteRefused)
+	assert.Len(t, kong.List("targets"), 3)
+
+	// 超过比例时不删除，调高比例后删除
+	registerInstance(t, discovery, ss.ServiceInfo{Name: "stock", InstanceId: "a", Ip: "10.0.0.1", HttpPort: 8080, Weight: 100})
+	drift, err = controller.Reconcile(ctx, "stock")
+	assert.ErrorIs(t, err, ss.ErrDeleteRefused)
+	assert.Equal(t, []string{"10.0.0.2:8080", "10.0.0.4:8080"}, drift.Stale)
+	assert.Len(t, kong.List("targets"), 3)
+	controller.MaxDeleteRatio = 1
+	_, err = controller.Reconcile(ctx, "stock")
+	assert.NoError(t, err)
+	assert.Len(t, kong.List("targets"), 1)
+
+	drifts, err = controller.ReconcileAll(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestTargetControllerResync(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	client := k.NewClient(kong.URL)
+	ctx := context.Background()
+	cli, etcd := newFakeEtcdClient(t)
+	discovery := ss.NewServiceDiscoveryFromClient(cli)
+	defer discovery.Close()
+	instances := []ss.ServiceInfo{
+		{Name: "stock", InstanceId: "a", Ip: "10.0.0.1", HttpPort: 8080, Weight: 100},
+		{Name: "stock", InstanceId: "b", Ip: "10.0.0.2", HttpPort: 8080, Weight: 100},
+		{Name: "stock", InstanceId: "c", Ip: "10.0.0.3", HttpPort: 8080, Weight: 100},
+	}
+	register := func(info ss.ServiceInfo) {
+		val, _ := json.Marshal(info)
+		_, err := cli.Put(ctx, ss.ServiceKey(info.Name, info.InstanceId), string(val))
+		assert.NoError(t, err)
+	}
+
+	_, err := client.CreateUpstream(ctx, &k.Upstream{Name: "stock"})
+	assert.NoError(t, err)
+	for _, info := range instances {
+		_, err = client.UpsertTarget(ctx, "stock", &k.Target{Target: info.HttpAddr(), Weight: info.Weight})
+		assert.NoError(t, err)
+	}
+	register(instances[0])
+	register(instances[1])
+	assert.NoError(t, discovery.WatchService(ss.ServicePrefix))
+	assert.False(t, discovery.Resyncing())
+	assert.Eventually(t, func() bool { return etcd.watching() == 1 }, time.Second, 10*time.Millisecond)
+
+	// 监听中断期间实例c注册，服务列表中还没有它，不删除它的target
+	etcd.disconnect()
+	assert.Eventually(t, discovery.Resyncing, time.Second, 10*time.Millisecond)
+	register(instances[2])
+	controller := ss.NewTargetController(discovery, client)
+	drift, err := controller.Reconcile(ctx, "stock")
+	assert.ErrorIs(t, err, ss.ErrDeleteRefused)
+	assert.Equal(t, []string{"10.0.0.3:8080"}, drift.Stale)
+	assert.Len(t, kong.List("targets"), 3)
+
+	// 重新监听并追上etcd后恢复
+	assert.Eventually(t, func() bool { return !discovery.Resyncing() }, 3*time.Second, 10*time.Millisecond)
+	drift, err = controller.Reconcile(ctx, "stock")
+	assert.NoError(t, err)
+	assert.True(t, drift.Empty(), drift)
+
+	_, err = cli.Delete(ctx, ss.ServiceKey("stock", "c"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(discovery.GetService("stock")) == 2 }, time.Second, 10*time.Millisecond)
+	_, err = controller.Reconcile(ctx, "stock")
+	assert.NoError(t, err)
+	assert.Len(t, kong.List("targets"), 2)
+}
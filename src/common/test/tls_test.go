@@ -0,0 +1,319 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	k "kongApi"
+	"kongApi/kongtest"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	ss "service"
+	"test-service/handler"
+	"test-service/pb"
+	"testing"
+	"time"
+)
+
+// testCA 测试用的CA，签发同时用于服务端和客户端认证的证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "msmall test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, ca.path("ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+// issue 签发127.0.0.1的证书，写入name.pem和name-key.pem
+func (ca *testCA) issue(t *testing.T, name string, serial int64) ss.TLSConfig {
+	return ca.issueFor(t, name, serial, net.ParseIP("127.0.0.1"))
+}
+
+// issueFor 签发ip的证书
+func (ca *testCA) issueFor(t *testing.T, name string, serial int64, ip net.IP) ss.TLSConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{ip},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	writePEM(t, ca.path(name+".pem"), "CERTIFICATE", der)
+	writePEM(t, ca.path(name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+	return ss.TLSConfig{CertFile: ca.path(name + ".pem"), KeyFile: ca.path(name + "-key.pem"), CAFile: ca.path("ca.pem")}
+}
+
+func writePEM(t *testing.T, file, kind string, der []byte) {
+	assert.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600))
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	assert.False(t, ss.TLSConfig{}.Enabled())
+	assert.Error(t, ss.TLSConfig{CertFile: "a.pem"}.Validate())
+	assert.Error(t, ss.TLSConfig{CertFile: "a.pem", KeyFile: "a-key.pem", ClientAuth: true}.Validate())
+	cfg := &ss.ServiceConfig{Info: ss.ServiceInfo{Name: "order"}, TLS: ss.TLSSettings{Etcd: ss.TLSConfig{KeyFile: "a-key.pem"}}}
+	assert.ErrorContains(t, cfg.Validate(), "etcd tls")
+
+	old := &ss.ServiceConfig{Info: ss.ServiceInfo{Name: "order"}}
+	cfg = &ss.ServiceConfig{Info: ss.ServiceInfo{Name: "order"}, TLS: ss.TLSSettings{Grpc: ss.TLSConfig{CAFile: "ca.pem"}}}
+	assert.True(t, ss.DiffConfig(old, cfg).Has(ss.ChangeGrpc|ss.ChangeGateway))
+	cfg.TLS = ss.TLSSettings{Etcd: ss.TLSConfig{CAFile: "ca.pem"}}
+	assert.Equal(t, ss.ConfigChange(0), ss.DiffConfig(old, cfg))
+}
+
+func TestServiceMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverTLS := ca.issue(t, "test", 2)
+	serverTLS.ClientAuth = true
+	s, err := ss.NewService(&ss.ServiceInfo{Name: "test", Ip: "127.0.0.1", Port: 50051, HttpPort: 50052})
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetTLS(ss.TLSSettings{Grpc: serverTLS, Gateway: ca.issue(t, "gateway", 3)}))
+	handler.NewTestService(s)
+	lis, server, err := s.StartGrpcService()
+	assert.NoError(t, err)
+	defer lis.Close()
+	defer server.Stop()
+	// 网关通过mTLS连接本服务的gRPC端口
+	conn, err := s.StartGrpcGatewayService()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://127.0.0.1:50052/test/a")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Contains(t, string(body), "service A is ok")
+	// 明文请求不会被转发
+	if resp, err := http.Get("http://127.0.0.1:50052/test/a"); err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// 内部服务之间使用同一CA签发的证书调用
+	caller, err := ss.NewService(&ss.ServiceInfo{Name: "order", Ip: "127.0.0.1", Port: 50053, HttpPort: 50054})
+	assert.NoError(t, err)
+	assert.NoError(t, caller.SetTLS(ss.TLSSettings{Grpc: ca.issue(t, "order", 4)}))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	cc, err := grpc.Dial("127.0.0.1:50051", caller.ClientCredentials())
+	assert.NoError(t, err)
+	defer cc.Close()
+	msg, err := pb.NewCheckStatusClient(cc).GetStatusA(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.Contains(t, msg.GetMsg(), "127.0.0.1")
+
+	// 没有客户端证书或使用明文时拒绝连接
+	noCert := &tls.Config{RootCAs: pool}
+	cc2, err := grpc.Dial("127.0.0.1:50051", grpc.WithTransportCredentials(credentials.NewTLS(noCert)))
+	assert.NoError(t, err)
+	defer cc2.Close()
+	_, err = pb.NewCheckStatusClient(cc2).GetStatusA(ctx, &pb.Empty{})
+	assert.Error(t, err)
+	cc3, err := grpc.Dial("127.0.0.1:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer cc3.Close()
+	_, err = pb.NewCheckStatusClient(cc3).GetStatusA(ctx, &pb.Empty{})
+	assert.Error(t, err)
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := ca.issue(t, "test", 10)
+	r, err := ss.NewCertReloader(cfg)
+	assert.NoError(t, err)
+	serial := func() int64 {
+		cert, err := r.ServerTLS().GetCertificate(&tls.ClientHelloInfo{})
+		assert.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		assert.NoError(t, err)
+		return leaf.SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(10), serial())
+	changed, err := r.Reload()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// 证书轮换后重新加载
+	ca.issue(t, "test", 11)
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(cfg.CertFile, future, future))
+	changed, err = r.Reload()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, int64(11), serial())
+
+	// 写入错误的证书时继续使用原来的证书
+	assert.NoError(t, os.WriteFile(cfg.CertFile, []byte("broken"), 0600))
+	future = future.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(cfg.CertFile, future, future))
+	_, err = r.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, int64(11), serial())
+}
+
+func TestTLSServerName(t *testing.T) {
+	ca := newTestCA(t)
+	// 同一CA为其他地址签发的证书
+	other, err := ss.NewCertReloader(ca.issueFor(t, "other", 20, net.ParseIP("10.9.9.9")))
+	assert.NoError(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", other.ServerTLS())
+	assert.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	addr := lis.Addr().String()
+	dial := func(cfg *tls.Config) error {
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	client, err := ss.NewCertReloader(ss.TLSConfig{CAFile: ca.path("ca.pem")})
+	assert.NoError(t, err)
+	// 按连接地址校验，证书不属于该地址时拒绝
+	assert.Error(t, dial(client.ClientTLSFor(addr)))
+	// 连接IP地址时没有SNI，未配置ServerName时拒绝
+	assert.ErrorContains(t, dial(client.ClientTLS()), "no server name")
+	// 配置的ServerName优先
+	named, err := ss.NewCertReloader(ss.TLSConfig{CAFile: ca.path("ca.pem"), ServerName: "10.9.9.9"})
+	assert.NoError(t, err)
+	assert.NoError(t, dial(named.ClientTLSFor(addr)))
+	assert.NoError(t, dial(named.ClientTLS()))
+}
+
+func TestKongAdminTLS(t *testing.T) {
+	kong := kongtest.NewTLSServer()
+	defer kong.Close()
+	ca := t.TempDir()
+	writePEM(t, filepath.Join(ca, "kong-ca.pem"), "CERTIFICATE", kong.Certificate().Raw)
+
+	s, err := ss.NewService(&ss.ServiceInfo{Name: "order", Ip: "127.0.0.1", Port: 50055, HttpPort: 50056})
+	assert.NoError(t, err)
+	s.Kong = k.NewClient(kong.URL)
+	_, err = s.Kong.ListServices(context.Background())
+	assert.Error(t, err)
+	assert.NoError(t, s.SetTLS(ss.TLSSettings{Kong: ss.TLSConfig{CAFile: filepath.Join(ca, "kong-ca.pem")}}))
+	assert.Equal(t, kong.URL, s.Kong.BaseURL())
+	_, err = s.Kong.ListServices(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestKongAdminTLSKeepsToken(t *testing.T) {
+	kong := kongtest.NewTLSServer()
+	kong.Token = "secret"
+	defer kong.Close()
+	ca := t.TempDir()
+	writePEM(t, filepath.Join(ca, "kong-ca.pem"), "CERTIFICATE", kong.Certificate().Raw)
+
+	s, err := ss.NewService(&ss.ServiceInfo{Name: "order", Ip: "127.0.0.1", Port: 50057, HttpPort: 50058})
+	assert.NoError(t, err)
+	s.Kong = k.NewClient(kong.URL, k.WithAdminToken("secret"))
+	// 修改Kong的TLS配置后仍然携带token
+	assert.NoError(t, s.SetTLS(ss.TLSSettings{Kong: ss.TLSConfig{CAFile: filepath.Join(ca, "kong-ca.pem")}}))
+	_, err = s.Kong.ListServices(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetTLS(ss.TLSSettings{Kong: ss.TLSConfig{CAFile: filepath.Join(ca, "kong-ca.pem"), ServerName: "127.0.0.1"}}))
+	_, err = s.Kong.ListServices(context.Background())
+	assert.NoError(t, err)
+
+	// 不携带token时被拒绝
+	tlsConfig, err := k.NewTLSConfig(filepath.Join(ca, "kong-ca.pem"), "", "")
+	assert.NoError(t, err)
+	_, err = k.NewClient(kong.URL).WithTLS(tlsConfig).ListServices(context.Background())
+	assert.ErrorIs(t, err, k.ErrUnauthorized)
+}
+
+func TestReloadGrpcTLSWithOpenStream(t *testing.T) {
+	ca := newTestCA(t)
+	s, err := ss.NewService(&ss.ServiceInfo{Name: "order", Ip: "127.0.0.1", Port: 50059, HttpPort: 50060})
+	assert.NoError(t, err)
+	s.SetShutdownConfig(ss.ShutdownConfig{GatewayTimeout: 1, GrpcTimeout: 1, HookTimeout: 1})
+	s.Register(func(*grpc.Server) {}, nil)
+	lis, server, err := s.StartGrpcService()
+	assert.NoError(t, err)
+	defer lis.Close()
+	defer server.Stop()
+
+	// Kong或客户端的健康检查Watch不会主动结束
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+
+	// 修改gRPC的TLS配置需要重启gRPC服务，超过GrpcTimeout后强制关闭流
+	cfg := &ss.ServiceConfig{Info: s.Info(), TLS: ss.TLSSettings{Grpc: ca.issue(t, "order", 2)}}
+	done := make(chan error, 1)
+	go func() { done <- s.ApplyConfig(ss.NewServiceManager(s), cfg) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config reload blocked by an open stream")
+	}
+	_, err = stream.Recv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,230 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"mqApi"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"tracing"
+)
+
+// memoryExporter 将span保存在内存中
+type memoryExporter struct {
+	lock  sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *memoryExporter) Export(spans []tracing.SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(ctx context.Context) error { return nil }
+
+func (e *memoryExporter) byKind(kind tracing.SpanKind) []tracing.SpanData {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var res []tracing.SpanData
+	for _, s := range e.spans {
+		if s.Kind == kind {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func TestTraceparent(t *testing.T) {
+	sc, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		_, err := tracing.ParseTraceparent(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	// 不导出span的服务仍按上游的采样标记向下游传递
+	tracer := tracing.NewTracer("relay", nil)
+	for _, flags := range []string{"01", "00"} {
+		parent, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-" + flags)
+		assert.NoError(t, err)
+		ctx := tracing.ContextWithRemoteSpanContext(context.Background(), parent)
+		_, span := tracer.Start(ctx, "relay", tracing.KindServer)
+		assert.Equal(t, parent.Sampled, span.SpanContext().Sampled)
+		assert.Equal(t, parent.TraceID, span.SpanContext().TraceID)
+		span.End()
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := tracing.NewTracer("test-service", exporter)
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.NewTracer("", nil))
+
+	// gRPC 服务端
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor()))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// 模拟网关：HTTP -> gRPC
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	var mqMsg mqApi.MqMsg
+	gateway := httptest.NewServer(tracing.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := client.Check(r.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		mqMsg = mqApi.MqMsg{MsgType: mqApi.StorageCreate}.WithContext(r.Context())
+	})))
+	defer gateway.Close()
+
+	// Kong 传入的 traceparent
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/v1/product/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, tracer.Flush(ctx))
+
+	servers := exporter.byKind(tracing.KindServer)
+	clients := exporter.byKind(tracing.KindClient)
+	assert.Len(t, servers, 2)
+	assert.Len(t, clients, 1)
+	var httpSpan, grpcSpan tracing.SpanData
+	for _, s := range servers {
+		if s.Name == "GET /v1/product/1" {
+			httpSpan = s
+		} else {
+			grpcSpan = s
+		}
+	}
+	// 所有span属于同一个trace，父子关系为 Kong -> HTTP -> gRPC客户端 -> gRPC服务端
+	for _, s := range append(servers, clients...) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID)
+	}
+	assert.Equal(t, "00f067aa0ba902b7", httpSpan.ParentSpanID)
+	assert.Equal(t, httpSpan.SpanID, clients[0].ParentSpanID)
+	assert.Equal(t, clients[0].SpanID, grpcSpan.ParentSpanID)
+	assert.Equal(t, "grpc.health.v1.Health/Check", grpcSpan.Name)
+	assert.Equal(t, "grpc", grpcSpan.Attributes["rpc.system"])
+	assert.Contains(t, resp.Header.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	// 消息头延续同一个trace
+	assert.Contains(t, mqMsg.Headers["traceparent"], "4bf92f3577b34da6a3ce929d0e0e4736")
+	sc := tracing.SpanContextFromContext(mqMsg.Context(context.Background()))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, httpSpan.SpanID, sc.SpanID.String())
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		data, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(data, &body))
+	}))
+	defer collector.Close()
+
+	exporter := tracing.NewOTLPExporter(collector.URL, nil)
+	now := time.Now()
+	err := exporter.Export([]tracing.SpanData{{
+		Service:    "order-service",
+		Name:       "stock.StockService/GetStock",
+		Kind:       tracing.KindClient,
+		TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:     "00f067aa0ba902b7",
+		Start:      now,
+		End:        now.Add(time.Millisecond),
+		Attributes: map[string]any{"rpc.grpc.status_code": 14},
+		StatusCode: tracing.StatusError,
+	}})
+	assert.NoError(t, err)
+
+	rs := body["resourceSpans"].([]any)[0].(map[string]any)
+	attr := rs["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	assert.Equal(t, "service.name", attr["key"])
+	assert.Equal(t, "order-service", attr["value"].(map[string]any)["stringValue"])
+	span := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span["traceId"])
+	assert.Equal(t, float64(tracing.KindClient), span["kind"])
+	assert.Equal(t, float64(tracing.StatusError), span["status"].(map[string]any)["code"])
+	assert.Equal(t, "14", span["attributes"].([]any)[0].(map[string]any)["value"].(map[string]any)["intValue"])
+
+	collector.Close()
+	assert.Error(t, exporter.Export([]tracing.SpanData{{Name: "lost"}}))
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := tracing.NewFileExporter(path)
+	assert.NoError(t, err)
+	tracer := tracing.NewTracer("product-service", exporter)
+
+	ctx, parent := tracer.Start(context.Background(), "seckill", tracing.KindInternal)
+	_, child := tracer.Start(ctx, "gorm.query", tracing.KindClient)
+	child.SetAttribute("db.statement", "SELECT 1")
+	child.End()
+	parent.End()
+	parent.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, tracer.Shutdown(shutdownCtx))
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var spans []tracing.SpanData
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s tracing.SpanData
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &s))
+		spans = append(spans, s)
+	}
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "gorm.query", spans[0].Name)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, "SELECT 1", spans[0].Attributes["db.statement"])
+}
@@ -0,0 +1,316 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gid"
+	"github.com/alicebob/miniredis/v2"
+	snowflak "github.com/bwmarrin/snowflake"
+	"github.com/stretchr/testify/assert"
+	"mqApi"
+	"reflect"
+	"storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+type wbProduct struct {
+	ID    uint    `json:"id"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+// fakeORM 记录写入操作，前failures次写入返回错误
+type fakeORM struct {
+	lock     sync.Mutex
+	ops      []string
+	rows     map[uint]wbProduct
+	batches  []int
+	failures int
+}
+
+func newFakeORM() *fakeORM {
+	return &fakeORM{rows: make(map[uint]wbProduct)}
+}
+
+func (o *fakeORM) fail() error {
+	if o.failures != 0 {
+		o.failures--
+		return errors.New("db unavailable")
+	}
+	return nil
+}
+
+func (o *fakeORM) Create(model interface{}) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if err := o.fail(); err != nil {
+		return err
+	}
+	p := model.(*wbProduct)
+	o.rows[p.ID] = *p
+	o.ops = append(o.ops, "create")
+	return nil
+}
+
+func (o *fakeORM) CreateBatch(models interface{}, batchSize int) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if err := o.fail(); err != nil {
+		return err
+	}
+	for _, p := range models.([]*wbProduct) {
+		o.rows[p.ID] = *p
+	}
+	o.batches = append(o.batches, len(models.([]*wbProduct)))
+	return nil
+}
+
+func (o *fakeORM) Find(model interface{}, id interface{}) (interface{}, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	p, ok := o.rows[uint(id.(int64))]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	*model.(*wbProduct) = p
+	return model, nil
+}
+
+func (o *fakeORM) ScanIDs(model interface{}, batchSize int, fn func(ids []int64) error) error {
+	o.lock.Lock()
+	ids := make([]int64, 0, len(o.rows))
+	for id := range o.rows {
+		ids = append(ids, int64(id))
+	}
+	o.lock.Unlock()
+	return fn(ids)
+}
+
+func (o *fakeORM) FindAll(model interface{}, condition storage.FieldCondition) ([]interface{}, error) {
+	return nil, nil
+}
+
+func (o *fakeORM) Update(model interface{}, id interface{}, fields map[string]interface{}) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if err := o.fail(); err != nil {
+		return err
+	}
+	p := o.rows[uint(id.(int64))]
+	v := reflect.ValueOf(&p).Elem()
+	for name, value := range fields {
+		v.FieldByName(name).Set(reflect.ValueOf(value))
+	}
+	o.rows[p.ID] = p
+	o.ops = append(o.ops, "update")
+	return nil
+}
+
+func (o *fakeORM) Delete(model interface{}, id interface{}) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if err := o.fail(); err != nil {
+		return err
+	}
+	delete(o.rows, uint(id.(int64)))
+	o.ops = append(o.ops, "delete")
+	return nil
+}
+
+// wbMsg 构造与BaseStorage发送的内容相同的消息，并经过一次JSON编解码
+func wbMsg(t *testing.T, msgType mqApi.MsgType, version int64, data ...*storage.STData) mqApi.MqMsg {
+	var payload interface{}
+	if len(data) == 1 {
+		d := *data[0]
+		d.Version = version
+		payload = d
+	} else {
+		pair := []storage.STData{*data[0], *data[1]}
+		pair[0].Version, pair[1].Version = version, version
+		payload = pair
+	}
+	body, err := json.Marshal(mqApi.MqMsg{MsgType: msgType, Data: payload})
+	assert.NoError(t, err)
+	var msg mqApi.MqMsg
+	assert.NoError(t, json.Unmarshal(body, &msg))
+	return msg
+}
+
+func newWbData(t *testing.T, id int64, p wbProduct) *storage.STData {
+	data, err := storage.NewSTData(&gid.SnowFlakeGID{ID: snowflak.ID(id)}, &p)
+	assert.NoError(t, err)
+	return data
+}
+
+func TestSTDataJSON(t *testing.T) {
+	data := newWbData(t, 1024, wbProduct{Name: "apple"})
+	assert.Equal(t, "test.wbProduct", data.Model)
+	assert.Equal(t, int64(1024), data.ID)
+	assert.Equal(t, snowflak.ID(1024).Base64(), data.Key)
+
+	body, err := json.Marshal(data)
+	assert.NoError(t, err)
+	var got storage.STData
+	assert.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, data.Key, got.Key)
+	assert.Equal(t, "apple", got.Value.(map[string]interface{})["name"])
+}
+
+func TestWriteBehindCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cache := storage.NewRedisCache(mr.Addr(), "", 0)
+	s := &storage.BaseStorage[string]{MiddlewareCache: cache}
+	w := storage.NewWriteBehind(s, storage.WriteBehindConfig{CacheTTL: time.Minute})
+	a := newWbData(t, 1, wbProduct{Name: "apple"})
+	b := newWbData(t, 2, wbProduct{Name: "banana"})
+
+	errs := w.HandleCache([]mqApi.MqMsg{
+		wbMsg(t, mqApi.StorageCreate, 1, a),
+		wbMsg(t, mqApi.StorageCreate, 1, b),
+		wbMsg(t, mqApi.StorageDelete, 2, b),
+	})
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	// 写入与读路径相同格式的缓存，读取时不再访问数据库
+	var p wbProduct
+	assert.NoError(t, s.Load(context.Background(), a.Key, &p, nil))
+	assert.Equal(t, "apple", p.Name)
+	assert.Equal(t, time.Minute+storage.DefaultCacheConfig().StaleTTL, mr.TTL(a.Key))
+	assert.False(t, mr.Exists(b.Key))
+
+	// 重复投递和过期的消息被确认但不再写入
+	errs = w.HandleCache([]mqApi.MqMsg{wbMsg(t, mqApi.StorageCreate, 1, b)})
+	assert.Equal(t, []error{nil}, errs)
+	assert.False(t, mr.Exists(b.Key))
+}
+
+func TestWriteBehindORM(t *testing.T) {
+	orm := newFakeORM()
+	w := storage.NewWriteBehind(&storage.BaseStorage[string]{ORM: orm}, storage.WriteBehindConfig{Workers: 1, BatchSize: 10})
+	w.RegisterModel(wbProduct{})
+	a := newWbData(t, 1, wbProduct{Name: "apple", Price: 1})
+	b := newWbData(t, 2, wbProduct{Name: "banana", Price: 2})
+	c := newWbData(t, 3, wbProduct{Name: "cherry", Price: 3})
+	newA := newWbData(t, 1, wbProduct{Price: 5})
+	newA.Value = map[string]interface{}{"price": 5}
+
+	// 连续的创建合并为一次批量插入，之后同一数据的更新和删除按顺序写入
+	errs := w.HandleORM([]mqApi.MqMsg{
+		wbMsg(t, mqApi.StorageCreate, 1, a),
+		wbMsg(t, mqApi.StorageCreate, 1, b),
+		wbMsg(t, mqApi.StorageCreate, 1, c),
+		wbMsg(t, mqApi.StorageUpdate, 2, a, newA),
+		wbMsg(t, mqApi.StorageDelete, 3, c),
+	})
+	assert.Equal(t, make([]error, 5), errs)
+	assert.Equal(t, []int{3}, orm.batches)
+	assert.Equal(t, []string{"update", "delete"}, orm.ops)
+	assert.Equal(t, wbProduct{ID: 1, Name: "apple", Price: 5}, orm.rows[1])
+	assert.Equal(t, wbProduct{ID: 2, Name: "banana", Price: 2}, orm.rows[2])
+	assert.NotContains(t, orm.rows, uint(3))
+
+	// 重复投递的创建不会覆盖更新后的数据
+	errs = w.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageCreate, 1, a)})
+	assert.Equal(t, []error{nil}, errs)
+	assert.Equal(t, float64(5), orm.rows[1].Price)
+}
+
+func TestWriteBehindRetryDeadLetter(t *testing.T) {
+	orm := newFakeORM()
+	var dead []string
+	deadErr := error(nil)
+	cfg := storage.WriteBehindConfig{
+		Workers:    1,
+		MaxRetries: 2,
+		RetryDelay: time.Millisecond,
+		DeadLetter: func(queue string, msg mqApi.MqMsg, reason error) error {
+			if deadErr != nil {
+				return deadErr
+			}
+			dead = append(dead, queue+": "+reason.Error())
+			return nil
+		},
+	}
+	w := storage.NewWriteBehind(&storage.BaseStorage[string]{ORM: orm}, cfg)
+	w.RegisterModel(wbProduct{})
+	a := newWbData(t, 1, wbProduct{Name: "apple"})
+
+	// 重试成功
+	orm.failures = 2
+	errs := w.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageCreate, 1, a)})
+	assert.Equal(t, []error{nil}, errs)
+	assert.Contains(t, orm.rows, uint(1))
+	assert.Empty(t, dead)
+
+	// 超过重试次数后转入死信队列并确认
+	orm.failures = 3
+	errs = w.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageDelete, 2, a)})
+	assert.Equal(t, []error{nil}, errs)
+	assert.Equal(t, []string{"OrmSys: db unavailable"}, dead)
+
+	// 未注册的模型不重试
+	unknown := newWbData(t, 7, wbProduct{})
+	unknown.Model = "test.unknown"
+	errs = w.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageDelete, 3, unknown)})
+	assert.Equal(t, []error{nil}, errs)
+	assert.Len(t, dead, 2)
+	assert.Contains(t, dead[1], `model "test.unknown" is not registered`)
+
+	// 死信队列不可用时重新入队，同一数据之后的消息也重新入队
+	deadErr = errors.New("rabbitmq down")
+	orm.failures = -1
+	b := newWbData(t, 2, wbProduct{Name: "banana"})
+	errs = w.HandleORM([]mqApi.MqMsg{
+		wbMsg(t, mqApi.StorageDelete, 4, a),
+		wbMsg(t, mqApi.StorageCreate, 5, a),
+		wbMsg(t, mqApi.StorageCreate, 5, b),
+	})
+	assert.ErrorContains(t, errs[0], "rabbitmq down")
+	assert.Error(t, errs[1])
+	assert.ErrorContains(t, errs[2], "rabbitmq down")
+}
+
+func TestWriteBehindReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	orm := newFakeORM()
+	orm.rows[1] = wbProduct{ID: 1, Name: "apple", Price: 1}
+	// 两个实例共享缓存中间件和数据库，消费同一个队列
+	s := newCacheAside(t, mr, storage.DefaultCacheConfig())
+	s.ORM = orm
+	w1 := storage.NewWriteBehind(s, storage.WriteBehindConfig{Workers: 1})
+	w2 := storage.NewWriteBehind(s, storage.WriteBehindConfig{Workers: 1})
+	w1.RegisterModel(wbProduct{})
+	w2.RegisterModel(wbProduct{})
+	old := newWbData(t, 1, wbProduct{})
+	v2 := newWbData(t, 1, wbProduct{})
+	v2.Value = map[string]interface{}{"price": 2}
+	v3 := newWbData(t, 1, wbProduct{})
+	v3.Value = map[string]interface{}{"price": 3}
+
+	// 写入前读取数据库回填的旧值在写入后被删除
+	ctx := context.Background()
+	data := newWbData(t, 1, wbProduct{})
+	assert.NoError(t, s.Get(ctx, data))
+	assert.True(t, mr.Exists(data.Key))
+	assert.Equal(t, []error{nil}, w1.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageUpdate, 3, old, v3)}))
+	assert.False(t, mr.Exists(data.Key))
+	assert.NoError(t, s.Get(ctx, data))
+	assert.Equal(t, float64(3), data.Value.(*wbProduct).Price)
+
+	// 另一个实例收到更早的更新时不再写入
+	assert.Equal(t, []error{nil}, w2.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageUpdate, 2, old, v2)}))
+	assert.Equal(t, float64(3), orm.rows[1].Price)
+	assert.Equal(t, []string{"update"}, orm.ops)
+
+	// 其他实例持有该数据的锁时重新入队
+	lock, err := storage.TryLock(ctx, storage.NewRedisCache(mr.Addr(), "", 0).Client(), "applied:OrmSys:1", time.Minute)
+	assert.NoError(t, err)
+	errs := w2.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageDelete, 4, old)})
+	assert.Error(t, errs[0])
+	assert.Contains(t, orm.rows, uint(1))
+	assert.NoError(t, lock.Unlock(ctx))
+	assert.Equal(t, []error{nil}, w2.HandleORM([]mqApi.MqMsg{wbMsg(t, mqApi.StorageDelete, 4, old)}))
+	assert.NotContains(t, orm.rows, uint(1))
+}
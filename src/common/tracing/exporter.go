@@ -0,0 +1,194 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter 导出已结束的span
+type Exporter interface {
+	Export(spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// FileExporter 以JSON Lines格式将span追加写入文件
+type FileExporter struct {
+	lock sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &FileExporter{file: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (e *FileExporter) Export(spans []SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	enc := json.NewEncoder(e.w)
+	for i := range spans {
+		if err := enc.Encode(&spans[i]); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+func (e *FileExporter) Shutdown(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	return e.file.Close()
+}
+
+// OTLPExporter 使用OTLP/HTTP的JSON编码将span发送到collector（POST <endpoint>/v1/traces）
+type OTLPExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewOTLPExporter endpoint形如 http://127.0.0.1:4318，headers可用于携带鉴权信息
+func NewOTLPExporter(endpoint string, headers map[string]string) *OTLPExporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &OTLPExporter{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// ---------------- OTLP JSON 编码 ----------------
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int32:
+		return map[string]any{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	res := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		res = append(res, otlpKeyValue{Key: k, Value: otlpValue(v)})
+	}
+	return res
+}
+
+// otlpRequest 按服务分组构造 ExportTraceServiceRequest
+func otlpRequest(spans []SpanData) map[string]any {
+	byService := make(map[string][]otlpSpan)
+	var services []string
+	for _, s := range spans {
+		if _, ok := byService[s.Service]; !ok {
+			services = append(services, s.Service)
+		}
+		byService[s.Service] = append(byService[s.Service], otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentSpanID,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.StatusCode, Message: s.StatusMessage},
+		})
+	}
+	resourceSpans := make([]map[string]any, 0, len(services))
+	for _, service := range services {
+		resourceSpans = append(resourceSpans, map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": service}),
+			},
+			"scopeSpans": []map[string]any{{
+				"scope": map[string]any{"name": "tracing"},
+				"spans": byService[service],
+			}},
+		})
+	}
+	return map[string]any{"resourceSpans": resourceSpans}
+}
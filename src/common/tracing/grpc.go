@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// grpcAttributes 按OpenTelemetry语义约定设置rpc属性
+func grpcAttributes(span *Span, fullMethod string) {
+	service, method := "", fullMethod
+	if parts := strings.Split(strings.TrimPrefix(fullMethod, "/"), "/"); len(parts) == 2 {
+		service, method = parts[0], parts[1]
+	}
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.service", service)
+	span.SetAttribute("rpc.method", method)
+}
+
+func endGrpcSpan(span *Span, err error) {
+	code := status.Code(err)
+	span.SetAttribute("rpc.grpc.status_code", int(code))
+	if err != nil {
+		span.SetStatus(StatusError, code.String()+": "+status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// endOnPanic handler发生panic时先结束span再继续向外抛出，由外层的recovery处理
+func endOnPanic(span *Span) {
+	if p := recover(); p != nil {
+		span.SetStatus(StatusError, fmt.Sprintf("panic: %v", p))
+		span.End()
+		panic(p)
+	}
+}
+
+// startServerSpan 从incoming metadata中读取上游链路并创建服务端span
+func startServerSpan(ctx context.Context, fullMethod string) (context.Context, *Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = Extract(ctx, MetadataCarrier(md))
+	}
+	ctx, span := Start(ctx, strings.TrimPrefix(fullMethod, "/"), KindServer)
+	grpcAttributes(span, fullMethod)
+	return ctx, span
+}
+
+// startClientSpan 创建客户端span并写入outgoing metadata
+func startClientSpan(ctx context.Context, fullMethod string) (context.Context, *Span) {
+	ctx, span := Start(ctx, strings.TrimPrefix(fullMethod, "/"), KindClient)
+	grpcAttributes(span, fullMethod)
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	Inject(ctx, MetadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// UnaryServerInterceptor 为每个一元请求创建服务端span
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, span := startServerSpan(ctx, info.FullMethod)
+		defer endOnPanic(span)
+		resp, err := handler(ctx, req)
+		endGrpcSpan(span, err)
+		return resp, err
+	}
+}
+
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor 为每个流创建服务端span，span覆盖整个流的生命周期
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServerSpan(ss.Context(), info.FullMethod)
+		defer endOnPanic(span)
+		err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx})
+		endGrpcSpan(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor 为每个一元调用创建客户端span，并通过metadata传递traceparent
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := startClientSpan(ctx, method)
+		span.SetAttribute("net.peer.name", cc.Target())
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endGrpcSpan(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor 为流式调用创建客户端span，仅记录建立流的结果
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startClientSpan(ctx, method)
+		span.SetAttribute("net.peer.name", cc.Target())
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		endGrpcSpan(span, err)
+		return stream, err
+	}
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"net/http"
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Handler 为每个HTTP请求创建服务端span，读取Kong等上游传入的traceparent，
+// 并在响应头中返回traceparent方便排查
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := Extract(r.Context(), HeaderCarrier(r.Header))
+		ctx, span := Start(ctx, r.Method+" "+r.URL.Path, KindServer)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.host", r.Host)
+		Inject(ctx, HeaderCarrier(w.Header()))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetStatus(StatusError, http.StatusText(rec.status))
+		}
+		span.End()
+	})
+}
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader W3C Trace Context 的传递字段
+const TraceparentHeader = "traceparent"
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// Traceparent 按W3C格式编码：00-<trace-id>-<parent-id>-<flags>
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent 解析W3C traceparent
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("%w: %q", errInvalidTraceparent, s)
+	}
+	// 版本00只允许4段，更高版本可能追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("%w: %q", errInvalidTraceparent, s)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("%w: %q", errInvalidTraceparent, s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("%w: %v", errInvalidTraceparent, err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("%w: %v", errInvalidTraceparent, err)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("%w: %v", errInvalidTraceparent, err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("%w: all-zero id", errInvalidTraceparent)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	sc.Remote = true
+	return sc, nil
+}
+
+// Carrier 链路上下文的载体（HTTP头、gRPC metadata、消息头等）
+type Carrier interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// MapCarrier 以map作为载体，用于消息队列的消息头
+type MapCarrier map[string]string
+
+func (c MapCarrier) Get(key string) string { return c[key] }
+
+func (c MapCarrier) Set(key, value string) { c[key] = value }
+
+// HeaderCarrier 以HTTP头作为载体
+type HeaderCarrier http.Header
+
+func (c HeaderCarrier) Get(key string) string { return http.Header(c).Get(key) }
+
+func (c HeaderCarrier) Set(key, value string) { http.Header(c).Set(key, value) }
+
+// MetadataCarrier 以gRPC metadata作为载体
+type MetadataCarrier metadata.MD
+
+func (c MetadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c MetadataCarrier) Set(key, value string) { metadata.MD(c).Set(key, value) }
+
+// Inject 将context中的链路上下文写入载体
+func Inject(ctx context.Context, carrier Carrier) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		carrier.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Extract 从载体中读取上游的链路上下文，读取失败时返回原context
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	v := carrier.Get(TraceparentHeader)
+	if v == "" {
+		return ctx
+	}
+	sc, err := ParseTraceparent(v)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
@@ -0,0 +1,390 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+// TraceID W3C trace-id，16字节
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID W3C parent-id，8字节
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext 在进程间传递的链路上下文
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	Remote  bool // 是否从上游传递过来
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind 与OpenTelemetry一致的span类型
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota + 1
+	KindServer
+	KindClient
+	KindProducer
+	KindConsumer
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	case KindProducer:
+		return "producer"
+	case KindConsumer:
+		return "consumer"
+	default:
+		return "internal"
+	}
+}
+
+// StatusCode span的状态
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// SpanData 结束后交给Exporter导出的span数据
+type SpanData struct {
+	Service       string         `json:"service"`
+	Name          string         `json:"name"`
+	Kind          SpanKind       `json:"kind"`
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	StatusCode    StatusCode     `json:"status_code"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+// Span 一次操作的链路记录，方法均可在nil上调用
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	lock  sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext 返回span的链路上下文
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// recording span会被导出：已采样且Tracer配置了Exporter
+// 本地不导出时采样标记保持不变，继续传递给下游
+func (s *Span) recording() bool {
+	return s.sc.Sampled && s.tracer != nil && s.tracer.exporter != nil
+}
+
+// SetAttribute 设置属性，值应为 string/bool/int/int64/float64
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil || !s.recording() {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetStatus 设置span状态
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil || !s.recording() {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.StatusCode, s.data.StatusMessage = code, msg
+}
+
+// RecordError err不为nil时将span标记为错误
+func (s *Span) RecordError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// End 结束span并提交导出，重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.lock.Unlock()
+	if s.recording() {
+		s.tracer.export(data)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan 将span放入context，之后创建的span以其为父span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 获取context中当前的span，没有时返回nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext 放入从上游传递过来的链路上下文
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext 返回context中当前的链路上下文，本地span优先
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Tracer 创建span并将结束的span批量交给Exporter
+type Tracer struct {
+	service  string
+	exporter Exporter
+	ratio    float64
+
+	queue   chan SpanData
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// TracerOption Tracer的可选配置
+type TracerOption func(t *Tracer)
+
+// WithSampleRatio 按比例采样新的trace，默认全部采样；上游已有trace时跟随上游的采样标记
+func WithSampleRatio(ratio float64) TracerOption {
+	return func(t *Tracer) {
+		t.ratio = ratio
+	}
+}
+
+// NewTracer 创建Tracer，exporter为nil时只生成和传递链路上下文，不导出span
+func NewTracer(service string, exporter Exporter, opts ...TracerOption) *Tracer {
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+		ratio:    1,
+		queue:    make(chan SpanData, 2048),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if exporter != nil {
+		go t.run()
+	}
+	return t
+}
+
+// Start 创建子span，返回包含新span的context
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID, sc.Sampled = parent.TraceID, parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.ratio >= 1 || mrand.Float64() < t.ratio
+	}
+	span := &Span{tracer: t, sc: sc}
+	span.data = SpanData{
+		Service: t.service,
+		Name:    name,
+		Kind:    kind,
+		TraceID: sc.TraceID.String(),
+		SpanID:  sc.SpanID.String(),
+		Start:   time.Now(),
+	}
+	if parent.SpanID.IsValid() {
+		span.data.ParentSpanID = parent.SpanID.String()
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) export(data SpanData) {
+	select {
+	case t.queue <- data:
+	case <-t.done:
+	default:
+		// 队列已满时丢弃，不阻塞业务请求
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, 256)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			slog.Warn("tracing export failed", "spans", len(batch), "error", err)
+		}
+		batch = make([]SpanData, 0, 256)
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= 256 {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ch := <-t.flush:
+			drain()
+			export()
+			close(ch)
+		case <-t.done:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+// Flush 立即导出已结束的span
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	ch := make(chan struct{})
+	select {
+	case t.flush <- ch:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown 导出剩余的span并关闭Exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	if err := t.Flush(ctx); err != nil {
+		return err
+	}
+	t.once.Do(func() { close(t.done) })
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+var (
+	globalLock   sync.RWMutex
+	globalTracer = NewTracer("", nil)
+)
+
+// SetTracer 设置全局Tracer，拦截器等均使用全局Tracer
+func SetTracer(t *Tracer) {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	globalTracer = t
+}
+
+// GetTracer 返回全局Tracer
+func GetTracer() *Tracer {
+	globalLock.RLock()
+	defer globalLock.RUnlock()
+	return globalTracer
+}
+
+// Start 使用全局Tracer创建span
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return GetTracer().Start(ctx, name, kind)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], mrand.Uint64())
+	}
+	return id
+}
@@ -1 +1,74 @@
 package main
+
+import (
+	"context"
+	"flag"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	k "kongApi"
+	"log/slog"
+	"logging"
+	"ms-service/models"
+	"os"
+	"os/signal"
+	ss "service"
+	"storage"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 秒杀路由调度：启动时按数据库中的秒杀活动重建路由计划，活动开始前创建高优先级路由，结束后删除
+// 可以部署多个副本，通过etcd选主保证同一时间只有一个副本修改Kong
+func main() {
+	endpoints := flag.String("etcd", "127.0.0.1:12379,127.0.0.1:22379,127.0.0.1:32379", "etcd endpoints, comma separated")
+	dsn := flag.String("dsn", "root:root@tcp(127.0.0.1:3307)/msmall?charset=utf8mb4&parseTime=True&loc=Local", "mysql dsn of the seckill activities")
+	kongURL := flag.String("kong", k.KongAdminURL, "kong admin api url")
+	kongToken := flag.String("kong-token", os.Getenv("KONG_ADMIN_TOKEN"), "kong admin token")
+	service := flag.String("service", "ms", "kong service the seckill routes forward to")
+	interval := flag.Duration("interval", time.Minute, "interval to reload seckill activities")
+	lead := flag.Duration("lead", time.Minute, "create seckill routes this long before the activity starts")
+	logLevel := flag.String("log-level", "info", "log level")
+	flag.Parse()
+
+	hostname, _ := os.Hostname()
+	id := hostname + "-" + strconv.Itoa(os.Getpid())
+	if err := logging.Setup(logging.Options{Service: "seckill-route-scheduler", Instance: id, Level: *logLevel}); err != nil {
+		slog.Error("failed to setup logging", "error", err)
+		os.Exit(1)
+	}
+
+	db, err := gorm.Open(mysql.Open(*dsn), &gorm.Config{})
+	if err != nil {
+		slog.Error("failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	if err := storage.RegisterMetrics(db); err != nil {
+		slog.Error("failed to register database metrics", "error", err)
+		os.Exit(1)
+	}
+	if err := db.AutoMigrate(&models.ProductSeckill{}); err != nil {
+		slog.Error("failed to migrate seckill activities", "error", err)
+		os.Exit(1)
+	}
+	cli, err := ss.NewEtcdClient(strings.Split(*endpoints, ","))
+	if err != nil {
+		slog.Error("failed to connect to etcd", "error", err)
+		os.Exit(1)
+	}
+	defer cli.Close()
+
+	var opts []k.Option
+	if *kongToken != "" {
+		opts = append(opts, k.WithAdminToken(*kongToken))
+	}
+	routes := models.NewSeckillRoutes(db, k.NewClient(*kongURL, opts...), *service)
+	routes.Interval = *interval
+	routes.Scheduler.Lead = *lead
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	err = ss.RunAsLeader(ctx, cli, ss.ElectionPrefix+"seckill-route-scheduler", id, routes.Run)
+	slog.Info("seckill route scheduler stopped", "error", err)
+}
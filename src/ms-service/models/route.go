@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"kongApi"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// SeckillRoutePriority 秒杀路由的regex_priority，高于普通路由，秒杀请求优先匹配
+const SeckillRoutePriority = 100
+
+// GatewayRoute 秒杀活动在Kong中独立的高优先级路由，活动开始前创建，结束后删除
+func (p *ProductSeckill) GatewayRoute(service string) kongApi.ScheduledRoute {
+	priority := SeckillRoutePriority
+	return kongApi.ScheduledRoute{
+		Route: kongApi.Route{
+			Name:          fmt.Sprintf("seckill-%d", p.ID),
+			Paths:         []string{fmt.Sprintf(`~/service/seckill/%d($|/)`, p.ID)},
+			Methods:       []string{"GET", "POST"},
+			RegexPriority: &priority,
+			Service:       &kongApi.Ref{Name: service},
+			Tags:          []string{"seckill"},
+		},
+		StartTime: p.StartTime,
+		EndTime:   p.EndTime,
+	}
+}
+
+// SeckillRoutes 按数据库中的秒杀活动维护路由计划，活动新增、修改或删除后在下一次Load时生效
+type SeckillRoutes struct {
+	db        *gorm.DB
+	kong      *kongApi.Client
+	Scheduler *kongApi.RouteScheduler // 可以修改Lead等调度参数
+	service   string                  // 秒杀路由转发到的Kong Service
+	Interval  time.Duration           // 重新读取活动的间隔
+}
+
+func NewSeckillRoutes(db *gorm.DB, kong *kongApi.Client, service string) *SeckillRoutes {
+	return &SeckillRoutes{
+		db:        db,
+		kong:      kong,
+		Scheduler: kongApi.NewRouteScheduler(kong),
+		service:   service,
+		Interval:  time.Minute,
+	}
+}
+
+// Load 读取未结束的活动重建路由计划，取消已删除或已结束的活动在计划中和Kong中的路由
+func (r *SeckillRoutes) Load(ctx context.Context, now time.Time) error {
+	var activities []ProductSeckill
+	if err := r.db.WithContext(ctx).Where("end_time > ?", now).Find(&activities).Error; err != nil {
+		return fmt.Errorf("load seckill activities: %w", err)
+	}
+	var errs []error
+	active := make(map[string]bool, len(activities))
+	for i := range activities {
+		route := activities[i].GatewayRoute(r.service)
+		if err := r.Scheduler.Schedule(route); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		active[route.Route.Name] = true
+	}
+
+	// 服务停止期间删除的活动不在计划中，它们的路由只能从Kong中按标签找到
+	stale := make(map[string]bool)
+	for _, name := range r.Scheduler.Scheduled() {
+		stale[name] = true
+	}
+	routes, err := r.kong.ListRoutes(ctx)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("list kong routes: %w", err))...)
+	}
+	for _, route := range routes {
+		if slices.Contains(route.Tags, "seckill") {
+			stale[route.Name] = true
+		}
+	}
+	for name := range stale {
+		if active[name] {
+			continue
+		}
+		if err := r.Scheduler.Cancel(ctx, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		slog.Info("seckill route cancelled", "route", name)
+	}
+	return errors.Join(errs...)
+}
+
+// Run 启动时重建路由计划，之后每隔Interval重新读取活动，直到ctx结束
+func (r *SeckillRoutes) Run(ctx context.Context) error {
+	if err := r.Load(ctx, time.Now()); err != nil {
+		slog.Warn("load seckill routes failed", "error", err)
+	}
+	go r.Scheduler.Run(ctx)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := r.Load(ctx, time.Now()); err != nil {
+				slog.Warn("load seckill routes failed", "error", err)
+			}
+		}
+	}
+}
@@ -4,18 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
-	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
-	"log"
 	"math"
-	"net"
-	"net/http"
 	"product-service/models"
 	"product-service/pb"
 	ss "service"
-	"strconv"
 	"time"
 )
 
@@ -24,62 +20,20 @@ type ProductService struct {
 	*ss.Service
 }
 
-func (t ProductService) StartGrpcService() (net.Listener, *grpc.Server, error) {
-	// 启动 grpc 服务
-	lis, err := net.Listen("tcp", t.ServiceInfo.Ip+":"+strconv.Itoa(t.ServiceInfo.Port))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	grpcServer := grpc.NewServer()
-	pb.RegisterProductServiceServer(grpcServer, &t)
-	go func() {
-		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	log.Println("gRPC server is running on " + t.ServiceInfo.Ip + ":" + strconv.Itoa(t.ServiceInfo.Port))
-	return lis, grpcServer, nil
-}
-func (t ProductService) StartGrpcGatewayService() (*grpc.ClientConn, error) {
-
-	// 启动 gRPC-Gateway
-	conn, err := grpc.Dial(t.ServiceInfo.Ip+":"+strconv.Itoa(t.ServiceInfo.Port), grpc.WithInsecure())
-	if err != nil {
-		log.Fatalln("Failed to dial server:", err)
-	}
-	//gwmux := runtime.NewServeMux()
-	gwmux := runtime.NewServeMux(
-		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
-			MarshalOptions: protojson.MarshalOptions{
-				UseProtoNames:   true, // 替代 OrigName
-				EmitUnpopulated: true, // 替代 EmitDefaults
-			},
-			UnmarshalOptions: protojson.UnmarshalOptions{},
-		}),
-	)
-	err = pb.RegisterProductServiceHandler(context.Background(), gwmux, conn)
-	if err != nil {
-		log.Fatalln("Failed to register gateway:", err)
-	}
-
-	gwServer := &http.Server{
-		Addr:    t.ServiceInfo.Ip + ":" + strconv.Itoa(t.ServiceInfo.HttpPort),
-		Handler: gwmux,
-	}
-
-	go func() {
-		if err := gwServer.ListenAndServe(); err != nil {
-			log.Println(err)
-		}
-	}()
-	log.Println("Serving gRPC-Gateway on http://" + gwServer.Addr)
-
-	return conn, nil
+// NewProductService 创建服务并注册gRPC服务和网关
+func NewProductService(s *ss.Service) *ProductService {
+	t := &ProductService{Service: s}
+	s.Register(func(server *grpc.Server) {
+		pb.RegisterProductServiceServer(server, t)
+	}, pb.RegisterProductServiceHandler)
+	return t
 }
 
+// Health 依赖不可用或正在退出时返回Unavailable，Kong的主动健康检查据此摘除实例
 func (t *ProductService) Health(ctx context.Context, empty *pb.Empty) (*pb.Empty, error) {
+	if err := t.Service.Probes.Ready(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	return &pb.Empty{}, nil
 }
 
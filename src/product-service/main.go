@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	k "kongApi"
 	"product-service/handler"
 	"product-service/models"
 	ss "service"
@@ -18,21 +19,26 @@ func main() {
 		HealthPath:  "/health",
 		ServicePath: "/products",
 		Paths:       []string{"/service/products"},
+		Plugins: []k.Plugin{
+			k.RateLimitingConfig{Second: 100, Policy: "local"}.Plugin(),
+			k.CORSConfig{Origins: []string{"*"}, Methods: []string{"GET", "POST", "PUT", "DELETE"}}.Plugin(),
+			k.RequestSizeLimitingConfig{AllowedPayloadSize: 1, SizeUnit: "megabytes"}.Plugin(),
+		},
 	})
 
-	s.GormMigrate("root:root@tcp(127.0.0.1:3307)/msmall?charset=utf8mb4&parseTime=True&loc=Local", &models.Product{})
-
-	s.UpdateOnStart = true
 	if err != nil {
 		panic(err)
 	}
+	if err := s.GormMigrate("root:root@tcp(127.0.0.1:3307)/msmall?charset=utf8mb4&parseTime=True&loc=Local", &models.Product{}); err != nil {
+		panic(err)
+	}
 
-	sm := ss.NewServiceManager(&handler.ProductService{
-		Service: s,
-	})
+	sm := ss.NewServiceManager(handler.NewProductService(s))
 
 	ctx, _ := context.WithTimeout(context.Background(), 200*time.Minute)
 
-	sm.StartService(ctx)
+	if err := sm.StartService(ctx); err != nil {
+		panic(err)
+	}
 
 }
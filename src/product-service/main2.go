@@ -20,19 +20,19 @@ func main() {
 		Paths:       []string{"/service/products"},
 	})
 
-	s.GormMigrate("root:root@tcp(127.0.0.1:3307)/msmall?charset=utf8mb4&parseTime=True&loc=Local", &models.Product{})
-
-	s.UpdateOnStart = true
 	if err != nil {
 		panic(err)
 	}
+	if err := s.GormMigrate("root:root@tcp(127.0.0.1:3307)/msmall?charset=utf8mb4&parseTime=True&loc=Local", &models.Product{}); err != nil {
+		panic(err)
+	}
 
-	sm := ss.NewServiceManager(&handler.ProductService{
-		Service: s,
-	})
+	sm := ss.NewServiceManager(handler.NewProductService(s))
 
 	ctx, _ := context.WithTimeout(context.Background(), 200*time.Minute)
 
-	sm.StartService(ctx)
+	if err := sm.StartService(ctx); err != nil {
+		panic(err)
+	}
 
 }
@@ -3,14 +3,11 @@ package handler
 import (
 	"context"
 	"fmt"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
-	"log"
-	"net"
-	"net/http"
+	"google.golang.org/grpc/status"
 	ss "service"
-	"strconv"
 	"test-service/pb"
 )
 
@@ -19,50 +16,13 @@ type TestService struct {
 	*ss.Service
 }
 
-func (t TestService) StartGrpcService() (net.Listener, *grpc.Server, error) {
-	// 启动 grpc 服务
-	lis, err := net.Listen("tcp", t.ServiceInfo.Ip+":"+strconv.Itoa(t.ServiceInfo.Port))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	grpcServer := grpc.NewServer()
-	pb.RegisterCheckStatusServer(grpcServer, &t)
-	go func() {
-		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	log.Println("gRPC server is running on " + t.ServiceInfo.Ip + ":" + strconv.Itoa(t.ServiceInfo.Port))
-	return lis, grpcServer, nil
-}
-func (t TestService) StartGrpcGatewayService() (*grpc.ClientConn, error) {
-
-	// 启动 gRPC-Gateway
-	conn, err := grpc.Dial(t.ServiceInfo.Ip+":"+strconv.Itoa(t.ServiceInfo.Port), grpc.WithInsecure())
-	if err != nil {
-		log.Fatalln("Failed to dial server:", err)
-	}
-	gwmux := runtime.NewServeMux()
-	err = pb.RegisterCheckStatusHandler(context.Background(), gwmux, conn)
-	if err != nil {
-		log.Fatalln("Failed to register gateway:", err)
-	}
-
-	gwServer := &http.Server{
-		Addr:    t.ServiceInfo.Ip + ":" + strconv.Itoa(t.ServiceInfo.HttpPort),
-		Handler: gwmux,
-	}
-
-	go func() {
-		if err := gwServer.ListenAndServe(); err != nil {
-			log.Println(err)
-		}
-	}()
-	log.Println("Serving gRPC-Gateway on http://" + gwServer.Addr)
-
-	return conn, nil
+// NewTestService 创建服务并注册gRPC服务和网关
+func NewTestService(s *ss.Service) *TestService {
+	t := &TestService{Service: s}
+	s.Register(func(server *grpc.Server) {
+		pb.RegisterCheckStatusServer(server, t)
+	}, pb.RegisterCheckStatusHandler)
+	return t
 }
 
 func (t *TestService) GetStatus(ctx context.Context, empty *pb.Empty) (*pb.TestMsg, error) {
@@ -84,6 +44,10 @@ func (t *TestService) GetStatusA(ctx context.Context, empty *pb.Empty) (*pb.Test
 	}, nil
 }
 
+// Health 依赖不可用或正在退出时返回Unavailable，Kong的主动健康检查据此摘除实例
 func (t *TestService) Health(ctx context.Context, empty *pb.Empty) (*pb.Empty, error) {
+	if err := t.Service.Probes.Ready(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	return &pb.Empty{}, nil
 }
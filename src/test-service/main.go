@@ -21,16 +21,15 @@ func main() {
 		Ip:          "127.0.0.1",
 	})
 
-	s.UpdateOnStart = true
 	if err != nil {
 		panic(err)
 	}
 
-	sm := ss.NewServiceManager(&handler.TestService{
-		Service: s,
-	})
+	sm := ss.NewServiceManager(handler.NewTestService(s))
 	ctx, _ := context.WithTimeout(context.Background(), 200*time.Second)
 
-	sm.StartService(ctx)
+	if err := sm.StartService(ctx); err != nil {
+		panic(err)
+	}
 
 }
@@ -19,15 +19,14 @@ func main() {
 		Paths:       []string{"/service/userA", "/service/userB"},
 	})
 
-	s.UpdateOnStart = true
 	if err != nil {
 		panic(err)
 	}
 
-	sm := ss.NewServiceManager(&handler.TestService{
-		Service: s,
-	})
+	sm := ss.NewServiceManager(handler.NewTestService(s))
 	ctx, _ := context.WithTimeout(context.Background(), 200*time.Second)
 
-	sm.StartService(ctx)
+	if err := sm.StartService(ctx); err != nil {
+		panic(err)
+	}
 }